@@ -0,0 +1,214 @@
+package main
+
+// AI-assisted transform on a visual selection (<leader>O): unlike
+// ollamaComplete's one-shot "complete this" action, startAITransformMode
+// takes a user-supplied natural-language instruction, shows a diff preview
+// of the model's proposed rewrite, and gates committing it behind the
+// existing ModeConfirm y/n mechanism so a bad suggestion can be retried
+// without reselecting the text.
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+// startAITransformMode captures the current visual selection (the same
+// bookkeeping startReplaceMode does, kept in separate aiTransformSel*
+// fields) and enters ModeAITransform to prompt for an instruction.
+func (e *Editor) startAITransformMode() {
+	b := e.activeBuffer()
+	if b == nil {
+		return
+	}
+	if e.ollamaClient == nil || !e.ollamaClient.IsOnline {
+		e.setMessage("Ollama is offline")
+		return
+	}
+
+	if e.visualStartY < b.PrimaryCursor().Y || (e.visualStartY == b.PrimaryCursor().Y && e.visualStartX < b.PrimaryCursor().X) {
+		e.aiTransformSelStartX = e.visualStartX
+		e.aiTransformSelStartY = e.visualStartY
+		e.aiTransformSelEndX = b.PrimaryCursor().X
+		e.aiTransformSelEndY = b.PrimaryCursor().Y
+	} else {
+		e.aiTransformSelStartX = b.PrimaryCursor().X
+		e.aiTransformSelStartY = b.PrimaryCursor().Y
+		e.aiTransformSelEndX = e.visualStartX
+		e.aiTransformSelEndY = e.visualStartY
+	}
+
+	if e.mode == ModeVisualLine {
+		e.aiTransformSelStartX = 0
+		if e.aiTransformSelEndY < len(b.buffer) {
+			e.aiTransformSelEndX = len(b.buffer[e.aiTransformSelEndY])
+		}
+	} else if e.aiTransformSelEndY < len(b.buffer) && e.aiTransformSelEndX < len(b.buffer[e.aiTransformSelEndY]) {
+		e.aiTransformSelEndX++
+	}
+
+	// Captured while e.mode is still a Visual variant, since getSelection
+	// relies on it; everything after this point works off the bounds above.
+	e.aiTransformOriginal = string(e.getSelection())
+	e.aiTransformResponse = ""
+	e.aiTransformInput = []rune{}
+	e.aiTransformPresetIdx = 0
+	e.mode = ModeAITransform
+}
+
+// handleAITransformMode processes input for the instruction prompt. Tab
+// cycles through aiTransformPresets for the active buffer's file type,
+// letting common requests ("add a doc comment") be inserted without typing
+// them out; the inserted text is still editable before Enter submits it.
+func (e *Editor) handleAITransformMode(ev termbox.Event) {
+	switch ev.Key {
+	case termbox.KeyEsc:
+		e.mode = ModeNormal
+		e.aiTransformInput = []rune{}
+		e.aiTransformOriginal = ""
+		e.aiTransformResponse = ""
+	case termbox.KeyEnter:
+		e.submitAITransform()
+	case termbox.KeyTab:
+		b := e.activeBuffer()
+		if b == nil {
+			return
+		}
+		presets := aiTransformPresets(b.fileType)
+		if len(presets) == 0 {
+			return
+		}
+		e.aiTransformInput = []rune(presets[e.aiTransformPresetIdx%len(presets)])
+		e.aiTransformPresetIdx++
+	case termbox.KeyBackspace, termbox.KeyBackspace2:
+		if len(e.aiTransformInput) > 0 {
+			e.aiTransformInput = e.aiTransformInput[:len(e.aiTransformInput)-1]
+		} else {
+			e.mode = ModeNormal
+		}
+	case termbox.KeySpace:
+		e.aiTransformInput = append(e.aiTransformInput, ' ')
+	default:
+		if ev.Ch != 0 {
+			e.aiTransformInput = append(e.aiTransformInput, ev.Ch)
+		}
+	}
+}
+
+// submitAITransform sends {instruction}\n\n---\n{selectionText} to Ollama,
+// then (on success) renders a diff preview into the hover popup and drops
+// into ModeConfirm to gate applying it. 'n'/Esc there returns to the
+// instruction prompt with the original selection and input still intact, so
+// the user can amend the wording and retry without reselecting.
+func (e *Editor) submitAITransform() {
+	instruction := strings.TrimSpace(string(e.aiTransformInput))
+	if instruction == "" {
+		e.setMessage("No instruction given")
+		return
+	}
+	if e.ollamaClient == nil || !e.ollamaClient.IsOnline {
+		e.setMessage("Ollama is offline")
+		return
+	}
+
+	prompt := instruction + "\n\n---\n" + e.aiTransformOriginal
+
+	firstLine := strings.Split(instruction, "\n")[0]
+	if len(firstLine) > 50 {
+		firstLine = firstLine[:47] + "..."
+	}
+	e.setMessage(fmt.Sprintf("Ollama is thinking about: %s", firstLine))
+	e.draw()
+
+	response, err := e.ollamaClient.Generate(prompt)
+	if err != nil {
+		e.setMessage(fmt.Sprintf("Ollama error: %v", err))
+		return
+	}
+	e.aiTransformResponse = strings.TrimRight(response, "\n")
+
+	e.hoverContent = renderAITransformDiff(e.aiTransformOriginal, e.aiTransformResponse)
+	e.showHover = true
+
+	e.pendingConfirm = func() { e.applyAITransform() }
+	e.pendingCancel = func() { e.mode = ModeAITransform }
+	e.mode = ModeConfirm
+	e.setMessage("Apply AI transform? (y/n)")
+}
+
+// applyAITransform replaces the originally captured selection with the
+// confirmed Ollama response, through the same whole-buffer saveState used
+// by executeReplace, followed by a full Reparse since the replacement can
+// change the number of lines (unlike replaceInRange's per-line splices).
+func (e *Editor) applyAITransform() {
+	b := e.activeBuffer()
+	if b == nil {
+		return
+	}
+	if b.readOnly {
+		e.setMessage("File is read-only")
+		return
+	}
+
+	e.saveState()
+
+	startY, startX := e.aiTransformSelStartY, e.aiTransformSelStartX
+	b.Delete(startY, startX, e.aiTransformSelEndY, e.aiTransformSelEndX)
+	b.Insert(startY, startX, []rune(e.aiTransformResponse))
+
+	cursor := b.PrimaryCursor()
+	cursor.Y = startY
+	cursor.X = startX
+
+	if b.syntax != nil {
+		b.syntaxReparse()
+	}
+	e.markModified()
+
+	e.aiTransformInput = []rune{}
+	e.aiTransformOriginal = ""
+	e.aiTransformResponse = ""
+	e.mode = ModeNormal
+	e.setMessage("AI transform applied")
+}
+
+// aiTransformPresets is a small built-in instruction library, phrased using
+// ft.Comment so the suggestion reads naturally for any language ("using //"
+// for Go, "using #" for Python, etc). Returns generic presets for file
+// types with no line-comment form (ft == nil or ft.Comment == "").
+func aiTransformPresets(ft *FileType) []string {
+	if ft == nil || ft.Comment == "" {
+		return []string{
+			"Add a doc comment explaining what this does",
+			"Simplify this without changing behavior",
+			"Fix any bugs you notice",
+		}
+	}
+	return []string{
+		fmt.Sprintf("Add a doc comment above this using %s", ft.Comment),
+		fmt.Sprintf("Add inline comments using %s explaining the non-obvious steps", ft.Comment),
+		"Simplify this without changing behavior",
+		"Fix any bugs you notice",
+	}
+}
+
+// renderAITransformDiff builds a unified-style preview ('-' for the
+// original selection, '+' for the proposed replacement) for the hover
+// popup. It's not a minimal line-level diff, just the two blocks in
+// sequence, which is enough to eyeball a small selection's rewrite.
+func renderAITransformDiff(original, response string) string {
+	var sb strings.Builder
+	sb.WriteString("AI transform preview (y to apply, n to retry):\n\n")
+	for _, line := range strings.Split(original, "\n") {
+		sb.WriteString("-")
+		sb.WriteString(line)
+		sb.WriteString("\n")
+	}
+	for _, line := range strings.Split(response, "\n") {
+		sb.WriteString("+")
+		sb.WriteString(line)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
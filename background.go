@@ -0,0 +1,117 @@
+package main
+
+// Terminal background detection via OSC 11 ("query background color"): most
+// terminal emulators that can be queried at all reply to ESC]11;?BEL with
+// ESC]11;rgb:RRRR/GGGG/BBBBBEL (or ST in place of the trailing BEL).
+// DetectBackgroundVariant sends that query, parses whichever reply comes
+// back within backgroundQueryTimeout, and classifies it as VariantLight or
+// VariantDark by perceived luminance. tmux/screen and some emulators never
+// reply at all, so the timeout is what keeps a non-answering terminal from
+// hanging startup; it just falls back to VariantDark, matching the editor's
+// historical (dark-oriented) default.
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// backgroundQueryTimeout bounds how long DetectBackgroundVariant waits for
+// an OSC 11 reply before giving up.
+const backgroundQueryTimeout = 200 * time.Millisecond
+
+// backgroundLuminanceThreshold is the 0-255 perceived-luminance cutoff
+// (0.299R+0.587G+0.114B) above which a background counts as light.
+const backgroundLuminanceThreshold = 128
+
+// DetectBackgroundVariant queries the terminal's background color and
+// returns VariantLight or VariantDark. Must run before termbox.Init() (see
+// main.go): it needs exclusive raw-mode access to stdin to read the OSC 11
+// reply, which would otherwise race termbox's own input poller for the same
+// bytes once termbox takes over the terminal.
+func DetectBackgroundVariant() Variant {
+	fd := int(os.Stdin.Fd())
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		return VariantDark
+	}
+	defer term.Restore(fd, oldState)
+
+	if _, err := os.Stdout.WriteString("\x1b]11;?\x07"); err != nil {
+		return VariantDark
+	}
+
+	// Bound the reader goroutine below with a deadline on the same fd, and
+	// wait for it to actually finish (rather than racing it with a
+	// separate time.After, the previous bug) before returning. Without
+	// this, a non-answering terminal (tmux/screen) leaves the goroutine
+	// blocked in os.Stdin.Read indefinitely, and main.go's termbox.Init()
+	// - which opens its own fd on the same tty right after this function
+	// returns - would then race it for whatever bytes arrive next.
+	os.Stdin.SetReadDeadline(time.Now().Add(backgroundQueryTimeout))
+	defer os.Stdin.SetReadDeadline(time.Time{})
+
+	done := make(chan string, 1)
+	go func() {
+		var sb strings.Builder
+		buf := make([]byte, 64)
+		for sb.Len() < 64 {
+			n, err := os.Stdin.Read(buf)
+			if n > 0 {
+				sb.Write(buf[:n])
+				if strings.ContainsAny(sb.String(), "\a") || strings.Contains(sb.String(), "\x1b\\") {
+					break
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		done <- sb.String()
+	}()
+
+	resp := <-done
+	r, g, b, ok := parseOSC11Reply(resp)
+	if !ok {
+		return VariantDark
+	}
+	luminance := 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+	if luminance >= backgroundLuminanceThreshold {
+		return VariantLight
+	}
+	return VariantDark
+}
+
+// parseOSC11Reply extracts 8-bit r, g, b from an OSC 11 reply of the form
+// "...rgb:RRRR/GGGG/BBBB..." (terminated by BEL or ST), keeping only the
+// high byte of each 16-bit hex component.
+func parseOSC11Reply(s string) (r, g, b int, ok bool) {
+	i := strings.Index(s, "rgb:")
+	if i < 0 {
+		return 0, 0, 0, false
+	}
+	body := s[i+len("rgb:"):]
+	if end := strings.IndexAny(body, "\a\x1b"); end >= 0 {
+		body = body[:end]
+	}
+
+	parts := strings.SplitN(body, "/", 3)
+	if len(parts) != 3 {
+		return 0, 0, 0, false
+	}
+	components := make([]int, 3)
+	for i, p := range parts {
+		if len(p) > 2 {
+			p = p[:2]
+		}
+		var v int
+		if _, err := fmt.Sscanf(p, "%x", &v); err != nil {
+			return 0, 0, 0, false
+		}
+		components[i] = v
+	}
+	return components[0], components[1], components[2], true
+}
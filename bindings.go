@@ -0,0 +1,951 @@
+package main
+
+// Configurable keybindings: a named-action registry plus a per-mode
+// chord-sequence table, replacing the hardcoded switch blocks that used to
+// live directly in handleNormalMode/handleVisualMode/etc. (kevent.go).
+//
+// A KeyChord is the canonical text form of one keypress: a bare rune for a
+// literal character ("d", "(", "\""), or a bracketed name for anything
+// else ("<Up>", "<C-d>", "<leader>", "<Esc>"). A binding is a sequence of
+// chords written back to back with no separator, exactly as vim/tmux-style
+// notation would, e.g. "dd", "<leader>w", "di(", "<M-Up>". handleNormalMode
+// and its Visual-mode siblings accumulate chords into e.pendingChords and
+// look the joined string up in Keymaps[e.mode] on every keystroke.
+//
+// Users can add or override bindings by dropping a keybindings.json next
+// to plumb.rules (see qweKeybindingsPath) or at runtime with `:bind <mode>
+// <keys> <action>`. Neither changes what an action does, only which chord
+// sequence triggers it, so bindingActions stays the single source of truth
+// for behavior.
+//
+// Insert/Command/Fuzzy/Find/Replace/Confirm mode are not covered: they're
+// primarily raw text-entry loops (every printable key inserts itself)
+// rather than fixed vocabularies of actions, so there's nothing meaningful
+// to remap there.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nsf/termbox-go"
+)
+
+// KeyChord is the canonical textual form of one keypress in a binding
+// sequence (see the package comment above).
+type KeyChord string
+
+// namedKeys maps the termbox keys the editor binds by name to their
+// canonical chord name (without the surrounding "<...>", added by
+// chordFromEvent). Keys with no entry here can't be bound yet; add them
+// here as they're needed.
+var namedKeys = map[termbox.Key]string{
+	termbox.KeyEsc:        "Esc",
+	termbox.KeyEnter:      "Enter",
+	termbox.KeyTab:        "Tab",
+	termbox.KeySpace:      "Space",
+	termbox.KeyBackspace:  "Backspace",
+	termbox.KeyBackspace2: "Backspace",
+	termbox.KeyArrowLeft:  "Left",
+	termbox.KeyArrowRight: "Right",
+	termbox.KeyArrowUp:    "Up",
+	termbox.KeyArrowDown:  "Down",
+	termbox.KeyCtrlC:      "C-c",
+	termbox.KeyCtrlX:      "C-x",
+	termbox.KeyCtrlD:      "C-d",
+	termbox.KeyCtrlG:      "C-g",
+	termbox.KeyCtrlB:      "C-b",
+	termbox.KeyCtrlP:      "C-p",
+	termbox.KeyCtrlN:      "C-n",
+	termbox.KeyCtrlO:      "C-o",
+	termbox.KeyCtrlR:      "C-r",
+	termbox.KeyCtrlV:      "C-v",
+	termbox.KeyCtrlK:      "C-k",
+	termbox.KeyCtrlW:      "C-w",
+	termbox.KeyCtrlU:      "C-u",
+	termbox.KeyCtrlT:      "C-t",
+	termbox.KeyCtrlS:      "C-s",
+}
+
+// chordFromEvent derives the KeyChord a termbox key event represents, or ""
+// if the event isn't one the registry can express (e.g. a bare Alt press).
+func chordFromEvent(ev termbox.Event) KeyChord {
+	var name string
+	if ev.Ch != 0 {
+		if Config.LeaderKey != 0 && ev.Ch == Config.LeaderKey {
+			name = "leader"
+		} else {
+			return KeyChord(ev.Ch)
+		}
+	} else if n, ok := namedKeys[ev.Key]; ok {
+		name = n
+	} else {
+		return ""
+	}
+
+	if ev.Mod&termbox.ModAlt != 0 {
+		name = "M-" + name
+	}
+	return KeyChord("<" + name + ">")
+}
+
+// parseChordSequence tokenizes a binding string like "di(" or "<leader>w"
+// into its chords: a "<...>" run is one chord, every other rune is its own.
+func parseChordSequence(s string) []KeyChord {
+	var chords []KeyChord
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] == '<' {
+			j := i + 1
+			for j < len(runes) && runes[j] != '>' {
+				j++
+			}
+			if j < len(runes) {
+				chords = append(chords, KeyChord(string(runes[i:j+1])))
+				i = j
+				continue
+			}
+		}
+		chords = append(chords, KeyChord(runes[i]))
+	}
+	return chords
+}
+
+// chordSeqString joins a chord sequence back into its canonical binding
+// string, e.g. for Keymaps lookups and `:bind` round-tripping.
+func chordSeqString(seq []KeyChord) string {
+	var sb strings.Builder
+	for _, c := range seq {
+		sb.WriteString(string(c))
+	}
+	return sb.String()
+}
+
+// bindingActions is the named-action registry: every primitive a keymap
+// entry can reference. Populated by initBindingActions at package init
+// time; see registerTextObjectActions for the generated Delete/Change
+// Inside/Around families.
+var bindingActions map[string]func(*Editor)
+
+// changeActionNames are the actions "." can replay: anything that mutates
+// the buffer. Populated below for hand-written actions, and by
+// registerTextObjectActions for the generated text-object families. Pure
+// motions, Undo/Redo, and RepeatLastChange itself are deliberately left
+// out, so runCountedActions never records them as the next "." target.
+var changeActionNames = map[string]bool{
+	"DeleteLine": true, "DeleteChar": true, "ToggleCommentLine": true,
+	"DeleteWordForward": true, "ChangeWord": true, "ChangeCharacter": true,
+	"ChangeToEndOfLine": true, "DeleteToEndOfLine": true, "JoinLines": true,
+	"PasteLine": true, "PasteLineAbove": true,
+	"DeleteSelection": true, "ChangeSelection": true, "PasteSelection": true,
+	"CommentSelection": true, "ToggleCaseSelection": true,
+	"EnterInsert": true, "EnterInsertAfterCursor": true, "EnterInsertLineEnd": true,
+	"EnterInsertFirstNonBlank": true, "OpenLineBelow": true, "OpenLineAbove": true,
+}
+
+// motionActionNames are the actions a count may repeat in Visual mode. A
+// count there multiplies how far a motion moves; it must not multiply an
+// editing action like DeleteSelection, so runCountedActions consults this
+// set before looping a Visual-mode binding more than once.
+var motionActionNames = map[string]bool{
+	"CursorLeft": true, "CursorRight": true, "CursorUp": true, "CursorDown": true,
+	"MoveWordForward": true, "MoveWordBackward": true,
+	"JumpToNextEmptyLine": true, "JumpToPrevEmptyLine": true,
+	"JumpToTop": true, "JumpToBottom": true, "JumpToMatchingBrace": true,
+	"JumpToFirstNonBlank": true, "JumpToLineEnd": true, "JumpToLineStart": true,
+	"FindNext": true, "FindPrev": true,
+	"RepeatCharSearchForward": true, "RepeatCharSearchBackward": true,
+}
+
+func init() {
+	initBindingActions()
+}
+
+// registerTextObjectActions registers the four Delete/Change x Inside/
+// Around action variants for a text object named name (e.g. "Word",
+// "Paren"), given its underlying deleteInsideX/changeInsideX-style
+// implementations.
+func registerTextObjectActions(name string, del func(e *Editor, around bool) bool, chg func(e *Editor, around bool)) {
+	bindingActions["DeleteInside"+name] = func(e *Editor) {
+		e.saveState()
+		del(e, false)
+		e.checkDiagnostics()
+	}
+	bindingActions["DeleteAround"+name] = func(e *Editor) {
+		e.saveState()
+		del(e, true)
+		e.checkDiagnostics()
+	}
+	bindingActions["ChangeInside"+name] = func(e *Editor) {
+		e.saveState()
+		chg(e, false)
+		e.checkDiagnostics()
+	}
+	bindingActions["ChangeAround"+name] = func(e *Editor) {
+		e.saveState()
+		chg(e, true)
+		e.checkDiagnostics()
+	}
+	for _, suffix := range []string{"DeleteInside", "DeleteAround", "ChangeInside", "ChangeAround"} {
+		changeActionNames[suffix+name] = true
+	}
+}
+
+func initBindingActions() {
+	bindingActions = map[string]func(*Editor){
+		"Noop": func(e *Editor) {},
+
+		// Movement.
+		"CursorLeft":  func(e *Editor) { e.moveCursor(-1, 0) },
+		"CursorRight": func(e *Editor) { e.moveCursor(1, 0) },
+		"CursorUp":    func(e *Editor) { e.moveCursor(0, -1) },
+		"CursorDown":  func(e *Editor) { e.moveCursor(0, 1) },
+
+		"AddCursorAbove":       func(e *Editor) { e.addCursorAbove() },
+		"AddCursorBelow":       func(e *Editor) { e.addCursorBelow() },
+		"AddCursorAtNextMatch": func(e *Editor) { e.AddCursorAtNextMatch() },
+		"SelectAllOccurrences": func(e *Editor) { e.selectAllOccurrences() },
+		"SkipMultiCursor":      func(e *Editor) { e.SkipMultiCursor() },
+		"SkipMultiCursorBack":  func(e *Editor) { e.SkipMultiCursorBack() },
+
+		"PrevBuffer":  func(e *Editor) { e.prevBuffer() },
+		"NextBuffer":  func(e *Editor) { e.nextBuffer() },
+		"JumpBack":    func(e *Editor) { e.jumpBack() },
+		"JumpForward": func(e *Editor) { e.jumpForward() },
+
+		"Undo":             func(e *Editor) { e.undo(); e.checkDiagnostics() },
+		"Redo":             func(e *Editor) { e.redo(); e.checkDiagnostics() },
+		"RepeatLastChange": func(e *Editor) { e.repeatLastChange() },
+
+		"RepeatCharSearchForward":  func(e *Editor) { e.repeatCharSearch(false) },
+		"RepeatCharSearchBackward": func(e *Editor) { e.repeatCharSearch(true) },
+
+		"JumpToJumpsEntry":    func(e *Editor) { e.jumpToJumpsEntry() },
+		"TriggerHover":        func(e *Editor) { e.triggerHover() },
+		"JumpToNextEmptyLine": func(e *Editor) { e.pushJump(); e.jumpToNextEmptyLine() },
+		"JumpToPrevEmptyLine": func(e *Editor) { e.pushJump(); e.jumpToPrevEmptyLine() },
+		"JumpToTop":           func(e *Editor) { e.pushJump(); e.jumpToTop() },
+		"JumpToBottom":        func(e *Editor) { e.pushJump(); e.jumpToBottom() },
+		"JumpToMatchingBrace": func(e *Editor) { e.JumpToMatchingBrace() },
+		"JumpToFirstNonBlank": func(e *Editor) { e.jumpToFirstNonBlank() },
+		"JumpToLineEnd":       func(e *Editor) { e.jumpToLineEnd() },
+		"JumpToLineStart":     func(e *Editor) { e.jumpToLineStart() },
+		"MoveWordForward":     func(e *Editor) { e.moveWordForward() },
+		"MoveWordBackward":    func(e *Editor) { e.moveWordBackward() },
+		"FindNext":            func(e *Editor) { e.findNext(); e.centerCursor() },
+		"FindPrev":            func(e *Editor) { e.findPrev(); e.centerCursor() },
+		"CenterScreen":        func(e *Editor) { e.centerScreen() },
+
+		// Mode switches.
+		"EnterInsert": func(e *Editor) {
+			// No edit yet, so no undo entry: the coalescing group opens
+			// lazily on the first character typed (see beginInsertGroup).
+			e.mode = ModeInsert
+			e.introDismissed = true
+		},
+		"EnterInsertAfterCursor": func(e *Editor) {
+			e.moveCursor(1, 0)
+			e.mode = ModeInsert
+			e.introDismissed = true
+		},
+		"EnterInsertLineEnd": func(e *Editor) {
+			e.jumpToLineEnd()
+			e.mode = ModeInsert
+			e.introDismissed = true
+		},
+		"EnterInsertFirstNonBlank": func(e *Editor) {
+			e.jumpToFirstNonBlank()
+			e.mode = ModeInsert
+			e.introDismissed = true
+		},
+		"OpenLineBelow": func(e *Editor) {
+			e.insertLineBelow()
+			e.mode = ModeInsert
+			e.introDismissed = true
+		},
+		"OpenLineAbove": func(e *Editor) {
+			e.insertLineAbove()
+			e.mode = ModeInsert
+			e.introDismissed = true
+		},
+		"EnterVisual": func(e *Editor) {
+			if b := e.activeBuffer(); b != nil {
+				e.visualStartX, e.visualStartY = b.PrimaryCursor().X, b.PrimaryCursor().Y
+			}
+			e.mode = ModeVisual
+		},
+		"EnterVisualLine": func(e *Editor) {
+			if b := e.activeBuffer(); b != nil {
+				e.visualStartX, e.visualStartY = b.PrimaryCursor().X, b.PrimaryCursor().Y
+			}
+			e.mode = ModeVisualLine
+		},
+		"EnterVisualBlockFromCursor": func(e *Editor) {
+			if b := e.activeBuffer(); b != nil {
+				e.visualStartX, e.visualStartY = b.PrimaryCursor().X, b.PrimaryCursor().Y
+			}
+			e.mode = ModeVisualBlock
+		},
+		"SwitchToVisual":     func(e *Editor) { e.mode = ModeVisual },
+		"SwitchToVisualLine": func(e *Editor) { e.mode = ModeVisualLine },
+		"ExitVisualToNormal": func(e *Editor) { e.mode = ModeNormal },
+		"EnterCommand": func(e *Editor) {
+			e.mode = ModeCommand
+			e.commandBuffer = []rune{}
+			e.commandCursorX = 0
+		},
+		"EnterCommandFromVisual": func(e *Editor) {
+			if b := e.activeBuffer(); b != nil {
+				e.lastVisualStartY = e.visualStartY
+				e.lastVisualEndY = b.PrimaryCursor().Y
+				if e.lastVisualStartY > e.lastVisualEndY {
+					e.lastVisualStartY, e.lastVisualEndY = e.lastVisualEndY, e.lastVisualStartY
+				}
+			}
+			e.mode = ModeCommand
+			e.commandBuffer = []rune("'<,'>")
+			e.commandCursorX = len(e.commandBuffer)
+		},
+		"EnterFind": func(e *Editor) {
+			e.findSavedSearch = e.lastSearch
+			e.mode = ModeFind
+			e.findBuffer = []rune{}
+		},
+		"SwapVisualAnchor": func(e *Editor) {
+			b := e.activeBuffer()
+			if b == nil {
+				return
+			}
+			cur := b.PrimaryCursor()
+			cur.X, cur.Y, e.visualStartX, e.visualStartY = e.visualStartX, e.visualStartY, cur.X, cur.Y
+		},
+		"StartReplaceMode":     func(e *Editor) { e.startReplaceMode() },
+		"StartAITransformMode": func(e *Editor) { e.startAITransformMode() },
+
+		// Leader commands and misc navigation.
+		"ToggleDebugWindow":        func(e *Editor) { e.toggleDebugWindow() },
+		"StartWarningsFuzzyFinder": func(e *Editor) { e.startWarningsFuzzyFinder() },
+		"StartFileFuzzyFinder":     func(e *Editor) { e.startFileFuzzyFinder() },
+		"StartBufferFuzzyFinder":   func(e *Editor) { e.startBufferFuzzyFinder() },
+		"ClearLastSearch":          func(e *Editor) { e.lastSearch = ""; e.invalidateSearchMatches() },
+		"DeleteCurrentBuffer":      func(e *Editor) { e.deleteCurrentBuffer() },
+		"GotoFile":                 func(e *Editor) { e.gotoFile() },
+		"GotoDefinition":           func(e *Editor) { e.gotoDefinition() },
+		"Plumb":                    func(e *Editor) { e.Plumb() },
+		"OllamaComplete":           func(e *Editor) { e.ollamaComplete() },
+		"OpenInExternalEditor":     func(e *Editor) { e.OpenInExternalEditor() },
+		"StartCodeAction":          func(e *Editor) { e.StartCodeAction() },
+
+		// Line/character editing.
+		"DeleteLine":        func(e *Editor) { e.deleteLine(); e.checkDiagnostics() },
+		"YankLine":          func(e *Editor) { e.yankLine(); e.setMessage("Line yanked") },
+		"DeleteChar":        func(e *Editor) { e.saveState(); e.DeleteChar(); e.checkDiagnostics() },
+		"ToggleCommentLine": func(e *Editor) { e.saveState(); e.toggleCommentLine(); e.checkDiagnostics() },
+		"FormatText":        func(e *Editor) { e.formatText(); e.checkDiagnostics() },
+		"DeleteWordForward": func(e *Editor) { e.saveState(); e.deleteWord(true); e.checkDiagnostics() },
+		"ChangeWord":        func(e *Editor) { e.saveState(); e.changeWord(); e.checkDiagnostics() },
+		"ChangeCharacter":   func(e *Editor) { e.saveState(); e.changeCharacter(); e.checkDiagnostics() },
+		"ChangeToEndOfLine": func(e *Editor) { e.saveState(); e.changeToEndOfLine(); e.checkDiagnostics() },
+		"DeleteToEndOfLine": func(e *Editor) { e.saveState(); e.deleteToEndOfLine(); e.checkDiagnostics() },
+		"JoinLines":         func(e *Editor) { e.JoinLines(); e.checkDiagnostics() },
+		"PasteLine":         func(e *Editor) { e.pasteLine(); e.checkDiagnostics() },
+		"PasteLineAbove":    func(e *Editor) { e.pasteLineAbove(); e.checkDiagnostics() },
+
+		// Visual-selection editing.
+		"YankSelection":                 func(e *Editor) { e.yankVisualSelection(); e.setMessage("Selection yanked") },
+		"DeleteSelection":               func(e *Editor) { e.deleteVisualSelection(); e.checkDiagnostics(); e.setMessage("Selection deleted") },
+		"ChangeSelection":               func(e *Editor) { e.saveState(); e.changeVisualSelection(); e.checkDiagnostics() },
+		"PasteSelection":                func(e *Editor) { e.pasteVisualSelection(); e.checkDiagnostics() },
+		"CommentSelection":              func(e *Editor) { e.saveState(); e.commentVisualSelection(); e.checkDiagnostics() },
+		"ToggleCaseSelection":           func(e *Editor) { e.saveState(); e.ToggleCaseVisualSelection(); e.checkDiagnostics() },
+		"MaterializeBlockCursorsBefore": func(e *Editor) { e.materializeBlockCursors(false) },
+		"MaterializeBlockCursorsAfter":  func(e *Editor) { e.materializeBlockCursors(true) },
+	}
+
+	registerTextObjectActions("Word",
+		func(e *Editor, around bool) bool { return e.deleteInsideWord(around) },
+		func(e *Editor, around bool) { e.changeInsideWord(around) })
+	registerTextObjectActions("Tag",
+		func(e *Editor, around bool) bool { return e.deleteInsideTag(around) },
+		func(e *Editor, around bool) { e.changeInsideTag(around) })
+	registerTextObjectActions("Paragraph",
+		func(e *Editor, around bool) bool { return e.deleteInsideParagraph(around) },
+		func(e *Editor, around bool) { e.changeInsideParagraph(around) })
+
+	nodeObjects := map[string]rune{"Func": 'f', "Block": 'b', "Class": 'c'}
+	for name, letter := range nodeObjects {
+		letter := letter
+		registerTextObjectActions(name,
+			func(e *Editor, around bool) bool { return e.deleteInsideNode(letter, around) },
+			func(e *Editor, around bool) { e.changeInsideNode(letter, around) })
+	}
+
+	delimiters := map[string][2]rune{
+		"Paren":   {'(', ')'},
+		"Bracket": {'[', ']'},
+		"Brace":   {'{', '}'},
+		"Quote":   {'\'', '\''},
+		"DQuote":  {'"', '"'},
+	}
+	for name, pair := range delimiters {
+		open, close := pair[0], pair[1]
+		registerTextObjectActions(name,
+			func(e *Editor, around bool) bool {
+				if around {
+					return e.deleteAround(open, close)
+				}
+				return e.deleteInside(open, close)
+			},
+			func(e *Editor, around bool) {
+				if around {
+					e.changeAround(open, close)
+				} else {
+					e.changeInside(open, close)
+				}
+			})
+	}
+}
+
+// defaultKeymaps are the out-of-the-box chord tables for the modes whose
+// dispatch goes through the registry. Keys are binding strings as parsed
+// by parseChordSequence; values are ordered lists of bindingActions names.
+var defaultKeymaps = map[Mode]map[string][]string{
+	ModeNormal: {
+		"<Left>":   {"CursorLeft"},
+		"<Right>":  {"CursorRight"},
+		"<Up>":     {"CursorUp"},
+		"<Down>":   {"CursorDown"},
+		"<M-Up>":   {"AddCursorAbove"},
+		"<M-Down>": {"AddCursorBelow"},
+		"<C-x>":    {"AddCursorBelow"},
+		"<C-d>":    {"AddCursorAtNextMatch"},
+		"<M-C-d>":  {"SelectAllOccurrences"},
+		"<C-g>":    {"SkipMultiCursor"},
+		"<C-b>":    {"SkipMultiCursorBack"},
+		"<C-p>":    {"PrevBuffer"},
+		"<C-n>":    {"NextBuffer"},
+		"<C-o>":    {"JumpBack"},
+		"<Tab>":    {"JumpForward"},
+		"<C-r>":    {"Redo"},
+		"<Enter>":  {"JumpToJumpsEntry"},
+		"<C-v>":    {"EnterVisualBlockFromCursor"},
+		"<C-k>":    {"TriggerHover"},
+
+		"i": {"EnterInsert"},
+		"a": {"EnterInsertAfterCursor"},
+		"A": {"EnterInsertLineEnd"},
+		"I": {"EnterInsertFirstNonBlank"},
+		"o": {"OpenLineBelow"},
+		"O": {"OpenLineAbove"},
+		"]": {"JumpToNextEmptyLine"},
+		"}": {"JumpToBottom"},
+		"%": {"JumpToMatchingBrace"},
+		"v": {"EnterVisual"},
+		"V": {"EnterVisualLine"},
+		":": {"EnterCommand"},
+		"/": {"EnterFind"},
+
+		"<leader>l": {"ToggleDebugWindow"},
+		"<leader>w": {"StartWarningsFuzzyFinder"},
+		"<leader>q": {"ClearLastSearch"},
+		"<leader>d": {"DeleteCurrentBuffer"},
+		"<leader>p": {"StartFileFuzzyFinder"},
+		"<leader>b": {"StartBufferFuzzyFinder"},
+		"<leader>P": {"Noop"},
+		"<leader>e": {"OpenInExternalEditor"},
+		"<leader>c": {"StartCodeAction"},
+
+		"w":   {"MoveWordForward"},
+		"dw":  {"DeleteWordForward"},
+		"cw":  {"ChangeWord"},
+		"diw": {"DeleteInsideWord"},
+		"daw": {"DeleteAroundWord"},
+		"ciw": {"ChangeInsideWord"},
+		"caw": {"ChangeAroundWord"},
+
+		"q":  {"MoveWordBackward"},
+		"zq": {"FormatText"},
+		"Q":  {"JumpToFirstNonBlank"},
+		"W":  {"JumpToLineEnd"},
+
+		"gf":  {"GotoFile"},
+		"gd":  {"GotoDefinition"},
+		"gp":  {"Plumb"},
+		"dif": {"DeleteInsideFunc"},
+		"daf": {"DeleteAroundFunc"},
+		"cif": {"ChangeInsideFunc"},
+		"caf": {"ChangeAroundFunc"},
+		"dib": {"DeleteInsideBlock"},
+		"dab": {"DeleteAroundBlock"},
+		"cib": {"ChangeInsideBlock"},
+		"cab": {"ChangeAroundBlock"},
+		"dic": {"DeleteInsideClass"},
+		"dac": {"DeleteAroundClass"},
+		"cic": {"ChangeInsideClass"},
+		"cac": {"ChangeAroundClass"},
+		"dit": {"DeleteInsideTag"},
+		"dat": {"DeleteAroundTag"},
+		"cit": {"ChangeInsideTag"},
+		"cat": {"ChangeAroundTag"},
+		"dip": {"DeleteInsideParagraph"},
+		"dap": {"DeleteAroundParagraph"},
+		"cip": {"ChangeInsideParagraph"},
+		"cap": {"ChangeAroundParagraph"},
+
+		"j": {"JoinLines"},
+
+		"dd": {"DeleteLine"},
+		"y":  {"YankLine"},
+		"x":  {"DeleteChar"},
+		"zx": {"ToggleCommentLine"},
+		"zz": {"CenterScreen"},
+
+		"cc": {"ChangeCharacter"},
+		"dc": {"DeleteChar"},
+		"C":  {"ChangeToEndOfLine"},
+		"D":  {"DeleteToEndOfLine"},
+
+		"d(": {"DeleteInsideParen"}, "c(": {"ChangeInsideParen"},
+		"d)": {"DeleteInsideParen"}, "c)": {"ChangeInsideParen"},
+		"di(": {"DeleteInsideParen"}, "da(": {"DeleteAroundParen"},
+		"ci(": {"ChangeInsideParen"}, "ca(": {"ChangeAroundParen"},
+		"di)": {"DeleteInsideParen"}, "da)": {"DeleteAroundParen"},
+		"ci)": {"ChangeInsideParen"}, "ca)": {"ChangeAroundParen"},
+
+		"[":  {"JumpToPrevEmptyLine"},
+		"d[": {"DeleteInsideBracket"}, "c[": {"ChangeInsideBracket"},
+		"di[": {"DeleteInsideBracket"}, "da[": {"DeleteAroundBracket"},
+		"ci[": {"ChangeInsideBracket"}, "ca[": {"ChangeAroundBracket"},
+
+		"{":  {"JumpToTop"},
+		"d{": {"DeleteInsideBrace"}, "c{": {"ChangeInsideBrace"},
+		"di{": {"DeleteInsideBrace"}, "da{": {"DeleteAroundBrace"},
+		"ci{": {"ChangeInsideBrace"}, "ca{": {"ChangeAroundBrace"},
+
+		"d'": {"DeleteInsideQuote"}, "c'": {"ChangeInsideQuote"},
+		"di'": {"DeleteInsideQuote"}, "da'": {"DeleteAroundQuote"},
+		"ci'": {"ChangeInsideQuote"}, "ca'": {"ChangeAroundQuote"},
+
+		"d\"": {"DeleteInsideDQuote"}, "c\"": {"ChangeInsideDQuote"},
+		"di\"": {"DeleteInsideDQuote"}, "da\"": {"DeleteAroundDQuote"},
+		"ci\"": {"ChangeInsideDQuote"}, "ca\"": {"ChangeAroundDQuote"},
+
+		"s": {"ChangeCharacter"},
+		"n": {"FindNext"},
+		"N": {"FindPrev"},
+		"u": {"Undo"},
+		"U": {"Redo"},
+		"p": {"PasteLine"},
+		"P": {"PasteLineAbove"},
+
+		"0": {"JumpToLineStart"},
+		".": {"RepeatLastChange"},
+		";": {"RepeatCharSearchForward"},
+		",": {"RepeatCharSearchBackward"},
+	},
+
+	ModeVisual:      visualFamilyKeymap(),
+	ModeVisualLine:  visualLineKeymap(),
+	ModeVisualBlock: visualBlockKeymap(),
+}
+
+// visualBaseKeymap holds the bindings shared by Visual, Visual Line, and
+// Visual Block mode (see handleVisualMode/handleVisualLineMode/
+// handleVisualBlockMode, which only differ in mode-switch keys and a
+// couple of block-only extras).
+func visualBaseKeymap() map[string][]string {
+	return map[string][]string{
+		"<Left>":  {"CursorLeft"},
+		"<Right>": {"CursorRight"},
+		"<Up>":    {"CursorUp"},
+		"<Down>":  {"CursorDown"},
+
+		"w":  {"MoveWordForward"},
+		"q":  {"MoveWordBackward"},
+		"zq": {"FormatText"},
+		"y":  {"YankSelection"},
+		"d":  {"DeleteSelection"},
+		"x":  {"DeleteSelection"},
+		"zx": {"CommentSelection"},
+		"c":  {"ChangeSelection"},
+		"Q":  {"JumpToFirstNonBlank"},
+		"W":  {"JumpToLineEnd"},
+		"~":  {"ToggleCaseSelection"},
+		"{":  {"JumpToTop"},
+		"}":  {"JumpToBottom"},
+		"[":  {"JumpToPrevEmptyLine"},
+		"]":  {"JumpToNextEmptyLine"},
+		"R":  {"StartReplaceMode"},
+		"p":  {"PasteSelection"},
+		"0":  {"JumpToLineStart"},
+		";":  {"RepeatCharSearchForward"},
+		",":  {"RepeatCharSearchBackward"},
+
+		"o":         {"SwapVisualAnchor"},
+		"<leader>o": {"OllamaComplete"},
+		"<leader>O": {"StartAITransformMode"},
+	}
+}
+
+func visualFamilyKeymap() map[string][]string {
+	km := visualBaseKeymap()
+	km["<leader>p"] = []string{"Plumb"}
+	km[":"] = []string{"EnterCommandFromVisual"}
+	km["V"] = []string{"SwitchToVisualLine"}
+	return km
+}
+
+func visualLineKeymap() map[string][]string {
+	km := visualBaseKeymap()
+	km["<leader>p"] = []string{"Plumb"}
+	km["v"] = []string{"SwitchToVisual"}
+	km["V"] = []string{"ExitVisualToNormal"}
+	return km
+}
+
+func visualBlockKeymap() map[string][]string {
+	km := visualBaseKeymap()
+	km["I"] = []string{"MaterializeBlockCursorsBefore"}
+	km["A"] = []string{"MaterializeBlockCursorsAfter"}
+	km["v"] = []string{"SwitchToVisual"}
+	km["V"] = []string{"SwitchToVisualLine"}
+	return km
+}
+
+// modeNames maps the mode names keybindings.json and `:bind` accept to
+// their Mode constant.
+var modeNames = map[string]Mode{
+	"normal":       ModeNormal,
+	"visual":       ModeVisual,
+	"visual-line":  ModeVisualLine,
+	"visual-block": ModeVisualBlock,
+}
+
+// Keymaps holds the resolved chord tables (defaults merged with user
+// overrides) that handleNormalMode and its Visual-mode siblings dispatch
+// through. Built once at startup by LoadKeybindings; mutated at runtime by
+// the :bind command.
+var Keymaps map[Mode]map[string][]string
+
+// keybindingsOverrides is the on-disk shape of keybindings.json: a flat map
+// from mode name to chord-sequence -> action-list overrides, merged over
+// defaultKeymaps. An entry replaces (rather than appends to) the default
+// binding for the same sequence.
+type keybindingsOverrides map[string]map[string][]string
+
+// qweKeybindingsPath returns the path to keybindings.json, next to
+// plumb.rules in $XDG_CONFIG_HOME/qwe (see plumbConfigDir).
+func qweKeybindingsPath() (string, error) {
+	dir, err := plumbConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "keybindings.json"), nil
+}
+
+// cloneKeymaps deep-copies a mode->sequence->actions table so overrides
+// can be merged in without mutating defaultKeymaps itself.
+func cloneKeymaps(src map[Mode]map[string][]string) map[Mode]map[string][]string {
+	dst := make(map[Mode]map[string][]string, len(src))
+	for mode, km := range src {
+		cp := make(map[string][]string, len(km))
+		for seq, actions := range km {
+			cp[seq] = append([]string{}, actions...)
+		}
+		dst[mode] = cp
+	}
+	return dst
+}
+
+// LoadKeybindings builds Keymaps from defaultKeymaps, then merges
+// keybindings.json on top if present. A missing or malformed file is not
+// an error: the editor just runs with the defaults, mirroring
+// LoadPlumbRules's treatment of a missing plumb.rules.
+func (e *Editor) LoadKeybindings() {
+	Keymaps = cloneKeymaps(defaultKeymaps)
+
+	path, err := qweKeybindingsPath()
+	if err != nil {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	var overrides keybindingsOverrides
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		e.addLog("Keybindings", fmt.Sprintf("ignoring malformed keybindings.json: %v", err))
+		return
+	}
+
+	for modeName, bindings := range overrides {
+		mode, ok := modeNames[modeName]
+		if !ok {
+			e.addLog("Keybindings", fmt.Sprintf("unknown mode %q in keybindings.json", modeName))
+			continue
+		}
+		if Keymaps[mode] == nil {
+			Keymaps[mode] = make(map[string][]string)
+		}
+		for seq, actions := range bindings {
+			Keymaps[mode][seq] = actions
+		}
+	}
+}
+
+// Bind adds or overrides one chord-sequence binding for mode at runtime,
+// implementing the `:bind <mode> <keys> <action...>` ex-command. Unlike
+// keybindings.json, which replaces a whole mode's table entry, this always
+// edits the live Keymaps in place, so it takes effect immediately.
+func (e *Editor) Bind(modeName, keys string, actions []string) error {
+	mode, ok := modeNames[modeName]
+	if !ok {
+		return fmt.Errorf("unknown mode %q (want one of: normal, visual, visual-line, visual-block)", modeName)
+	}
+	if keys == "" {
+		return fmt.Errorf("no key sequence given")
+	}
+	for _, name := range actions {
+		if _, ok := bindingActions[name]; !ok {
+			return fmt.Errorf("unknown action %q", name)
+		}
+	}
+
+	if Keymaps == nil {
+		Keymaps = cloneKeymaps(defaultKeymaps)
+	}
+	if Keymaps[mode] == nil {
+		Keymaps[mode] = make(map[string][]string)
+	}
+	Keymaps[mode][keys] = actions
+	return nil
+}
+
+// resolveChord looks seq (the chords typed so far) up in mode's keymap.
+// exact is true and actions non-nil on a full match; prefix is true if seq
+// is the strict prefix of some longer binding, meaning the caller should
+// keep accumulating instead of giving up.
+func resolveChord(mode Mode, seq []KeyChord) (actions []string, exact, prefix bool) {
+	km := Keymaps[mode]
+	if km == nil {
+		return nil, false, false
+	}
+	key := chordSeqString(seq)
+	if a, ok := km[key]; ok {
+		return a, true, false
+	}
+	for k := range km {
+		if len(k) > len(key) && strings.HasPrefix(k, key) {
+			prefix = true
+			break
+		}
+	}
+	return nil, false, prefix
+}
+
+// runActions executes a keymap entry's action list in order.
+func (e *Editor) runActions(names []string) {
+	for _, name := range names {
+		if fn, ok := bindingActions[name]; ok {
+			fn(e)
+		}
+	}
+}
+
+// LastChange is the most recent recordable change, replayed by the "."
+// binding. insertedText is filled in afterwards, by HandleEvents, if the
+// change left the editor in Insert mode (e.g. "ciw" followed by typing);
+// see the prevMode == ModeInsert transition in kevent.go, which fills this
+// in right next to the existing Registers.SetDot call for the "." register.
+type LastChange struct {
+	actionNames  []string
+	count        int
+	insertedText []rune
+}
+
+// runCountedActions runs actions e.pendingCount times (1 if no count was
+// given), resetting pendingCount for the next chord. A count only repeats
+// a Visual-mode binding if every action in it is a pure motion
+// (motionActionNames); otherwise it runs once, since Vim's counts multiply
+// distance moved, not how many times an edit is applied to a selection. If
+// actions contains a mutating action (changeActionNames), it's recorded as
+// e.lastChange for "." to replay later.
+func (e *Editor) runCountedActions(actions []string) {
+	count := e.pendingCount
+	e.pendingCount = 0
+	if count < 1 {
+		count = 1
+	}
+
+	for _, name := range actions {
+		if changeActionNames[name] {
+			e.lastChange = &LastChange{actionNames: append([]string{}, actions...), count: count}
+			break
+		}
+	}
+
+	repeat := count
+	if e.mode != ModeNormal {
+		for _, name := range actions {
+			if !motionActionNames[name] {
+				repeat = 1
+				break
+			}
+		}
+	}
+
+	for i := 0; i < repeat; i++ {
+		e.runActions(actions)
+	}
+}
+
+// repeatLastChange implements the "." binding: re-runs the last recorded
+// change e.lastChange.count times, retyping any text it inserted. A change
+// that never entered Insert mode (e.g. "dd") has no insertedText, so the
+// retyping loop below is a no-op for it.
+func (e *Editor) repeatLastChange() {
+	lc := e.lastChange
+	if lc == nil {
+		return
+	}
+
+	count := lc.count
+	if count < 1 {
+		count = 1
+	}
+	for i := 0; i < count; i++ {
+		e.runActions(lc.actionNames)
+		for _, r := range lc.insertedText {
+			if r == '\n' {
+				e.insertNewline()
+			} else {
+				e.insertRune(r)
+			}
+		}
+		if e.mode == ModeInsert {
+			e.mode = ModeNormal
+		}
+	}
+}
+
+// dispatchChord feeds one key event through e's chord registry for the
+// current mode: accumulates it onto e.pendingChords, runs the matched
+// action(s) on a complete sequence, or keeps waiting on a partial one. It
+// reports whether the event was consumed, so callers with additional
+// special-cased keys (e.g. the `"<reg>` prefix) can check those first.
+//
+// Before any of that, a leading run of digits is split off into
+// e.pendingCount instead of becoming part of the chord sequence, giving
+// every binding an implicit "Nx" count prefix (e.g. "5dd", "3w", "10x"). A
+// bare "0" with no count yet falls through as an ordinary chord, since
+// it's bound to JumpToLineStart, matching Vim's "0 moves to column 0,
+// unless it's continuing a count" rule.
+func (e *Editor) dispatchChord(ev termbox.Event) bool {
+	if len(e.pendingChords) == 0 && ev.Ch >= '0' && ev.Ch <= '9' && (ev.Ch != '0' || e.pendingCount > 0) {
+		e.pendingCount = e.pendingCount*10 + int(ev.Ch-'0')
+		return true
+	}
+
+	chord := chordFromEvent(ev)
+	if chord == "" {
+		e.pendingChords = nil
+		e.pendingCount = 0
+		e.disarmChordTimeout()
+		return false
+	}
+
+	e.pendingChords = append(e.pendingChords, chord)
+	if actions, exact, prefix := resolveChord(e.mode, e.pendingChords); exact {
+		e.disarmChordTimeout()
+		e.runCountedActions(actions)
+		e.pendingChords = nil
+		return true
+	} else if prefix {
+		e.armChordTimeout()
+		return true
+	}
+
+	// The accumulated sequence doesn't lead anywhere: drop it and retry
+	// with just the newest chord, so an abandoned prefix (e.g. "d" then an
+	// unbound key) doesn't swallow the next keystroke.
+	e.pendingChords = []KeyChord{chord}
+	if actions, exact, prefix := resolveChord(e.mode, e.pendingChords); exact {
+		e.disarmChordTimeout()
+		e.runCountedActions(actions)
+		e.pendingChords = nil
+	} else if prefix {
+		e.armChordTimeout()
+	} else {
+		e.pendingChords = nil
+		e.pendingCount = 0
+		e.disarmChordTimeout()
+	}
+	return true
+}
+
+// armChordTimeout (re)starts the Config.KeyTimeoutMs timer while
+// e.pendingChords is waiting on a prefix with no exact match yet, so an
+// abandoned chord (e.g. a lone "g" the user never follows up with "d"/"f"/
+// "g") doesn't leave the editor silently waiting forever. Firing wakes the
+// main loop via termbox.Interrupt(), the same pattern shell.go and
+// fuzzysearch.go use to report background state back to a render loop that
+// otherwise only wakes up on real input; resolveChordTimeout (kevent.go)
+// does the actual commit-or-abort once the resulting interrupt is handled.
+func (e *Editor) armChordTimeout() {
+	if e.chordTimer != nil {
+		e.chordTimer.Stop()
+	}
+	timeout := time.Duration(Config.KeyTimeoutMs) * time.Millisecond
+	e.chordTimer = time.AfterFunc(timeout, func() {
+		e.chordTimeoutFired = true
+		termbox.Interrupt()
+	})
+}
+
+// disarmChordTimeout cancels a pending chord timeout, e.g. once a sequence
+// resolves (or fails to resolve) before the timer ever fires.
+func (e *Editor) disarmChordTimeout() {
+	if e.chordTimer != nil {
+		e.chordTimer.Stop()
+		e.chordTimer = nil
+	}
+}
+
+// resolveChordTimeout is called from the main loop (kevent.go) when a chord
+// timeout interrupt arrives: e.pendingChords went unextended for
+// Config.KeyTimeoutMs, so it's committed if the sequence typed so far is
+// itself a complete binding, or abandoned otherwise.
+func (e *Editor) resolveChordTimeout() {
+	e.chordTimer = nil
+	if len(e.pendingChords) == 0 {
+		return
+	}
+	actions, exact, _ := resolveChord(e.mode, e.pendingChords)
+	e.pendingChords = nil
+	if exact {
+		e.runCountedActions(actions)
+	} else {
+		e.pendingCount = 0
+	}
+}
+
+// parseBindArgs splits a `:bind` ex-command argument string ("<mode> <keys>
+// <action> [action...]") into its parts.
+func parseBindArgs(args string) (mode, keys string, actions []string, err error) {
+	fields := strings.Fields(args)
+	if len(fields) < 3 {
+		return "", "", nil, fmt.Errorf("usage: bind <mode> <keys> <action> [action...]")
+	}
+	return fields[0], fields[1], fields[2:], nil
+}
+
+// quoteForDisplay is used by :bind's error messages when echoing back a
+// malformed key sequence.
+func quoteForDisplay(s string) string {
+	return strconv.Quote(s)
+}
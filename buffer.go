@@ -6,6 +6,8 @@ package main
 import (
 	"strings"
 	"time"
+
+	sitter "github.com/mitjafelicijan/go-tree-sitter"
 )
 
 // Cursor represents a position in the buffer.
@@ -15,10 +17,72 @@ type Cursor struct {
 	PreferredCol int // Remembers the intended column when moving up/down.
 }
 
-// HistoryState stores a snapshot of the buffer and cursors for undo/redo.
-type HistoryState struct {
-	buffer  [][]rune
-	cursors []Cursor
+// EditKind classifies what an Edit record represents. It doesn't affect how
+// undo/redo apply the edit (that only needs Old/New), but it's what a future
+// persistent-undo log would switch on, and it's used to decide whether
+// consecutive insert-mode edits coalesce.
+type EditKind int
+
+const (
+	EditInsert EditKind = iota
+	EditDelete
+	EditReplace
+)
+
+// Edit records one undoable change to a buffer: the half-open range
+// (Y1,X1)-(Y2,X2) that Old occupied before the edit, and New, the text that
+// took its place. Undo restores Old; redo re-applies New. CursorsBefore and
+// CursorsAfter are restored to match. timestamp records when the edit was
+// made, used by `:earlier`/`:later` to walk history by elapsed time rather
+// than by count.
+//
+// Many edits (deleteLine, duplicateLine, the paste family, JoinLines,
+// deleteVisualSelection, single-cursor insert-mode typing, ollamaComplete)
+// know their own range and New text up front, since they already go through
+// the TextStore methods in rope.go. Editor.saveState, still used by
+// mutating commands whose range isn't known ahead of time (and by
+// multi-cursor edits, whose per-cursor ranges can shift each other in ways
+// not worth capturing precisely), pushes a whole-buffer Edit with New left
+// uncaptured (captured == false); undo fills in New and CursorsAfter from
+// the live buffer the first time that entry is popped. See Editor.undo.
+//
+// group ties together the run of Edits pushed by one insert-mode session
+// (see Editor.beginInsertGroup) so undo/redo treat however many keystrokes
+// the user typed as one step, the same granularity saveState's old
+// whole-buffer-per-session snapshots gave. 0 means "standalone": every edit
+// pushed outside an insert-mode session uses it, and undo/redo never merge
+// a standalone entry with its neighbors even if they happen to share it.
+type Edit struct {
+	Kind                        EditKind
+	Y1, X1, Y2, X2              int
+	Old, New                    []rune
+	CursorsBefore, CursorsAfter []Cursor
+	captured                    bool
+	group                       int64
+	timestamp                   time.Time
+}
+
+// BufType classifies what kind of content a Buffer holds, mirroring the
+// model used by micro (https://github.com/zyedidia/micro). It governs
+// whether SaveFile will write the buffer and whether syntax/LSP
+// initialization should run for it.
+type BufType int
+
+const (
+	BufTypeDefault BufType = iota // A normal on-disk file buffer.
+	BufTypeHelp                   // Read-only built-in help text (:help).
+	BufTypeLog                    // Read-only append-only debug log (see Editor.LogBuf).
+	BufTypeScratch                // Writable in-memory buffer with no backing file.
+	BufTypeRaw                    // Read-only output of :! / :r! (see shell.go).
+)
+
+// setBufType assigns t and, for the read-only-by-nature types, forces
+// readOnly on so callers don't have to remember to set it themselves.
+func (b *Buffer) setBufType(t BufType) {
+	b.bufType = t
+	if t == BufTypeHelp || t == BufTypeLog {
+		b.readOnly = true
+	}
 }
 
 // Buffer represents an open file and its associated editor state.
@@ -30,13 +94,39 @@ type Buffer struct {
 	filename    string             // Path to the file on disk.
 	modified    bool               // True if changes haven't been saved.
 	readOnly    bool               // True if the buffer cannot be edited.
-	undoStack   []HistoryState     // For undo functionality.
-	redoStack   []HistoryState     // For redo functionality.
+	bufType     BufType            // What kind of content this buffer holds (Default/Help/Log/Scratch/Raw).
+	undoStack   []Edit             // For undo functionality.
+	redoStack   []Edit             // For redo functionality.
 	fileType    *FileType          // Language-specific settings.
 	lspClient   *LSPClient         // Associated LSP client for this buffer.
 	diagnostics []Diagnostic       // Errors/warnings for this buffer.
 	syntax      *SyntaxHighlighter // Syntax highlighting engine.
 	lastModTime time.Time          // Last modified time of the file on disk.
+	encoding    string             // Canonical charset name (e.g. "utf-8", "shift_jis"); set on load, overridable via :set fenc=.
+	lineEnding  LineEnding         // Newline convention detected on load; overridable via :set ff=.
+
+	// syntaxBytes caches the byte encoding toString() would produce, kept in
+	// sync by syntaxParse/syntaxReparse (the full-rebuild call sites) and
+	// patched incrementally by handleEdit so a keystroke re-encodes only the
+	// line(s) it touched instead of the whole buffer. nil means stale/unset;
+	// the next handleEdit or syntaxParse/syntaxReparse call rebuilds it.
+	syntaxBytes []byte
+
+	// searchMatches caches hlsearch results per buffer line, keyed by line
+	// index. A missing key means "not computed yet". The whole cache is
+	// dropped on any edit (see Editor.markModified) rather than tracking
+	// exactly which lines changed, since edits in this codebase already
+	// funnel through that one choke point.
+	searchMatches map[int][]MatchRange
+
+	// ghostText is the pending, unaccepted inline AI suggestion rendered to
+	// the right of the cursor (see ghosttext.go); ghostY/ghostX record the
+	// cursor position it was generated for, so a suggestion left over from
+	// before the cursor moved is recognized as stale instead of rendered in
+	// the wrong place. Never part of buffer, undo, or LSP content.
+	ghostText []rune
+	ghostY    int
+	ghostX    int
 }
 
 // PrimaryCursor returns the first cursor in the list.
@@ -61,6 +151,42 @@ func (b *Buffer) ClearCursors() {
 	}
 }
 
+// removeCursorAt deletes the cursor at (y, x), if one is there. Used by
+// skipMultiCursor (see multicursor.go) to drop the cursor it's about to
+// relocate; a no-op if mergeCursors already folded it into a neighbour.
+func (b *Buffer) removeCursorAt(y, x int) {
+	for i, c := range b.cursors {
+		if c.Y == y && c.X == x {
+			b.cursors = append(b.cursors[:i], b.cursors[i+1:]...)
+			return
+		}
+	}
+}
+
+// pushEdit records ed as the most recent undoable change, capping the undo
+// stack at 100 entries (same limit the old whole-buffer snapshots used) and
+// clearing the redo stack, since ed supersedes whatever used to be redoable.
+func (b *Buffer) pushEdit(ed Edit) {
+	b.undoStack = append(b.undoStack, ed)
+	if len(b.undoStack) > 100 {
+		b.undoStack = b.undoStack[1:]
+	}
+	b.redoStack = nil
+}
+
+// rangeEnd returns the (row, col) immediately after text has been inserted
+// starting at (y, x), i.e. the end of the half-open range text would occupy.
+func rangeEnd(y, x int, text []rune) (int, int) {
+	if len(text) == 0 {
+		return y, x
+	}
+	lines := splitRuneLines(text)
+	if len(lines) == 1 {
+		return y, x + len(lines[0])
+	}
+	return y + len(lines) - 1, len(lines[len(lines)-1])
+}
+
 // getLineByteOffset calculates the byte index for a given column in a line of
 // runes.
 func (b *Buffer) getLineByteOffset(line []rune, col int) uint32 {
@@ -97,12 +223,128 @@ func (b *Buffer) toString() string {
 	return result.String()
 }
 
-// handleEdit is a placeholder for incremental syntax highlighting updates.
+// syntaxParse feeds the buffer's current content to syntax.Parse, the
+// full-rebuild path used by every mutation that doesn't go through
+// handleEdit (paste, multi-cursor edits, regex replace, external edits,
+// ...). It also refreshes syntaxBytes, so the very next handleEdit call can
+// resume patching incrementally instead of paying for another full
+// toString() rebuild on the first keystroke after a bulk change.
+func (b *Buffer) syntaxParse() {
+	if b.syntax == nil {
+		return
+	}
+	content := []byte(b.toString())
+	b.syntaxBytes = content
+	b.syntax.Parse(content)
+}
+
+// syntaxReparse is syntaxParse's counterpart for SyntaxHighlighter.Reparse.
+func (b *Buffer) syntaxReparse() {
+	if b.syntax == nil {
+		return
+	}
+	content := []byte(b.toString())
+	b.syntaxBytes = content
+	b.syntax.Reparse(content)
+}
+
+// bytesOfRange returns the byte encoding of buffer lines [startRow, endRow],
+// joined with '\n', restricted to [startColBytes, endColBytes) on the first
+// and last line respectively - the same encoding toString would produce for
+// that span, without paying to re-encode anything outside it. Columns are
+// byte offsets (as produced by getLineByteOffset), matching the edit
+// boundaries handleEdit already computes.
+func (b *Buffer) bytesOfRange(startRow int, startColBytes uint32, endRow int, endColBytes uint32) []byte {
+	var result []byte
+	for row := startRow; row <= endRow && row < len(b.buffer); row++ {
+		line := []byte(string(b.buffer[row]))
+		lo, hi := 0, len(line)
+		if row == startRow {
+			lo = int(startColBytes)
+		}
+		if row == endRow {
+			hi = int(endColBytes)
+		}
+		if lo > len(line) {
+			lo = len(line)
+		}
+		if hi > len(line) {
+			hi = len(line)
+		}
+		if lo < hi {
+			result = append(result, line[lo:hi]...)
+		}
+		if row < endRow {
+			result = append(result, '\n')
+		}
+	}
+	return result
+}
+
+// patchSyntaxBytes returns the buffer's full byte content after the edit
+// handleEdit just translated, reusing syntaxBytes (the cached result of the
+// last full rebuild or patch) rather than re-encoding every line through
+// toString. Only the edited span itself - already known precisely from
+// handleEdit's byte offsets - is re-encoded via bytesOfRange; the unedited
+// prefix and suffix are copied as raw bytes, skipping the []rune-to-string
+// conversion toString would otherwise redo for the entire file on every
+// keystroke. The result still costs a copy the length of the buffer (a
+// genuine O(edit-size) patch would need a rope/piece table, which rope.go's
+// TextStore deliberately defers - see its doc comment), but it removes the
+// redundant per-line UTF-8 re-encoding that toString paid for every
+// unchanged line.
+//
+// Falls back to a full rebuild (and reprimes the cache) if syntaxBytes is
+// unset, or if it's shorter than this edit's start implies - which
+// shouldn't happen as long as every mutation path keeps it in sync via
+// syntaxParse/syntaxReparse, but silently patching out of range would feed
+// the parser corrupt input, so treat it as "stale" instead.
+func (b *Buffer) patchSyntaxBytes(startIndex, bytesRemoved uint32, startRow int, startColBytes uint32, newEndRow int, newEndColBytes uint32) []byte {
+	old := b.syntaxBytes
+	if old == nil || int(startIndex+bytesRemoved) > len(old) {
+		content := []byte(b.toString())
+		b.syntaxBytes = content
+		return content
+	}
+
+	newSpan := b.bytesOfRange(startRow, startColBytes, newEndRow, newEndColBytes)
+
+	patched := make([]byte, 0, len(old)-int(bytesRemoved)+len(newSpan))
+	patched = append(patched, old[:startIndex]...)
+	patched = append(patched, newSpan...)
+	patched = append(patched, old[startIndex+bytesRemoved:]...)
+
+	b.syntaxBytes = patched
+	return patched
+}
+
+// handleEdit translates one buffer mutation into a tree-sitter EditInput and
+// feeds it to the incremental parser (see SyntaxHighlighter.Edit), so normal
+// typing only reparses the edited region instead of the whole buffer. It
+// must be called after b.buffer already reflects the edit (callers pass
+// oldEndColBytes/newEndColBytes as the affected line's byte offsets before
+// and after the change), since StartIndex/StartPoint are derived from the
+// current buffer content up to (startRow, startCol).
 func (b *Buffer) handleEdit(startRow, startCol int, bytesRemoved, bytesAdded uint32, oldEndRow int, oldEndColBytes uint32, newEndRow int, newEndColBytes uint32) {
 	if b.syntax == nil {
 		return
 	}
 
-	// We batch syntax updates in editor.go via Reparse, so we don't need
-	// incremental updates here.
+	var startColBytes uint32
+	if startRow < len(b.buffer) {
+		startColBytes = b.getLineByteOffset(b.buffer[startRow], startCol)
+	}
+	startIndex := b.getByteOffset(startRow, startCol)
+
+	edit := sitter.EditInput{
+		StartIndex:  startIndex,
+		OldEndIndex: startIndex + bytesRemoved,
+		NewEndIndex: startIndex + bytesAdded,
+		StartPoint:  sitter.Point{Row: uint32(startRow), Column: startColBytes},
+		OldEndPoint: sitter.Point{Row: uint32(oldEndRow), Column: oldEndColBytes},
+		NewEndPoint: sitter.Point{Row: uint32(newEndRow), Column: newEndColBytes},
+	}
+
+	content := b.patchSyntaxBytes(startIndex, bytesRemoved, startRow, startColBytes, newEndRow, newEndColBytes)
+	b.syntax.Edit(edit, content)
 }
@@ -0,0 +1,271 @@
+package main
+
+// In-line character search: "f<char>"/"F<char>" jump to the next/previous
+// occurrence of <char> on the current line, "t<char>"/"T<char>" stop just
+// before/after it, and ";"/"," repeat the last one forward/reversed. Like
+// the text objects in textobjects.go, these compose with the d/c/y
+// operators ("dfx", "ct)") and with a leading or operator-embedded count
+// ("d2fx"); unlike text objects they read an extra, arbitrary target
+// character, so they can't be static bindings.go chord-table entries and
+// are instead handled as a small prefix state machine in handleNormalMode
+// and the Visual-mode handlers (kevent.go), mirroring the existing
+// `"<reg>`/`m<reg>`/`@<reg>` prefixes there.
+
+import "github.com/nsf/termbox-go"
+
+// charSearchState is in progress once f/F/t/T (and its operator and count,
+// if any) has been typed; the next keystroke supplies its target character.
+type charSearchState struct {
+	operator rune // 0, or 'd'/'c'/'y' if this motion completes an operator.
+	kind     rune // 'f', 'F', 't', or 'T'.
+	count    int  // 0 means "no count given", same convention as pendingCount.
+}
+
+// charSearch is the last completed f/F/t/T, replayed by ";" (same
+// direction) and "," (reversed).
+type charSearch struct {
+	kind   rune
+	target rune
+}
+
+// findCharInLine returns the column of the count-th occurrence of target
+// strictly after (forward) or before (!forward) x on line, or ok=false if
+// line doesn't contain that many.
+func findCharInLine(line []rune, x int, target rune, forward bool, count int) (col int, ok bool) {
+	found := 0
+	if forward {
+		for i := x + 1; i < len(line); i++ {
+			if line[i] == target {
+				found++
+				if found == count {
+					return i, true
+				}
+			}
+		}
+		return 0, false
+	}
+	for i := x - 1; i >= 0; i-- {
+		if line[i] == target {
+			found++
+			if found == count {
+				return i, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// charMotionColumn resolves kind/target/count to the destination column on
+// line for a cursor at x: the matched occurrence itself for f/F, or the
+// column just short of it for t/T.
+func charMotionColumn(line []rune, x int, kind, target rune, count int) (col int, ok bool) {
+	switch kind {
+	case 'f':
+		return findCharInLine(line, x, target, true, count)
+	case 'F':
+		return findCharInLine(line, x, target, false, count)
+	case 't':
+		col, ok := findCharInLine(line, x, target, true, count)
+		if !ok || col == 0 {
+			return 0, false
+		}
+		return col - 1, true
+	case 'T':
+		col, ok := findCharInLine(line, x, target, false, count)
+		if !ok {
+			return 0, false
+		}
+		return col + 1, true
+	}
+	return 0, false
+}
+
+// invertCharSearchKind maps f<->F and t<->T, for ",".
+func invertCharSearchKind(kind rune) rune {
+	switch kind {
+	case 'f':
+		return 'F'
+	case 'F':
+		return 'f'
+	case 't':
+		return 'T'
+	case 'T':
+		return 't'
+	}
+	return kind
+}
+
+// moveToChar is the bare (operator-less) f/F/t/T motion: it just moves
+// every cursor to its own line's target column, which is all a Visual-mode
+// use of f/F/t/T needs to extend the selection.
+func (e *Editor) moveToChar(kind, target rune, count int) {
+	b := e.activeBuffer()
+	if b == nil {
+		return
+	}
+	if count < 1 {
+		count = 1
+	}
+	for i := range b.cursors {
+		c := &b.cursors[i]
+		if c.Y >= len(b.buffer) {
+			continue
+		}
+		if col, ok := charMotionColumn(b.buffer[c.Y], c.X, kind, target, count); ok {
+			c.X = col
+		}
+	}
+}
+
+// charSearchRange resolves kind/target/count to the inclusive [lo, hi]
+// column range an operator should act on, starting from column x on line.
+func charSearchRange(line []rune, x int, kind, target rune, count int) (lo, hi int, ok bool) {
+	dest, ok := charMotionColumn(line, x, kind, target, count)
+	if !ok {
+		return 0, 0, false
+	}
+	if kind == 'F' || kind == 'T' {
+		return dest, x - 1, true
+	}
+	return x, dest, true
+}
+
+// deleteCharSearch deletes the range a d/c-operator's f/F/t/T motion spans
+// on each cursor's own line, and reports whether anything was deleted (so
+// changeCharSearch knows whether to enter Insert mode).
+func (e *Editor) deleteCharSearch(kind, target rune, count int) bool {
+	b := e.activeBuffer()
+	if b == nil {
+		return false
+	}
+	if b.readOnly {
+		e.setMessage("File is read-only")
+		return false
+	}
+	if count < 1 {
+		count = 1
+	}
+
+	changed := false
+	cursors := e.getSortedCursorsDesc()
+	for _, c := range cursors {
+		if c.Y >= len(b.buffer) {
+			continue
+		}
+		line := b.buffer[c.Y]
+		lo, hi, ok := charSearchRange(line, c.X, kind, target, count)
+		if !ok || hi < lo {
+			continue
+		}
+
+		if c == b.PrimaryCursor() {
+			e.deleteToRegister(line[lo:hi+1], RegCharwise)
+		}
+
+		deletedBytes := uint32(len(string(line[lo : hi+1])))
+		newLine := append(append([]rune{}, line[:lo]...), line[hi+1:]...)
+		b.buffer[c.Y] = newLine
+		b.adjustCursorsForEdit(c, c.Y, lo, -(hi-lo+1), 0)
+		c.X = lo
+
+		if b.syntax != nil {
+			oldColBytes := b.getLineByteOffset(line, lo)
+			newColBytes := b.getLineByteOffset(newLine, lo)
+			b.handleEdit(c.Y, lo, deletedBytes, 0, c.Y, oldColBytes+deletedBytes, c.Y, newColBytes)
+		}
+		changed = true
+	}
+
+	if changed {
+		e.markModified()
+		e.mergeCursors()
+	}
+	return changed
+}
+
+// yankCharSearch yanks (without deleting) the range a y-operator's
+// f/F/t/T motion spans, on the primary cursor's line only, matching
+// yankLine's primary-cursor-only convention.
+func (e *Editor) yankCharSearch(kind, target rune, count int) {
+	b := e.activeBuffer()
+	if b == nil {
+		return
+	}
+	if count < 1 {
+		count = 1
+	}
+
+	c := b.PrimaryCursor()
+	if c.Y >= len(b.buffer) {
+		return
+	}
+	line := b.buffer[c.Y]
+	lo, hi, ok := charSearchRange(line, c.X, kind, target, count)
+	if !ok || hi < lo {
+		return
+	}
+	e.yankToRegister(line[lo:hi+1], RegCharwise)
+}
+
+// runCharSearch executes a finished f/F/t/T motion: a bare cursor jump if
+// operator is 0, or the matching delete/change/yank if it completes an
+// operator (e.g. "dfx", "ct)", "d2fx"). It always records (kind, target) as
+// the last search, so ";"/"," have something to repeat even when f/F/t/T
+// was used as an operator's motion.
+func (e *Editor) runCharSearch(operator, kind, target rune, count int) {
+	e.lastCharSearch = &charSearch{kind: kind, target: target}
+
+	switch operator {
+	case 'd':
+		e.saveState()
+		e.deleteCharSearch(kind, target, count)
+		e.checkDiagnostics()
+	case 'c':
+		e.saveState()
+		if e.deleteCharSearch(kind, target, count) {
+			e.mode = ModeInsert
+		}
+		e.checkDiagnostics()
+	case 'y':
+		e.yankCharSearch(kind, target, count)
+	default:
+		e.moveToChar(kind, target, count)
+	}
+}
+
+// repeatCharSearch implements ";" (reverse=false) and "," (reverse=true):
+// replay the last f/F/t/T as a bare motion, inverting its direction for ",".
+func (e *Editor) repeatCharSearch(reverse bool) {
+	last := e.lastCharSearch
+	if last == nil {
+		return
+	}
+	kind := last.kind
+	if reverse {
+		kind = invertCharSearchKind(kind)
+	}
+	e.moveToChar(kind, last.target, 1)
+}
+
+// dispatchCharSearchPrefix handles the bare (operator-less) f/F/t/T
+// trigger and the "read the target character" continuation, shared by
+// Normal and all three Visual-mode handlers. Normal mode additionally
+// recognizes the operator-composed form (see handleNormalMode) before
+// falling back to this. It reports whether ev was consumed.
+func (e *Editor) dispatchCharSearchPrefix(ev termbox.Event) bool {
+	if e.pendingCharSearch != nil {
+		cs := e.pendingCharSearch
+		e.pendingCharSearch = nil
+		e.runCharSearch(cs.operator, cs.kind, ev.Ch, cs.count)
+		return true
+	}
+	if len(e.pendingChords) == 0 {
+		switch ev.Ch {
+		case 'f', 'F', 't', 'T':
+			e.pendingCharSearch = &charSearchState{kind: ev.Ch, count: e.pendingCount}
+			e.pendingCount = 0
+			return true
+		}
+	}
+	return false
+}
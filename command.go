@@ -7,9 +7,9 @@ import (
 	"bufio"
 	"fmt"
 	"os"
-	"os/exec"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/nsf/termbox-go"
 )
@@ -34,7 +34,12 @@ func (ch *Command) IsValidCommand(cmd string) bool {
 
 	// Valid if it's a known command
 	switch cmd {
-	case "q", "Q", "q!", "Q!", "w", "W", "wa", "WA", "wq", "WQ", "waq", "WAQ", "reload", "bd", "bd!", "debug", "help", "mouse", "e", "edit", "n":
+	case "q", "Q", "q!", "Q!", "w", "W", "wa", "WA", "wq", "WQ", "waq", "WAQ", "reload", "bd", "bd!", "debug", "help", "mouse", "e", "edit", "edit!", "n", "history", "history clear", "messages", "messages clear", "set hlsearch", "nohlsearch", "dirs", "tags", "jumps", "reg", "ts-scopes", "codeaction":
+		return true
+	}
+
+	// Valid if it's a :rename command with a new name.
+	if strings.HasPrefix(cmd, "rename ") {
 		return true
 	}
 
@@ -48,6 +53,41 @@ func (ch *Command) IsValidCommand(cmd string) bool {
 		return true
 	}
 
+	// Valid if it starts with "buffer " (switch to buffer by name)
+	if strings.HasPrefix(cmd, "buffer ") {
+		return true
+	}
+
+	// Valid if it's a :set fenc= or :set ff= assignment
+	if strings.HasPrefix(cmd, "set fenc=") || strings.HasPrefix(cmd, "set ff=") {
+		return true
+	}
+
+	// Valid if it's a :set background= assignment
+	if strings.HasPrefix(cmd, "set background=") {
+		return true
+	}
+
+	// Valid if it's a :earlier/:later time-travel command
+	if strings.HasPrefix(cmd, "earlier ") || strings.HasPrefix(cmd, "later ") {
+		return true
+	}
+
+	// Valid if it's a :bind keymap override
+	if strings.HasPrefix(cmd, "bind ") {
+		return true
+	}
+
+	// Valid if it's a :theme switch
+	if strings.HasPrefix(cmd, "theme ") {
+		return true
+	}
+
+	// Valid if it's a :project-replace invocation or its apply step.
+	if strings.HasPrefix(cmd, "project-replace ") || cmd == "project-replace-apply" {
+		return true
+	}
+
 	// Everything else is considered invalid (will show "Command not found" message)
 	return false
 }
@@ -100,9 +140,107 @@ func (ch *Command) NavigateHistoryDown() {
 	}
 }
 
+// StartHistorySearch enters reverse-incremental search mode (Ctrl-R), similar
+// to bash/readline. The current command buffer is saved so Esc can restore it.
+func (ch *Command) StartHistorySearch() {
+	ch.e.historySearchActive = true
+	ch.e.historySearchQuery = []rune{}
+	ch.e.historySearchIndex = -1
+	ch.e.historySearchSaved = append([]rune{}, ch.e.commandBuffer...)
+}
+
+// historyMatches reports whether a history entry matches the current query
+// under the active filter mode (substring vs prefix).
+func (ch *Command) historyMatches(entry string) bool {
+	query := string(ch.e.historySearchQuery)
+	if query == "" {
+		return false
+	}
+	if ch.e.historySearchPrefixMode {
+		return strings.HasPrefix(entry, query)
+	}
+	return strings.Contains(entry, query)
+}
+
+// StepHistorySearch walks the history looking for the next match relative to
+// the current one. reverse=true (Ctrl-R) walks toward older entries, false
+// (Ctrl-S) walks toward newer ones.
+func (ch *Command) StepHistorySearch(reverse bool) {
+	if len(ch.e.historySearchQuery) == 0 {
+		// Nothing typed yet: fall back to stepping through everything, oldest first.
+		ch.e.historySearchIndex = -1
+	}
+
+	start := ch.e.historySearchIndex
+	if reverse {
+		if start == -1 {
+			start = len(ch.e.commandHistory)
+		}
+		for i := start - 1; i >= 0; i-- {
+			if ch.historyMatches(ch.e.commandHistory[i]) {
+				ch.e.historySearchIndex = i
+				ch.e.commandBuffer = []rune(ch.e.commandHistory[i])
+				return
+			}
+		}
+	} else {
+		for i := start + 1; i < len(ch.e.commandHistory); i++ {
+			if ch.historyMatches(ch.e.commandHistory[i]) {
+				ch.e.historySearchIndex = i
+				ch.e.commandBuffer = []rune(ch.e.commandHistory[i])
+				return
+			}
+		}
+		// Ran out of newer matches: go back to the saved, un-searched buffer.
+		ch.e.historySearchIndex = -1
+		ch.e.commandBuffer = append([]rune{}, ch.e.historySearchSaved...)
+	}
+}
+
+// RefreshHistorySearch re-matches the query from the newest history entry,
+// called whenever the query text changes.
+func (ch *Command) RefreshHistorySearch() {
+	ch.e.historySearchIndex = -1
+	ch.StepHistorySearch(true)
+}
+
+// ToggleHistorySearchMode flips between substring ("contains") and prefix
+// matching, mirroring the hs9001 style mode selector (Ctrl-T).
+func (ch *Command) ToggleHistorySearchMode() {
+	ch.e.historySearchPrefixMode = !ch.e.historySearchPrefixMode
+	ch.RefreshHistorySearch()
+}
+
+// AcceptHistorySearch executes the currently matched command and leaves search mode.
+func (ch *Command) AcceptHistorySearch() {
+	ch.e.historySearchActive = false
+	ch.e.commandHistoryIdx = -1
+	cmd := string(ch.e.commandBuffer)
+	ch.HandleAndSaveToHistory(cmd)
+}
+
+// CancelHistorySearch restores the original command buffer and leaves search mode.
+func (ch *Command) CancelHistorySearch() {
+	ch.e.historySearchActive = false
+	ch.e.commandBuffer = ch.e.historySearchSaved
+	ch.e.commandCursorX = len(ch.e.commandBuffer)
+	ch.e.historySearchIndex = -1
+}
+
 // Handle parses and executes a command string.
 func (ch *Command) Handle(cmd string) {
 	cmd = strings.TrimSpace(cmd)
+
+	// Ranged ex commands (10,20d, %s/.../.../, '<,'>y, :g/pat/cmd, ...) are
+	// tried before the fixed command set below.
+	if ch.HandleRanged(cmd) {
+		if ch.e.mode == ModeCommand {
+			ch.e.mode = ModeNormal
+		}
+		ch.e.commandBuffer = []rune{}
+		return
+	}
+
 	switch {
 	case cmd == "q" || cmd == "Q":
 		ch.quit(false)
@@ -145,18 +283,16 @@ func (ch *Command) Handle(cmd string) {
 		// Load help content from the embedded filesystem.
 		f, err := ContentFS.Open("content/help.txt")
 		if err != nil {
-			ch.e.message = fmt.Sprintf("Error opening help: %v", err)
+			ch.e.setMessage(fmt.Sprintf("Error opening help: %v", err))
 		} else {
 			defer f.Close()
-			err = ch.e.LoadFromReader("help.txt", f)
+			err = ch.e.LoadFromReaderAs("help.txt", f, BufTypeHelp)
 			if err != nil {
-				ch.e.message = fmt.Sprintf("Error loading help: %v", err)
+				ch.e.setMessage(fmt.Sprintf("Error loading help: %v", err))
 			} else {
-				// Help is read-only to prevent accidental edits.
-				b := ch.e.activeBuffer()
-				if b != nil {
-					b.readOnly = true
-					ch.e.message = "Help opened (Read-Only)"
+				// BufTypeHelp forces readOnly; just report it.
+				if ch.e.activeBuffer() != nil {
+					ch.e.setMessage("Help opened (Read-Only)")
 				}
 			}
 		}
@@ -172,15 +308,68 @@ func (ch *Command) Handle(cmd string) {
 		if filename != "" {
 			err := ch.e.LoadFile(filename)
 			if err != nil {
-				ch.e.message = fmt.Sprintf("Error opening file: %v", err)
+				ch.e.setMessage(fmt.Sprintf("Error opening file: %v", err))
 			} else {
-				ch.e.message = fmt.Sprintf("Opened: %s", filename)
+				ch.e.setMessage(fmt.Sprintf("Opened: %s", filename))
 			}
 		} else {
-			ch.e.message = "No filename specified"
+			ch.e.setMessage("No filename specified")
 		}
 	case cmd == "e" || cmd == "edit":
-		ch.e.message = "No filename specified"
+		ch.e.setMessage("No filename specified")
+	case cmd == "edit!":
+		ch.e.OpenInExternalEditor()
+	case cmd == "history":
+		ch.historyCommand("")
+	case cmd == "history clear":
+		ch.historyCommand("clear")
+	case cmd == "messages":
+		ch.messagesCommand("")
+	case cmd == "messages clear":
+		ch.messagesCommand("clear")
+	case cmd == "dirs":
+		ch.e.startDirectoryFuzzyFinder()
+	case cmd == "tags":
+		ch.e.startTagFuzzyFinder()
+	case cmd == "jumps":
+		ch.jumpsCommand()
+	case cmd == "reg":
+		ch.regCommand()
+	case cmd == "ts-scopes":
+		ch.tsScopesCommand()
+	case cmd == "codeaction":
+		ch.e.StartCodeAction()
+	case strings.HasPrefix(cmd, "rename "):
+		ch.e.RenameSymbol(strings.TrimSpace(strings.TrimPrefix(cmd, "rename ")))
+	case strings.HasPrefix(cmd, "buffer "):
+		ch.switchBuffer(strings.TrimPrefix(cmd, "buffer "))
+	case cmd == "set hlsearch":
+		ch.e.hlsearch = !ch.e.hlsearch
+		if ch.e.hlsearch {
+			ch.e.setMessage("hlsearch enabled")
+		} else {
+			ch.e.setMessage("hlsearch disabled")
+		}
+	case cmd == "nohlsearch":
+		ch.e.UnhighlightSearch()
+	case strings.HasPrefix(cmd, "set fenc="):
+		ch.setFileEncoding(strings.TrimPrefix(cmd, "set fenc="))
+	case strings.HasPrefix(cmd, "set ff="):
+		ch.setFileFormat(strings.TrimPrefix(cmd, "set ff="))
+	case strings.HasPrefix(cmd, "set background="):
+		ch.setBackgroundCommand(strings.TrimPrefix(cmd, "set background="))
+	case strings.HasPrefix(cmd, "earlier "):
+		ch.earlier(strings.TrimSpace(strings.TrimPrefix(cmd, "earlier ")))
+	case strings.HasPrefix(cmd, "later "):
+		ch.later(strings.TrimSpace(strings.TrimPrefix(cmd, "later ")))
+	case strings.HasPrefix(cmd, "bind "):
+		ch.bindCommand(strings.TrimPrefix(cmd, "bind "))
+	case strings.HasPrefix(cmd, "theme "):
+		ch.themeCommand(strings.TrimPrefix(cmd, "theme "))
+	case strings.HasPrefix(cmd, "project-replace "):
+		ch.projectReplaceCommand(strings.TrimPrefix(cmd, "project-replace "))
+	case cmd == "project-replace-apply":
+		ch.projectReplaceApplyCommand()
 	default:
 		if cmd == "" {
 			break
@@ -201,7 +390,7 @@ func (ch *Command) Handle(cmd string) {
 		if lineNum, err := strconv.Atoi(cmd); err == nil {
 			ch.goToLine(lineNum)
 		} else {
-			ch.e.message = fmt.Sprintf("Command not found: %s", cmd)
+			ch.e.setMessage(fmt.Sprintf("Command not found: %s", cmd))
 		}
 	}
 	// After executing a command, return to Normal mode and clear the command buffer.
@@ -217,11 +406,14 @@ func (ch *Command) quit(force bool) {
 		// Check if any buffer has unsaved changes
 		for _, b := range ch.e.buffers {
 			if b.modified {
-				ch.e.message = "No write since last change (use :q! to override)"
+				ch.e.setMessage("No write since last change (use :q! to override)")
 				return
 			}
 		}
 	}
+	ch.e.FlushHistories()
+	ch.e.FlushJumplist()
+	ch.e.ShutdownAllLSPClients()
 	termbox.Close()
 	os.Exit(0)
 }
@@ -239,29 +431,29 @@ func (ch *Command) write(filename string) {
 	if err != nil {
 		// Handle conflict if the file was changed externally.
 		if err.Error() == "file changed on disk" {
-			ch.e.message = "File changed on disk. Overwrite? (y/n) "
+			ch.e.setMessage("File changed on disk. Overwrite? (y/n) ")
 			ch.e.mode = ModeConfirm
 			ch.e.pendingConfirm = func() {
 				err := ch.e.SaveFile(true) // Force overwrite.
 				if err != nil {
-					ch.e.message = err.Error()
+					ch.e.setMessage(err.Error())
 				} else {
 					name := ch.e.activeBuffer().filename
 					if name == "" {
 						name = "[No Name]"
 					}
-					ch.e.message = fmt.Sprintf("\"%s\" written", name)
+					ch.e.setMessage(fmt.Sprintf("\"%s\" written", name))
 				}
 			}
 		} else {
-			ch.e.message = err.Error()
+			ch.e.setMessage(err.Error())
 		}
 	} else {
 		name := ch.e.activeBuffer().filename
 		if name == "" {
 			name = "[No Name]"
 		}
-		ch.e.message = fmt.Sprintf("\"%s\" written", name)
+		ch.e.setMessage(fmt.Sprintf("\"%s\" written", name))
 	}
 }
 
@@ -270,21 +462,27 @@ func (ch *Command) writeQuit() {
 	err := ch.e.SaveFile(false)
 	if err != nil {
 		if err.Error() == "file changed on disk" {
-			ch.e.message = "File changed on disk. Overwrite? (y/n) "
+			ch.e.setMessage("File changed on disk. Overwrite? (y/n) ")
 			ch.e.mode = ModeConfirm
 			ch.e.pendingConfirm = func() {
 				err := ch.e.SaveFile(true)
 				if err == nil {
+					ch.e.FlushHistories()
+					ch.e.FlushJumplist()
+					ch.e.ShutdownAllLSPClients()
 					termbox.Close()
 					os.Exit(0)
 				} else {
-					ch.e.message = err.Error()
+					ch.e.setMessage(err.Error())
 				}
 			}
 		} else {
-			ch.e.message = err.Error()
+			ch.e.setMessage(err.Error())
 		}
 	} else {
+		ch.e.FlushHistories()
+		ch.e.FlushJumplist()
+		ch.e.ShutdownAllLSPClients()
 		termbox.Close()
 		os.Exit(0)
 	}
@@ -350,13 +548,13 @@ func (ch *Command) writeAll() {
 
 	// Display appropriate message.
 	if lastErr != nil {
-		ch.e.message = fmt.Sprintf("Error saving some files: %v", lastErr)
+		ch.e.setMessage(fmt.Sprintf("Error saving some files: %v", lastErr))
 	} else if savedCount == 0 {
-		ch.e.message = "No files to save"
+		ch.e.setMessage("No files to save")
 	} else if savedCount == 1 {
-		ch.e.message = "1 file written"
+		ch.e.setMessage("1 file written")
 	} else {
-		ch.e.message = fmt.Sprintf("%d files written", savedCount)
+		ch.e.setMessage(fmt.Sprintf("%d files written", savedCount))
 	}
 }
 
@@ -364,175 +562,204 @@ func (ch *Command) writeAll() {
 func (ch *Command) bufferDelete(force bool) {
 	b := ch.e.activeBuffer()
 	if !force && b != nil && b.modified {
-		ch.e.message = "No write since last change (use :bd! to override)"
+		ch.e.setMessage("No write since last change (use :bd! to override)")
 		return
 	}
 	ch.e.deleteCurrentBuffer()
 }
 
-// toggleMouse enables/disables mouse interaction in the terminal.
-func (ch *Command) toggleMouse() {
-	ch.e.mouseEnabled = !ch.e.mouseEnabled
-	if ch.e.mouseEnabled {
-		termbox.SetInputMode(termbox.InputEsc | termbox.InputMouse)
-	} else {
-		termbox.SetInputMode(termbox.InputEsc)
-	}
-}
-
-// goToLine moves the cursor to the beginning of the specified line number.
-func (ch *Command) goToLine(lineNum int) {
-	b := ch.e.activeBuffer()
-	if b != nil {
-		targetY := lineNum - 1 // Convert 1-based UI line number to 0-based index.
-		if targetY < 0 {
-			targetY = 0
-		}
-		if targetY >= len(b.buffer) {
-			targetY = len(b.buffer) - 1
-		}
-		b.PrimaryCursor().Y = targetY
-		b.PrimaryCursor().X = 0
-		ch.e.centerCursor()
+// switchBuffer activates the first open buffer whose filename contains
+// query (case-insensitive), e.g. `:buffer log` jumps to the [Log] buffer.
+func (ch *Command) switchBuffer(query string) {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		ch.e.setMessage("No buffer name specified")
+		return
 	}
-}
-
-// reload re-reads the active buffer from disk.
-func (ch *Command) reload() {
-	b := ch.e.activeBuffer()
-	if b != nil {
-		err := ch.e.ReloadBuffer(b)
-		if err != nil {
-			ch.e.message = fmt.Sprintf("Reload failed: %v", err)
-		} else {
-			ch.e.message = fmt.Sprintf("\"%s\" reloaded", b.filename)
+	for i, b := range ch.e.buffers {
+		if strings.Contains(strings.ToLower(b.filename), query) {
+			ch.e.activeBufferIndex = i
+			return
 		}
 	}
+	ch.e.setMessage(fmt.Sprintf("No buffer matching %q", query))
 }
 
-// executeShell runs a shell command and displays the output.
-func (ch *Command) executeShell(shellCmd string) {
-	shellCmd = strings.TrimSpace(shellCmd)
-	if shellCmd == "" {
-		ch.e.message = "No shell command specified"
+// tsScopesCommand implements `:ts-scopes`, reporting the tree-sitter node
+// chain and matching query captures under the cursor in the hover popup.
+// Meant for writing/debugging queries/<lang>/*.scm and theme mappings
+// without recompiling.
+func (ch *Command) tsScopesCommand() {
+	e := ch.e
+	b := e.activeBuffer()
+	if b == nil || b.syntax == nil {
+		e.setMessage("No syntax highlighter for this buffer")
 		return
 	}
 
-	// Execute the command using sh -c for proper shell interpretation.
-	cmd := exec.Command("/bin/sh", "-c", shellCmd)
-	output, err := cmd.CombinedOutput()
-
-	if err != nil {
-		// Display error along with any output that was produced.
-		if len(output) > 0 {
-			ch.e.message = fmt.Sprintf("Error: %v | Output: %s", err, strings.TrimSpace(string(output)))
-		} else {
-			ch.e.message = fmt.Sprintf("Error executing command: %v", err)
-		}
+	cursor := b.PrimaryCursor()
+	colBytes := b.getLineByteOffset(b.buffer[cursor.Y], cursor.X)
+	scopes := b.syntax.ScopesAt(cursor.Y, int(colBytes))
+	if len(scopes) == 0 {
+		e.setMessage("No tree-sitter node under cursor")
 		return
 	}
 
-	// Display the command output, truncating if too long.
-	outputStr := strings.TrimSpace(string(output))
-	if outputStr == "" {
-		ch.e.message = "Command executed successfully (no output)"
-	} else {
-		// Truncate output if it's too long for the message bar.
-		const maxLen = 200
-		if len(outputStr) > maxLen {
-			ch.e.message = outputStr[:maxLen] + "..."
-		} else {
-			ch.e.message = outputStr
-		}
+	var sb strings.Builder
+	sb.WriteString("**tree-sitter scopes**\n\n")
+	for _, scope := range scopes {
+		sb.WriteString(fmt.Sprintf("- `%s`\n", scope))
 	}
+
+	e.hoverContent = sb.String()
+	e.showHover = true
 }
 
-// readShell runs a shell command and inserts the output into the buffer at cursor position.
-func (ch *Command) readShell(shellCmd string) {
-	shellCmd = strings.TrimSpace(shellCmd)
-	if shellCmd == "" {
-		ch.e.message = "No shell command specified"
+// setFileEncoding overrides the active buffer's charset for the next :w,
+// re-encoding its content (e.g. "shift_jis", "gbk", "latin1").
+func (ch *Command) setFileEncoding(name string) {
+	b := ch.e.activeBuffer()
+	if b == nil {
 		return
 	}
+	_, canonical, err := lookupEncoding(name)
+	if err != nil {
+		ch.e.setMessage(err.Error())
+		return
+	}
+	b.encoding = canonical
+	b.modified = true
+	ch.e.setMessage(fmt.Sprintf("fileencoding=%s", canonical))
+}
 
+// setFileFormat overrides the active buffer's line-ending convention for the
+// next :w (vim-style "unix"/"dos"/"mac").
+func (ch *Command) setFileFormat(ff string) {
 	b := ch.e.activeBuffer()
 	if b == nil {
 		return
 	}
-
-	if b.readOnly {
-		ch.e.message = "File is read-only"
+	le, err := lineEndingFromFileFormat(ff)
+	if err != nil {
+		ch.e.setMessage(err.Error())
 		return
 	}
+	b.lineEnding = le
+	b.modified = true
+	ch.e.setMessage(fmt.Sprintf("fileformat=%s", ff))
+}
 
-	// Execute the command using sh -c for proper shell interpretation.
-	cmd := exec.Command("/bin/sh", "-c", shellCmd)
-	output, err := cmd.CombinedOutput()
-
+// bindCommand implements `:bind <mode> <keys> <action> [action...]`,
+// overriding or adding one chord-sequence binding in the live keymap (see
+// bindings.go). Unlike keybindings.json, which is read once at startup,
+// this takes effect immediately.
+func (ch *Command) bindCommand(args string) {
+	mode, keys, actions, err := parseBindArgs(args)
 	if err != nil {
-		// Display error along with any output that was produced.
-		if len(output) > 0 {
-			ch.e.message = fmt.Sprintf("Error: %v | Output: %s", err, strings.TrimSpace(string(output)))
-		} else {
-			ch.e.message = fmt.Sprintf("Error executing command: %v", err)
-		}
+		ch.e.setMessage(err.Error())
 		return
 	}
-
-	outputStr := string(output)
-	if outputStr == "" {
-		ch.e.message = "Command executed (no output to insert)"
+	if err := ch.e.Bind(mode, keys, actions); err != nil {
+		ch.e.setMessage(err.Error())
 		return
 	}
+	ch.e.setMessage(fmt.Sprintf("Bound %s in %s mode", quoteForDisplay(keys), mode))
+}
 
-	// Save state for undo.
-	ch.e.saveState()
+// earlier implements `:earlier {duration}` (e.g. "5m", "30s"), undoing
+// entries off the undo stack for as long as they were recorded within the
+// given duration, vim-style time-travel through Edit.timestamp.
+func (ch *Command) earlier(arg string) {
+	ch.timeTravel(arg, true)
+}
 
-	// Split output into lines and insert them into the buffer.
-	lines := strings.Split(outputStr, "\n")
-	// Remove trailing empty line if present (common with command output).
-	if len(lines) > 0 && lines[len(lines)-1] == "" {
-		lines = lines[:len(lines)-1]
-	}
+// later implements `:later {duration}` (e.g. "30s"), the inverse of
+// earlier: it redoes entries that were undone within the given duration.
+func (ch *Command) later(arg string) {
+	ch.timeTravel(arg, false)
+}
 
-	if len(lines) == 0 {
-		ch.e.message = "Command executed (no output to insert)"
+// timeTravel walks the undo stack (back) or redo stack (forward) for as
+// long as the next entry's timestamp is within dur of now, stopping at the
+// first entry older than that.
+func (ch *Command) timeTravel(arg string, back bool) {
+	dur, err := time.ParseDuration(arg)
+	if err != nil {
+		ch.e.setMessage(fmt.Sprintf("Invalid duration %q: %v", arg, err))
 		return
 	}
 
-	c := b.PrimaryCursor()
-	currentY := c.Y
+	b := ch.e.activeBuffer()
+	if b == nil {
+		return
+	}
 
-	// Insert output starting from the line after the cursor.
-	for i, line := range lines {
-		insertY := currentY + i + 1
-		// Create new line in buffer.
-		newLine := []rune(line)
-		// Insert the line into the buffer.
-		if insertY <= len(b.buffer) {
-			b.buffer = append(b.buffer[:insertY], append([][]rune{newLine}, b.buffer[insertY:]...)...)
+	cutoff := time.Now().Add(-dur)
+	steps := 0
+	for {
+		var stack []Edit
+		if back {
+			stack = b.undoStack
+		} else {
+			stack = b.redoStack
+		}
+		if len(stack) == 0 || stack[len(stack)-1].timestamp.Before(cutoff) {
+			break
+		}
+		if back {
+			ch.e.undo()
 		} else {
-			b.buffer = append(b.buffer, newLine)
+			ch.e.redo()
 		}
+		steps++
 	}
 
-	// Mark buffer as modified.
-	ch.e.markModified()
+	ch.e.checkDiagnostics()
+	if back {
+		ch.e.setMessage(fmt.Sprintf("%d change(s) undone", steps))
+	} else {
+		ch.e.setMessage(fmt.Sprintf("%d change(s) redone", steps))
+	}
+}
 
-	// Reparse syntax if needed.
-	if b.syntax != nil {
-		b.syntax.Reparse([]byte(b.toString()))
+// toggleMouse enables/disables mouse interaction in the terminal.
+func (ch *Command) toggleMouse() {
+	ch.e.mouseEnabled = !ch.e.mouseEnabled
+	if ch.e.mouseEnabled {
+		termbox.SetInputMode(termbox.InputEsc | termbox.InputMouse)
+	} else {
+		termbox.SetInputMode(termbox.InputEsc)
 	}
+}
 
-	// Notify LSP of the change.
-	if b.lspClient != nil {
-		b.lspClient.SendDidChange(b.toString())
+// goToLine moves the cursor to the beginning of the specified line number.
+func (ch *Command) goToLine(lineNum int) {
+	b := ch.e.activeBuffer()
+	if b != nil {
+		targetY := lineNum - 1 // Convert 1-based UI line number to 0-based index.
+		if targetY < 0 {
+			targetY = 0
+		}
+		if targetY >= len(b.buffer) {
+			targetY = len(b.buffer) - 1
+		}
+		b.PrimaryCursor().Y = targetY
+		b.PrimaryCursor().X = 0
+		ch.e.centerCursor()
 	}
+}
 
-	lineCount := len(lines)
-	if lineCount == 1 {
-		ch.e.message = "1 line inserted"
-	} else {
-		ch.e.message = fmt.Sprintf("%d lines inserted", lineCount)
+// reload re-reads the active buffer from disk.
+func (ch *Command) reload() {
+	b := ch.e.activeBuffer()
+	if b != nil {
+		err := ch.e.ReloadBuffer(b)
+		if err != nil {
+			ch.e.setMessage(fmt.Sprintf("Reload failed: %v", err))
+		} else {
+			ch.e.setMessage(fmt.Sprintf("\"%s\" reloaded", b.filename))
+		}
 	}
 }
+
+// executeShell and readShell (asynchronous, streaming versions) live in shell.go.
@@ -0,0 +1,251 @@
+package main
+
+// Tab-completion subsystem for the : command line. Pressing Tab completes the
+// first token against known ex commands, filesystem paths after `:e `/`:w `,
+// or open buffer names after `:bd `/`:b `. Ambiguous completions are shown in
+// a popup navigable with Tab/Shift-Tab, similar to readline's complete_helper.
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// exCommandNames mirrors the command set recognized by Command.IsValidCommand.
+var exCommandNames = []string{
+	"q", "Q", "q!", "Q!", "w", "W", "wa", "WA", "wq", "WQ", "waq", "WAQ",
+	"reload", "bd", "bd!", "debug", "help", "mouse", "e", "edit", "n", "history", "messages",
+	"dirs", "tags", "jumps", "reg", "buffer", "set fenc=", "set ff=", "nohlsearch", "earlier", "later",
+}
+
+// Completer produces candidates for the text currently typed on the command
+// line. Returning a nil slice means "not applicable"; callers try the next
+// completer in the chain. start is the rune offset into line where the
+// candidate text begins (so the caller knows what to replace).
+type Completer interface {
+	Candidates(e *Editor, line string) (candidates []string, start int)
+}
+
+// ExCommandCompleter completes the first token against known ex commands.
+type ExCommandCompleter struct{}
+
+func (ExCommandCompleter) Candidates(e *Editor, line string) ([]string, int) {
+	if strings.Contains(line, " ") {
+		return nil, 0
+	}
+	var out []string
+	for _, name := range exCommandNames {
+		if strings.HasPrefix(name, line) {
+			out = append(out, name)
+		}
+	}
+	return out, 0
+}
+
+// FileCompleter completes filesystem paths after `:e ` or `:w `.
+type FileCompleter struct{}
+
+func (FileCompleter) Candidates(e *Editor, line string) ([]string, int) {
+	var arg string
+	switch {
+	case strings.HasPrefix(line, "e "):
+		arg = strings.TrimPrefix(line, "e ")
+	case strings.HasPrefix(line, "edit "):
+		arg = strings.TrimPrefix(line, "edit ")
+	case strings.HasPrefix(line, "w "):
+		arg = strings.TrimPrefix(line, "w ")
+	default:
+		return nil, 0
+	}
+
+	start := len(line) - len(arg)
+
+	expanded := arg
+	if expanded == "~" || strings.HasPrefix(expanded, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			expanded = home + expanded[1:]
+		}
+	}
+
+	dir := filepath.Dir(expanded)
+	prefix := filepath.Base(expanded)
+	if strings.HasSuffix(expanded, "/") {
+		dir = expanded
+		prefix = ""
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, 0
+	}
+
+	var out []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		full := filepath.Join(dir, name)
+		if dir == "." {
+			full = name
+		}
+		if entry.IsDir() {
+			full += "/"
+		}
+		out = append(out, full)
+	}
+	return out, start
+}
+
+// BufferCompleter completes open buffer filenames after `:bd ` or `:b `.
+type BufferCompleter struct{}
+
+func (BufferCompleter) Candidates(e *Editor, line string) ([]string, int) {
+	var arg string
+	switch {
+	case strings.HasPrefix(line, "bd "):
+		arg = strings.TrimPrefix(line, "bd ")
+	case strings.HasPrefix(line, "b "):
+		arg = strings.TrimPrefix(line, "b ")
+	default:
+		return nil, 0
+	}
+
+	start := len(line) - len(arg)
+
+	var out []string
+	for _, b := range e.buffers {
+		name := b.filename
+		if name == "" {
+			continue
+		}
+		if strings.HasPrefix(name, arg) {
+			out = append(out, name)
+		}
+	}
+	return out, start
+}
+
+// commandCompleters lists the completer chain in priority order.
+var commandCompleters = []Completer{
+	FileCompleter{},
+	BufferCompleter{},
+	ExCommandCompleter{},
+}
+
+// triggerCompletion computes candidates for the text before the cursor and
+// either applies the single match or opens the ambiguous-match popup.
+func (e *Editor) triggerCompletion() {
+	line := string(e.commandBuffer[:e.commandCursorX])
+
+	var candidates []string
+	var start int
+	for _, c := range commandCompleters {
+		if cands, s := c.Candidates(e, line); cands != nil {
+			candidates, start = cands, s
+			break
+		}
+	}
+
+	if len(candidates) == 0 {
+		e.setMessage("No completions")
+		return
+	}
+
+	sort.Strings(candidates)
+
+	if len(candidates) == 1 {
+		e.applyCompletion(candidates[0], start)
+		e.showCompletion = false
+		return
+	}
+
+	e.completionItems = candidates
+	e.completionIndex = 0
+	e.completionReplaceLo = start
+	e.completionReplaceHi = e.commandCursorX
+	e.showCompletion = true
+	e.applyCompletion(candidates[0], start)
+}
+
+// cycleCompletion moves the selection within an already-open popup and
+// replaces the command line text with the newly selected candidate.
+func (e *Editor) cycleCompletion(dir int) {
+	if !e.showCompletion || len(e.completionItems) == 0 {
+		return
+	}
+	e.completionIndex = (e.completionIndex + dir + len(e.completionItems)) % len(e.completionItems)
+	e.applyCompletion(e.completionItems[e.completionIndex], e.completionReplaceLo)
+}
+
+// applyCompletion replaces commandBuffer[start:commandCursorX] with candidate
+// and moves the cursor to the end of the inserted text.
+func (e *Editor) applyCompletion(candidate string, start int) {
+	tail := append([]rune{}, e.commandBuffer[e.commandCursorX:]...)
+	newBuf := append([]rune{}, e.commandBuffer[:start]...)
+	newBuf = append(newBuf, []rune(candidate)...)
+	e.completionReplaceHi = len(newBuf)
+	newBuf = append(newBuf, tail...)
+	e.commandBuffer = newBuf
+	e.commandCursorX = e.completionReplaceHi
+}
+
+// closeCompletion dismisses the completion popup without altering the buffer.
+func (e *Editor) closeCompletion() {
+	e.showCompletion = false
+	e.completionItems = nil
+}
+
+// drawCompletionPopup renders the ambiguous-match candidate list above the command line.
+func (e *Editor) drawCompletionPopup() {
+	if len(e.completionItems) == 0 {
+		return
+	}
+
+	w, h := e.termSize()
+
+	maxWidth := 0
+	for _, item := range e.completionItems {
+		if len(item) > maxWidth {
+			maxWidth = len(item)
+		}
+	}
+	if maxWidth > w-2 {
+		maxWidth = w - 2
+	}
+
+	popupHeight := len(e.completionItems)
+	if popupHeight > 10 {
+		popupHeight = 10
+	}
+
+	startX := 0
+	startY := h - 1 - popupHeight - 1 // just above the command bar
+
+	fg, bg := GetThemeColor(ColorAutocompleteWindow)
+	selFg, selBg := GetThemeColor(ColorAutocompleteSelected)
+
+	for y := 0; y < popupHeight; y++ {
+		itemIdx := y
+		if itemIdx >= len(e.completionItems) {
+			break
+		}
+		item := e.completionItems[itemIdx]
+
+		currentFg, currentBg := fg, bg
+		if itemIdx == e.completionIndex {
+			currentFg, currentBg = selFg, selBg
+		}
+
+		for x := 0; x < maxWidth+2; x++ {
+			e.setCell(startX+x, startY+y, ' ', currentFg, currentBg)
+		}
+		for i, r := range item {
+			if i >= maxWidth {
+				break
+			}
+			e.setCell(startX+1+i, startY+y, r, currentFg, currentBg)
+		}
+	}
+}
@@ -5,27 +5,63 @@ package main
 
 import (
 	"flag"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// LSPServerEntry configures one external LSP server: which file types it
+// attaches to, how to launch it, and which directory markers (searched
+// upward from the open file) define its project root.
+type LSPServerEntry struct {
+	FileTypes    []string               // FileType.Name values this server handles (e.g. "Go").
+	Command      string                 // Executable name of the LSP server.
+	Args         []string               // Arguments to pass to the LSP server.
+	RootPatterns []string               // Filenames that mark a project root (e.g. "go.mod").
+	InitOptions  map[string]interface{} // Sent verbatim as initialize's initializationOptions (e.g. gopls's build.directoryFilters).
+}
+
 // Configuration holds all adjustable settings for the editor.
 type Configuration struct {
-	GutterWidth          int           // Width of the left column (line numbers, LSP signs).
-	DefaultTabWidth      int           // Number of spaces a tab character represents.
-	FuzzyFinderHeight    int           // Number of rows the fuzzy finder takes up.
-	LeaderKey            rune          // The prefix key for many custom commands (default: \).
-	UseLogFile           bool          // Whether to write debug logs to a file.
-	LogFilePath          string        // Where to store the debug logs.
-	NumLogsInDebugWindow int           // How many recent logs to show in the UI debug window.
-	OllamaCheckInterval  time.Duration // How often to check if Ollama is running.
-	FileCheckInterval    time.Duration // How often to check for external file changes.
-	OllamaURL            string        // Endpoint for the Ollama AI service.
-	OllamaModel          string        // The specific AI model to use for completion.
-	DevMode              bool          // Enables verbose logging and developer tools.
-	ShowColors           bool          // Command-line flag to show available colors and exit.
-	ShowInfo             bool          // Command-line flag to show file types and exit.
-	ShowVersion          bool          // Command-line flag to show version and exit.
-	FormatterMarkers     []string      // List of comment prefixes for text formatting (no CLI flag).
+	GutterWidth          int              // Width of the left column (line numbers, LSP signs).
+	DefaultTabWidth      int              // Number of spaces a tab character represents.
+	FuzzyFinderHeight    int              // Number of rows the fuzzy finder takes up.
+	LeaderKey            rune             // The prefix key for many custom commands (default: \).
+	UseLogFile           bool             // Whether to write debug logs to a file.
+	LogFilePath          string           // Where to store the debug logs.
+	NumLogsInDebugWindow int              // How many recent logs to show in the UI debug window.
+	OllamaCheckInterval  time.Duration    // How often to check if Ollama is running.
+	FileCheckInterval    time.Duration    // How often to check for external file changes.
+	OllamaURL            string           // Endpoint for the Ollama AI service.
+	OllamaModel          string           // The specific AI model to use for completion.
+	DevMode              bool             // Enables verbose logging and developer tools.
+	ShowColors           bool             // Command-line flag to show available colors and exit.
+	ShowInfo             bool             // Command-line flag to show file types and exit.
+	ShowVersion          bool             // Command-line flag to show version and exit.
+	FormatterMarkers     []string         // List of comment prefixes for text formatting (no CLI flag).
+	HistoryMaxLen        int              // Maximum number of entries kept in the persisted command/search history.
+	FuzzyIgnore          []string         // Shell globs skipped by the file fuzzy finder, in addition to .git/node_modules.
+	DefaultEncoding      string           // Charset assumed for files with no detectable BOM (e.g. "utf-8", "shift_jis").
+	BackupOnSave         bool             // Whether :w keeps a copy of the previous version under ~/.qwe/backups.
+	BackupMaxVersions    int              // How many backed-up versions of a file to keep.
+	SwapInterval         time.Duration    // How often dirty buffers are snapshotted to ~/.qwe/swap for crash recovery.
+	JumpSaveInterval     time.Duration    // How often the jumplist is flushed to $XDG_STATE_HOME/qwe/jumps.
+	LSPServers           []LSPServerEntry // Per-language server table (no CLI flag, edit in config.go).
+	Height               string           // fzf-style --height spec ("N" rows or "N%"); "" means fullscreen.
+	SearchLiteral        bool             // Disables Unicode diacritic-folding in search, matching accents literally.
+	HighlightTrailingWS  bool             // Highlights trailing spaces/tabs at the end of a line.
+	HighlightMixedIndent bool             // Highlights leading indent that mixes tabs and spaces.
+	FuzzyLiteral         bool             // Disables Unicode diacritic-folding in the fuzzy finder, matching accents literally.
+	FuzzyPreviewEnabled  bool             // Whether the fuzzy finder shows a preview pane for the selected file.
+	FuzzyPreviewWidth    int              // Width of the fuzzy finder preview pane, as a percentage of terminal width.
+	FuzzyMaxResults      int              // Cap on scored fuzzy finder results kept in memory (see fuzzysearch.go's top-K heap).
+	RainbowBrackets      bool             // Colorize nested delimiters by depth using queries/<lang>/rainbows.scm (see syntax.go).
+	ExternalEditor       string           // Editor exec'd by :edit!/Ctrl+X Ctrl+E; falls back to $EDITOR, then "vi".
+	KeyTimeoutMs         int              // How long a partially-typed chord (e.g. a lone "g") waits for its next key before being abandoned; see armChordTimeout (bindings.go).
+	NoNerdFont           bool             // Swaps Icons/FileIcons (see icons.go) to plain ASCII for terminals without a patched Nerd Font.
+	LSPTracePath         string           // If set, every LSP client records its JSON-RPC traffic here (see lsp_replay.go).
+	LSPReplayPath        string           // If set, every LSP client replays this recorded trace instead of spawning a real server (see lsp_replay.go).
+	LSPReplayFast        bool             // Replay a --lsp-replay trace as fast as possible instead of honoring its recorded timing.
 }
 
 // Config is the global configuration instance.
@@ -34,6 +70,7 @@ var Config Configuration
 // InitConfig sets up command-line flags and parses them into the global Config.
 func InitConfig() {
 	var leaderKey string
+	var fuzzyIgnore string
 
 	flag.IntVar(&Config.GutterWidth, "gutter-width", 7, "Width of the gutter")
 	flag.IntVar(&Config.DefaultTabWidth, "tab-width", 4, "Default tab width")
@@ -50,9 +87,40 @@ func InitConfig() {
 	flag.BoolVar(&Config.ShowColors, "colors", false, "Show available colors")
 	flag.BoolVar(&Config.ShowInfo, "info", false, "Show file associations and LSP info")
 	flag.BoolVar(&Config.ShowVersion, "version", false, "Show version")
+	flag.IntVar(&Config.HistoryMaxLen, "history-max", 10000, "Maximum persisted command/search history entries")
+	flag.StringVar(&fuzzyIgnore, "fuzzy-ignore", "", "Semicolon-separated shell globs to skip in the file fuzzy finder (e.g. *.pyc;log/**;*.png)")
+	flag.StringVar(&Config.DefaultEncoding, "encoding", "utf-8", "Charset assumed for files with no detectable BOM")
+	flag.BoolVar(&Config.BackupOnSave, "backup", false, "Keep a copy of the previous version under ~/.qwe/backups on every write")
+	flag.IntVar(&Config.BackupMaxVersions, "backup-max-versions", 5, "Number of backed-up versions of a file to keep")
+	flag.DurationVar(&Config.SwapInterval, "swap-interval", 4*time.Second, "How often dirty buffers are snapshotted for crash recovery")
+	flag.DurationVar(&Config.JumpSaveInterval, "jump-save-interval", 30*time.Second, "How often the jumplist is flushed to disk")
+	flag.StringVar(&Config.Height, "height", "", "Render into only the bottom N rows or N% of the terminal, like fzf (default: fullscreen)")
+	flag.BoolVar(&Config.SearchLiteral, "search-literal", false, "Disable Unicode diacritic folding in search (e.g. 'sodanco' no longer matches 'Só Dança')")
+	flag.BoolVar(&Config.HighlightTrailingWS, "highlight-trailing-ws", true, "Highlight trailing spaces/tabs at the end of a line")
+	flag.BoolVar(&Config.HighlightMixedIndent, "highlight-mixed-indent", true, "Highlight leading indent that mixes tabs and spaces")
+	flag.BoolVar(&Config.FuzzyLiteral, "fuzzy-literal", false, "Disable Unicode diacritic folding in the fuzzy finder (e.g. 'sodanco' no longer matches 'Só Dança')")
+	flag.BoolVar(&Config.FuzzyPreviewEnabled, "fuzzy-preview", true, "Show a preview pane for the selected file in the fuzzy finder")
+	flag.IntVar(&Config.FuzzyPreviewWidth, "fuzzy-preview-width", 50, "Width of the fuzzy finder preview pane, as a percentage of terminal width")
+	flag.IntVar(&Config.FuzzyMaxResults, "fuzzy-max-results", 5000, "Cap on scored fuzzy finder results kept in memory")
+	flag.BoolVar(&Config.RainbowBrackets, "rainbow-brackets", false, "Colorize nested delimiters by depth")
+	flag.StringVar(&Config.ExternalEditor, "editor", "", "Editor exec'd by :edit!/Ctrl+X Ctrl+E (default: $EDITOR, then vi)")
+	flag.IntVar(&Config.KeyTimeoutMs, "key-timeout-ms", 500, "How long a partial chord (e.g. a lone \"g\") waits for its next key before being abandoned")
+	flag.BoolVar(&Config.NoNerdFont, "no-nerdfont", false, "Use plain ASCII instead of Nerd Font icons for gutter signs, status indicators, and fuzzy finder file icons")
+	flag.StringVar(&Config.LSPTracePath, "lsp-trace", "", "Record every LSP server's JSON-RPC traffic (both directions) to this file, for later replay with -lsp-replay")
+	flag.StringVar(&Config.LSPReplayPath, "lsp-replay", "", "Feed a -lsp-trace log back into the editor's LSP message handling instead of spawning a real server")
+	flag.BoolVar(&Config.LSPReplayFast, "lsp-replay-fast", false, "Replay a -lsp-replay log as fast as possible instead of honoring its recorded timing")
 
 	flag.Parse()
 
+	if fuzzyIgnore != "" {
+		Config.FuzzyIgnore = strings.Split(fuzzyIgnore, ";")
+	}
+
+	if Config.NoNerdFont {
+		Icons = asciiIcons
+		FileIcons = nil
+	}
+
 	// Convert the first character of the leader flag into a rune.
 	if len(leaderKey) > 0 {
 		Config.LeaderKey = rune(leaderKey[0])
@@ -60,11 +128,52 @@ func InitConfig() {
 
 	// Initialize formatter markers for text formatting.
 	Config.FormatterMarkers = []string{
-		"//", // C/C++/Go/JavaScript/Rust
-		"--", // SQL/Lua/Haskell
-		"#",  // Python/Shell/Ruby/YAML
-		";;", // Lisp/Scheme
-		"%",  // LaTeX/MATLAB
-		">",  // Markdown quote
+		"//",   // C/C++/Go/JavaScript/Rust
+		"--",   // SQL/Lua/Haskell
+		"#",    // Python/Shell/Ruby/YAML
+		";;",   // Lisp/Scheme
+		"%",    // LaTeX/MATLAB
+		">",    // Markdown quote
+		"/*",   // C/C++/CSS/JS block comment
+		"<!--", // HTML block comment
+	}
+
+	// Default LSP servers, one entry per language. Users can replace this
+	// slice in config.go to add clangd flags, swap pyright for another
+	// Python server, etc.
+	Config.LSPServers = []LSPServerEntry{
+		{FileTypes: []string{"Go"}, Command: "gopls", RootPatterns: []string{"go.mod", ".git"},
+			InitOptions: map[string]interface{}{"build.directoryFilters": []string{}}},
+		{FileTypes: []string{"C", "C++"}, Command: "clangd", RootPatterns: []string{"compile_commands.json", ".git"},
+			InitOptions: map[string]interface{}{"compilationDatabasePath": "."}},
+		{FileTypes: []string{"JavaScript", "TypeScript", "TSX"}, Command: "typescript-language-server", Args: []string{"--stdio"}, RootPatterns: []string{"package.json", ".git"}},
+		{FileTypes: []string{"Python"}, Command: "pyright-langserver", Args: []string{"--stdio"}, RootPatterns: []string{"pyproject.toml", "setup.py", ".git"}},
+	}
+}
+
+// resolveHeight interprets Config.Height ("" for fullscreen, "N" for a row
+// count, "N%" for a percentage of termH) and clamps the result to
+// [1, termH].
+func resolveHeight(termH int) int {
+	spec := strings.TrimSpace(Config.Height)
+	if spec == "" {
+		return termH
+	}
+
+	h := termH
+	if strings.HasSuffix(spec, "%") {
+		if n, err := strconv.Atoi(strings.TrimSuffix(spec, "%")); err == nil {
+			h = termH * n / 100
+		}
+	} else if n, err := strconv.Atoi(spec); err == nil {
+		h = n
+	}
+
+	if h < 1 {
+		h = 1
+	}
+	if h > termH {
+		h = termH
 	}
+	return h
 }
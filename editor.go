@@ -6,11 +6,13 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"sort"
 	"strconv"
@@ -35,6 +37,7 @@ const (
 	ModeReplace     // Pattern replacement mode
 	ModeVisualBlock // Columnar selection
 	ModeConfirm     // Yes/No confirmation prompt
+	ModeAITransform // Natural-language instruction prompt for AI-assisted selection rewrite (see aitransform.go)
 )
 
 type FuzzyType int
@@ -43,12 +46,19 @@ const (
 	FuzzyModeFile FuzzyType = iota
 	FuzzyModeBuffer
 	FuzzyModeWarning
+	FuzzyModeDirectory
+	FuzzyModeTag
+	FuzzyModeCodeAction
+	FuzzyModeRenamePreview
 )
 
 type Jump struct {
-	filename string
-	cursorX  int
-	cursorY  int
+	filename     string
+	cursorX      int
+	cursorY      int
+	scrollY      int       // Viewport offset to restore alongside the cursor position.
+	preferredCol int       // Cursor.PreferredCol at the time of the jump.
+	timestamp    time.Time // When the jump was recorded, for :jumps display and ordering.
 }
 
 type DiagnosticItem struct {
@@ -59,6 +69,15 @@ type DiagnosticItem struct {
 	severity  int
 }
 
+// TagItem is one entry parsed from a ctags-style `tags` file:
+// name<TAB>file<TAB>address, where address is either a line number or a
+// /pattern/ search command.
+type TagItem struct {
+	name    string
+	file    string
+	address string
+}
+
 // MatchRange represents a span of text matched by search or replace.
 type MatchRange struct {
 	startLine int
@@ -69,39 +88,106 @@ type MatchRange struct {
 
 // Editor is the main controller struct that holds all global state.
 type Editor struct {
-	buffers            []*Buffer        // All open file buffers.
-	activeBufferIndex  int              // Currently visible buffer.
-	mode               Mode             // Current editor mode.
-	clipboard          []rune           // Basic internal clipboard.
-	pendingKey         rune             // Stores the first character of a multi-key command (e.g., 'g').
-	commandBuffer      []rune           // Input for the : command line.
-	commandCursorX     int              // Cursor position within commandBuffer.
-	commandHistory     []string         // History of executed commands.
-	commandHistoryIdx  int              // Current position in command history (-1 = not navigating).
-	findBuffer         []rune           // Input for the / find line.
-	findSavedSearch    string           // Search term before incremental search started.
-	lastSearch         string           // The last searched term (for 'n'/'N').
-	fuzzyBuffer        []rune           // Filter pattern in fuzzy finder.
-	fuzzyResults       []string         // Filtered items shown to the user.
-	fuzzyResultIndices []int            // Map from displayed results back to original candidates.
-	fuzzyIndex         int              // Highlighted item in the result list.
-	fuzzyScroll        int              // Viewport offset for the result list.
-	fuzzyCandidates    []string         // Raw list of all possible items (files/buffers/etc.).
-	fuzzyType          FuzzyType        // What the fuzzy finder is searching for.
-	fuzzyDiagnostics   []DiagnosticItem // Diagnostics from all buffers (accessible via finder).
-	mouseEnabled       bool             // Toggle for mouse support.
-	visualStartX       int              // Starting anchor for visual selection.
-	visualStartY       int              // Starting anchor for visual selection.
-	logMessages        []string         // Internal debug logs shown in the Log window.
-	maxLogMessages     int              // Maximum capacity of the log ring buffer.
-	showDebugLog       bool             // Visibility toggle for the log window.
-	jumplist           []Jump           // History of cursor locations (for Ctrl-O/Ctrl-I).
-	jumpIndex          int              // Current position in the jumplist.
-	message            string           // Status message shown at the bottom.
-	commands           *Command         // Command handler instance.
-	devMode            bool             // Internal developer mode toggle.
-	ollamaClient       *OllamaClient    // Client for local AI features.
-	introDismissed     bool             // Whether the splash screen was hidden.
+	buffers            []*Buffer   // All open file buffers.
+	activeBufferIndex  int         // Currently visible buffer.
+	mode               Mode        // Current editor mode.
+	registers          *Registers  // Named/numbered registers (see registers.go); replaces the old single clipboard.
+	pendingRegister    rune        // Register named by a `"<reg>` prefix, consumed by the next y/d/c/p/P.
+	pendingKey         rune        // Holds '"', 'm' or '@' while the next key is read as a register/macro name (see bindings.go, macros.go).
+	pendingInsertCtrlX bool        // Set by Ctrl+X in Insert mode while waiting for the Ctrl+E of the open-in-$EDITOR chord (see externaleditor.go).
+	pendingChords      []KeyChord  // Chords accumulated so far toward a multi-key binding (e.g., "d" while waiting for "dd").
+	pendingCount       int         // Numeric prefix accumulated so far (e.g. the "5" of "5dd"); 0 means no count given.
+	chordTimer         *time.Timer // Armed while pendingChords is a prefix with no exact match yet; see armChordTimeout (bindings.go).
+	chordTimeoutFired  bool        // Set by chordTimer's goroutine, consumed by HandleEvents' EventInterrupt case (kevent.go).
+	lastChange         *LastChange // Last recordable change, replayed by the "." binding (see bindings.go).
+
+	macros            map[rune][]termbox.Event // Recorded macros, keyed by register letter (see macros.go).
+	recordingRegister rune                     // Register currently being recorded into; 0 means not recording.
+	lastMacroRegister rune                     // Register last played with @x, replayed again by @@.
+	macroDepth        int                      // Nesting depth of in-progress @x playback, guarding against a macro that invokes itself.
+
+	pendingCharSearch *charSearchState // Set once f/F/t/T (and its operator/count, if any) has been typed, waiting for its target character (see charsearch.go).
+	lastCharSearch    *charSearch      // Last completed f/F/t/T, repeated by ";" and reversed by ",".
+	commandBuffer     []rune           // Input for the : command line.
+	commandCursorX    int              // Cursor position within commandBuffer.
+	commandHistory    []string         // History of executed commands.
+	commandHistoryIdx int              // Current position in command history (-1 = not navigating).
+
+	// Tab-completion state for the : command line.
+	showCompletion      bool     // Visibility toggle for the completion popup.
+	completionItems     []string // Current candidate list.
+	completionIndex     int      // Currently highlighted candidate.
+	completionReplaceLo int      // Start rune offset in commandBuffer that completion replaces.
+	completionReplaceHi int      // End rune offset in commandBuffer that completion replaces.
+
+	// Reverse-incremental history search state (Ctrl-R in ModeCommand).
+	historySearchActive     bool                          // Whether the reverse-i-search prompt is showing.
+	historySearchQuery      []rune                        // The text typed into the search prompt.
+	historySearchIndex      int                           // Index into commandHistory of the current match (-1 = none).
+	historySearchSaved      []rune                        // commandBuffer contents before the search started, restored on Esc.
+	historySearchPrefixMode bool                          // false = substring ("contains") matching, true = prefix matching.
+	findBuffer              []rune                        // Input for the / find line.
+	findSavedSearch         string                        // Search term before incremental search started.
+	lastSearch              string                        // The last searched term (for 'n'/'N').
+	searchHistory           []string                      // Persisted history of search patterns.
+	hlsearch                bool                          // Whether matches of lastSearch are highlighted across the viewport.
+	searchRegex             bool                          // Whether lastSearch is interpreted as a regular expression.
+	searchLiteral           bool                          // Per-session override of Config.SearchLiteral, toggled with Ctrl-U in / mode.
+	lastSearchRegex         *regexp.Regexp                // Compiled form of lastSearch, cached so findNext/findPrev don't recompile it.
+	lastSearchRegexSrc      string                        // The pattern lastSearchRegex was compiled from.
+	fuzzyBuffer             []rune                        // Filter pattern in fuzzy finder.
+	fuzzyResults            []string                      // Filtered items shown to the user.
+	fuzzyResultIndices      []int                         // Map from displayed results back to original candidates.
+	fuzzyResultSpans        [][][2]int                    // Per-result matched rune spans (see fuzzyquery.go), for drawFuzzyFinder highlighting.
+	fuzzyIndex              int                           // Highlighted item in the result list.
+	fuzzyScroll             int                           // Viewport offset for the result list.
+	fuzzyCandidates         []string                      // Raw list of all possible items (files/buffers/etc.).
+	fuzzyType               FuzzyType                     // What the fuzzy finder is searching for.
+	fuzzyDiagnostics        []DiagnosticItem              // Diagnostics from all buffers (accessible via finder).
+	fuzzyTags               []TagItem                     // Tags parsed from the ctags `tags` file (accessible via finder).
+	fuzzyCodeActions        []CodeAction                  // Code actions offered for the cursor position (accessible via finder).
+	fuzzyRenameEdit         *WorkspaceEdit                // Pending rename awaiting confirmation in the FuzzyModeRenamePreview list.
+	fuzzyRenameLabel        string                        // "newName across N location(s) in M file(s)", shown as the preview's confirm row.
+	fuzzyPreviewCache       map[string]*fuzzyPreviewEntry // Cached, mtime-invalidated previews (see fuzzypreview.go).
+	fuzzySearchCancel       context.CancelFunc            // Cancels the in-flight background scoring pass, if any (see fuzzysearch.go).
+	mruFiles                []string                      // Most-recently-opened files, most recent first, persisted to ~/.qwe/mru.json.
+	mouseEnabled            bool                          // Toggle for mouse support.
+	screenBaseY             int                           // Row offset of the reserved render region (see resolveHeight/--height).
+	trueColorQueue          []trueColorCell               // Cells pending the true-color overlay pass (see truecolor.go), cleared each frame.
+	lastCursorX             int                           // Terminal cursor column set this frame (see setCursor), restored after the overlay paints.
+	lastCursorY             int                           // Terminal cursor row set this frame (see setCursor), restored after the overlay paints.
+	visualStartX            int                           // Starting anchor for visual selection.
+	visualStartY            int                           // Starting anchor for visual selection.
+	lastVisualStartY        int                           // Line of the '< mark (start of the last visual selection).
+	lastVisualEndY          int                           // Line of the '> mark (end of the last visual selection).
+	marks                   map[rune]int                  // Named marks ('a-'z), resolved to 0-based line numbers.
+	logMessages             []string                      // Internal debug logs shown in the Log window.
+	maxLogMessages          int                           // Maximum capacity of the log ring buffer.
+	messageLog              []Message                     // Append-only history of status messages, shown via :messages.
+	maxMessageLog           int                           // Maximum capacity of the message-log ring buffer.
+	showDebugLog            bool                          // Visibility toggle for the log window.
+	jumplist                []Jump                        // History of cursor locations (for Ctrl-O/Ctrl-I).
+	jumpIndex               int                           // Current position in the jumplist.
+	insertGroupOpen         bool                          // Whether consecutive insert-mode edits coalesce into one undo entry (see beginInsertGroup).
+	nextInsertGroup         int64                         // Last-minted coalescing group id; incremented by beginInsertGroup.
+	insertedText            []rune                        // Text typed during the current insert session, saved to "." on exit (see registers.go).
+	message                 string                        // Status message shown at the bottom.
+	commands                *Command                      // Command handler instance.
+	devMode                 bool                          // Internal developer mode toggle.
+	ollamaClient            *OllamaClient                 // Client for local AI features.
+	introDismissed          bool                          // Whether the splash screen was hidden.
+	activeShellJob          *ShellJob                     // Currently running :! or :r! job, if any.
+	LogBuf                  *Buffer                       // Persistent BufTypeLog buffer mirroring addLog output, opened via :buffer log.
+	plumbRules              []PlumbRule                   // Rules loaded from plumb.rules, tried in order by gotoFile (see plumber.go).
+	lspManager              *LSPManager                   // Shares one LSP server process per (language, workspace root) across buffers (see lsp_manager.go).
+
+	// AddCursorAtNextMatch/SkipMultiCursor/SkipMultiCursorBack session state
+	// (see multicursor.go). multiCursorHistory records, oldest first, every
+	// cursor the current session has placed, since mergeCursors re-sorts
+	// b.cursors by position and loses the order cursors were added in.
+	multiCursorMatch     string   // Text the current session is searching for; "" when no session is active.
+	multiCursorWholeWord bool     // Whether matches must fall on whole-word boundaries (word-under-cursor) vs. exact substring (visual selection).
+	multiCursorHistory   []Cursor // Cursors placed by this session, oldest first.
 
 	// Replace mode state (regex replacement UI)
 	replaceInput     []rune
@@ -111,14 +197,56 @@ type Editor struct {
 	replaceSelEndY   int
 	replaceMatches   []MatchRange
 	pendingConfirm   func() // Callback for the confirmation mode.
-	hoverContent     string // Text content for the LSP hover popup.
-	showHover        bool   // Visibility toggle for the hover popup.
+	pendingCancel    func() // Optional callback run when a confirmation is declined/cancelled.
+
+	// Project-wide replace state (see projectreplace.go). Populated by
+	// :project-replace and consumed by :project-replace-apply; the user's
+	// only way to narrow the scope is to delete lines out of the
+	// "[Project Replace]" preview buffer before running apply.
+	projectReplacePattern     string
+	projectReplaceReplacement string
+	projectReplaceFlags       ReplaceFlags
+	projectReplaceEntries     []projectReplaceEntry
+
+	// AI-assisted transform state (see aitransform.go). Mirrors the Replace
+	// mode bookkeeping above (selection bounds captured up front, restored
+	// on retry) but kept in its own fields rather than reusing replaceSel*,
+	// so the two features can't alias each other's in-flight selection.
+	aiTransformInput     []rune
+	aiTransformSelStartX int
+	aiTransformSelStartY int
+	aiTransformSelEndX   int
+	aiTransformSelEndY   int
+	aiTransformOriginal  string // Selection text captured once at startAITransformMode, so a retry doesn't require reselecting.
+	aiTransformResponse  string // Latest Ollama response, pending the ModeConfirm apply/retry gate.
+	aiTransformPresetIdx int    // Cursor into aiTransformPresets, advanced each time Tab cycles a suggestion into the input.
+
+	hoverContent string // Text content for the LSP hover popup.
+	showHover    bool   // Visibility toggle for the hover popup.
+
+	// Signature help state
+	showSignature    bool          // Visibility toggle for the signature help popup.
+	signatureContent SignatureInfo // Active call's signature, parameters, and which one is active.
 
 	// Autocomplete state
-	showAutocomplete   bool             // Visibility toggle for the autocomplete popup.
-	autocompleteItems  []CompletionItem // List of completion suggestions from LSP.
-	autocompleteIndex  int              // Currently selected item in the autocomplete list.
-	autocompleteScroll int              // Scroll offset for autocomplete popup.
+	showAutocomplete   bool               // Visibility toggle for the autocomplete popup.
+	autocompleteItems  []CompletionItem   // List of completion suggestions from LSP.
+	autocompleteIndex  int                // Currently selected item in the autocomplete list.
+	autocompleteScroll int                // Scroll offset for autocomplete popup.
+	completionGen      int64              // Bumped on every keystroke that should invalidate an in-flight completion request; see triggerAutocomplete.
+	completionTimer    *time.Timer        // Debounces triggerAutocomplete so fast typing issues one LSP request, not one per keystroke.
+	completionCancel   context.CancelFunc // Cancels the in-flight textDocument/completion request for the current autocomplete, if any (mirrors ghostCancel below).
+	resolveTimer       *time.Timer        // Debounces resolveSelectedCompletion so arrowing through the list quickly doesn't fire one completionItem/resolve per item skipped over.
+
+	// Snippet tabstop state (see snippet.go), live after accepting a
+	// snippet-format completion.
+	snippetStops []SnippetStop // Tabstops left to visit, in LSP stop order ($0 last); nil when no snippet is active.
+	snippetIndex int           // Index into snippetStops the cursor currently sits on.
+
+	// Ghost-text (inline AI suggestion) state; see ghosttext.go.
+	ghostGen    int64              // Bumped on every keystroke/cursor move that should invalidate an in-flight suggestion; see triggerGhostText.
+	ghostTimer  *time.Timer        // Debounces triggerGhostText the same way completionTimer debounces triggerAutocomplete.
+	ghostCancel context.CancelFunc // Cancels the in-flight Ollama request for the current suggestion, if any.
 }
 
 // activeBuffer returns the Buffer currently being edited.
@@ -141,7 +269,53 @@ func (e *Editor) markModified() {
 	b := e.activeBuffer()
 	if b != nil {
 		b.modified = true
+		b.searchMatches = nil
+	}
+}
+
+// invalidateSearchMatches clears every buffer's cached hlsearch match lines,
+// not just the active one. lastSearch (and hlsearch highlighting) is shared
+// editor-wide state, but hlsearchMatchesForLine's cache is per-buffer and
+// keyed only by line number, with nothing recording which search term it was
+// computed against; committing a new search must invalidate all of them, or
+// a buffer visited before the change keeps showing highlights for whatever
+// term was active the last time one of its lines was rendered.
+func (e *Editor) invalidateSearchMatches() {
+	for _, b := range e.buffers {
+		b.searchMatches = nil
+	}
+}
+
+// hlsearchMatchesForLine returns the hlsearch match ranges for one line,
+// computing and caching them on first access. Scrolling through a large file
+// redraws the same lines repeatedly without editing them, so this avoids
+// re-scanning every visible line on every frame.
+func (e *Editor) hlsearchMatchesForLine(b *Buffer, y int) []MatchRange {
+	if matches, ok := b.searchMatches[y]; ok {
+		return matches
+	}
+
+	if b.searchMatches == nil {
+		b.searchMatches = make(map[int][]MatchRange)
+	}
+
+	var matches []MatchRange
+	if e.lastSearch != "" && y >= 0 && y < len(b.buffer) {
+		for _, m := range e.searchMatchesInLine(b.buffer[y], e.lastSearch) {
+			matches = append(matches, MatchRange{startLine: y, startCol: m[0], endLine: y, endCol: m[1]})
+		}
 	}
+
+	b.searchMatches[y] = matches
+	return matches
+}
+
+// UnhighlightSearch turns off hlsearch highlighting without forgetting
+// lastSearch, mirroring vim's :nohlsearch (bound here to Esc in Normal mode).
+// Starting a new search re-enables it.
+func (e *Editor) UnhighlightSearch() {
+	e.hlsearch = false
+	e.setMessage("Search highlight cleared")
 }
 
 func (e *Editor) visualWidth(r rune, currentX int) int {
@@ -196,25 +370,108 @@ func NewEditor(devMode bool) *Editor {
 		mouseEnabled:      true,
 		logMessages:       []string{},
 		maxLogMessages:    50,
+		messageLog:        []Message{},
+		maxMessageLog:     500,
 		showDebugLog:      false,
 		jumplist:          []Jump{},
 		jumpIndex:         -1,
+		marks:             make(map[rune]int),
+		registers:         newRegisters(),
+		macros:            make(map[rune][]termbox.Event),
 		devMode:           devMode,
 		ollamaClient:      NewOllamaClient(),
+		fuzzyPreviewCache: make(map[string]*fuzzyPreviewEntry),
+		lspManager:        NewLSPManager(),
 	}
-	e.addLog("Editor", "Editor initialized")
 	// Add an initial empty buffer with default file type
 	defaultType := fileTypes[len(fileTypes)-1]
 	e.buffers = append(e.buffers, &Buffer{
 		buffer:    [][]rune{{}},
-		undoStack: []HistoryState{},
-		redoStack: []HistoryState{},
+		undoStack: []Edit{},
+		redoStack: []Edit{},
 		fileType:  defaultType,
 	})
+
+	// The Log buffer mirrors addLog's output and stays open for the whole
+	// session so it can be reached via :buffer log or the buffer fuzzy
+	// finder, in addition to the logMessages ring shown by the debug window.
+	logBuf := &Buffer{
+		buffer:    [][]rune{{}},
+		filename:  "[Log]",
+		undoStack: []Edit{},
+		redoStack: []Edit{},
+		fileType:  getFileType("[Log]"),
+	}
+	logBuf.setBufType(BufTypeLog)
+	e.LogBuf = logBuf
+	e.buffers = append(e.buffers, logBuf)
+
+	e.addLog("Editor", "Editor initialized")
 	e.commands = &Command{e: e}
+	e.LoadHistories()
+	e.LoadMRU()
+	e.LoadJumplist()
+	e.LoadPlumbRules()
+	e.LoadNodeObjectRules()
+	e.LoadKeybindings()
 	return e
 }
 
+// MessageSeverity classifies an entry in the message log so :messages can
+// render failures distinctly from routine status updates.
+type MessageSeverity int
+
+const (
+	MessageInfo MessageSeverity = iota
+	MessageWarning
+	MessageError
+)
+
+// Message is one entry in the append-only status message log shown by
+// :messages. Unlike e.message, which is overwritten by the next status
+// update, the log preserves every message for the session (up to
+// maxMessageLog entries).
+type Message struct {
+	Timestamp time.Time
+	Severity  MessageSeverity
+	Text      string
+}
+
+// inferMessageSeverity guesses a severity from the wording of a status
+// message, since call sites only ever set plain text (there's no separate
+// severity parameter threaded through ch.e.message assignments).
+func inferMessageSeverity(text string) MessageSeverity {
+	lower := strings.ToLower(text)
+	switch {
+	case strings.Contains(lower, "error"), strings.Contains(lower, "failed"), strings.Contains(lower, "invalid"):
+		return MessageError
+	case strings.Contains(lower, "warn"), strings.Contains(lower, "no "), strings.Contains(lower, "not found"):
+		return MessageWarning
+	default:
+		return MessageInfo
+	}
+}
+
+// setMessage sets the status line shown at the bottom of the screen and
+// appends the message to the ring-buffered log that :messages displays, so
+// earlier errors and shell output aren't lost the moment the next command
+// runs.
+func (e *Editor) setMessage(text string) {
+	e.message = text
+	if text == "" {
+		return
+	}
+
+	e.messageLog = append(e.messageLog, Message{
+		Timestamp: time.Now(),
+		Severity:  inferMessageSeverity(text),
+		Text:      text,
+	})
+	if len(e.messageLog) > e.maxMessageLog {
+		e.messageLog = e.messageLog[len(e.messageLog)-e.maxMessageLog:]
+	}
+}
+
 func (e *Editor) addLog(group, msg string) {
 	t := time.Now()
 	timestamp := fmt.Sprintf("[%02d:%01d:%02d]", t.Hour(), t.Minute(), t.Second())
@@ -225,6 +482,14 @@ func (e *Editor) addLog(group, msg string) {
 		e.logMessages = e.logMessages[len(e.logMessages)-e.maxLogMessages:]
 	}
 
+	if e.LogBuf != nil {
+		if len(e.LogBuf.buffer) == 1 && len(e.LogBuf.buffer[0]) == 0 {
+			e.LogBuf.buffer[0] = []rune(logMsg)
+		} else {
+			e.LogBuf.buffer = append(e.LogBuf.buffer, []rune(logMsg))
+		}
+	}
+
 	if Config.UseLogFile {
 		f, err := os.OpenFile(Config.LogFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err == nil {
@@ -273,15 +538,68 @@ func (e *Editor) LoadFile(filename string) error {
 		if info != nil {
 			e.activeBuffer().lastModTime = info.ModTime()
 		}
+		e.recordMRU(filename)
+		if info != nil && swapFileNewerThan(filename, info.ModTime()) {
+			e.offerSwapRecovery(filename)
+		}
 	}
 	return err
 }
 
+// offerSwapRecovery prompts the user to recover unsaved edits found in
+// filename's swap file (see recovery.go). Recovering applies the swap
+// content to the just-loaded buffer and marks it modified; declining simply
+// discards the stale swap file.
+func (e *Editor) offerSwapRecovery(filename string) {
+	b := e.activeBuffer()
+	if b == nil || b.filename != filename {
+		return
+	}
+
+	e.setMessage(recoveryPromptMessage(filename))
+	e.mode = ModeConfirm
+	e.pendingConfirm = func() {
+		state, err := readSwapFile(filename)
+		if err != nil {
+			e.setMessage(fmt.Sprintf("Failed to read swap file: %v", err))
+			return
+		}
+		applySwapState(b, state)
+		e.setMessage(fmt.Sprintf("Recovered unsaved changes for %q", filepath.Base(filename)))
+	}
+	e.pendingCancel = func() {
+		removeSwapFile(filename)
+	}
+}
+
+// LoadFromReader loads filename's content as a normal (BufTypeDefault)
+// buffer. See LoadFromReaderAs to load it as a Help/Log/Scratch/Raw buffer
+// instead.
 func (e *Editor) LoadFromReader(filename string, r io.Reader) error {
+	return e.LoadFromReaderAs(filename, r, BufTypeDefault)
+}
+
+// LoadFromReaderAs is LoadFromReader with an explicit BufType. Syntax
+// highlighting and LSP are only initialized for BufTypeDefault buffers;
+// other types are inert, read-only-by-default views into text the editor
+// generated itself.
+func (e *Editor) LoadFromReaderAs(filename string, r io.Reader, bufType BufType) error {
 	ft := getFileType(filename)
 
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	enc, encName := detectEncoding(raw, Config.DefaultEncoding)
+	lineEnding := detectLineEnding(raw)
+	decoded, err := decodeBytes(raw, enc, encName)
+	if err != nil {
+		return fmt.Errorf("decoding %s as %s: %w", filename, encName, err)
+	}
+
 	var bufferLines [][]rune
-	reader := bufio.NewReader(r)
+	reader := bufio.NewReader(strings.NewReader(decoded))
 	for {
 		line, err := reader.ReadString('\n')
 		if err != nil && err != io.EOF {
@@ -321,59 +639,71 @@ func (e *Editor) LoadFromReader(filename string, r io.Reader) error {
 		b.PrimaryCursor().Y = 0
 		b.scrollX = 0
 		b.scrollY = 0
-		b.undoStack = []HistoryState{}
-		b.redoStack = []HistoryState{}
-		b.redoStack = []HistoryState{}
+		b.undoStack = nil
+		b.redoStack = nil
+		b.redoStack = nil
 		b.fileType = ft
+		b.encoding = encName
+		b.lineEnding = lineEnding
+		b.setBufType(bufType)
+
+		if bufType == BufTypeDefault {
+			// Initialize Syntax Highlighter
+			syntax := NewSyntaxHighlighter(ft.Name, e.addLog)
+			if syntax != nil {
+				content := []byte(e.bufferToString(bufferLines))
+				syntax.Parse(content)
+				b.syntax = syntax
+				b.syntaxBytes = content
+			}
 
-		// Initialize Syntax Highlighter
-		syntax := NewSyntaxHighlighter(ft.Name, e.addLog)
-		if syntax != nil {
-			content := e.bufferToString(bufferLines)
-			syntax.Parse([]byte(content))
-			b.syntax = syntax
-		}
-
-		// Initialize LSP if enabled for this file type
-		if ft.EnableLSP && ft.LSPCommand != "" {
-			e.addLog("LSP", fmt.Sprintf("Starting LSP for %s", filepath.Base(filename)))
-			content := e.bufferToString(bufferLines)
-			lspClient, err := NewLSPClient(filename, content, e.addLog, ft)
-			if err == nil {
-				b.lspClient = lspClient
-				e.addLog("LSP", "LSP client initialized successfully")
-			} else {
-				e.addLog("LSP", fmt.Sprintf("LSP init failed: %v", err))
+			// Initialize LSP if enabled for this file type
+			if ft.EnableLSP && ft.LSPCommand != "" {
+				e.addLog("LSP", fmt.Sprintf("Starting LSP for %s", filepath.Base(filename)))
+				content := e.bufferToString(bufferLines)
+				lspClient, err := e.lspManager.Open(filename, content, e.addLog, ft)
+				if err == nil {
+					b.lspClient = lspClient
+					e.addLog("LSP", "LSP client initialized successfully")
+				} else {
+					e.addLog("LSP", fmt.Sprintf("LSP init failed: %v", err))
+				}
 			}
 		}
 	} else {
 		// add new buffer
 		newB := &Buffer{
-			buffer:    bufferLines,
-			filename:  filename,
-			undoStack: []HistoryState{},
-			redoStack: []HistoryState{},
-			fileType:  ft,
-		}
-
-		// Initialize Syntax Highlighter
-		syntax := NewSyntaxHighlighter(ft.Name, e.addLog)
-		if syntax != nil {
-			content := e.bufferToString(bufferLines)
-			syntax.Parse([]byte(content))
-			newB.syntax = syntax
-		}
+			buffer:     bufferLines,
+			filename:   filename,
+			undoStack:  []Edit{},
+			redoStack:  []Edit{},
+			fileType:   ft,
+			encoding:   encName,
+			lineEnding: lineEnding,
+		}
+		newB.setBufType(bufType)
+
+		if bufType == BufTypeDefault {
+			// Initialize Syntax Highlighter
+			syntax := NewSyntaxHighlighter(ft.Name, e.addLog)
+			if syntax != nil {
+				content := []byte(e.bufferToString(bufferLines))
+				syntax.Parse(content)
+				newB.syntax = syntax
+				newB.syntaxBytes = content
+			}
 
-		// Initialize LSP if enabled for this file type
-		if ft.EnableLSP && ft.LSPCommand != "" {
-			e.addLog("LSP", fmt.Sprintf("Starting LSP for %s", filepath.Base(filename)))
-			content := e.bufferToString(bufferLines)
-			lspClient, err := NewLSPClient(filename, content, e.addLog, ft)
-			if err == nil {
-				newB.lspClient = lspClient
-				e.addLog("LSP", "LSP client initialized successfully")
-			} else {
-				e.addLog("LSP", fmt.Sprintf("LSP init failed: %v", err))
+			// Initialize LSP if enabled for this file type
+			if ft.EnableLSP && ft.LSPCommand != "" {
+				e.addLog("LSP", fmt.Sprintf("Starting LSP for %s", filepath.Base(filename)))
+				content := e.bufferToString(bufferLines)
+				lspClient, err := e.lspManager.Open(filename, content, e.addLog, ft)
+				if err == nil {
+					newB.lspClient = lspClient
+					e.addLog("LSP", "LSP client initialized successfully")
+				} else {
+					e.addLog("LSP", fmt.Sprintf("LSP init failed: %v", err))
+				}
 			}
 		}
 
@@ -390,6 +720,9 @@ func (e *Editor) SaveFile(force bool) error {
 	if b == nil || b.filename == "" {
 		return fmt.Errorf("no filename")
 	}
+	if b.bufType == BufTypeScratch || b.bufType == BufTypeLog {
+		return fmt.Errorf("cannot write a scratch/log buffer")
+	}
 
 	// Check for external modifications unless forced.
 	if !force {
@@ -399,33 +732,61 @@ func (e *Editor) SaveFile(force bool) error {
 		}
 	}
 
-	file, err := os.Create(b.filename)
+	nl := b.lineEnding.bytes()
+	var content strings.Builder
+	for i, line := range b.buffer {
+		content.WriteString(string(line))
+		// Write newline if not the last line (or if buffer should end with newline).
+		if i < len(b.buffer)-1 || (len(b.buffer) > 0 && (len(b.buffer) > 1 || len(b.buffer[0]) > 0)) {
+			content.WriteString(nl)
+		}
+	}
+
+	enc, _, lookupErr := lookupEncoding(b.encoding)
+	if lookupErr != nil {
+		enc, _, _ = lookupEncoding("utf-8")
+	}
+	raw, err := encodeString(content.String(), enc)
+	if err != nil {
+		return fmt.Errorf("encoding %s as %s: %w", b.filename, b.encoding, err)
+	}
+
+	// Snapshot the previous version before we clobber it, then write the new
+	// content to a temp file and fsync/rename over the target so a crash or
+	// power loss mid-write never leaves a truncated file on disk.
+	if err := backupBeforeOverwrite(b.filename); err != nil {
+		e.addLog("Editor", fmt.Sprintf("Backup of %q failed: %v", b.filename, err))
+	}
+
+	tmp := b.filename + ".tmp"
+	file, err := os.Create(tmp)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
 	writer := bufio.NewWriter(file)
-	for i, line := range b.buffer {
-		_, err := writer.WriteString(string(line))
-		if err != nil {
-			return err
-		}
-		// Write newline if not the last line (or if buffer should end with newline).
-		if i < len(b.buffer)-1 || (len(b.buffer) > 0 && (len(b.buffer) > 1 || len(b.buffer[0]) > 0)) {
-			_, err = writer.WriteString("\n")
-			if err != nil {
-				return err
-			}
-		}
+	_, err = writer.Write(raw)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, b.filename)
 	}
-	err = writer.Flush()
 	if err == nil {
 		b.modified = false
-		info, err := os.Stat(b.filename)
-		if err == nil {
+		info, statErr := os.Stat(b.filename)
+		if statErr == nil {
 			b.lastModTime = info.ModTime()
 		}
+		removeSwapFile(b.filename)
+	} else {
+		os.Remove(tmp)
 	}
 	return err
 }
@@ -509,12 +870,14 @@ func (e *Editor) ReloadBuffer(b *Buffer) error {
 
 	// Reinitialize Syntax Highlighter
 	if b.syntax != nil {
-		b.syntax.Reparse([]byte(b.toString()))
+		b.syntaxReparse()
 	} else {
 		syntax := NewSyntaxHighlighter(ft.Name, e.addLog)
 		if syntax != nil {
-			syntax.Parse([]byte(b.toString()))
+			content := []byte(b.toString())
+			syntax.Parse(content)
 			b.syntax = syntax
+			b.syntaxBytes = content
 		}
 	}
 
@@ -539,20 +902,27 @@ func (e *Editor) CheckFilesOnDisk() {
 
 		if info.ModTime().After(b.lastModTime) {
 			isActive := b == e.activeBuffer()
+
+			// Tell every LSP server about the out-of-band edit, since it has
+			// no way to watch the filesystem itself.
+			if absPath, err := filepath.Abs(b.filename); err == nil {
+				e.lspManager.NotifyWatchedFileChanged("file://"+absPath, 2)
+			}
+
 			if !b.modified {
 				// Auto reload if not dirty
 				err := e.ReloadBuffer(b)
 				if err == nil {
 					e.addLog("Editor", fmt.Sprintf("Auto-reloaded \"%s\" (changed on disk)", filepath.Base(b.filename)))
 					if isActive {
-						e.message = fmt.Sprintf("\"%s\" reloaded from disk", filepath.Base(b.filename))
+						e.setMessage(fmt.Sprintf("\"%s\" reloaded from disk", filepath.Base(b.filename)))
 					}
 				} else {
 					e.addLog("Editor", fmt.Sprintf("Failed to auto-reload \"%s\": %v", b.filename, err))
 				}
 			} else if isActive {
 				// Buffer is dirty, just notify the user (only if active)
-				e.message = fmt.Sprintf("WARNING: \"%s\" changed on disk. Use :reload to update.", filepath.Base(b.filename))
+				e.setMessage(fmt.Sprintf("WARNING: \"%s\" changed on disk. Use :reload to update.", filepath.Base(b.filename)))
 				e.addLog("Editor", fmt.Sprintf("\"%s\" changed on disk but buffer is modified", b.filename))
 				// Update lastModTime so we don't spam the message?
 				// Actually, better to keep it so they realize it's still different.
@@ -569,6 +939,34 @@ func (e *Editor) PeriodicFileChangesCheck() {
 			termbox.Interrupt()
 		}
 	}()
+
+	go func() {
+		for {
+			time.Sleep(Config.SwapInterval)
+			e.writeSwapFiles()
+		}
+	}()
+
+	go func() {
+		for {
+			time.Sleep(Config.JumpSaveInterval)
+			e.FlushJumplist()
+		}
+	}()
+}
+
+// writeSwapFiles serializes every dirty on-disk buffer to its swap file so a
+// crash or power loss loses at most Config.SwapInterval of edits. See
+// recovery.go for the swap file format and LoadFile for recovery.
+func (e *Editor) writeSwapFiles() {
+	for _, b := range e.buffers {
+		if b.filename == "" || !b.modified {
+			continue
+		}
+		if err := writeSwapFile(b); err != nil {
+			e.addLog("Editor", fmt.Sprintf("Swap write for %q failed: %v", b.filename, err))
+		}
+	}
 }
 
 func (e *Editor) startFileFuzzyFinder() {
@@ -583,6 +981,9 @@ func (e *Editor) startFileFuzzyFinder() {
 			}
 			return nil
 		}
+		if fuzzyIgnored(path) {
+			return nil
+		}
 		e.fuzzyCandidates = append(e.fuzzyCandidates, path)
 		return nil
 	})
@@ -593,6 +994,32 @@ func (e *Editor) startFileFuzzyFinder() {
 	e.mode = ModeFuzzy
 }
 
+// fuzzyIgnored reports whether path matches one of Config.FuzzyIgnore, a
+// list of shell globs (as understood by filepath.Match) applied in addition
+// to the hard-coded .git/node_modules skip above. "**" in a glob is treated
+// as matching any number of path segments.
+func fuzzyIgnored(path string) bool {
+	for _, pattern := range Config.FuzzyIgnore {
+		if pattern == "" {
+			continue
+		}
+		if strings.Contains(pattern, "**") {
+			prefix := strings.SplitN(pattern, "**", 2)[0]
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+			continue
+		}
+		if matched, err := filepath.Match(pattern, path); err == nil && matched {
+			return true
+		}
+		if matched, err := filepath.Match(pattern, filepath.Base(path)); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
 func (e *Editor) startBufferFuzzyFinder() {
 	e.fuzzyCandidates = []string{}
 	for _, b := range e.buffers {
@@ -664,6 +1091,74 @@ func (e *Editor) startWarningsFuzzyFinder() {
 	e.mode = ModeFuzzy
 }
 
+// startDirectoryFuzzyFinder walks the tree collecting only directories.
+// Selecting one (see openSelectedFile) changes the process working
+// directory, which subsequent file finders and LSP root detection pick up.
+func (e *Editor) startDirectoryFuzzyFinder() {
+	e.fuzzyCandidates = []string{}
+	filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if info.Name() == ".git" || info.Name() == "node_modules" {
+			return filepath.SkipDir
+		}
+		if path == "." {
+			return nil
+		}
+		if fuzzyIgnored(path) {
+			return filepath.SkipDir
+		}
+		e.fuzzyCandidates = append(e.fuzzyCandidates, path)
+		return nil
+	})
+	e.fuzzyBuffer = []rune{}
+	e.fuzzyIndex = 0
+	e.fuzzyType = FuzzyModeDirectory
+	e.updateFuzzyResults()
+	e.mode = ModeFuzzy
+}
+
+// startTagFuzzyFinder reads a ctags-style `tags` file from the project root
+// and offers each tag as a candidate. Selecting one (see openSelectedFile)
+// jumps to the tag's file/line, pushing the current location onto the
+// jumplist first.
+func (e *Editor) startTagFuzzyFinder() {
+	e.fuzzyCandidates = []string{}
+	e.fuzzyTags = []TagItem{}
+
+	file, err := os.Open("tags")
+	if err != nil {
+		e.setMessage(fmt.Sprintf("Error opening tags file: %v", err))
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "!") {
+			continue // Skip blank lines and ctags header (!_TAG_*) entries.
+		}
+		parts := strings.SplitN(line, "\t", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		tag := TagItem{name: parts[0], file: parts[1], address: parts[2]}
+		e.fuzzyTags = append(e.fuzzyTags, tag)
+		e.fuzzyCandidates = append(e.fuzzyCandidates, fmt.Sprintf("%s\t%s", tag.name, tag.file))
+	}
+
+	e.fuzzyBuffer = []rune{}
+	e.fuzzyIndex = 0
+	e.fuzzyType = FuzzyModeTag
+	e.updateFuzzyResults()
+	e.mode = ModeFuzzy
+}
+
 func fuzzyMatch(query, target string) (int, bool) {
 	if query == "" {
 		return 0, true
@@ -720,43 +1215,93 @@ func fuzzyMatch(query, target string) (int, bool) {
 	return score, true
 }
 
+// mruBonus returns a score bonus for a file candidate decaying with its MRU
+// rank (0 = most recently opened), or 0 if the candidate isn't in the MRU
+// list or the finder isn't in file mode.
+func (e *Editor) mruBonus(candidate string) int {
+	if e.fuzzyType != FuzzyModeFile {
+		return 0
+	}
+	rank, ok := e.mruRank(candidate)
+	if !ok {
+		return 0
+	}
+	bonus := 60 - rank*3
+	if bonus < 0 {
+		bonus = 0
+	}
+	return bonus
+}
+
+// updateFuzzyResults re-filters fuzzyCandidates against the current query.
+// An empty query is cheap (just MRU ordering) and is applied synchronously;
+// a non-empty query is scored in a cancellable background goroutine (see
+// fuzzysearch.go) so repos with tens of thousands of candidates don't stall
+// typing while the full list is rescored.
 func (e *Editor) updateFuzzyResults() {
+	e.cancelFuzzySearch()
+
 	query := string(e.fuzzyBuffer)
+	e.fuzzyResultSpans = nil
 	if query == "" {
-		e.fuzzyResults = make([]string, len(e.fuzzyCandidates))
-		e.fuzzyResultIndices = make([]int, len(e.fuzzyCandidates))
-		copy(e.fuzzyResults, e.fuzzyCandidates)
-		for i := range e.fuzzyResultIndices {
-			e.fuzzyResultIndices[i] = i
-		}
-	} else {
-		type result struct {
-			path  string
-			index int
-			score int
-		}
-		var results []result
-		for i, candidate := range e.fuzzyCandidates {
-			if score, ok := fuzzyMatch(query, candidate); ok {
-				results = append(results, result{candidate, i, score})
+		if e.fuzzyType == FuzzyModeFile && len(e.mruFiles) > 0 {
+			e.fuzzyResults, e.fuzzyResultIndices = e.mruFirstCandidates()
+		} else {
+			e.fuzzyResults = make([]string, len(e.fuzzyCandidates))
+			e.fuzzyResultIndices = make([]int, len(e.fuzzyCandidates))
+			copy(e.fuzzyResults, e.fuzzyCandidates)
+			for i := range e.fuzzyResultIndices {
+				e.fuzzyResultIndices[i] = i
 			}
 		}
+		if e.fuzzyIndex >= len(e.fuzzyResults) {
+			e.fuzzyIndex = 0
+		}
+		e.fuzzyScroll = 0
+		return
+	}
 
-		sort.Slice(results, func(i, j int) bool {
-			return results[i].score > results[j].score
-		})
+	e.fuzzyResults = nil
+	e.fuzzyResultIndices = nil
+	e.fuzzyIndex = 0
+	e.fuzzyScroll = 0
+	e.startFuzzySearch(query)
+}
+
+// mruFirstCandidates orders fuzzyCandidates with MRU entries first (most
+// recent first), followed by the remaining candidates in their original
+// order. Used when the fuzzy finder query is empty so recently opened files
+// float to the top like FuzzyFinderTextMate/FUF do.
+func (e *Editor) mruFirstCandidates() ([]string, []int) {
+	indexByPath := make(map[string]int, len(e.fuzzyCandidates))
+	for i, c := range e.fuzzyCandidates {
+		indexByPath[c] = i
+	}
+
+	seen := make(map[string]bool, len(e.mruFiles))
+	results := make([]string, 0, len(e.fuzzyCandidates))
+	indices := make([]int, 0, len(e.fuzzyCandidates))
 
-		e.fuzzyResults = make([]string, len(results))
-		e.fuzzyResultIndices = make([]int, len(results))
-		for i, res := range results {
-			e.fuzzyResults[i] = res.path
-			e.fuzzyResultIndices[i] = res.index
+	for _, mruPath := range e.mruFiles {
+		rel, err := filepath.Rel(".", mruPath)
+		if err != nil {
+			rel = mruPath
+		}
+		if idx, ok := indexByPath[rel]; ok {
+			results = append(results, rel)
+			indices = append(indices, idx)
+			seen[rel] = true
 		}
 	}
-	if e.fuzzyIndex >= len(e.fuzzyResults) {
-		e.fuzzyIndex = 0
+
+	for i, c := range e.fuzzyCandidates {
+		if !seen[c] {
+			results = append(results, c)
+			indices = append(indices, i)
+		}
 	}
-	e.fuzzyScroll = 0
+
+	return results, indices
 }
 
 func (e *Editor) openSelectedFile() {
@@ -832,25 +1377,114 @@ func (e *Editor) openSelectedFile() {
 			}
 			e.mode = ModeNormal
 		}
+	} else if e.fuzzyType == FuzzyModeDirectory {
+		if err := os.Chdir(selection); err == nil {
+			e.mode = ModeNormal
+		} else {
+			e.setMessage(fmt.Sprintf("Error changing directory: %v", err))
+		}
+	} else if e.fuzzyType == FuzzyModeTag {
+		if e.fuzzyIndex >= len(e.fuzzyResultIndices) {
+			return
+		}
+
+		tagIndex := e.fuzzyResultIndices[e.fuzzyIndex]
+		if tagIndex < 0 || tagIndex >= len(e.fuzzyTags) {
+			return
+		}
+
+		e.jumpToTag(e.fuzzyTags[tagIndex])
+		e.mode = ModeNormal
+	} else if e.fuzzyType == FuzzyModeCodeAction {
+		if e.fuzzyIndex >= len(e.fuzzyResultIndices) {
+			return
+		}
+
+		actionIndex := e.fuzzyResultIndices[e.fuzzyIndex]
+		if actionIndex < 0 || actionIndex >= len(e.fuzzyCodeActions) {
+			return
+		}
+
+		action := e.fuzzyCodeActions[actionIndex]
+		e.mode = ModeNormal
+		e.runCodeAction(action)
+	} else if e.fuzzyType == FuzzyModeRenamePreview {
+		e.mode = ModeNormal
+		e.confirmRenamePreview()
 	}
 }
 
-func (e *Editor) fuzzyMove(dir int) {
-	if len(e.fuzzyResults) == 0 {
-		return
+// jumpToTag opens tag.file if needed and moves the cursor to the location
+// described by tag.address, which is either a 1-based line number or a
+// ctags /pattern/ (the first matching line is used). The current cursor
+// position is pushed onto the jumplist first.
+func (e *Editor) jumpToTag(tag TagItem) {
+	e.pushJump()
+
+	bufferIndex := -1
+	for i, b := range e.buffers {
+		absT, _ := filepath.Abs(tag.file)
+		absB, _ := filepath.Abs(b.filename)
+		if absT == absB {
+			bufferIndex = i
+			break
+		}
 	}
-	e.fuzzyIndex += dir
-	if e.fuzzyIndex < 0 {
-		e.fuzzyIndex = len(e.fuzzyResults) - 1
-	} else if e.fuzzyIndex >= len(e.fuzzyResults) {
-		e.fuzzyIndex = 0
+
+	if bufferIndex == -1 {
+		if err := e.LoadFile(tag.file); err != nil {
+			e.setMessage(fmt.Sprintf("Error opening %s: %v", tag.file, err))
+			return
+		}
+		bufferIndex = e.activeBufferIndex
 	}
 
-	// Adjust scroll
-	if e.fuzzyIndex < e.fuzzyScroll {
-		e.fuzzyScroll = e.fuzzyIndex
-	} else if e.fuzzyIndex >= e.fuzzyScroll+Config.FuzzyFinderHeight {
-		e.fuzzyScroll = e.fuzzyIndex - Config.FuzzyFinderHeight + 1
+	e.activeBufferIndex = bufferIndex
+	b := e.activeBuffer()
+	if b == nil {
+		return
+	}
+
+	line := 0
+	if n, err := strconv.Atoi(strings.TrimSuffix(tag.address, ";\"")); err == nil {
+		line = n - 1
+	} else {
+		pattern := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(tag.address, "/"), "/"), ";\"")
+		for i, row := range b.buffer {
+			if strings.Contains(string(row), pattern) {
+				line = i
+				break
+			}
+		}
+	}
+
+	if line < 0 {
+		line = 0
+	}
+	if line >= len(b.buffer) {
+		line = len(b.buffer) - 1
+	}
+	b.PrimaryCursor().Y = line
+	b.PrimaryCursor().X = 0
+	e.centerScreen()
+}
+
+func (e *Editor) fuzzyMove(dir int) {
+	if len(e.fuzzyResults) == 0 {
+		return
+	}
+	e.fuzzyIndex += dir
+	if e.fuzzyIndex < 0 {
+		e.fuzzyIndex = len(e.fuzzyResults) - 1
+	} else if e.fuzzyIndex >= len(e.fuzzyResults) {
+		e.fuzzyIndex = 0
+	}
+
+	// Adjust scroll
+	if e.fuzzyIndex < e.fuzzyScroll {
+		e.fuzzyScroll = e.fuzzyIndex
+	} else if e.fuzzyIndex >= e.fuzzyScroll+Config.FuzzyFinderHeight {
+		e.fuzzyScroll = e.fuzzyIndex - Config.FuzzyFinderHeight + 1
 	}
 
 	// Special case for wrapping
@@ -907,11 +1541,25 @@ func (e *Editor) insertRune(r rune) {
 		return
 	}
 	if b.readOnly {
-		e.message = "File is read-only"
+		e.setMessage("File is read-only")
 		return
 	}
 
 	cursors := e.getSortedCursorsDesc()
+	group := e.beginInsertGroup()
+	cursorsBefore := append([]Cursor(nil), b.cursors...)
+	if len(cursors) != 1 {
+		// Several simultaneous cursors: each insertion could land on a line
+		// another cursor is also about to touch, so there's no single
+		// (y1,x1)-(y2,x2) range describing all of them; fall back to a
+		// whole-buffer snapshot (what every keystroke used before this was
+		// split out), tagged into the same coalescing group as any
+		// single-cursor edits around it.
+		e.pushGroupedSnapshot(group)
+	}
+	e.insertedText = append(e.insertedText, r)
+
+	editY, editX := cursors[0].Y, cursors[0].X
 	for _, c := range cursors {
 		line := b.buffer[c.Y]
 		newLine := make([]rune, len(line)+1)
@@ -919,8 +1567,16 @@ func (e *Editor) insertRune(r rune) {
 		newLine[c.X] = r
 		copy(newLine[c.X+1:], line[c.X:])
 		b.buffer[c.Y] = newLine
+		if len(e.snippetStops) > 0 {
+			e.adjustSnippetStopsForEdit(c.Y, c.X, 1, 0)
+		}
+		editedY, editedX := c.Y, c.X
 		c.X++
 
+		if len(cursors) == 1 {
+			e.pushPreciseEdit(EditInsert, editedY, editedX, editedY, editedX+1, nil, []rune{r}, cursorsBefore, append([]Cursor(nil), b.cursors...), group)
+		}
+
 		// Handle syntax update
 		if b.syntax != nil {
 			insertedBytes := uint32(len(string(r)))
@@ -928,14 +1584,19 @@ func (e *Editor) insertRune(r rune) {
 		}
 	}
 
-	if b.syntax != nil {
-		b.syntax.Reparse([]byte(b.toString()))
-	}
 	e.markModified()
+	e.mergeCursors()
 
-	// Notify LSP of the change
+	// Notify LSP of the change. A single cursor is the common case and gets
+	// the incremental path NotifyChange exists for; multiple simultaneous
+	// cursors fall back to shipping the whole buffer, since their edit
+	// ranges would need adjusting against each other to describe correctly.
 	if b.lspClient != nil {
-		b.lspClient.SendDidChange(b.toString())
+		if len(cursors) == 1 {
+			b.lspClient.NotifyChange(editY, editX, editY, editX, string(r), func() string { return b.toString() })
+		} else {
+			b.lspClient.SendDidChange(b.toString())
+		}
 	}
 }
 
@@ -946,20 +1607,21 @@ func (e *Editor) DeleteChar() {
 		return
 	}
 	if b.readOnly {
-		e.message = "File is read-only"
+		e.setMessage("File is read-only")
 		return
 	}
 
 	cursors := e.getSortedCursorsDesc()
+	editY, editX := cursors[0].Y, cursors[0].X
 	for _, c := range cursors {
 		if c.Y >= len(b.buffer) || c.X >= len(b.buffer[c.Y]) {
 			continue
 		}
 
 		line := b.buffer[c.Y]
-		// Store deleted character in clipboard (primary cursor only).
+		// Store deleted character in the registers (primary cursor only).
 		if c == b.PrimaryCursor() {
-			e.clipboard = []rune{line[c.X]}
+			e.deleteToRegister([]rune{line[c.X]}, RegCharwise)
 		}
 
 		deletedBytes := uint32(len(string(line[c.X])))
@@ -980,10 +1642,16 @@ func (e *Editor) DeleteChar() {
 			b.handleEdit(c.Y, c.X, deletedBytes, 0, c.Y, oldColBytes+deletedBytes, c.Y, newColBytes)
 		}
 	}
-	if b.syntax != nil {
-		b.syntax.Reparse([]byte(b.toString()))
-	}
 	e.markModified()
+	e.mergeCursors()
+
+	if b.lspClient != nil {
+		if len(cursors) == 1 {
+			b.lspClient.NotifyChange(editY, editX, editY, editX+1, "", func() string { return b.toString() })
+		} else {
+			b.lspClient.SendDidChange(b.toString())
+		}
+	}
 }
 
 func (e *Editor) backspace() {
@@ -992,18 +1660,41 @@ func (e *Editor) backspace() {
 		return
 	}
 	if b.readOnly {
-		e.message = "File is read-only"
+		e.setMessage("File is read-only")
 		return
 	}
 
 	cursors := e.getSortedCursorsDesc()
+	group := e.beginInsertGroup()
+	cursorsBefore := append([]Cursor(nil), b.cursors...)
+	if len(cursors) != 1 {
+		// Several simultaneous cursors: a line merge on one cursor shifts
+		// every cursor below it, so there's no single range describing all
+		// of them; fall back to a whole-buffer snapshot (what every
+		// backspace used before this was split out), tagged into the same
+		// coalescing group as any single-cursor edits around it.
+		e.pushGroupedSnapshot(group)
+	}
+
+	origY, origX := cursors[0].Y, cursors[0].X
+	mergedLine := false
 	for _, c := range cursors {
 		if c.X > 0 {
 			line := b.buffer[c.Y]
 			deletedChar := line[c.X-1]
+			delStart := c.X - 1
+			delY := c.Y
 			newLine := append(line[:c.X-1], line[c.X:]...)
 			b.buffer[c.Y] = newLine
 			c.X--
+			b.adjustCursorsForEdit(c, c.Y, delStart, -1, 0)
+			if len(e.snippetStops) > 0 {
+				e.adjustSnippetStopsForEdit(c.Y, delStart, -1, 0)
+			}
+
+			if len(cursors) == 1 {
+				e.pushPreciseEdit(EditDelete, delY, delStart, delY, delStart+1, []rune{deletedChar}, nil, cursorsBefore, append([]Cursor(nil), b.cursors...), group)
+			}
 
 			if b.syntax != nil {
 				deletedBytes := uint32(len(string(deletedChar)))
@@ -1013,39 +1704,50 @@ func (e *Editor) backspace() {
 				b.handleEdit(c.Y, c.X, deletedBytes, 0, c.Y, oldColBytes, c.Y, newColBytes)
 			}
 		} else if c.Y > 0 {
+			mergedLine = true
 			// Merge with previous line
 			prevLine := b.buffer[c.Y-1]
+			mergeY := c.Y
+			prevLineLen := len(prevLine)
 			c.X = len(prevLine)
 			b.buffer[c.Y-1] = append(prevLine, b.buffer[c.Y]...)
 			b.buffer = append(b.buffer[:c.Y], b.buffer[c.Y+1:]...)
-			// We need to shift cursors that are 'below' the current merge point.
-			for j := range b.cursors {
-				if b.cursors[j].Y > c.Y {
-					b.cursors[j].Y--
-				}
-			}
+			// Shift cursors that are 'below' the current merge point.
+			b.adjustCursorsForEdit(c, mergeY, 0, 0, -1)
 
 			c.Y--
 
-			// So I need to find other cursors on the same line that haven't been processed?
-			// Or just all cursors on the same line.
+			// Cursors that were on the line we just merged into the previous
+			// one need to move up onto it too, offset by the merged prefix.
 			for j := range b.cursors {
-				if &b.cursors[j] != c && b.cursors[j].Y == c.Y+1 { // c.Y was decremented
-					// This cursor was on the line we just merged
+				if &b.cursors[j] != c && b.cursors[j].Y == mergeY {
 					b.cursors[j].Y--
-					b.cursors[j].X += len(prevLine)
+					b.cursors[j].X += prevLineLen
 				}
 			}
 
+			if len(cursors) == 1 {
+				e.pushPreciseEdit(EditDelete, mergeY-1, prevLineLen, mergeY, 0, []rune{'\n'}, nil, cursorsBefore, append([]Cursor(nil), b.cursors...), group)
+			}
+
 			if b.syntax != nil {
 				b.handleEdit(c.Y, c.X, 1, 0, c.Y+1, 0, c.Y, b.getLineByteOffset(b.buffer[c.Y], c.X))
 			}
 		}
 	}
-	if b.syntax != nil {
-		b.syntax.Reparse([]byte(b.toString()))
-	}
 	e.markModified()
+	e.mergeCursors()
+
+	// A line merge changes the line count, which a same-line [origX-1, origX)
+	// range can't describe; fall back to full sync for that case and for
+	// multiple simultaneous cursors, same as insertRune/DeleteChar.
+	if b.lspClient != nil {
+		if len(cursors) == 1 && !mergedLine {
+			b.lspClient.NotifyChange(origY, origX-1, origY, origX, "", func() string { return b.toString() })
+		} else {
+			b.lspClient.SendDidChange(b.toString())
+		}
+	}
 }
 
 func (e *Editor) getIndentation(line []rune) []rune {
@@ -1067,11 +1769,24 @@ func (e *Editor) insertNewline() {
 		return
 	}
 	if b.readOnly {
-		e.message = "File is read-only"
+		e.setMessage("File is read-only")
 		return
 	}
 
+	// A newline always gets its own undo entry and never coalesces with the
+	// typing before or after it.
 	cursors := e.getSortedCursorsDesc()
+	cursorsBefore := append([]Cursor(nil), b.cursors...)
+	if len(cursors) != 1 {
+		// Several simultaneous cursors: each split lands on a different
+		// line with its own indentation, so there's no single range
+		// describing all of them; fall back to a whole-buffer snapshot,
+		// same as every other multi-cursor edit in this file.
+		e.saveState()
+	}
+	e.insertGroupOpen = false
+	e.insertedText = append(e.insertedText, '\n')
+
 	for _, c := range cursors {
 		line := b.buffer[c.Y]
 
@@ -1095,6 +1810,8 @@ func (e *Editor) insertNewline() {
 		copy(remaining, line[c.X:])
 
 		newLine := append(indent, remaining...)
+		splitY, splitX := c.Y, c.X
+
 		b.buffer[c.Y] = line[:c.X]
 
 		// Insert the new line into the buffer.
@@ -1104,11 +1821,11 @@ func (e *Editor) insertNewline() {
 		copy(newBuffer[c.Y+2:], b.buffer[c.Y+1:])
 		b.buffer = newBuffer
 
-		// Shift all cursors below this point, or later on this same line.
+		// Shift cursors strictly below this point down by the new line.
+		b.adjustCursorsForEdit(c, c.Y, c.X, 0, 1)
+		// Cursors later on this same (now-split) line move onto the new one.
 		for j := range b.cursors {
-			if b.cursors[j].Y > c.Y {
-				b.cursors[j].Y++
-			} else if b.cursors[j].Y == c.Y && b.cursors[j].X >= c.X && &b.cursors[j] != c {
+			if &b.cursors[j] != c && b.cursors[j].Y == c.Y && b.cursors[j].X >= c.X {
 				b.cursors[j].Y++
 				b.cursors[j].X = len(indent) + (b.cursors[j].X - c.X)
 			}
@@ -1118,15 +1835,18 @@ func (e *Editor) insertNewline() {
 		c.Y++
 		c.X = len(indent)
 
+		if len(cursors) == 1 {
+			inserted := append([]rune{'\n'}, indent...)
+			e.pushPreciseEdit(EditInsert, splitY, splitX, splitY, splitX, nil, inserted, cursorsBefore, append([]Cursor(nil), b.cursors...), 0)
+		}
+
 		if b.syntax != nil {
 			insertedBytes := uint32(1 + len(string(indent)))
 			b.handleEdit(c.Y-1, oldCursorX, 0, insertedBytes, c.Y-1, b.getLineByteOffset(b.buffer[c.Y-1], oldCursorX), c.Y, b.getLineByteOffset(b.buffer[c.Y], c.X))
 		}
 	}
-	if b.syntax != nil {
-		b.syntax.Reparse([]byte(b.toString()))
-	}
 	e.markModified()
+	e.mergeCursors()
 }
 
 func (e *Editor) insertLineBelow() {
@@ -1135,7 +1855,7 @@ func (e *Editor) insertLineBelow() {
 		return
 	}
 	if b.readOnly {
-		e.message = "File is read-only"
+		e.setMessage("File is read-only")
 		return
 	}
 	line := b.buffer[b.PrimaryCursor().Y]
@@ -1155,6 +1875,10 @@ func (e *Editor) insertLineBelow() {
 		}
 	}
 
+	cursorsBefore := append([]Cursor(nil), b.cursors...)
+	origY, origX := b.PrimaryCursor().Y, len(line)
+	inserted := append([]rune{'\n'}, indent...)
+
 	newBuffer := make([][]rune, len(b.buffer)+1)
 	copy(newBuffer[:b.PrimaryCursor().Y+1], b.buffer[:b.PrimaryCursor().Y+1])
 	newBuffer[b.PrimaryCursor().Y+1] = indent
@@ -1164,6 +1888,8 @@ func (e *Editor) insertLineBelow() {
 	b.PrimaryCursor().Y++
 	b.PrimaryCursor().X = len(indent)
 
+	e.pushPreciseEdit(EditInsert, origY, origX, origY, origX, nil, inserted, cursorsBefore, append([]Cursor(nil), b.cursors...), e.beginInsertGroup())
+
 	if b.syntax != nil {
 		insertedBytes := uint32(1 + len(string(indent)))
 		oldLineLen := b.getLineByteOffset(line, len(line))
@@ -1171,9 +1897,6 @@ func (e *Editor) insertLineBelow() {
 	}
 
 	e.mode = ModeInsert
-	if b.syntax != nil {
-		b.syntax.Reparse([]byte(b.toString()))
-	}
 	e.markModified()
 }
 
@@ -1183,12 +1906,16 @@ func (e *Editor) insertLineAbove() {
 		return
 	}
 	if b.readOnly {
-		e.message = "File is read-only"
+		e.setMessage("File is read-only")
 		return
 	}
 	line := b.buffer[b.PrimaryCursor().Y]
 	indent := e.getIndentation(line)
 
+	cursorsBefore := append([]Cursor(nil), b.cursors...)
+	origY := b.PrimaryCursor().Y
+	inserted := append(append([]rune(nil), indent...), '\n')
+
 	newBuffer := make([][]rune, len(b.buffer)+1)
 	copy(newBuffer[:b.PrimaryCursor().Y], b.buffer[:b.PrimaryCursor().Y])
 	newBuffer[b.PrimaryCursor().Y] = indent
@@ -1197,15 +1924,14 @@ func (e *Editor) insertLineAbove() {
 
 	b.PrimaryCursor().X = len(indent)
 
+	e.pushPreciseEdit(EditInsert, origY, 0, origY, 0, nil, inserted, cursorsBefore, append([]Cursor(nil), b.cursors...), e.beginInsertGroup())
+
 	if b.syntax != nil {
 		insertedBytes := uint32(1 + len(string(indent)))
 		b.handleEdit(b.PrimaryCursor().Y, 0, 0, insertedBytes, b.PrimaryCursor().Y, 0, b.PrimaryCursor().Y+1, 0)
 	}
 
 	e.mode = ModeInsert
-	if b.syntax != nil {
-		b.syntax.Reparse([]byte(b.toString()))
-	}
 	e.markModified()
 }
 
@@ -1250,7 +1976,7 @@ func (e *Editor) moveCursor(dx int, dy int) {
 			c.PreferredCol = c.X
 		}
 	}
-	// TODO: Merge overlapping cursors
+	e.mergeCursors()
 }
 
 func (e *Editor) mergeCursors() {
@@ -1291,24 +2017,10 @@ func (e *Editor) getWordUnderCursor() string {
 		return ""
 	}
 	line := b.buffer[b.PrimaryCursor().Y]
-	if len(line) == 0 || b.PrimaryCursor().X >= len(line) {
-		return ""
-	}
-
-	if !e.isWordChar(line[b.PrimaryCursor().X]) {
+	start, end, ok := wordBoundsAt(e, line, b.PrimaryCursor().X)
+	if !ok {
 		return ""
 	}
-
-	start := b.PrimaryCursor().X
-	for start > 0 && e.isWordChar(line[start-1]) {
-		start--
-	}
-
-	end := b.PrimaryCursor().X
-	for end < len(line) && e.isWordChar(line[end]) {
-		end++
-	}
-
 	return string(line[start:end])
 }
 
@@ -1316,7 +2028,11 @@ func (e *Editor) isPathChar(r rune) bool {
 	return e.isWordChar(r) || r == '/' || r == '.' || r == '-' || r == '_' || r == '~' || r == '\\' || r == ':'
 }
 
-func (e *Editor) getPathUnderCursor() string {
+// textUnderCursor extracts the run of characters touching the cursor that
+// satisfy isChar, expanding left and right from the cursor column. Used by
+// getPathUnderCursor and, with other character classes, by the plumber
+// (see plumber.go).
+func (e *Editor) textUnderCursor(isChar func(rune) bool) string {
 	b := e.activeBuffer()
 	if b == nil || len(b.buffer) == 0 {
 		return ""
@@ -1326,28 +2042,76 @@ func (e *Editor) getPathUnderCursor() string {
 		return ""
 	}
 
-	if !e.isPathChar(line[b.PrimaryCursor().X]) {
+	if !isChar(line[b.PrimaryCursor().X]) {
 		return ""
 	}
 
 	// Start searching from the current cursor position
 	start := b.PrimaryCursor().X
-	for start > 0 && e.isPathChar(line[start-1]) {
+	for start > 0 && isChar(line[start-1]) {
 		start--
 	}
 
 	end := b.PrimaryCursor().X
-	for end < len(line) && e.isPathChar(line[end]) {
+	for end < len(line) && isChar(line[end]) {
 		end++
 	}
 
 	return string(line[start:end])
 }
 
+func (e *Editor) getPathUnderCursor() string {
+	return e.textUnderCursor(e.isPathChar)
+}
+
+// getWORDUnderCursor returns vim's "WORD" touching the cursor: the maximal
+// run of non-blank characters, unlike getWordUnderCursor's identifier-only
+// word or getPathUnderCursor's path-punctuation class. Used by Plumb (see
+// plumber.go) so plumbing a bare URL or a "file.go:42:10" reference doesn't
+// depend on isPathChar covering every punctuation mark a rule might match on.
+func (e *Editor) getWORDUnderCursor() string {
+	return e.textUnderCursor(func(r rune) bool {
+		return r != ' ' && r != '\t'
+	})
+}
+
+// gotoFile is the `gf` entry point. It runs the text under the cursor
+// through the user's plumb.rules (see plumber.go) and dispatches to the
+// first rule that matches. If no rule matches (including when plumb.rules
+// doesn't exist), it falls back to plumbFallback's built-in file/URL
+// heuristic, so the plumber is entirely optional.
 func (e *Editor) gotoFile() {
+	b := e.activeBuffer()
+	if b == nil {
+		return
+	}
+
+	for _, rule := range e.plumbRules {
+		if !e.plumbRuleApplies(rule, b) {
+			continue
+		}
+		text := e.textUnderCursor(e.plumbExtractor(rule.chars))
+		if text == "" {
+			continue
+		}
+		match := rule.pattern.FindStringSubmatchIndex(text)
+		if match == nil {
+			continue
+		}
+		e.dispatchPlumbRule(rule, text, match)
+		return
+	}
+
+	e.plumbFallback()
+}
+
+// plumbFallback is the editor's original gotoFile behavior, used when no
+// plumb.rule matches the text under the cursor: treat it as a URL or as a
+// file path relative to the current buffer (then the CWD).
+func (e *Editor) plumbFallback() {
 	path := e.getPathUnderCursor()
 	if path == "" {
-		e.message = "No path under cursor"
+		e.setMessage("No path under cursor")
 		return
 	}
 
@@ -1370,7 +2134,7 @@ func (e *Editor) gotoFile() {
 		// Try relative to CWD
 		targetPath = path
 		if _, err := os.Stat(targetPath); os.IsNotExist(err) {
-			e.message = "File not found: " + path
+			e.setMessage("File not found: " + path)
 			return
 		}
 	}
@@ -1378,7 +2142,7 @@ func (e *Editor) gotoFile() {
 	// Resolve absolute path for comparison
 	absPath, err := filepath.Abs(targetPath)
 	if err != nil {
-		e.message = "Error resolving path: " + err.Error()
+		e.setMessage("Error resolving path: " + err.Error())
 		return
 	}
 
@@ -1395,7 +2159,7 @@ func (e *Editor) gotoFile() {
 	// Open new file
 	e.pushJump()
 	if err := e.LoadFile(targetPath); err != nil {
-		e.message = "Error opening file: " + err.Error()
+		e.setMessage("Error opening file: " + err.Error())
 	}
 }
 
@@ -1418,9 +2182,9 @@ func (e *Editor) openURL(url string) {
 	// Execute the command
 	exec := exec.Command(cmd, args...)
 	if err := exec.Start(); err != nil {
-		e.message = "Error opening URL: " + err.Error()
+		e.setMessage("Error opening URL: " + err.Error())
 	} else {
-		e.message = "Opening URL in browser..."
+		e.setMessage("Opening URL in browser...")
 	}
 }
 
@@ -1431,7 +2195,7 @@ func (e *Editor) centerCursor() {
 		return
 	}
 
-	_, h := termbox.Size()
+	_, h := e.termSize()
 	visibleHeight := h - 2 // Status bar and message line.
 	if visibleHeight < 1 {
 		visibleHeight = 1
@@ -1461,7 +2225,7 @@ func (e *Editor) gotoDefinition() {
 
 	e.pushJump()
 
-	locs, err := b.lspClient.Definition(b.PrimaryCursor().Y, b.PrimaryCursor().X)
+	locs, err := b.lspClient.Definition(context.Background(), b.PrimaryCursor().Y, b.PrimaryCursor().X)
 	if err != nil {
 		e.addLog("Editor", fmt.Sprintf("gotoDefinition error: %v", err))
 		return
@@ -1514,6 +2278,11 @@ func (e *Editor) gotoDefinition() {
 	e.centerCursor()
 }
 
+// jumpCollapseLines is how close (in lines, same file) a new jump has to be
+// to the last recorded one before it's treated as noise and merged into it
+// rather than added as a new entry.
+const jumpCollapseLines = 5
+
 func (e *Editor) pushJump() {
 	b := e.activeBuffer()
 	if b == nil {
@@ -1521,9 +2290,12 @@ func (e *Editor) pushJump() {
 	}
 
 	jump := Jump{
-		filename: b.filename,
-		cursorX:  b.PrimaryCursor().X,
-		cursorY:  b.PrimaryCursor().Y,
+		filename:     b.filename,
+		cursorX:      b.PrimaryCursor().X,
+		cursorY:      b.PrimaryCursor().Y,
+		scrollY:      b.scrollY,
+		preferredCol: b.PrimaryCursor().PreferredCol,
+		timestamp:    time.Now(),
 	}
 
 	// If we're not at the end of the jumplist, truncate it
@@ -1537,6 +2309,15 @@ func (e *Editor) pushJump() {
 		if last.filename == jump.filename && last.cursorX == jump.cursorX && last.cursorY == jump.cursorY {
 			return
 		}
+
+		// Collapse jumps that land close to the previous one in the same file,
+		// refreshing its position/timestamp instead of growing the list with
+		// noise from small local movements.
+		if last.filename == jump.filename && abs(jump.cursorY-last.cursorY) <= jumpCollapseLines {
+			e.jumplist[len(e.jumplist)-1] = jump
+			e.jumpIndex = len(e.jumplist) - 1
+			return
+		}
 	}
 
 	e.jumplist = append(e.jumplist, jump)
@@ -1546,6 +2327,14 @@ func (e *Editor) pushJump() {
 	e.jumpIndex = len(e.jumplist) - 1
 }
 
+// abs returns the absolute value of an int.
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
 func (e *Editor) jumpBack() {
 	if e.jumpIndex < 0 {
 		return
@@ -1555,9 +2344,17 @@ func (e *Editor) jumpBack() {
 	if e.jumpIndex == len(e.jumplist)-1 {
 		b := e.activeBuffer()
 		if b != nil {
-			curr := Jump{filename: b.filename, cursorX: b.PrimaryCursor().X, cursorY: b.PrimaryCursor().Y}
+			curr := Jump{
+				filename:     b.filename,
+				cursorX:      b.PrimaryCursor().X,
+				cursorY:      b.PrimaryCursor().Y,
+				scrollY:      b.scrollY,
+				preferredCol: b.PrimaryCursor().PreferredCol,
+				timestamp:    time.Now(),
+			}
 			last := e.jumplist[e.jumpIndex]
-			if curr != last {
+			samePos := curr.filename == last.filename && curr.cursorX == last.cursorX && curr.cursorY == last.cursorY
+			if !samePos {
 				e.jumplist = append(e.jumplist, curr)
 				e.jumpIndex = len(e.jumplist) - 2 // Point to the one before the one we just added
 			} else {
@@ -1609,6 +2406,8 @@ func (e *Editor) performJump(jump Jump) {
 	b := e.activeBuffer()
 	b.PrimaryCursor().Y = jump.cursorY
 	b.PrimaryCursor().X = jump.cursorX
+	b.PrimaryCursor().PreferredCol = jump.preferredCol
+	b.scrollY = jump.scrollY
 
 	// Ensure cursor is within bounds
 	if b.PrimaryCursor().Y < 0 {
@@ -1623,6 +2422,9 @@ func (e *Editor) performJump(jump Jump) {
 	if b.PrimaryCursor().X > len(b.buffer[b.PrimaryCursor().Y]) {
 		b.PrimaryCursor().X = len(b.buffer[b.PrimaryCursor().Y])
 	}
+	if b.scrollY < 0 {
+		b.scrollY = 0
+	}
 }
 
 // deleteWord removes a word-clump from the current cursor position.
@@ -1632,7 +2434,7 @@ func (e *Editor) deleteWord(includeSpaces bool) {
 		return
 	}
 	if b.readOnly {
-		e.message = "File is read-only"
+		e.setMessage("File is read-only")
 		return
 	}
 
@@ -1677,15 +2479,15 @@ func (e *Editor) deleteWord(includeSpaces bool) {
 			}
 		}
 
-		// Copy to clipboard (only for primary cursor)
+		// Record into the registers (only for primary cursor)
 		if c == b.PrimaryCursor() {
-			e.clipboard = make([]rune, end-start)
-			copy(e.clipboard, line[start:end])
+			e.deleteToRegister(line[start:end], RegCharwise)
 		}
 
 		// Delete from start to end
 		newLine := append(line[:start], line[end:]...)
 		b.buffer[c.Y] = newLine
+		b.adjustCursorsForEdit(c, c.Y, start, -(end - start), 0)
 
 		// Ensure cursor is within bounds
 		if c.X >= len(b.buffer[c.Y]) {
@@ -1704,10 +2506,8 @@ func (e *Editor) deleteWord(includeSpaces bool) {
 		}
 	}
 
-	if b.syntax != nil {
-		b.syntax.Reparse([]byte(b.toString()))
-	}
 	e.markModified()
+	e.mergeCursors()
 }
 
 func (e *Editor) deleteWordBackward() {
@@ -1716,7 +2516,7 @@ func (e *Editor) deleteWordBackward() {
 		return
 	}
 	if b.readOnly {
-		e.message = "File is read-only"
+		e.setMessage("File is read-only")
 		return
 	}
 	line := b.buffer[b.PrimaryCursor().Y]
@@ -1761,9 +2561,6 @@ func (e *Editor) deleteWordBackward() {
 		b.handleEdit(b.PrimaryCursor().Y, start, deletedBytes, 0, b.PrimaryCursor().Y, oldColBytes+deletedBytes, b.PrimaryCursor().Y, newColBytes)
 	}
 
-	if b.syntax != nil {
-		b.syntax.Reparse([]byte(b.toString()))
-	}
 	e.markModified()
 }
 
@@ -1804,7 +2601,7 @@ func (e *Editor) deleteWordBackwardFromBuffer() {
 func (e *Editor) changeWord() {
 	b := e.activeBuffer()
 	if b != nil && b.readOnly {
-		e.message = "File is read-only"
+		e.setMessage("File is read-only")
 		return
 	}
 	e.deleteWord(false)
@@ -1814,7 +2611,7 @@ func (e *Editor) changeWord() {
 func (e *Editor) changeCharacter() {
 	b := e.activeBuffer()
 	if b != nil && b.readOnly {
-		e.message = "File is read-only"
+		e.setMessage("File is read-only")
 		return
 	}
 	e.DeleteChar()
@@ -1827,7 +2624,7 @@ func (e *Editor) deleteToEndOfLine() {
 		return
 	}
 	if b.readOnly {
-		e.message = "File is read-only"
+		e.setMessage("File is read-only")
 		return
 	}
 
@@ -1842,17 +2639,16 @@ func (e *Editor) deleteToEndOfLine() {
 			continue
 		}
 
-		// Save deleted text of the primary cursor to the clipboard
+		// Record deleted text of the primary cursor into the registers
 		if c == b.PrimaryCursor() {
-			deletedText := line[c.X:]
-			e.clipboard = make([]rune, len(deletedText))
-			copy(e.clipboard, deletedText)
+			e.deleteToRegister(line[c.X:], RegCharwise)
 		}
 
 		// Truncate the line at the cursor position
 		deletedBytes := uint32(len(string(line[c.X:])))
 		newLine := line[:c.X]
 		b.buffer[c.Y] = newLine
+		b.adjustCursorsForEdit(c, c.Y, c.X, -(len(line) - c.X), 0)
 
 		// Handle syntax update
 		if b.syntax != nil {
@@ -1862,116 +2658,206 @@ func (e *Editor) deleteToEndOfLine() {
 		}
 	}
 
-	if b.syntax != nil {
-		b.syntax.Reparse([]byte(b.toString()))
-	}
 	e.markModified()
+	e.mergeCursors()
 }
 
 func (e *Editor) changeToEndOfLine() {
 	b := e.activeBuffer()
 	if b != nil && b.readOnly {
-		e.message = "File is read-only"
+		e.setMessage("File is read-only")
 		return
 	}
 	e.deleteToEndOfLine()
 	e.mode = ModeInsert
 }
 
-// deleteInside removes text within a pair of delimiters (e.g., "", (), {}).
+// deleteInside removes text strictly between a pair of delimiters (e.g., "",
+// (), {}), keeping the delimiters themselves. Quote-like delimiters (open ==
+// close) are only matched on the current line; bracket-like delimiters are
+// matched with a stack across the whole buffer (see textobjects.go), so e.g.
+// "di{" works on a brace whose matching close is several lines down.
 func (e *Editor) deleteInside(open, close rune) bool {
+	return e.deleteDelimiterPair(open, close, false)
+}
+
+// changeInside is deleteInside followed by entering insert mode at the gap
+// left behind.
+func (e *Editor) changeInside(open, close rune) {
+	if e.deleteInside(open, close) {
+		e.mode = ModeInsert
+	}
+}
+
+// deleteAround removes a pair of delimiters and everything between them.
+func (e *Editor) deleteAround(open, close rune) bool {
+	return e.deleteDelimiterPair(open, close, true)
+}
+
+// changeAround is deleteAround followed by entering insert mode at the gap
+// left behind.
+func (e *Editor) changeAround(open, close rune) {
+	if e.deleteAround(open, close) {
+		e.mode = ModeInsert
+	}
+}
+
+// deleteDelimiterPair implements deleteInside/deleteAround: it finds the
+// delimiter pair enclosing (or next after) the cursor and removes the
+// content between them, or the delimiters plus content when around is true.
+func (e *Editor) deleteDelimiterPair(open, close rune, around bool) bool {
 	b := e.activeBuffer()
 	if b == nil || len(b.buffer) == 0 {
 		return false
 	}
 	if b.readOnly {
-		e.message = "File is read-only"
+		e.setMessage("File is read-only")
 		return false
 	}
-	line := b.buffer[b.PrimaryCursor().Y]
-	if len(line) == 0 {
+
+	cursor := b.PrimaryCursor()
+	start, end, ok := findDelimiterPair(b, cursor.Y, cursor.X, open, close)
+	if !ok {
 		return false
 	}
-
-	type pair struct {
-		start, end int
+	if !around && end <= start+1 {
+		return false
 	}
-	var pairs []pair
 
-	// Find all candidate delimiter pairs on the current line.
-	if open == close {
-		var indices []int
-		for i, r := range line {
-			if r == open {
-				indices = append(indices, i)
-			}
-		}
-		for i := 0; i+1 < len(indices); i += 2 {
-			pairs = append(pairs, pair{indices[i], indices[i+1]})
-		}
+	var from, to int
+	if around {
+		from, to = start, end
 	} else {
-		var stack []int
-		for i, r := range line {
-			if r == open {
-				stack = append(stack, i)
-			} else if r == close {
-				if len(stack) > 0 {
-					start := stack[len(stack)-1]
-					stack = stack[:len(stack)-1]
-					pairs = append(pairs, pair{start, i})
-				}
-			}
-		}
+		from, to = start+1, end-1
+	}
+	if to < from {
+		return false
 	}
 
-	// Find the smallest pair that strictly contains the cursor.
-	var bestPair *pair
-	for i := range pairs {
-		p := &pairs[i]
-		if b.PrimaryCursor().X >= p.start && b.PrimaryCursor().X <= p.end {
-			if bestPair == nil || (p.start > bestPair.start) {
-				bestPair = p
-			}
-		}
+	e.applyTextObjectDelete(b, from, to)
+	return true
+}
+
+// deleteInsideWord removes the word under the cursor (vim's "iw"); when
+// around is true, it also consumes one run of trailing (or, failing that,
+// leading) whitespace, matching vim's "aw".
+func (e *Editor) deleteInsideWord(around bool) bool {
+	b := e.activeBuffer()
+	if b == nil || len(b.buffer) == 0 {
+		return false
+	}
+	if b.readOnly {
+		e.setMessage("File is read-only")
+		return false
 	}
 
-	if bestPair == nil {
-		for i := range pairs {
-			p := &pairs[i]
-			if p.start >= b.PrimaryCursor().X {
-				if bestPair == nil || p.start < bestPair.start {
-					bestPair = p
-				}
-			}
-		}
+	cursor := b.PrimaryCursor()
+	start, end, ok := wordObjectRange(b, cursor.Y, cursor.X, around)
+	if !ok {
+		return false
 	}
 
-	if bestPair != nil && bestPair.end > bestPair.start+1 {
-		start := bestPair.start
-		end := bestPair.end
-		deletedChars := line[start+1 : end]
-		deletedBytes := uint32(len(string(deletedChars)))
+	e.applyTextObjectDelete(b, start, end)
+	return true
+}
 
-		newLine := append(line[:start+1], line[end:]...)
-		b.buffer[b.PrimaryCursor().Y] = newLine
-		b.PrimaryCursor().X = start + 1
+func (e *Editor) changeInsideWord(around bool) {
+	if e.deleteInsideWord(around) {
+		e.mode = ModeInsert
+	}
+}
 
-		if b.syntax != nil {
-			oldColBytes := b.getLineByteOffset(line, start+1)
-			newColBytes := b.getLineByteOffset(newLine, start+1)
-			b.handleEdit(b.PrimaryCursor().Y, start+1, deletedBytes, 0, b.PrimaryCursor().Y, oldColBytes+deletedBytes, b.PrimaryCursor().Y, newColBytes)
-		}
-		if b.syntax != nil {
-			b.syntax.Reparse([]byte(b.toString()))
-		}
-		e.markModified()
-		return true
+// deleteInsideTag removes the content of the HTML/XML tag enclosing the
+// cursor (vim's "it"); around also removes the opening and closing tags
+// themselves ("at").
+func (e *Editor) deleteInsideTag(around bool) bool {
+	b := e.activeBuffer()
+	if b == nil || len(b.buffer) == 0 {
+		return false
 	}
-	return false
+	if b.readOnly {
+		e.setMessage("File is read-only")
+		return false
+	}
+
+	cursor := b.PrimaryCursor()
+	start, end, ok := tagObjectRange(b, cursor.Y, cursor.X, around)
+	if !ok {
+		return false
+	}
+
+	e.applyTextObjectDelete(b, start, end)
+	return true
 }
 
-func (e *Editor) changeInside(open, close rune) {
-	if e.deleteInside(open, close) {
+func (e *Editor) changeInsideTag(around bool) {
+	if e.deleteInsideTag(around) {
+		e.mode = ModeInsert
+	}
+}
+
+// deleteInsideParagraph removes the paragraph enclosing the cursor (vim's
+// "ip"); around also consumes one adjoining run of blank lines ("ap"). See
+// paragraphObjectRange (textobjects.go) for the boundary rules.
+func (e *Editor) deleteInsideParagraph(around bool) bool {
+	b := e.activeBuffer()
+	if b == nil || len(b.buffer) == 0 {
+		return false
+	}
+	if b.readOnly {
+		e.setMessage("File is read-only")
+		return false
+	}
+
+	cursor := b.PrimaryCursor()
+	start, end, ok := paragraphObjectRange(b, cursor.Y, cursor.X, around)
+	if !ok {
+		return false
+	}
+
+	e.applyTextObjectDelete(b, start, end)
+	return true
+}
+
+func (e *Editor) changeInsideParagraph(around bool) {
+	if e.deleteInsideParagraph(around) {
+		e.mode = ModeInsert
+	}
+}
+
+// deleteInsideNode removes the smallest tree-sitter node of the kind
+// letter maps to (per nodeObjectKindsFor) that encloses the cursor (vim's
+// "if"/"ic"/"ib" for the built-in function/class/block letters); around
+// keeps the whole node ("af"/"ac"/"ab") instead of peeling its braces. A
+// buffer with no syntax tree, or a language/letter with no mapping, simply
+// has no such object.
+func (e *Editor) deleteInsideNode(letter rune, around bool) bool {
+	b := e.activeBuffer()
+	if b == nil || len(b.buffer) == 0 {
+		return false
+	}
+	if b.readOnly {
+		e.setMessage("File is read-only")
+		return false
+	}
+
+	kinds := nodeObjectKindsFor(b)
+	if kinds == nil || kinds[letter] == nil {
+		return false
+	}
+
+	cursor := b.PrimaryCursor()
+	start, end, ok := nodeObjectRange(b, cursor.Y, cursor.X, kinds[letter], around)
+	if !ok {
+		return false
+	}
+
+	e.applyTextObjectDelete(b, start, end)
+	return true
+}
+
+func (e *Editor) changeInsideNode(letter rune, around bool) {
+	if e.deleteInsideNode(letter, around) {
 		e.mode = ModeInsert
 	}
 }
@@ -2149,24 +3035,31 @@ func (e *Editor) moveWordBackward() {
 	e.mergeCursors()
 }
 
-// deleteLine removes the current line and saves it to the clipboard.
+// deleteLine removes the current line and saves it to the registers.
 func (e *Editor) deleteLine() {
 	b := e.activeBuffer()
 	if b == nil || len(b.buffer) == 0 {
 		return
 	}
 	if b.readOnly {
-		e.message = "File is read-only"
+		e.setMessage("File is read-only")
 		return
 	}
 
-	line := b.buffer[b.PrimaryCursor().Y]
-	e.clipboard = make([]rune, len(line)+1)
-	copy(e.clipboard, line)
-	e.clipboard[len(line)] = '\n'
+	cursorsBefore := append([]Cursor(nil), b.cursors...)
+	y := b.PrimaryCursor().Y
+	line := b.buffer[y]
+	deleted := make([]rune, len(line)+1)
+	copy(deleted, line)
+	deleted[len(line)] = '\n'
+	e.deleteToRegister(deleted, RegLinewise)
 
+	var y1, x1, y2, x2 int
+	var old []rune
 	if len(b.buffer) == 1 {
 		lineLen := uint32(len(string(b.buffer[0])))
+		y1, x1, y2, x2 = 0, 0, 0, len(b.buffer[0])
+		old = append([]rune(nil), b.buffer[0]...)
 		b.buffer[0] = []rune{}
 		b.PrimaryCursor().X = 0
 
@@ -2174,11 +3067,13 @@ func (e *Editor) deleteLine() {
 			b.handleEdit(0, 0, lineLen, 0, 0, lineLen, 0, 0)
 		}
 	} else {
-		lineLen := uint32(len(string(b.buffer[b.PrimaryCursor().Y]))) + 1
-		b.buffer = append(b.buffer[:b.PrimaryCursor().Y], b.buffer[b.PrimaryCursor().Y+1:]...)
+		lineLen := uint32(len(string(line))) + 1
+		y1, x1, y2, x2 = y, 0, y+1, 0
+		old = deleted
+		b.Delete(y, 0, y+1, 0)
 
 		if b.syntax != nil {
-			b.handleEdit(b.PrimaryCursor().Y, 0, lineLen, 0, b.PrimaryCursor().Y+1, 0, b.PrimaryCursor().Y, 0)
+			b.handleEdit(y, 0, lineLen, 0, y+1, 0, y, 0)
 		}
 
 		if b.PrimaryCursor().Y >= len(b.buffer) {
@@ -2186,10 +3081,8 @@ func (e *Editor) deleteLine() {
 		}
 		b.PrimaryCursor().X = 0
 	}
-	if b.syntax != nil {
-		b.syntax.Reparse([]byte(b.toString()))
-	}
 	e.markModified()
+	e.pushPreciseEdit(EditDelete, y1, x1, y2, x2, old, nil, cursorsBefore, append([]Cursor(nil), b.cursors...), 0)
 }
 
 func (e *Editor) yankLine() {
@@ -2198,195 +3091,124 @@ func (e *Editor) yankLine() {
 		return
 	}
 	line := b.buffer[b.PrimaryCursor().Y]
-	e.clipboard = make([]rune, len(line)+1)
-	copy(e.clipboard, line)
-	e.clipboard[len(line)] = '\n'
+	yanked := make([]rune, len(line)+1)
+	copy(yanked, line)
+	yanked[len(line)] = '\n'
+	e.yankToRegister(yanked, RegLinewise)
 }
 
 func (e *Editor) pasteLine() {
 	b := e.activeBuffer()
-	if b == nil || len(e.clipboard) == 0 {
+	clipboard, kind := e.pasteRegister()
+	if b == nil || len(clipboard) == 0 {
 		return
 	}
 	if b.readOnly {
-		e.message = "File is read-only"
+		e.setMessage("File is read-only")
 		return
 	}
 
-	isLineWise := e.clipboard[len(e.clipboard)-1] == '\n'
-
-	if isLineWise {
-		content := e.clipboard[:len(e.clipboard)-1]
-		parts := strings.Split(string(content), "\n")
-		count := len(parts)
+	cursorsBefore := append([]Cursor(nil), b.cursors...)
+	cursor := b.PrimaryCursor()
+	var insertY, insertX int
+	var inserted []rune
+	if kind == RegLinewise {
+		content := clipboard[:len(clipboard)-1]
+		count := len(strings.Split(string(content), "\n"))
 
-		newBuffer := make([][]rune, len(b.buffer)+count)
-		copy(newBuffer[:b.PrimaryCursor().Y+1], b.buffer[:b.PrimaryCursor().Y+1])
+		line := b.buffer[cursor.Y]
+		insertY, insertX = cursor.Y, len(line)
+		inserted = append([]rune{'\n'}, content...)
+		b.Insert(insertY, insertX, inserted)
 
-		for i, part := range parts {
-			newBuffer[b.PrimaryCursor().Y+1+i] = []rune(part)
+		cursor.Y += count
+		cursor.X = 0
+	} else {
+		// Character-wise: paste after cursor
+		line := b.buffer[cursor.Y]
+		at := cursor.X
+		if len(line) > 0 {
+			at++
+		}
+		if at > len(line) {
+			at = len(line)
 		}
 
-		copy(newBuffer[b.PrimaryCursor().Y+1+count:], b.buffer[b.PrimaryCursor().Y+1:])
-		b.buffer = newBuffer
-
-		b.PrimaryCursor().Y += count
-		b.PrimaryCursor().X = 0
-	} else {
-		// Character-wise: paste after cursor
-		fullText := string(e.clipboard)
-		parts := strings.Split(fullText, "\n")
+		parts := strings.Split(string(clipboard), "\n")
+		insertY, insertX = cursor.Y, at
+		inserted = clipboard
+		b.Insert(insertY, insertX, inserted)
 
 		if len(parts) == 1 {
-			line := b.buffer[b.PrimaryCursor().Y]
-			at := b.PrimaryCursor().X
-			if len(line) > 0 {
-				at++
-			}
-			if at > len(line) {
-				at = len(line)
-			}
-
-			newLine := make([]rune, len(line)+len(e.clipboard))
-			copy(newLine[:at], line[:at])
-			copy(newLine[at:], e.clipboard)
-			copy(newLine[at+len(e.clipboard):], line[at:])
-			b.buffer[b.PrimaryCursor().Y] = newLine
-			b.PrimaryCursor().X = at + len(e.clipboard) - 1
-			if b.PrimaryCursor().X < 0 {
-				b.PrimaryCursor().X = 0
+			cursor.X = at + len(clipboard) - 1
+			if cursor.X < 0 {
+				cursor.X = 0
 			}
 		} else {
-			// Multi-line character-wise paste after cursor
-			line := b.buffer[b.PrimaryCursor().Y]
-			at := b.PrimaryCursor().X
-			if len(line) > 0 {
-				at++
-			}
-			if at > len(line) {
-				at = len(line)
-			}
-
-			prefix := line[:at]
-			suffix := line[at:]
-
-			newLines := make([][]rune, len(parts))
-			newLines[0] = append([]rune(nil), prefix...)
-			newLines[0] = append(newLines[0], []rune(parts[0])...)
-
-			for i := 1; i < len(parts)-1; i++ {
-				newLines[i] = []rune(parts[i])
-			}
-
-			lastIndex := len(parts) - 1
-			newLines[lastIndex] = []rune(parts[lastIndex])
-			newLines[lastIndex] = append(newLines[lastIndex], suffix...)
-
-			// Insert into buffer
-			newBuffer := make([][]rune, len(b.buffer)+len(parts)-1)
-			copy(newBuffer[:b.PrimaryCursor().Y], b.buffer[:b.PrimaryCursor().Y])
-			copy(newBuffer[b.PrimaryCursor().Y:b.PrimaryCursor().Y+len(parts)], newLines)
-			copy(newBuffer[b.PrimaryCursor().Y+len(parts):], b.buffer[b.PrimaryCursor().Y+1:])
-			b.buffer = newBuffer
-
 			// Move cursor to end of pasted text
-			b.PrimaryCursor().Y = b.PrimaryCursor().Y + len(parts) - 1
-			b.PrimaryCursor().X = len([]rune(parts[lastIndex]))
+			cursor.Y += len(parts) - 1
+			cursor.X = len([]rune(parts[len(parts)-1]))
 		}
 	}
 	e.markModified()
 
 	if b.syntax != nil {
-		b.syntax.Parse([]byte(b.toString()))
+		b.syntaxParse()
 	}
+	e.pushPreciseEdit(EditInsert, insertY, insertX, insertY, insertX, nil, inserted, cursorsBefore, append([]Cursor(nil), b.cursors...), 0)
 }
 
 func (e *Editor) pasteLineAbove() {
 	b := e.activeBuffer()
-	if b == nil || len(e.clipboard) == 0 {
+	clipboard, kind := e.pasteRegister()
+	if b == nil || len(clipboard) == 0 {
 		return
 	}
 	if b.readOnly {
-		e.message = "File is read-only"
+		e.setMessage("File is read-only")
 		return
 	}
 
-	isLineWise := e.clipboard[len(e.clipboard)-1] == '\n'
-
-	if isLineWise {
-		content := e.clipboard[:len(e.clipboard)-1]
-		parts := strings.Split(string(content), "\n")
-		count := len(parts)
-
-		newBuffer := make([][]rune, len(b.buffer)+count)
-		copy(newBuffer[:b.PrimaryCursor().Y], b.buffer[:b.PrimaryCursor().Y])
-
-		for i, part := range parts {
-			newBuffer[b.PrimaryCursor().Y+i] = []rune(part)
+	cursorsBefore := append([]Cursor(nil), b.cursors...)
+	cursor := b.PrimaryCursor()
+	var insertY, insertX int
+	var inserted []rune
+	if kind == RegLinewise {
+		content := clipboard[:len(clipboard)-1]
+		insertY, insertX = cursor.Y, 0
+		inserted = append(append([]rune(nil), content...), '\n')
+		b.Insert(insertY, insertX, inserted)
+		cursor.X = 0
+	} else {
+		// Character-wise: paste at cursor. Handle potential newlines in the
+		// clipboard (e.g. from visual selection).
+		at := cursor.X
+		if line := b.buffer[cursor.Y]; at > len(line) {
+			at = len(line)
 		}
 
-		copy(newBuffer[b.PrimaryCursor().Y+count:], b.buffer[b.PrimaryCursor().Y:])
-		b.buffer = newBuffer
-
-		b.PrimaryCursor().X = 0
-	} else {
-		// Character-wise: paste at cursor
-		// Handle potential newlines in character-wise clipboard (e.g. from visual selection)
-		fullText := string(e.clipboard)
-		parts := strings.Split(fullText, "\n")
+		parts := strings.Split(string(clipboard), "\n")
+		insertY, insertX = cursor.Y, at
+		inserted = clipboard
+		b.Insert(insertY, insertX, inserted)
 
 		if len(parts) == 1 {
-			// Single line character-wise paste
-			line := b.buffer[b.PrimaryCursor().Y]
-			at := b.PrimaryCursor().X
-			if at > len(line) {
-				at = len(line)
-			}
-
-			newLine := make([]rune, len(line)+len(e.clipboard))
-			copy(newLine[:at], line[:at])
-			copy(newLine[at:], e.clipboard)
-			copy(newLine[at+len(e.clipboard):], line[at:])
-			b.buffer[b.PrimaryCursor().Y] = newLine
-			b.PrimaryCursor().X = at + len(e.clipboard) - 1
-			if b.PrimaryCursor().X < 0 {
-				b.PrimaryCursor().X = 0
+			cursor.X = at + len(clipboard) - 1
+			if cursor.X < 0 {
+				cursor.X = 0
 			}
 		} else {
-			// Multi-line character-wise paste
-			line := b.buffer[b.PrimaryCursor().Y]
-			prefix := line[:b.PrimaryCursor().X]
-			suffix := line[b.PrimaryCursor().X:]
-
-			newLines := make([][]rune, len(parts))
-			newLines[0] = append([]rune(nil), prefix...)
-			newLines[0] = append(newLines[0], []rune(parts[0])...)
-
-			for i := 1; i < len(parts)-1; i++ {
-				newLines[i] = []rune(parts[i])
-			}
-
-			lastIndex := len(parts) - 1
-			newLines[lastIndex] = []rune(parts[lastIndex])
-			newLines[lastIndex] = append(newLines[lastIndex], suffix...)
-
-			// Insert into buffer
-			newBuffer := make([][]rune, len(b.buffer)+len(parts)-1)
-			copy(newBuffer[:b.PrimaryCursor().Y], b.buffer[:b.PrimaryCursor().Y])
-			copy(newBuffer[b.PrimaryCursor().Y:b.PrimaryCursor().Y+len(parts)], newLines)
-			copy(newBuffer[b.PrimaryCursor().Y+len(parts):], b.buffer[b.PrimaryCursor().Y+1:])
-			b.buffer = newBuffer
-
 			// Move cursor to end of pasted text
-			b.PrimaryCursor().Y = b.PrimaryCursor().Y + len(parts) - 1
-			b.PrimaryCursor().X = len([]rune(parts[lastIndex]))
+			cursor.Y += len(parts) - 1
+			cursor.X = len([]rune(parts[len(parts)-1]))
 		}
 	}
 	e.markModified()
 
 	if b.syntax != nil {
-		b.syntax.Parse([]byte(b.toString()))
+		b.syntaxParse()
 	}
+	e.pushPreciseEdit(EditInsert, insertY, insertX, insertY, insertX, nil, inserted, cursorsBefore, append([]Cursor(nil), b.cursors...), 0)
 }
 
 func (e *Editor) duplicateLine() {
@@ -2395,25 +3217,23 @@ func (e *Editor) duplicateLine() {
 		return
 	}
 	if b.readOnly {
-		e.message = "File is read-only"
+		e.setMessage("File is read-only")
 		return
 	}
 
-	line := make([]rune, len(b.buffer[b.PrimaryCursor().Y]))
-	copy(line, b.buffer[b.PrimaryCursor().Y])
-
-	newBuffer := make([][]rune, len(b.buffer)+1)
-	copy(newBuffer[:b.PrimaryCursor().Y+1], b.buffer[:b.PrimaryCursor().Y+1])
-	newBuffer[b.PrimaryCursor().Y+1] = line
-	copy(newBuffer[b.PrimaryCursor().Y+2:], b.buffer[b.PrimaryCursor().Y+1:])
-	b.buffer = newBuffer
+	cursorsBefore := append([]Cursor(nil), b.cursors...)
+	y := b.PrimaryCursor().Y
+	line := b.buffer[y]
+	insert := append([]rune{'\n'}, line...)
+	b.Insert(y, len(line), insert)
 
 	b.PrimaryCursor().Y++
 	e.markModified()
 
 	if b.syntax != nil {
-		b.syntax.Parse([]byte(b.toString()))
+		b.syntaxParse()
 	}
+	e.pushPreciseEdit(EditInsert, y, len(line), y, len(line), nil, insert, cursorsBefore, append([]Cursor(nil), b.cursors...), 0)
 }
 
 func (e *Editor) jumpToPrevEmptyLine() {
@@ -2504,97 +3324,191 @@ func (e *Editor) jumpToFirstNonBlank() {
 	}
 }
 
-// saveState captures a deep copy of the current buffer and cursors for the undo stack.
+// saveState pushes a whole-buffer undo entry for callers that don't know
+// their edit's range ahead of the mutation they're about to make. New and
+// CursorsAfter can't be filled in yet (the mutation hasn't happened), so
+// they're captured lazily the first time undo() pops this entry; see Edit
+// and Editor.undo.
 func (e *Editor) saveState() {
 	b := e.activeBuffer()
 	if b == nil {
 		return
 	}
-	// Deep copy the buffer to ensure historical states aren't mutated.
-	bufferCopy := make([][]rune, len(b.buffer))
-	for i, line := range b.buffer {
-		lineCopy := make([]rune, len(line))
-		copy(lineCopy, line)
-		bufferCopy[i] = lineCopy
+	last := len(b.buffer) - 1
+	b.pushEdit(Edit{
+		Kind:          EditReplace,
+		Y1:            0,
+		X1:            0,
+		Y2:            last,
+		X2:            len(b.buffer[last]),
+		Old:           []rune(b.toString()),
+		CursorsBefore: append([]Cursor(nil), b.cursors...),
+		captured:      false,
+		timestamp:     time.Now(),
+	})
+}
+
+// pushPreciseEdit records an Edit whose range and New text are already known
+// at the call site (see the TextStore-based operations in this file), so no
+// lazy fill-in is needed on undo. group ties it to an insert-mode
+// coalescing session (see beginInsertGroup); pass 0 for a standalone edit.
+func (e *Editor) pushPreciseEdit(kind EditKind, y1, x1, y2, x2 int, old, newText []rune, cursorsBefore, cursorsAfter []Cursor, group int64) {
+	b := e.activeBuffer()
+	if b == nil {
+		return
 	}
+	b.pushEdit(Edit{
+		Kind:          kind,
+		Y1:            y1,
+		X1:            x1,
+		Y2:            y2,
+		X2:            x2,
+		Old:           append([]rune(nil), old...),
+		New:           append([]rune(nil), newText...),
+		CursorsBefore: cursorsBefore,
+		CursorsAfter:  cursorsAfter,
+		captured:      true,
+		group:         group,
+		timestamp:     time.Now(),
+	})
+}
 
-	// Deep copy cursors.
-	cursorsCopy := make([]Cursor, len(b.cursors))
-	copy(cursorsCopy, b.cursors)
+// beginInsertGroup returns the coalescing group id for the insert-mode
+// session currently in progress, minting a new one (and marking the group
+// open) the first time it's called since the group last closed. Every edit
+// pushed while the group stays open — whether a precise single-cursor edit
+// or a multi-cursor whole-buffer snapshot (see pushGroupedSnapshot) —
+// shares this id, so undo/redo apply or revert the whole run as one
+// user-visible step, the same granularity the old saveStateForInsert gave
+// by pushing one whole-buffer snapshot per session instead of per
+// keystroke. The group closes (see insertGroupOpen) on leaving Insert mode,
+// an Insert-mode cursor move, or any edit that's deliberately its own step
+// (insertNewline, acceptGhostText, insertCompletion).
+func (e *Editor) beginInsertGroup() int64 {
+	if !e.insertGroupOpen {
+		e.insertGroupOpen = true
+		e.nextInsertGroup++
+	}
+	return e.nextInsertGroup
+}
 
-	b.undoStack = append(b.undoStack, HistoryState{
-		buffer:  bufferCopy,
-		cursors: cursorsCopy,
-	})
-	// Cap undo stack at 100 entries to prevent memory exhaustion.
-	if len(b.undoStack) > 100 {
-		b.undoStack = b.undoStack[1:]
+// pushGroupedSnapshot is saveState's whole-buffer snapshot, tagged into
+// insert-mode group instead of pushed standalone. Used by the multi-cursor
+// branches of insertRune/backspace: with several cursors live, a single
+// keystroke can touch multiple lines in ways that shift each other, so
+// there's no one (y1,x1)-(y2,x2) range that describes all of them, and a
+// whole-buffer fallback (same as the old saveStateForInsert) is still
+// needed — it just needs to coalesce with the single-cursor precise edits
+// around it rather than always standing alone.
+func (e *Editor) pushGroupedSnapshot(group int64) {
+	b := e.activeBuffer()
+	if b == nil {
+		return
 	}
-	// Clear the redo stack whenever a new action is performed.
-	b.redoStack = []HistoryState{}
+	last := len(b.buffer) - 1
+	b.pushEdit(Edit{
+		Kind:          EditReplace,
+		Y1:            0,
+		X1:            0,
+		Y2:            last,
+		X2:            len(b.buffer[last]),
+		Old:           []rune(b.toString()),
+		CursorsBefore: append([]Cursor(nil), b.cursors...),
+		captured:      false,
+		group:         group,
+		timestamp:     time.Now(),
+	})
 }
 
+// undo reverts the most recent undo-stack entry and, if it belongs to a
+// nonzero coalescing group (see beginInsertGroup), keeps reverting entries
+// off the top of the stack as long as they share that same group id — so
+// however many keystrokes made up one insert-mode session are undone as a
+// single user-visible step, the same granularity saveState's old
+// one-snapshot-per-session approach gave.
 func (e *Editor) undo() {
 	b := e.activeBuffer()
 	if b == nil || len(b.undoStack) == 0 {
 		return
 	}
 
-	// Save current state to redo stack
-	bufferCopy := make([][]rune, len(b.buffer))
-	for i, line := range b.buffer {
-		lineCopy := make([]rune, len(line))
-		copy(lineCopy, line)
-		bufferCopy[i] = lineCopy
+	group := b.undoStack[len(b.undoStack)-1].group
+	e.undoOne(b)
+	for group != 0 && len(b.undoStack) > 0 && b.undoStack[len(b.undoStack)-1].group == group {
+		e.undoOne(b)
 	}
-	cursorsCopy := make([]Cursor, len(b.cursors))
-	copy(cursorsCopy, b.cursors)
-
-	b.redoStack = append(b.redoStack, HistoryState{
-		buffer:  bufferCopy,
-		cursors: cursorsCopy,
-	})
+}
 
-	// Restore from undo stack
-	state := b.undoStack[len(b.undoStack)-1]
+// undoOne pops and reverts the top of b's undo stack, pushing it onto the
+// redo stack. Factored out of undo so a whole coalescing group can be
+// unwound entry by entry.
+func (e *Editor) undoOne(b *Buffer) {
+	ed := b.undoStack[len(b.undoStack)-1]
 	b.undoStack = b.undoStack[:len(b.undoStack)-1]
-	b.buffer = state.buffer
-	b.cursors = state.cursors
+
+	if !ed.captured {
+		// A whole-buffer entry from saveState() or pushGroupedSnapshot():
+		// New/CursorsAfter were unknown when it was pushed, since the
+		// mutation hadn't happened yet. Fill them in now, from the buffer
+		// as it stands right before we overwrite it with Old.
+		last := len(b.buffer) - 1
+		ed.New = []rune(b.toString())
+		ed.Y2 = last
+		ed.X2 = len(b.buffer[last])
+		ed.CursorsAfter = append([]Cursor(nil), b.cursors...)
+		ed.captured = true
+	}
+
+	y2, x2 := rangeEnd(ed.Y1, ed.X1, ed.New)
+	b.Delete(ed.Y1, ed.X1, y2, x2)
+	if len(ed.Old) > 0 {
+		b.Insert(ed.Y1, ed.X1, ed.Old)
+	}
+	b.cursors = append([]Cursor(nil), ed.CursorsBefore...)
+
+	b.redoStack = append(b.redoStack, ed)
 
 	if b.syntax != nil {
-		b.syntax.Parse([]byte(b.toString()))
+		b.syntaxParse()
 	}
 }
 
+// redo re-applies the most recently undone entry and, like undo, keeps
+// re-applying entries off the top of the redo stack as long as they share
+// the same nonzero coalescing group.
 func (e *Editor) redo() {
 	b := e.activeBuffer()
 	if b == nil || len(b.redoStack) == 0 {
 		return
 	}
 
-	// Save current state to undo stack
-	bufferCopy := make([][]rune, len(b.buffer))
-	for i, line := range b.buffer {
-		lineCopy := make([]rune, len(line))
-		copy(lineCopy, line)
-		bufferCopy[i] = lineCopy
+	group := b.redoStack[len(b.redoStack)-1].group
+	e.redoOne(b)
+	for group != 0 && len(b.redoStack) > 0 && b.redoStack[len(b.redoStack)-1].group == group {
+		e.redoOne(b)
 	}
-	cursorsCopy := make([]Cursor, len(b.cursors))
-	copy(cursorsCopy, b.cursors)
-
-	b.undoStack = append(b.undoStack, HistoryState{
-		buffer:  bufferCopy,
-		cursors: cursorsCopy,
-	})
+}
 
-	// Restore from redo stack
-	state := b.redoStack[len(b.redoStack)-1]
+// redoOne pops and re-applies the top of b's redo stack, pushing it back
+// onto the undo stack. Factored out of redo so a whole coalescing group can
+// be re-applied entry by entry.
+func (e *Editor) redoOne(b *Buffer) {
+	ed := b.redoStack[len(b.redoStack)-1]
 	b.redoStack = b.redoStack[:len(b.redoStack)-1]
-	b.buffer = state.buffer
-	b.cursors = state.cursors
+
+	b.Delete(ed.Y1, ed.X1, ed.Y2, ed.X2)
+	if len(ed.New) > 0 {
+		b.Insert(ed.Y1, ed.X1, ed.New)
+	}
+	b.cursors = append([]Cursor(nil), ed.CursorsAfter...)
+
+	b.undoStack = append(b.undoStack, ed)
+	if len(b.undoStack) > 100 {
+		b.undoStack = b.undoStack[1:]
+	}
 
 	if b.syntax != nil {
-		b.syntax.Parse([]byte(b.toString()))
+		b.syntaxParse()
 	}
 }
 
@@ -2605,7 +3519,7 @@ func (e *Editor) JoinLines() {
 		return
 	}
 	if b.readOnly {
-		e.message = "File is read-only"
+		e.setMessage("File is read-only")
 		return
 	}
 
@@ -2633,31 +3547,34 @@ func (e *Editor) JoinLines() {
 		needsSpace = false
 	}
 
-	// Join lines
-	newLine := make([]rune, 0, len(currentLine)+len(trimmedNextLine)+1)
-	newLine = append(newLine, currentLine...)
+	cursorsBefore := append([]Cursor(nil), b.cursors...)
+	old := b.Slice(cursor.Y, len(currentLine), cursor.Y+1, trimIdx)
+	var newText []rune
 	if needsSpace {
-		newLine = append(newLine, ' ')
+		newText = []rune{' '}
 	}
-	newLine = append(newLine, trimmedNextLine...)
 
-	// Update buffer
-	b.buffer[cursor.Y] = newLine
-	b.buffer = append(b.buffer[:cursor.Y+1], b.buffer[cursor.Y+2:]...)
+	// Join lines: drop the newline and the next line's leading whitespace,
+	// merging the two into one, then insert the separating space if needed.
+	b.Delete(cursor.Y, len(currentLine), cursor.Y+1, trimIdx)
+	if needsSpace {
+		b.Insert(cursor.Y, len(currentLine), []rune{' '})
+	}
 
 	// Set cursor position to the join point
 	cursor.X = len(currentLine)
 	if needsSpace {
 		// Vim usually puts cursor on the space
-	} else if cursor.X >= len(newLine) && len(newLine) > 0 {
+	} else if newLine := b.buffer[cursor.Y]; cursor.X >= len(newLine) && len(newLine) > 0 {
 		cursor.X = len(newLine) - 1
 	}
 
 	// Syntax update
 	if b.syntax != nil {
-		b.syntax.Reparse([]byte(b.toString()))
+		b.syntaxReparse()
 	}
 	e.markModified()
+	e.pushPreciseEdit(EditReplace, cursor.Y, len(currentLine), cursor.Y+1, trimIdx, old, newText, cursorsBefore, append([]Cursor(nil), b.cursors...), 0)
 }
 
 // getSelectionBounds returns the normalized coordinates (top-left to bottom-right) of the visual selection.
@@ -2693,7 +3610,7 @@ func (e *Editor) ollamaComplete() {
 		return
 	}
 	if e.ollamaClient == nil || !e.ollamaClient.IsOnline {
-		e.message = "Ollama is offline"
+		e.setMessage("Ollama is offline")
 		return
 	}
 
@@ -2743,30 +3660,33 @@ func (e *Editor) ollamaComplete() {
 	if len(firstLine) > 50 {
 		firstLine = firstLine[:47] + "..."
 	}
-	e.message = fmt.Sprintf("Ollama is thinking about: %s", firstLine)
+	e.setMessage(fmt.Sprintf("Ollama is thinking about: %s", firstLine))
 	e.draw()
 
 	// Call the Ollama API.
 	response, err := e.ollamaClient.Generate(prompt)
 	if err != nil {
-		e.message = fmt.Sprintf("Ollama error: %v", err)
+		e.setMessage(fmt.Sprintf("Ollama error: %v", err))
 		return
 	}
 
-	// Replace the visual selection with the AI's response.
-	e.saveState()
+	// Replace the visual selection with the AI's response. This pushes two
+	// precise edits (delete, then insert) rather than one, so reverting the
+	// whole completion takes two undo presses instead of one.
 	e.deleteVisualSelection()
 
 	lines := strings.Split(strings.TrimSpace(response), "\n")
 
-	at := b.PrimaryCursor().X
-	currentLine := b.buffer[b.PrimaryCursor().Y]
+	cursor := b.PrimaryCursor()
+	cursorsBefore := append([]Cursor(nil), b.cursors...)
+	at := cursor.X
+	currentLine := b.buffer[cursor.Y]
 	hasSuffix := at < len(currentLine)
 
-	nextExists := b.PrimaryCursor().Y+1 < len(b.buffer)
+	nextExists := cursor.Y+1 < len(b.buffer)
 	nextIsBlank := false
 	if nextExists {
-		nextIsBlank = len(b.buffer[b.PrimaryCursor().Y+1]) == 0
+		nextIsBlank = len(b.buffer[cursor.Y+1]) == 0
 	}
 
 	// Add formatting newlines if necessary.
@@ -2776,54 +3696,28 @@ func (e *Editor) ollamaComplete() {
 		lines = append(lines, "")
 	}
 
-	if len(lines) == 1 {
-		line := b.buffer[b.PrimaryCursor().Y]
-		at := b.PrimaryCursor().X
-		if at > len(line) {
-			at = len(line)
-		}
-
-		respRunes := []rune(lines[0])
-		newLine := make([]rune, len(line)+len(respRunes))
-		copy(newLine[:at], line[:at])
-		copy(newLine[at:], respRunes)
-		copy(newLine[at+len(respRunes):], line[at:])
-		b.buffer[b.PrimaryCursor().Y] = newLine
-		b.PrimaryCursor().X = at + len(respRunes)
-	} else {
-		line := b.buffer[b.PrimaryCursor().Y]
-		at := b.PrimaryCursor().X
-		if at > len(line) {
-			at = len(line)
-		}
-
-		prefix := line[:at]
-		suffix := line[at:]
-
-		newLines := make([][]rune, len(lines))
-		for i, l := range lines {
-			newLines[i] = []rune(l)
-		}
-
-		newLines[0] = append([]rune(string(prefix)), newLines[0]...)
-		newLines[len(newLines)-1] = append(newLines[len(newLines)-1], suffix...)
+	if at > len(currentLine) {
+		at = len(currentLine)
+	}
 
-		newBuffer := make([][]rune, len(b.buffer)+len(newLines)-1)
-		copy(newBuffer[:b.PrimaryCursor().Y], b.buffer[:b.PrimaryCursor().Y])
-		copy(newBuffer[b.PrimaryCursor().Y:], newLines)
-		copy(newBuffer[b.PrimaryCursor().Y+len(newLines):], b.buffer[b.PrimaryCursor().Y+1:])
-		b.buffer = newBuffer
+	respText := []rune(strings.Join(lines, "\n"))
+	insertY, insertX := cursor.Y, at
+	b.Insert(insertY, insertX, respText)
 
-		b.PrimaryCursor().Y = b.PrimaryCursor().Y + len(newLines) - 1
-		b.PrimaryCursor().X = len(newLines[len(newLines)-1]) - len(suffix)
+	if len(lines) == 1 {
+		cursor.X = at + len(respText)
+	} else {
+		cursor.Y += len(lines) - 1
+		cursor.X = len([]rune(lines[len(lines)-1]))
 	}
+	e.pushPreciseEdit(EditInsert, insertY, insertX, insertY, insertX, nil, respText, cursorsBefore, append([]Cursor(nil), b.cursors...), 0)
 
 	e.mode = ModeNormal
 	e.markModified()
-	e.message = "Ollama completion inserted (replaced selection)"
+	e.setMessage("Ollama completion inserted (replaced selection)")
 
 	if b.syntax != nil {
-		b.syntax.Parse([]byte(b.toString()))
+		b.syntaxParse()
 	}
 }
 
@@ -2878,24 +3772,26 @@ func (e *Editor) deleteVisualSelection() {
 	b := e.activeBuffer()
 	y1, x1, y2, x2 := e.getSelectionBounds()
 	if b.readOnly {
-		e.message = "File is read-only"
+		e.setMessage("File is read-only")
 		return
 	}
 
-	// Copy to clipboard
-	e.clipboard = e.getSelection()
+	cursorsBefore := append([]Cursor(nil), b.cursors...)
+	selection := e.getSelection()
+
+	// Record into the registers
+	e.deleteToRegister(selection, e.selectionKind())
 
 	if e.mode == ModeVisualLine {
-		// Remove all selected lines
-		b.buffer = append(b.buffer[:y1], b.buffer[y2+1:]...)
-		if len(b.buffer) == 0 {
-			b.buffer = [][]rune{{}}
-		}
-		if y1 >= len(b.buffer) {
-			y1 = len(b.buffer) - 1
-		}
-		b.PrimaryCursor().Y = y1
-		b.PrimaryCursor().X = 0
+		// Remove all selected lines.
+		b.Delete(y1, 0, y2+1, 0)
+		cursor := b.PrimaryCursor()
+		cursor.Y = y1
+		if cursor.Y >= b.LineCount() {
+			cursor.Y = b.LineCount() - 1
+		}
+		cursor.X = 0
+		e.pushPreciseEdit(EditDelete, y1, 0, y2+1, 0, selection, nil, cursorsBefore, append([]Cursor(nil), b.cursors...), 0)
 	} else if e.mode == ModeVisualBlock {
 		startX := x1
 		endX := x2
@@ -2903,69 +3799,67 @@ func (e *Editor) deleteVisualSelection() {
 			startX, endX = endX, startX
 		}
 
-		for y := y1; y <= y2; y++ {
-			if y < len(b.buffer) {
-				line := b.buffer[y]
-				s := startX
-				e := endX + 1
-				if s > len(line) {
-					s = len(line)
-				}
-				if e > len(line) {
-					e = len(line)
-				}
-
-				if s < e {
-					newLine := append(line[:s], line[e:]...)
-					b.buffer[y] = newLine
-				}
+		// A block selection isn't a contiguous range, so each row is deleted
+		// (and pushed as its own undo entry) separately rather than through
+		// one Delete call.
+		for y := y1; y <= y2 && y < b.LineCount(); y++ {
+			line := b.Line(y)
+			s := startX
+			en := endX + 1
+			if s > len(line) {
+				s = len(line)
+			}
+			if en > len(line) {
+				en = len(line)
+			}
+			if s < en {
+				old := b.Slice(y, s, y, en)
+				b.Delete(y, s, y, en)
+				e.pushPreciseEdit(EditDelete, y, s, y, en, old, nil, cursorsBefore, cursorsBefore, 0)
 			}
 		}
-		b.PrimaryCursor().Y = y1
-		b.PrimaryCursor().X = startX
+		cursor := b.PrimaryCursor()
+		cursor.Y = y1
+		cursor.X = startX
 	} else {
-		// Modify buffer for character-wise selection
-		line1 := b.buffer[y1]
-		line2 := b.buffer[y2]
-
-		prefix := make([]rune, x1)
-		copy(prefix, line1[:x1])
-
-		suffix := []rune{}
-		if x2+1 < len(line2) {
-			suffix = make([]rune, len(line2)-(x2+1))
-			copy(suffix, line2[x2+1:])
-		}
-
-		newLine := append(prefix, suffix...)
-		b.buffer[y1] = newLine
-
-		// Remove lines between
-		if y1 != y2 {
-			b.buffer = append(b.buffer[:y1+1], b.buffer[y2+1:]...)
-		}
-
-		b.PrimaryCursor().Y = y1
-		b.PrimaryCursor().X = x1
+		// Character-wise selection: a single contiguous range.
+		b.Delete(y1, x1, y2, x2+1)
+		cursor := b.PrimaryCursor()
+		cursor.Y = y1
+		cursor.X = x1
+		e.pushPreciseEdit(EditDelete, y1, x1, y2, x2+1, selection, nil, cursorsBefore, append([]Cursor(nil), b.cursors...), 0)
 	}
 
 	e.mode = ModeNormal
 	e.markModified()
 
 	if b.syntax != nil {
-		b.syntax.Parse([]byte(b.toString()))
+		b.syntaxParse()
 	}
 }
 
 func (e *Editor) yankVisualSelection() {
-	e.clipboard = e.getSelection()
+	e.yankToRegister(e.getSelection(), e.selectionKind())
 	e.mode = ModeNormal
 }
 
+// selectionKind reports the RegisterKind the current visual selection
+// should be recorded as, based on the active visual mode.
+func (e *Editor) selectionKind() RegisterKind {
+	switch e.mode {
+	case ModeVisualLine:
+		return RegLinewise
+	case ModeVisualBlock:
+		return RegBlockwise
+	default:
+		return RegCharwise
+	}
+}
+
 func (e *Editor) changeVisualSelection() {
 	b := e.activeBuffer()
 	if b != nil && b.readOnly {
-		e.message = "File is read-only"
+		e.setMessage("File is read-only")
 		return
 	}
 	e.deleteVisualSelection()
@@ -2973,32 +3867,35 @@ func (e *Editor) changeVisualSelection() {
 }
 
 func (e *Editor) pasteVisualSelection() {
-	if len(e.clipboard) == 0 {
+	clipboard, kind := e.pasteRegister()
+	if len(clipboard) == 0 {
 		return
 	}
 	b := e.activeBuffer()
 	if b != nil && b.readOnly {
-		e.message = "File is read-only"
+		e.setMessage("File is read-only")
 		return
 	}
-	// Save clipboard because deleteVisualSelection overwrites it
-	tmpClipboard := make([]rune, len(e.clipboard))
-	copy(tmpClipboard, e.clipboard)
 
+	// deleteVisualSelection consumes the pending register (writing the
+	// selection into the unnamed register), so stash what we're about to
+	// paste and restore it as the unnamed register afterwards.
 	e.deleteVisualSelection()
-
-	// Restore clipboard and paste
-	e.clipboard = tmpClipboard
+	e.registers.unnamed = Register{Text: clipboard, Kind: kind}
 	e.pasteLineAbove()
 }
 
 func (e *Editor) toggleComment(y int) {
 	b := e.activeBuffer()
-	if b == nil || len(b.buffer) == 0 || b.fileType == nil || b.fileType.Comment == "" {
+	if b == nil || len(b.buffer) == 0 || b.fileType == nil {
+		return
+	}
+	ft := b.fileType
+	if ft.Comment == "" && ft.CommentStart == "" {
 		return
 	}
 	if b.readOnly {
-		e.message = "File is read-only"
+		e.setMessage("File is read-only")
 		return
 	}
 	if y < 0 || y >= len(b.buffer) {
@@ -3010,32 +3907,34 @@ func (e *Editor) toggleComment(y int) {
 		return
 	}
 
-	comment := []rune(b.fileType.Comment)
-
-	// Check if already commented at the beginning of the line
-	isCommented := false
-	if len(line) >= len(comment) {
-		match := true
-		for i, r := range comment {
-			if line[i] != r {
-				match = false
-				break
-			}
-		}
-		isCommented = match
+	// Filetypes with no line-comment form (CSS, HTML) always wrap the whole
+	// line in a single-line block comment instead.
+	if ft.Comment == "" {
+		e.toggleBlockComment(y, 0, y, len(line))
+		return
 	}
 
+	lineStr := string(line)
+	comment := []rune(ft.Comment)
+	isLineCommented := len(line) >= len(comment) && string(line[:len(comment)]) == ft.Comment
+	isBlockCommented := ft.CommentStart != "" &&
+		strings.HasPrefix(lineStr, ft.CommentStart) && strings.HasSuffix(lineStr, ft.CommentEnd)
+
 	var newLine []rune
-	if isCommented {
-		// Uncomment
+	switch {
+	case isBlockCommented:
+		// Recognize a single-line block wrapper too, so un-commenting still
+		// works if the line was block-commented by hand or by another tool.
+		inner := strings.TrimSpace(lineStr[len(ft.CommentStart) : len(lineStr)-len(ft.CommentEnd)])
+		newLine = []rune(inner)
+	case isLineCommented:
 		contentStart := len(comment)
 		// Skip optional following space
 		if contentStart < len(line) && line[contentStart] == ' ' {
 			contentStart++
 		}
 		newLine = append(newLine, line[contentStart:]...)
-	} else {
-		// Comment
+	default:
 		newLine = append(newLine, comment...)
 		newLine = append(newLine, ' ')
 		newLine = append(newLine, line...)
@@ -3045,8 +3944,94 @@ func (e *Editor) toggleComment(y int) {
 	e.markModified()
 
 	if b.syntax != nil {
-		b.syntax.Parse([]byte(b.toString()))
+		b.syntaxParse()
+	}
+}
+
+// toggleBlockComment wraps (or, if already wrapped, unwraps) the range from
+// (y1,x1) to (y2,x2) (x2 exclusive) in the active filetype's CommentStart /
+// CommentEnd markers. Used for filetypes whose only comment form is a block
+// comment (CSS, HTML) and for commentVisualSelection's single-block mode.
+func (e *Editor) toggleBlockComment(y1, x1, y2, x2 int) {
+	b := e.activeBuffer()
+	if b == nil || b.fileType == nil || b.fileType.CommentStart == "" {
+		return
+	}
+	if b.readOnly {
+		e.setMessage("File is read-only")
+		return
+	}
+	if y1 < 0 || y2 < y1 || y2 >= len(b.buffer) {
+		return
+	}
+
+	start := []rune(b.fileType.CommentStart)
+	end := []rune(b.fileType.CommentEnd)
+
+	if x1 < 0 {
+		x1 = 0
+	}
+	lastLine := b.buffer[y2]
+	if x2 < 0 || x2 > len(lastLine) {
+		x2 = len(lastLine)
+	}
+	firstLine := b.buffer[y1]
+
+	wrapped := len(firstLine)-x1 >= len(start) && runesEqual(firstLine[x1:x1+len(start)], start) &&
+		x2 >= len(end) && runesEqual(lastLine[x2-len(end):x2], end)
+
+	if y1 == y2 {
+		line := b.buffer[y1]
+		var newLine []rune
+		if wrapped {
+			newLine = append(newLine, line[:x1]...)
+			newLine = append(newLine, line[x1+len(start):x2-len(end)]...)
+			newLine = append(newLine, line[x2:]...)
+		} else {
+			newLine = append(newLine, line[:x1]...)
+			newLine = append(newLine, start...)
+			newLine = append(newLine, line[x1:x2]...)
+			newLine = append(newLine, end...)
+			newLine = append(newLine, line[x2:]...)
+		}
+		b.buffer[y1] = newLine
+	} else if wrapped {
+		newLast := append([]rune{}, lastLine[:x2-len(end)]...)
+		newLast = append(newLast, lastLine[x2:]...)
+		b.buffer[y2] = newLast
+
+		newFirst := append([]rune{}, firstLine[:x1]...)
+		newFirst = append(newFirst, firstLine[x1+len(start):]...)
+		b.buffer[y1] = newFirst
+	} else {
+		newLast := append([]rune{}, lastLine[:x2]...)
+		newLast = append(newLast, end...)
+		newLast = append(newLast, lastLine[x2:]...)
+		b.buffer[y2] = newLast
+
+		newFirst := append([]rune{}, firstLine[:x1]...)
+		newFirst = append(newFirst, start...)
+		newFirst = append(newFirst, firstLine[x1:]...)
+		b.buffer[y1] = newFirst
+	}
+
+	e.markModified()
+	if b.syntax != nil {
+		b.syntaxParse()
+	}
+}
+
+// runesEqual reports whether two rune slices hold the same runes.
+func runesEqual(a, b []rune) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
 	}
+	return true
 }
 
 func (e *Editor) toggleCommentLine() {
@@ -3056,8 +4041,18 @@ func (e *Editor) toggleCommentLine() {
 	}
 }
 
+// commentVisualSelection toggles a comment over the current visual
+// selection. Filetypes with only a block-comment form (CSS, HTML) get a
+// single block wrapped around the whole selection; others get each line
+// commented independently, as before.
 func (e *Editor) commentVisualSelection() {
-	y1, _, y2, _ := e.getSelectionBounds()
+	b := e.activeBuffer()
+	y1, x1, y2, x2 := e.getSelectionBounds()
+	if b != nil && b.fileType != nil && b.fileType.Comment == "" && b.fileType.CommentStart != "" {
+		e.toggleBlockComment(y1, x1, y2, x2+1)
+		e.mode = ModeNormal
+		return
+	}
 	for y := y1; y <= y2; y++ {
 		e.toggleComment(y)
 	}
@@ -3099,7 +4094,7 @@ func (e *Editor) ToggleCaseUnderCursor() {
 		return
 	}
 	if b.readOnly {
-		e.message = "File is read-only"
+		e.setMessage("File is read-only")
 		return
 	}
 
@@ -3108,7 +4103,7 @@ func (e *Editor) ToggleCaseUnderCursor() {
 	e.markModified()
 
 	if b.syntax != nil {
-		b.syntax.Parse([]byte(b.toString()))
+		b.syntaxParse()
 	}
 }
 
@@ -3118,7 +4113,7 @@ func (e *Editor) ToggleCaseVisualSelection() {
 		return
 	}
 	if b.readOnly {
-		e.message = "File is read-only"
+		e.setMessage("File is read-only")
 		return
 	}
 
@@ -3150,7 +4145,7 @@ func (e *Editor) ToggleCaseVisualSelection() {
 	e.markModified()
 
 	if b.syntax != nil {
-		b.syntax.Parse([]byte(b.toString()))
+		b.syntaxParse()
 	}
 }
 
@@ -3179,7 +4174,7 @@ func (e *Editor) formatText() {
 		return
 	}
 	if b.readOnly {
-		e.message = "File is read-only"
+		e.setMessage("File is read-only")
 		return
 	}
 
@@ -3357,20 +4352,20 @@ func (e *Editor) formatText() {
 	e.markModified()
 
 	if b.syntax != nil {
-		b.syntax.Parse([]byte(b.toString()))
+		b.syntaxParse()
 	}
 
-	e.message = "Text formatted"
+	e.setMessage("Text formatted")
 }
 
-// performSearch performs a linear case-insensitive search for a query string.
+// performSearch performs a linear search for a query string, honoring
+// e.searchRegex and the Unicode diacritic-folding toggle (see search.go).
 func (e *Editor) performSearch(query string, forward bool) {
 	b := e.activeBuffer()
 	if b == nil || len(b.buffer) == 0 || query == "" {
 		return
 	}
 
-	queryLower := strings.ToLower(query)
 	startY := b.PrimaryCursor().Y
 	startX := b.PrimaryCursor().X
 
@@ -3384,40 +4379,27 @@ func (e *Editor) performSearch(query string, forward bool) {
 
 	// Loop through the entire buffer once.
 	for i := 0; i <= len(b.buffer); i++ {
-		line := string(b.buffer[y])
-		lineLower := strings.ToLower(line)
-
-		matches := []int{}
-		// Scan line for all occurrences.
-		for pos := 0; pos < len(lineLower); {
-			idx := strings.Index(lineLower[pos:], queryLower)
-			if idx == -1 {
-				break
-			}
-			matchPos := pos + idx
-			matches = append(matches, matchPos)
-			pos = matchPos + 1
-		}
+		matches := e.searchMatchesInLine(b.buffer[y], query)
 
 		if len(matches) > 0 {
 			if forward {
 				for _, m := range matches {
 					// Ensure we skip the current cursor position on the first line.
-					if firstLoop && m <= startX {
+					if firstLoop && m[0] <= startX {
 						continue
 					}
 					b.PrimaryCursor().Y = y
-					b.PrimaryCursor().X = m
+					b.PrimaryCursor().X = m[0]
 					return
 				}
 			} else {
 				for j := len(matches) - 1; j >= 0; j-- {
 					m := matches[j]
-					if firstLoop && m >= startX {
+					if firstLoop && m[0] >= startX {
 						continue
 					}
 					b.PrimaryCursor().Y = y
-					b.PrimaryCursor().X = m
+					b.PrimaryCursor().X = m[0]
 					return
 				}
 			}
@@ -3461,12 +4443,23 @@ func (e *Editor) checkDiagnostics() {
 	}
 
 	// Diagnostics will be updated asynchronously when clangd sends publishDiagnostics
-	// The background readMessages goroutine handles this automatically
+	// The background Conn.Run goroutine (jsonrpc2.go) handles this automatically
 	// Get current diagnostics (may be from previous check)
 	b.diagnostics = b.lspClient.GetDiagnostics()
 	e.addLog("LSP", fmt.Sprintf("Current diagnostics: %d", len(b.diagnostics)))
 }
 
+// ShutdownAllLSPClients sends shutdown/exit to every buffer's LSP client.
+// Called before the process exits so language servers aren't left running
+// as orphans (deleteCurrentBuffer does the same for a single buffer).
+func (e *Editor) ShutdownAllLSPClients() {
+	for _, b := range e.buffers {
+		if b.lspClient != nil {
+			b.lspClient.Shutdown()
+		}
+	}
+}
+
 func (e *Editor) deleteCurrentBuffer() {
 	if len(e.buffers) == 0 {
 		return
@@ -3487,8 +4480,8 @@ func (e *Editor) deleteCurrentBuffer() {
 		defaultType := fileTypes[len(fileTypes)-1]
 		e.buffers = append(e.buffers, &Buffer{
 			buffer:    [][]rune{{}},
-			undoStack: []HistoryState{},
-			redoStack: []HistoryState{},
+			undoStack: []Edit{},
+			redoStack: []Edit{},
 			fileType:  defaultType,
 		})
 		e.activeBufferIndex = 0
@@ -3499,7 +4492,7 @@ func (e *Editor) deleteCurrentBuffer() {
 
 // drawStatusBar renders the bottom-aligned information bar showing file details and editor state.
 func (e *Editor) drawStatusBar(statusY int) {
-	w, _ := termbox.Size()
+	w, _ := e.termSize()
 	b := e.activeBuffer()
 	if b == nil {
 		return
@@ -3510,7 +4503,7 @@ func (e *Editor) drawStatusBar(statusY int) {
 	// Fill background for the entire status line.
 	for x := 0; x < w; x++ {
 		fg, bg := GetThemeColor(ColorStatusBar)
-		termbox.SetCell(x, statusY, ' ', fg, bg)
+		e.setCell(x, statusY, ' ', fg, bg)
 	}
 
 	// Draw the primary mode indicator.
@@ -3533,6 +4526,18 @@ func (e *Editor) drawStatusBar(statusY int) {
 		case FuzzyModeWarning:
 			modeStr = "WARNINGS"
 			fg, bg = GetThemeColor(ColorFuzzyModeWarnings)
+		case FuzzyModeDirectory:
+			modeStr = "DIRS"
+			fg, bg = GetThemeColor(ColorFuzzyModeDirs)
+		case FuzzyModeTag:
+			modeStr = "TAGS"
+			fg, bg = GetThemeColor(ColorFuzzyModeTags)
+		case FuzzyModeCodeAction:
+			modeStr = "CODE ACTIONS"
+			fg, bg = GetThemeColor(ColorFuzzyModeCodeActions)
+		case FuzzyModeRenamePreview:
+			modeStr = "RENAME PREVIEW"
+			fg, bg = GetThemeColor(ColorFuzzyModeRename)
 		default:
 			modeStr = "FUZZY"
 			fg, bg = GetThemeColor(ColorNormalMode)
@@ -3542,11 +4547,11 @@ func (e *Editor) drawStatusBar(statusY int) {
 		fg, bg = GetThemeColor(ColorNormalMode)
 	}
 
-	termbox.SetCell(0, statusY, ' ', fg, bg)
+	e.setCell(0, statusY, ' ', fg, bg)
 	for i, r := range modeStr {
-		termbox.SetCell(i+1, statusY, r, fg, bg)
+		e.setCell(i+1, statusY, r, fg, bg)
 	}
-	termbox.SetCell(len(modeStr)+1, statusY, ' ', fg, bg)
+	e.setCell(len(modeStr)+1, statusY, ' ', fg, bg)
 
 	// Draw filename and modification status.
 	fileStr := "[no file]"
@@ -3562,7 +4567,7 @@ func (e *Editor) drawStatusBar(statusY int) {
 	fileX := len(modeStr) + 2 + 1
 	for i, r := range fileStr {
 		fg, bg := GetThemeColor(ColorStatusBar)
-		termbox.SetCell(fileX+i, statusY, r, fg, bg)
+		e.setCell(fileX+i, statusY, r, fg, bg)
 	}
 
 	// Draw cursor coordinates and file metadata.
@@ -3577,12 +4582,20 @@ func (e *Editor) drawStatusBar(statusY int) {
 	if b.fileType != nil {
 		fileTypeStr = strings.ToLower(b.fileType.Name)
 	}
-	statusRight := fmt.Sprintf("(%s) [%d/%d] %d,%d %d%% ", fileTypeStr, e.activeBufferIndex+1, len(e.buffers), lineNum, visualCol, percent)
+	encodingStr := b.encoding
+	if encodingStr == "" {
+		encodingStr = "utf-8"
+	}
+	lineEndingStr := b.lineEnding
+	if lineEndingStr == "" {
+		lineEndingStr = LineEndingLF
+	}
+	statusRight := fmt.Sprintf("(%s) %s:%s [%d/%d] %d,%d %d%% ", fileTypeStr, encodingStr, lineEndingStr, e.activeBufferIndex+1, len(e.buffers), lineNum, visualCol, percent)
 	rightPositionWidth := 6
 	rightX := w - len(statusRight) - rightPositionWidth
 	for i, r := range statusRight {
 		fg, bg := GetThemeColor(ColorStatusBar)
-		termbox.SetCell(rightX+i, statusY, r, fg, bg)
+		e.setCell(rightX+i, statusY, r, fg, bg)
 	}
 
 	// Draw connectivity status for LSP and Ollama.
@@ -3591,8 +4604,8 @@ func (e *Editor) drawStatusBar(statusY int) {
 		lspColor = ColorLSPStatusConnected
 	}
 	fgL, bgL := GetThemeColor(lspColor)
-	for i, r := range " L " {
-		termbox.SetCell(w-6+i, statusY, r, fgL, bgL)
+	for i, r := range " " + string(IconRune(IconLSP)) + " " {
+		e.setCell(w-6+i, statusY, r, fgL, bgL)
 	}
 
 	ollamaColor := ColorOllamaStatusDisconnected
@@ -3600,22 +4613,29 @@ func (e *Editor) drawStatusBar(statusY int) {
 		ollamaColor = ColorOllamaStatusConnected
 	}
 	fgO, bgO := GetThemeColor(ollamaColor)
-	for i, r := range " O " {
-		termbox.SetCell(w-3+i, statusY, r, fgO, bgO)
+	for i, r := range " " + string(IconRune(IconOllama)) + " " {
+		e.setCell(w-3+i, statusY, r, fgO, bgO)
 	}
 }
 
 func (e *Editor) drawCommandBar(cmdY int) {
-	w, _ := termbox.Size()
+	w, _ := e.termSize()
 	for x := 0; x < w; x++ {
 		fg, bg := GetThemeColor(ColorDefault)
-		termbox.SetCell(x, cmdY, ' ', fg, bg)
+		e.setCell(x, cmdY, ' ', fg, bg)
 	}
 
 	prompt := ""
 	buffer := []rune{}
 	startX := 0
-	if e.mode == ModeCommand {
+	if e.mode == ModeCommand && e.historySearchActive {
+		matchMode := "reverse-i-search"
+		if e.historySearchPrefixMode {
+			matchMode = "prefix-i-search"
+		}
+		prompt = fmt.Sprintf("(%s)`%s': ", matchMode, string(e.historySearchQuery))
+		buffer = e.commandBuffer
+	} else if e.mode == ModeCommand {
 		prompt = ":"
 		buffer = e.commandBuffer
 	} else if e.mode == ModeFuzzy {
@@ -3624,10 +4644,30 @@ func (e *Editor) drawCommandBar(cmdY int) {
 		startX = 1
 	} else if e.mode == ModeFind {
 		prompt = "/"
+		if e.searchRegex {
+			prompt += "[re]"
+		}
+		if Config.SearchLiteral != e.searchLiteral {
+			prompt += "[lit]"
+		}
 		buffer = e.findBuffer
 	} else if e.mode == ModeReplace {
 		prompt = "replace: "
 		buffer = e.replaceInput
+	} else if e.mode == ModeAITransform {
+		prompt = "ai: "
+		buffer = e.aiTransformInput
+	} else if e.activeShellJob != nil && e.activeShellJob.running {
+		frame, elapsed := e.activeShellJob.Spinner()
+		status := fmt.Sprintf("%c running: %s (%s)", frame, e.activeShellJob.cmdline, elapsed.Round(time.Second))
+		for i, r := range status {
+			if i >= w {
+				break
+			}
+			fg, bg := GetThemeColor(ColorDefault)
+			e.setCell(i, cmdY, r, fg, bg)
+		}
+		return
 	} else if e.message != "" {
 		// Draw transient message
 		for i, r := range e.message {
@@ -3635,7 +4675,7 @@ func (e *Editor) drawCommandBar(cmdY int) {
 				break
 			}
 			fg, bg := GetThemeColor(ColorDefault)
-			termbox.SetCell(i, cmdY, r, fg, bg)
+			e.setCell(i, cmdY, r, fg, bg)
 		}
 		return
 	} else {
@@ -3680,7 +4720,7 @@ func (e *Editor) drawCommandBar(cmdY int) {
 					break
 				}
 				_, bg := GetThemeColor(ColorDefault)
-				termbox.SetCell(i, cmdY, r, fg, bg)
+				e.setCell(i, cmdY, r, fg, bg)
 			}
 			return
 		}
@@ -3689,13 +4729,13 @@ func (e *Editor) drawCommandBar(cmdY int) {
 	// Draw prompt
 	for i, r := range prompt {
 		fg, bg := GetThemeColor(ColorDefault)
-		termbox.SetCell(startX+i, cmdY, r, fg, bg)
+		e.setCell(startX+i, cmdY, r, fg, bg)
 	}
 
 	// Draw buffer content
 	for i, r := range buffer {
 		fg, bg := GetThemeColor(ColorDefault)
-		termbox.SetCell(startX+len(prompt)+i, cmdY, r, fg, bg)
+		e.setCell(startX+len(prompt)+i, cmdY, r, fg, bg)
 	}
 }
 
@@ -3712,6 +4752,15 @@ func (e *Editor) highlightLine(lineIdx int, line []rune) ([]termbox.Attribute, [
 	}
 
 	b := e.activeBuffer()
+	if b != nil && b.filename == "[Messages]" {
+		if fg, ok := messageLineColor(line); ok {
+			for i := range fgAttrs {
+				fgAttrs[i] = fg
+			}
+		}
+		return fgAttrs, bgAttrs
+	}
+
 	if b != nil && b.syntax != nil {
 		attrs := b.syntax.Highlight(lineIdx, line)
 		// SyntaxHighlighter returns FG colors.
@@ -3723,9 +4772,76 @@ func (e *Editor) highlightLine(lineIdx int, line []rune) ([]termbox.Attribute, [
 		fgAttrs = attrs
 	}
 
+	e.applyWhitespaceWarnings(b, lineIdx, line, fgAttrs, bgAttrs)
+
 	return fgAttrs, bgAttrs
 }
 
+// applyWhitespaceWarnings overlays ColorTrailingWhitespace/ColorMixedIndent
+// onto fgAttrs/bgAttrs in place, gated by Config.HighlightTrailingWS and
+// Config.HighlightMixedIndent. The line currently being edited in Insert mode
+// is exempt from the trailing-whitespace marker so typing a space doesn't
+// flash red before more text follows it.
+func (e *Editor) applyWhitespaceWarnings(b *Buffer, lineIdx int, line []rune, fgAttrs, bgAttrs []termbox.Attribute) {
+	if b == nil {
+		return
+	}
+
+	if Config.HighlightTrailingWS {
+		editingThisLine := e.mode == ModeInsert && b.PrimaryCursor().Y == lineIdx
+		if !editingThisLine {
+			end := len(line)
+			for end > 0 && (line[end-1] == ' ' || line[end-1] == '\t') {
+				end--
+			}
+			if end < len(line) {
+				fg, bg := GetThemeColor(ColorTrailingWhitespace)
+				for i := end; i < len(line); i++ {
+					fgAttrs[i] = fg
+					bgAttrs[i] = bg
+				}
+			}
+		}
+	}
+
+	if Config.HighlightMixedIndent {
+		sawSpace, sawTab := false, false
+		indentEnd := 0
+		for indentEnd < len(line) && (line[indentEnd] == ' ' || line[indentEnd] == '\t') {
+			if line[indentEnd] == ' ' {
+				sawSpace = true
+			} else {
+				sawTab = true
+			}
+			indentEnd++
+		}
+		if sawSpace && sawTab {
+			fg, bg := GetThemeColor(ColorMixedIndent)
+			for i := 0; i < indentEnd; i++ {
+				fgAttrs[i] = fg
+				bgAttrs[i] = bg
+			}
+		}
+	}
+}
+
+// messageLineColor picks a foreground color for a rendered [Messages] line
+// based on the "[ERROR]"/"[WARN]" tag messagesCommand prefixed it with, so
+// users scanning the log can spot failures at a glance.
+func messageLineColor(line []rune) (termbox.Attribute, bool) {
+	text := string(line)
+	switch {
+	case strings.Contains(text, "["+severityLabel(MessageError)+"]"):
+		fg, _ := GetThemeColor(ColorDiagSummaryError)
+		return fg, true
+	case strings.Contains(text, "["+severityLabel(MessageWarning)+"]"):
+		fg, _ := GetThemeColor(ColorDiagSummaryWarning)
+		return fg, true
+	default:
+		return 0, false
+	}
+}
+
 func matchesKeyword(runes []rune, start int, keyword string) bool {
 	if start+len(keyword) > len(runes) {
 		return false
@@ -3747,14 +4863,53 @@ func isWordStart(line []rune, i int) bool {
 	return !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_')
 }
 
+// setCell writes a cell through the reserved render region's vertical
+// offset (screenBaseY), so every drawXxx call stays inside the --height
+// rows the editor owns instead of touching the terminal rows above it.
+// When the terminal advertises 24-bit color (see truecolor.go), it also
+// queues the cell for the true-color overlay pass that runs after
+// termbox.Flush(), since termbox itself only ever paints from its 256/16
+// palette.
+func (e *Editor) setCell(x, y int, ch rune, fg, bg termbox.Attribute) {
+	ty := y + e.screenBaseY
+	termbox.SetCell(x, ty, ch, fg, bg)
+	if trueColorActive {
+		e.queueTrueColorCell(x, ty, ch, fg, bg)
+	}
+}
+
+// termSize returns the terminal width and the editor's effective height
+// (see resolveHeight/--height), so every drawXxx function lays out rows
+// against the region the editor actually owns rather than the full
+// terminal.
+func (e *Editor) termSize() (int, int) {
+	w, termH := termbox.Size()
+	return w, resolveHeight(termH)
+}
+
+// clearRegion blanks only the rows the editor owns (screenBaseY onward),
+// leaving any terminal scrollback above that region untouched.
+func (e *Editor) clearRegion(w, h int, bg termbox.Attribute) {
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			e.setCell(x, y, ' ', termbox.ColorDefault, bg)
+		}
+	}
+}
+
 // draw is the main UI rendering loop.
 func (e *Editor) draw() {
 	_, defaultBg := GetThemeColor(ColorDefault)
-	termbox.Clear(termbox.ColorDefault, defaultBg)
-	w, h := termbox.Size()
+	w, termH := termbox.Size()
+	h := resolveHeight(termH)
+	e.screenBaseY = termH - h
+	e.clearRegion(w, h, defaultBg)
 	b := e.activeBuffer()
 	if b == nil {
 		termbox.Flush()
+		if trueColorActive {
+			e.flushTrueColorOverlay()
+		}
 		return
 	}
 
@@ -3781,6 +4936,17 @@ func (e *Editor) draw() {
 		b.scrollX = visualCursorX - textWidth + 1
 	}
 
+	// Ask the background highlight worker to cover whatever's now on screen
+	// (see SyntaxHighlighter.EnqueueViewport); a no-op unless the viewport
+	// actually changed since the last frame.
+	if b.syntax != nil {
+		viewportEnd := b.scrollY + visibleHeight - 1
+		if viewportEnd >= len(b.buffer) {
+			viewportEnd = len(b.buffer) - 1
+		}
+		b.syntax.EnqueueViewport(b.syntax.Tree, b.scrollY, viewportEnd)
+	}
+
 	// Optimized mapping for faster cursor lookup during rendering.
 	cursorMap := make(map[int]map[int]bool)
 	for _, c := range b.cursors {
@@ -3790,6 +4956,10 @@ func (e *Editor) draw() {
 		cursorMap[c.Y][c.X] = true
 	}
 
+	// The brace pair under the primary cursor (if any), highlighted below so
+	// it always agrees with where '%' (JumpToMatchingBrace) would jump.
+	braceOpenY, braceOpenX, braceCloseY, braceCloseX, braceOK := FindMatchingBrace(b, b.PrimaryCursor().Y, b.PrimaryCursor().X)
+
 	for screenY := 0; screenY < visibleHeight; screenY++ {
 		bufferY := screenY + b.scrollY
 		if bufferY < len(b.buffer) {
@@ -3799,31 +4969,32 @@ func (e *Editor) draw() {
 			if b.diagnostics != nil {
 				for _, diag := range b.diagnostics {
 					if diag.Range.Start.Line == bufferY {
+						errSign := IconRune(IconDiagError)
 						if diag.Severity == 1 {
-							diagSign = 'E'
+							diagSign = errSign
 							diagColor, diagBg = GetThemeColor(ColorGutterSignError)
-						} else if diag.Severity == 2 && diagSign != 'E' {
-							diagSign = 'W'
+						} else if diag.Severity == 2 && diagSign != errSign {
+							diagSign = IconRune(IconDiagWarning)
 							diagColor, diagBg = GetThemeColor(ColorGutterSignWarning)
-						} else if diag.Severity == 3 && diagSign != 'E' {
-							diagSign = 'I'
+						} else if diag.Severity == 3 && diagSign != errSign {
+							diagSign = IconRune(IconDiagInfo)
 							diagColor, diagBg = GetThemeColor(ColorGutterSignInfo)
-						} else if diag.Severity == 4 && diagSign != 'E' {
-							diagSign = 'H'
+						} else if diag.Severity == 4 && diagSign != errSign {
+							diagSign = IconRune(IconDiagHint)
 							diagColor, diagBg = GetThemeColor(ColorGutterSignHint)
 						}
 					}
 				}
 			}
 
-			termbox.SetCell(0, screenY, diagSign, diagColor, diagBg)
-			termbox.SetCell(1, screenY, ' ', diagBg, diagBg)
+			e.setCell(0, screenY, diagSign, diagColor, diagBg)
+			e.setCell(1, screenY, ' ', diagBg, diagBg)
 
 			// Gutter line number rendering.
 			lineNum := strconv.Itoa(bufferY + 1)
 			gutterFg, gutterBg := GetThemeColor(ColorGutterLineNumber)
 			for i, r := range lineNum {
-				termbox.SetCell(Config.GutterWidth-len(lineNum)-1+i, screenY, r, gutterFg, gutterBg)
+				e.setCell(Config.GutterWidth-len(lineNum)-1+i, screenY, r, gutterFg, gutterBg)
 			}
 
 			// Text highlighting and rendering block.
@@ -3844,7 +5015,7 @@ func (e *Editor) draw() {
 				_, bg = GetThemeColor(ColorHighlightedLine)
 				for x := 0; x < textWidth; x++ {
 					fg, _ := GetThemeColor(ColorDefault)
-					termbox.SetCell(x+Config.GutterWidth, screenY, ' ', fg, bg)
+					e.setCell(x+Config.GutterWidth, screenY, ' ', fg, bg)
 				}
 			}
 
@@ -3861,24 +5032,11 @@ func (e *Editor) draw() {
 			}
 
 			searchMatches := []bool{}
-			if e.lastSearch != "" {
+			if e.hlsearch && e.lastSearch != "" {
 				searchMatches = make([]bool, len(b.buffer[bufferY]))
-				lineRunes := b.buffer[bufferY]
-				queryRunes := []rune(strings.ToLower(e.lastSearch))
-				queryLen := len(queryRunes)
-
-				for i := 0; i <= len(lineRunes)-queryLen; i++ {
-					match := true
-					for j := 0; j < queryLen; j++ {
-						if unicode.ToLower(lineRunes[i+j]) != queryRunes[j] {
-							match = false
-							break
-						}
-					}
-					if match {
-						for k := 0; k < queryLen; k++ {
-							searchMatches[i+k] = true
-						}
+				for _, m := range e.hlsearchMatchesForLine(b, bufferY) {
+					for k := m.startCol; k < m.endCol && k < len(searchMatches); k++ {
+						searchMatches[k] = true
 					}
 				}
 			}
@@ -3949,6 +5107,13 @@ func (e *Editor) draw() {
 					fgAttrs[idx] = searchMatchFg
 				}
 
+				if !isVisualSelected && braceOK &&
+					((bufferY == braceOpenY && idx == braceOpenX) || (bufferY == braceCloseY && idx == braceCloseX)) {
+					braceFg, braceBg := GetThemeColor(ColorMatchingBrace)
+					charBg = braceBg
+					fgAttrs[idx] = braceFg
+				}
+
 				if e.mode == ModeReplace {
 					for _, match := range e.replaceMatches {
 						if match.startLine == bufferY && idx >= match.startCol && idx < match.endCol {
@@ -3971,23 +5136,50 @@ func (e *Editor) draw() {
 						if r == '\t' {
 							char = ' '
 						}
-						termbox.SetCell(screenX+Config.GutterWidth, screenY, char, fgAttrs[idx], charBg)
+						e.setCell(screenX+Config.GutterWidth, screenY, char, fgAttrs[idx], charBg)
 					}
 				}
 				visualX += width
 			}
 
+			// Ghost-text rendering: a dim, unaccepted AI suggestion drawn past
+			// the cursor without touching b.buffer. Only rendered when the
+			// cursor sits at end-of-line, where "past the cursor" has an
+			// unambiguous screen position; a mid-line suggestion is still
+			// generated (see ghosttext.go) but not drawn, since splicing it
+			// into the middle of already-rendered real text would require
+			// shifting every cell after the cursor.
+			if e.mode == ModeInsert && bufferY == b.ghostY && b.ghostX == len(b.buffer[bufferY]) &&
+				b.PrimaryCursor().X == b.ghostX && b.PrimaryCursor().Y == b.ghostY {
+				ghostFg, ghostBg := GetThemeColor(ColorGhostText)
+				screenX := visualX - b.scrollX
+				for _, r := range b.ghostText {
+					if r == '\n' {
+						break // Only the first line of a multi-line suggestion is shown inline.
+					}
+					width := e.visualWidth(r, visualX)
+					for i := 0; i < width; i++ {
+						sx := screenX + i
+						if sx >= 0 && sx < textWidth {
+							e.setCell(sx+Config.GutterWidth, screenY, r, ghostFg, ghostBg)
+						}
+					}
+					screenX += width
+					visualX += width
+				}
+			}
+
 			if e.mode == ModeVisualLine && bufferY >= vStartY && bufferY <= vEndY {
 				_, visualModeLineBg := GetThemeColor(ColorVisualModeSelection)
 				for x := visualX - b.scrollX; x < textWidth; x++ {
 					if x >= 0 {
-						termbox.SetCell(x+Config.GutterWidth, screenY, ' ', termbox.ColorDefault, visualModeLineBg)
+						e.setCell(x+Config.GutterWidth, screenY, ' ', termbox.ColorDefault, visualModeLineBg)
 					}
 				}
 			}
 		} else {
 			fg, bg := GetThemeColor(ColorEmptyLineMarker)
-			termbox.SetCell(0, screenY, '~', fg, bg)
+			e.setCell(0, screenY, '~', fg, bg)
 		}
 	}
 
@@ -4016,27 +5208,49 @@ func (e *Editor) draw() {
 		e.drawHoverPopup()
 	}
 
+	if e.showSignature {
+		e.drawSignaturePopup()
+	}
+
 	if e.showAutocomplete {
 		e.drawAutocompletePopup()
+		e.drawAutocompleteDetailPopup()
+	}
+
+	if e.mode == ModeCommand && e.showCompletion {
+		e.drawCompletionPopup()
 	}
 
 	// Synchronize terminal cursor with editor focus.
 	if e.mode == ModeCommand {
-		termbox.SetCursor(e.commandCursorX+1, h-1)
+		e.setCursor(e.commandCursorX+1, h-1)
 	} else if e.mode == ModeFuzzy {
-		termbox.SetCursor(len(e.fuzzyBuffer)+3, h-1)
+		e.setCursor(len(e.fuzzyBuffer)+3, h-1)
 	} else if e.mode == ModeFind {
-		termbox.SetCursor(len(e.findBuffer)+1, h-1)
+		e.setCursor(len(e.findBuffer)+1, h-1)
 	} else if e.mode == ModeReplace {
-		termbox.SetCursor(len(e.replaceInput)+9, h-1)
+		e.setCursor(len(e.replaceInput)+9, h-1)
+	} else if e.mode == ModeAITransform {
+		e.setCursor(len(e.aiTransformInput)+4, h-1)
 	} else {
-		termbox.SetCursor(visualCursorX-b.scrollX+Config.GutterWidth, b.PrimaryCursor().Y-b.scrollY)
+		e.setCursor(visualCursorX-b.scrollX+Config.GutterWidth, b.PrimaryCursor().Y-b.scrollY)
 	}
 	termbox.Flush()
+	if trueColorActive {
+		e.flushTrueColorOverlay()
+	}
+}
+
+// setCursor moves the terminal cursor and remembers where, so
+// flushTrueColorOverlay can put it back after painting raw ANSI escapes on
+// top of termbox's own Flush.
+func (e *Editor) setCursor(x, y int) {
+	e.lastCursorX, e.lastCursorY = x, y
+	termbox.SetCursor(x, y)
 }
 
 func (e *Editor) drawDebugDiagnostics() {
-	w, h := termbox.Size()
+	w, h := e.termSize()
 	b := e.activeBuffer()
 	if b == nil {
 		return
@@ -4049,7 +5263,7 @@ func (e *Editor) drawDebugDiagnostics() {
 	for y := startY; y < startY+w && y < h-2; y++ {
 		for x := startX; x < w; x++ {
 			fg, bg := GetThemeColor(ColorDebugWindow)
-			termbox.SetCell(x, y, ' ', fg, bg)
+			e.setCell(x, y, ' ', fg, bg)
 		}
 	}
 
@@ -4058,7 +5272,7 @@ func (e *Editor) drawDebugDiagnostics() {
 	titleX := startX + (w-len(title))/2
 	for i, r := range title {
 		fg, bg := GetThemeColor(ColorDebugTitle)
-		termbox.SetCell(titleX+i, startY, r, fg, bg)
+		e.setCell(titleX+i, startY, r, fg, bg)
 	}
 
 	// Prepare content lines
@@ -4120,13 +5334,25 @@ func (e *Editor) drawDebugDiagnostics() {
 				break
 			}
 			fg, bg := GetThemeColor(ColorDebugWindow)
-			termbox.SetCell(x+j, y, r, fg, bg)
+			e.setCell(x+j, y, r, fg, bg)
 		}
 	}
 }
 
 func (e *Editor) drawFuzzyFinder(startY int, fuzzyHeight int) {
-	w, _ := termbox.Size()
+	w, _ := e.termSize()
+
+	listWidth := w
+	if Config.FuzzyPreviewEnabled && (e.fuzzyType == FuzzyModeFile || e.fuzzyType == FuzzyModeBuffer) {
+		previewWidth := w * Config.FuzzyPreviewWidth / 100
+		if previewWidth > w-10 {
+			previewWidth = w - 10
+		}
+		if previewWidth > 0 {
+			listWidth = w - previewWidth
+			e.drawFuzzyPreview(listWidth, startY, previewWidth, fuzzyHeight)
+		}
+	}
 
 	// Draw results
 	for i := 0; i < fuzzyHeight; i++ {
@@ -4138,12 +5364,19 @@ func (e *Editor) drawFuzzyFinder(startY int, fuzzyHeight int) {
 		file := e.fuzzyResults[resultIdx]
 		y := startY + fuzzyHeight - 1 - i
 		fg, bg := GetThemeColor(ColorFuzzyResult)
+		matchFg, _ := GetThemeColor(ColorFuzzyMatch)
 
+		prefixLen := 3 // "   " or " > "
+		if e.fuzzyType == FuzzyModeFile || e.fuzzyType == FuzzyModeBuffer {
+			icon := FileIcon(getFileType(file).Name)
+			file = icon + " " + file
+			prefixLen += len([]rune(icon)) + 1
+		}
 		if resultIdx == e.fuzzyIndex {
 			// Highlight the entire selected line
 			selFg, selBg := GetThemeColor(ColorFuzzySelected)
-			for x := 0; x < w; x++ {
-				termbox.SetCell(x, y, ' ', selFg, selBg)
+			for x := 0; x < listWidth; x++ {
+				e.setCell(x, y, ' ', selFg, selBg)
 			}
 			fg, bg = selFg, selBg
 			file = " > " + file
@@ -4151,12 +5384,33 @@ func (e *Editor) drawFuzzyFinder(startY int, fuzzyHeight int) {
 			file = "   " + file
 		}
 
-		for x, r := range file {
-			if x < w {
-				termbox.SetCell(x, y, r, fg, bg)
+		var spans [][2]int
+		if resultIdx < len(e.fuzzyResultSpans) {
+			spans = e.fuzzyResultSpans[resultIdx]
+		}
+
+		for x, r := range []rune(file) {
+			if x >= listWidth {
+				break
 			}
+			charFg := fg
+			if idx := x - prefixLen; idx >= 0 && inFuzzySpans(spans, idx) {
+				charFg = matchFg
+			}
+			e.setCell(x, y, r, charFg, bg)
+		}
+	}
+}
+
+// inFuzzySpans reports whether rune index idx falls within any of spans
+// (each a [start, end) pair), as returned by matchFuzzyQuery.
+func inFuzzySpans(spans [][2]int, idx int) bool {
+	for _, s := range spans {
+		if idx >= s[0] && idx < s[1] {
+			return true
 		}
 	}
+	return false
 }
 
 func (e *Editor) centerScreen() {
@@ -4164,7 +5418,7 @@ func (e *Editor) centerScreen() {
 	if b == nil {
 		return
 	}
-	_, h := termbox.Size()
+	_, h := e.termSize()
 	visibleHeight := h - 2
 
 	// Calculate target scroll to center current line
@@ -4230,46 +5484,40 @@ func (e *Editor) clearSecondaryCursors() {
 	b.ClearCursors()
 }
 
-func (e *Editor) drawHoverPopup() {
-	if !e.showHover || e.hoverContent == "" {
-		return
-	}
+// drawPopup renders pre-styled lines inside a padded box anchored just
+// above (x, y) — typically the cursor's screen position — clipping against
+// the terminal's edges: it flips to open below the anchor when there isn't
+// room above, and slides horizontally to stay on screen. lines are capped
+// to maxW/maxH so callers don't need their own truncation pass. Shared by
+// the hover and signature help popups, and available to the completion
+// popup (autocompletePopupGeometry) should it want the same clipping.
+func (e *Editor) drawPopup(x, y int, lines [][]StyledCell, maxW, maxH int) {
+	w, h := e.termSize()
 
-	w, _ := termbox.Size()
-	b := e.activeBuffer()
-	if b == nil {
-		return
-	}
-
-	lines := strings.Split(e.hoverContent, "\n")
-	maxWidth := 0
-	for _, line := range lines {
-		if len(line) > maxWidth {
-			maxWidth = len(line)
+	contentWidth := 0
+	for _, row := range lines {
+		if len(row) > contentWidth {
+			contentWidth = len(row)
 		}
 	}
-
-	// Cap width to terminal width
-	if maxWidth > w-10 {
-		maxWidth = w - 10
+	if contentWidth > maxW {
+		contentWidth = maxW
+	}
+	contentHeight := len(lines)
+	if contentHeight > maxH {
+		contentHeight = maxH
 	}
 
-	paddingX := 2
-	paddingY := 1
-	popupWidth := maxWidth + (paddingX * 2)
-	popupHeight := len(lines) + (paddingY * 2)
-
-	// Calculate position (above cursor)
-	visualCursorX := e.bufferToVisual(b.buffer[b.PrimaryCursor().Y], b.PrimaryCursor().X)
-	cursorScreenX := visualCursorX - b.scrollX + Config.GutterWidth
-	cursorScreenY := b.PrimaryCursor().Y - b.scrollY
-
-	startX := cursorScreenX
-	startY := cursorScreenY - popupHeight
+	paddingX, paddingY := 2, 1
+	popupWidth := contentWidth + paddingX*2
+	popupHeight := contentHeight + paddingY*2
 
-	// Adjust if out of bounds
+	startX, startY := x, y-popupHeight
 	if startY < 0 {
-		startY = cursorScreenY + 1
+		startY = y + 1 // No room above the anchor; open below it instead.
+	}
+	if startY+popupHeight > h {
+		startY = h - popupHeight
 	}
 	if startX+popupWidth > w {
 		startX = w - popupWidth
@@ -4279,30 +5527,52 @@ func (e *Editor) drawHoverPopup() {
 	}
 
 	fg, bg := GetThemeColor(ColorHoverWindow)
-	// Draw background and content
-	for y := 0; y < popupHeight; y++ {
-		for x := 0; x < popupWidth; x++ {
-			termbox.SetCell(startX+x, startY+y, ' ', fg, bg)
+	for dy := 0; dy < popupHeight; dy++ {
+		for dx := 0; dx < popupWidth; dx++ {
+			e.setCell(startX+dx, startY+dy, ' ', fg, bg)
 		}
 	}
 
-	// Draw content lines
-	for i, line := range lines {
-		if i >= len(lines) {
+	for i, row := range lines {
+		if i >= contentHeight {
 			break
 		}
-		y := startY + paddingY + i
-		for j, r := range line {
-			if j >= maxWidth {
+		ry := startY + paddingY + i
+		for j, cell := range row {
+			if j >= contentWidth {
 				break
 			}
 			if startX+paddingX+j < w {
-				termbox.SetCell(startX+paddingX+j, y, r, fg, bg)
+				e.setCell(startX+paddingX+j, ry, cell.Ch, cell.Fg, cell.Bg)
 			}
 		}
 	}
 }
 
+func (e *Editor) drawHoverPopup() {
+	if !e.showHover || e.hoverContent == "" {
+		return
+	}
+
+	w, _ := e.termSize()
+	b := e.activeBuffer()
+	if b == nil {
+		return
+	}
+
+	maxWidth := w - 10
+	if maxWidth < 1 {
+		maxWidth = 1
+	}
+	rows := renderMarkdown(e.hoverContent, maxWidth, b)
+
+	visualCursorX := e.bufferToVisual(b.buffer[b.PrimaryCursor().Y], b.PrimaryCursor().X)
+	cursorScreenX := visualCursorX - b.scrollX + Config.GutterWidth
+	cursorScreenY := b.PrimaryCursor().Y - b.scrollY
+
+	e.drawPopup(cursorScreenX, cursorScreenY, rows, maxWidth, len(rows))
+}
+
 // triggerHover initiates an LSP hover request for the current cursor position.
 func (e *Editor) triggerHover() {
 	b := e.activeBuffer()
@@ -4310,13 +5580,13 @@ func (e *Editor) triggerHover() {
 		return
 	}
 
-	e.message = "Requesting signature..."
+	e.setMessage("Requesting signature...")
 	e.draw()
 
 	cursor := b.PrimaryCursor()
-	content, err := b.lspClient.Hover(cursor.Y, cursor.X)
+	content, err := b.lspClient.Hover(context.Background(), cursor.Y, cursor.X)
 	if err != nil {
-		e.message = fmt.Sprintf("LSP Hover error: %v", err)
+		e.setMessage(fmt.Sprintf("LSP Hover error: %v", err))
 		return
 	}
 
@@ -4324,48 +5594,220 @@ func (e *Editor) triggerHover() {
 	e.showHover = true
 }
 
-// triggerAutocomplete initiates an LSP completion request for the current cursor position.
-func (e *Editor) triggerAutocomplete() {
+// triggerSignatureHelp requests the signature of the call enclosing the
+// cursor and shows the popup, re-querying ActiveParameter each time it's
+// called so the highlighted parameter tracks what the user is typing.
+func (e *Editor) triggerSignatureHelp() {
 	b := e.activeBuffer()
 	if b == nil || b.lspClient == nil {
 		return
 	}
 
-	e.message = "Requesting completions..."
-	e.draw()
-
 	cursor := b.PrimaryCursor()
-	items, err := b.lspClient.Completion(cursor.Y, cursor.X)
-	if err != nil {
-		e.message = fmt.Sprintf("LSP Completion error: %v", err)
+	info, err := b.lspClient.SignatureHelp(cursor.Y, cursor.X)
+	if err != nil || info.Label == "" {
+		e.showSignature = false
 		return
 	}
 
-	if len(items) == 0 {
-		e.message = "No completions available"
+	e.signatureContent = info
+	e.showSignature = true
+}
+
+// cycleSignatureOverload moves the signature popup to another candidate in
+// the active call's Overloads (wrapping around), for when the server's own
+// ActiveSignature guess picked the wrong one of several overloaded
+// signatures. A no-op if the server only returned one signature.
+func (e *Editor) cycleSignatureOverload(delta int) {
+	overloads := e.signatureContent.Overloads
+	if len(overloads) < 2 {
 		return
 	}
 
-	e.autocompleteItems = items
-	e.autocompleteIndex = 0
-	e.autocompleteScroll = 0
-	e.showAutocomplete = true
-	e.message = ""
+	next := (e.signatureContent.ActiveSignature + delta) % len(overloads)
+	if next < 0 {
+		next += len(overloads)
+	}
+
+	info := overloads[next]
+	info.Overloads = overloads
+	info.ActiveSignature = next
+	e.signatureContent = info
 }
 
-func (e *Editor) drawAutocompletePopup() {
-	if !e.showAutocomplete || len(e.autocompleteItems) == 0 {
+// drawSignaturePopup renders the active call's signature above or below the
+// cursor, bolding the range of the parameter the cursor currently sits on.
+func (e *Editor) drawSignaturePopup() {
+	if !e.showSignature || e.signatureContent.Label == "" {
 		return
 	}
 
-	w, h := termbox.Size()
+	w, _ := e.termSize()
 	b := e.activeBuffer()
 	if b == nil {
 		return
 	}
 
-	// Calculate max label width for alignment
-	maxLabelWidth := 0
+	label := e.signatureContent.Label
+	maxWidth := len(label)
+	if maxWidth > w-10 {
+		maxWidth = w - 10
+	}
+
+	fg, bg := GetThemeColor(ColorHoverWindow)
+	activeFg := fg | termbox.AttrBold
+
+	var activeStart, activeEnd int
+	if params := e.signatureContent.Parameters; e.signatureContent.ActiveParameter >= 0 && e.signatureContent.ActiveParameter < len(params) {
+		p := params[e.signatureContent.ActiveParameter]
+		activeStart, activeEnd = p.Start, p.End
+	}
+
+	row := make([]StyledCell, 0, len(label))
+	for j, r := range label {
+		cellFg := fg
+		if j >= activeStart && j < activeEnd {
+			cellFg = activeFg
+		}
+		row = append(row, StyledCell{Ch: r, Fg: cellFg, Bg: bg})
+	}
+
+	visualCursorX := e.bufferToVisual(b.buffer[b.PrimaryCursor().Y], b.PrimaryCursor().X)
+	cursorScreenX := visualCursorX - b.scrollX + Config.GutterWidth
+	cursorScreenY := b.PrimaryCursor().Y - b.scrollY
+
+	e.drawPopup(cursorScreenX, cursorScreenY, [][]StyledCell{row}, maxWidth, 1)
+}
+
+// completionDebounce is how long triggerAutocomplete waits after the most
+// recent keystroke before actually issuing an LSP request, so a fast typist
+// fires one completion request per pause instead of one per character.
+const completionDebounce = 150 * time.Millisecond
+
+// triggerAutocomplete debounces an LSP completion request for the current
+// cursor position: it (re)arms completionTimer, and the request itself runs
+// in a background goroutine once the timer fires, writing results back and
+// waking the render loop with termbox.Interrupt() the same way shell.go and
+// fuzzysearch.go report background state. Every call bumps completionGen, so
+// a result that arrives after the cursor has moved on (a newer call already
+// bumped the generation again) is recognized as stale and dropped instead of
+// clobbering whatever the user is looking at now; it also cancels
+// completionCancel first (mirroring invalidateGhostText), so a request
+// already in flight on the server gets a $/cancelRequest instead of just
+// having its answer ignored once it eventually arrives.
+func (e *Editor) triggerAutocomplete() {
+	b := e.activeBuffer()
+	if b == nil || b.lspClient == nil {
+		return
+	}
+
+	e.completionGen++
+	gen := e.completionGen
+	lspClient := b.lspClient
+	cursor := b.PrimaryCursor()
+	line, character := cursor.Y, cursor.X
+
+	if e.completionTimer != nil {
+		e.completionTimer.Stop()
+	}
+	if e.completionCancel != nil {
+		e.completionCancel()
+		e.completionCancel = nil
+	}
+	e.completionTimer = time.AfterFunc(completionDebounce, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		e.completionCancel = cancel
+
+		items, err := lspClient.Completion(ctx, line, character)
+		if gen != e.completionGen {
+			return // Superseded by a later keystroke or cursor move.
+		}
+		if err != nil {
+			e.setMessage(fmt.Sprintf("LSP Completion error: %v", err))
+			termbox.Interrupt()
+			return
+		}
+		if len(items) == 0 {
+			e.setMessage("No completions available")
+			termbox.Interrupt()
+			return
+		}
+
+		e.autocompleteItems = items
+		e.autocompleteIndex = 0
+		e.autocompleteScroll = 0
+		e.showAutocomplete = true
+		e.message = ""
+		e.resolveSelectedCompletion()
+		termbox.Interrupt()
+	})
+}
+
+// moveAutocompleteSelection moves the autocomplete selection by delta
+// (+1/-1), wrapping around, and scrolls the popup to keep it visible.
+func (e *Editor) moveAutocompleteSelection(delta int) {
+	n := len(e.autocompleteItems)
+	if n == 0 {
+		return
+	}
+	e.autocompleteIndex = (e.autocompleteIndex + delta + n) % n
+	if e.autocompleteIndex < e.autocompleteScroll {
+		e.autocompleteScroll = e.autocompleteIndex
+	}
+	if e.autocompleteIndex >= e.autocompleteScroll+10 {
+		e.autocompleteScroll = e.autocompleteIndex - 9
+	}
+	e.resolveSelectedCompletion()
+}
+
+// resolveSelectedCompletion debounces a completionItem/resolve call for the
+// highlighted autocompleteItems entry when it looks unresolved (no
+// Documentation/Detail yet, but a Data payload the server can resolve
+// against — see CompletionItem.Data). Results are written back into
+// autocompleteItems by index, which is safe as long as the popup is still
+// open on the same list; a stale reply for a list the user has since closed
+// or replaced just gets dropped via the same completionGen check
+// triggerAutocomplete uses.
+func (e *Editor) resolveSelectedCompletion() {
+	b := e.activeBuffer()
+	if b == nil || b.lspClient == nil {
+		return
+	}
+	idx := e.autocompleteIndex
+	if idx < 0 || idx >= len(e.autocompleteItems) {
+		return
+	}
+	item := e.autocompleteItems[idx]
+	if len(item.Data) == 0 || item.Documentation != "" || item.Detail != "" {
+		return
+	}
+
+	lspClient := b.lspClient
+	gen := e.completionGen
+
+	if e.resolveTimer != nil {
+		e.resolveTimer.Stop()
+	}
+	e.resolveTimer = time.AfterFunc(completionDebounce, func() {
+		resolved, err := lspClient.ResolveCompletionItem(item)
+		if err != nil || gen != e.completionGen {
+			return
+		}
+		if idx >= len(e.autocompleteItems) || e.autocompleteItems[idx].Label != item.Label {
+			return // The list has since been replaced.
+		}
+		e.autocompleteItems[idx] = resolved
+		termbox.Interrupt()
+	})
+}
+
+// autocompletePopupGeometry computes the completion list's on-screen
+// position and size, shared by drawAutocompletePopup and
+// drawAutocompleteDetailPopup so the detail pane can anchor off the list
+// without recomputing it differently.
+func (e *Editor) autocompletePopupGeometry(b *Buffer) (startX, startY, popupWidth, popupHeight, maxLabelWidth int) {
+	w, h := e.termSize()
+
 	for _, item := range e.autocompleteItems {
 		if len(item.Label) > maxLabelWidth {
 			maxLabelWidth = len(item.Label)
@@ -4391,8 +5833,8 @@ func (e *Editor) drawAutocompletePopup() {
 		maxWidth = w - 10
 	}
 
-	popupWidth := maxWidth + 2
-	popupHeight := len(e.autocompleteItems)
+	popupWidth = maxWidth + 2
+	popupHeight = len(e.autocompleteItems)
 	if popupHeight > 10 {
 		popupHeight = 10
 	}
@@ -4402,8 +5844,8 @@ func (e *Editor) drawAutocompletePopup() {
 	cursorScreenX := visualCursorX - b.scrollX + Config.GutterWidth
 	cursorScreenY := b.PrimaryCursor().Y - b.scrollY
 
-	startX := cursorScreenX
-	startY := cursorScreenY + 1
+	startX = cursorScreenX
+	startY = cursorScreenY + 1
 
 	// Adjust if out of bounds
 	if startY+popupHeight > h-1 {
@@ -4416,6 +5858,22 @@ func (e *Editor) drawAutocompletePopup() {
 		startX = 0
 	}
 
+	return startX, startY, popupWidth, popupHeight, maxLabelWidth
+}
+
+func (e *Editor) drawAutocompletePopup() {
+	if !e.showAutocomplete || len(e.autocompleteItems) == 0 {
+		return
+	}
+
+	b := e.activeBuffer()
+	if b == nil {
+		return
+	}
+
+	startX, startY, popupWidth, popupHeight, maxLabelWidth := e.autocompletePopupGeometry(b)
+	maxWidth := popupWidth - 2
+
 	fg, bg := GetThemeColor(ColorAutocompleteWindow)
 	selFg, selBg := GetThemeColor(ColorAutocompleteSelected)
 
@@ -4434,7 +5892,7 @@ func (e *Editor) drawAutocompletePopup() {
 
 		// Fill line
 		for x := 0; x < popupWidth; x++ {
-			termbox.SetCell(startX+x, startY+y, ' ', currentFg, currentBg)
+			e.setCell(startX+x, startY+y, ' ', currentFg, currentBg)
 		}
 
 		// Draw label and detail (signature) with alignment
@@ -4448,64 +5906,236 @@ func (e *Editor) drawAutocompletePopup() {
 			displayText = displayText[:maxWidth-3] + "..."
 		}
 		for j, r := range displayText {
-			termbox.SetCell(startX+1+j, startY+y, r, currentFg, currentBg)
+			e.setCell(startX+1+j, startY+y, r, currentFg, currentBg)
 		}
 	}
 }
 
-func (e *Editor) insertCompletion(item CompletionItem) {
+// drawAutocompleteDetailPopup renders the selected completion item's
+// Documentation as Markdown in a pane beside the completion list (to its
+// right, or below it when there isn't room), matching the detail gopls/
+// pyright attach to each item.
+func (e *Editor) drawAutocompleteDetailPopup() {
+	if !e.showAutocomplete || len(e.autocompleteItems) == 0 {
+		return
+	}
+	if e.autocompleteIndex < 0 || e.autocompleteIndex >= len(e.autocompleteItems) {
+		return
+	}
+	item := e.autocompleteItems[e.autocompleteIndex]
+	if item.Documentation == "" {
+		return
+	}
+
 	b := e.activeBuffer()
 	if b == nil {
 		return
 	}
 
-	cursor := b.PrimaryCursor()
-	line := b.buffer[cursor.Y]
+	w, h := e.termSize()
+	listX, listY, listWidth, listHeight, _ := e.autocompletePopupGeometry(b)
 
-	// Find the start of the word we're completing
-	start := cursor.X
-	for start > 0 {
-		r := line[start-1]
-		if !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_') {
+	maxWidth := w - 10
+	if maxWidth < 1 {
+		maxWidth = 1
+	}
+	if rem := w - (listX + listWidth) - 4; rem > 0 && rem < maxWidth {
+		maxWidth = rem
+	}
+	rows := renderMarkdown(item.Documentation, maxWidth, b)
+
+	contentWidth := 0
+	for _, row := range rows {
+		if len(row) > contentWidth {
+			contentWidth = len(row)
+		}
+	}
+
+	paddingX := 1
+	popupWidth := contentWidth + (paddingX * 2)
+	popupHeight := len(rows)
+	if popupHeight > listHeight {
+		popupHeight = listHeight
+	}
+
+	startX := listX + listWidth + 1
+	startY := listY
+	if startX+popupWidth > w {
+		// No room to the right; stack it under the completion list instead.
+		startX = listX
+		startY = listY + listHeight
+	}
+	if startY+popupHeight > h-1 {
+		startY = h - 1 - popupHeight
+	}
+	if startY < 0 {
+		startY = 0
+	}
+
+	fg, bg := GetThemeColor(ColorHoverWindow)
+	for y := 0; y < popupHeight; y++ {
+		for x := 0; x < popupWidth; x++ {
+			e.setCell(startX+x, startY+y, ' ', fg, bg)
+		}
+	}
+	for i, row := range rows {
+		if i >= popupHeight {
 			break
 		}
-		start--
+		y := startY + i
+		for j, cell := range row {
+			if startX+paddingX+j < w {
+				e.setCell(startX+paddingX+j, y, cell.Ch, cell.Fg, cell.Bg)
+			}
+		}
 	}
+}
 
-	// Text to insert
-	insertText := item.InsertText
-	if insertText == "" {
-		insertText = item.Label
+// applyLSPTextEdit replaces the text in edit.Range with edit.NewText and
+// returns the (y, x) position right after the inserted text, so callers
+// applying several edits in sequence (see insertCompletion) can place the
+// cursor without recomputing it from scratch.
+func (e *Editor) applyLSPTextEdit(b *Buffer, edit TextEdit) (endY, endX int) {
+	startY, startX := edit.Range.Start.Line, edit.Range.Start.Character
+	endLine, endCol := edit.Range.End.Line, edit.Range.End.Character
+	if startY < 0 || startY >= len(b.buffer) || endLine < 0 || endLine >= len(b.buffer) {
+		return startY, startX
 	}
 
-	// Check if this is a function/method (Kind 2=Method, 3=Function)
-	// or if the Detail contains "func" indicating it's a function
-	isFunction := item.Kind == 2 || item.Kind == 3 || strings.Contains(item.Detail, "func")
+	first := b.buffer[startY]
+	if startX > len(first) {
+		startX = len(first)
+	}
+	last := b.buffer[endLine]
+	if endCol > len(last) {
+		endCol = len(last)
+	}
 
-	// Replace the prefix with the completion
-	newRuneLine := make([]rune, start)
-	copy(newRuneLine, line[:start])
-	newRuneLine = append(newRuneLine, []rune(insertText)...)
+	before := append([]rune{}, first[:startX]...)
+	after := append([]rune{}, last[endCol:]...)
+	newLines := splitRuneLines([]rune(edit.NewText))
 
-	// Add () for functions if not already present
-	cursorOffset := len(insertText)
-	if isFunction {
-		// Check if next character is already (
-		nextIdx := cursor.X
-		if nextIdx >= len(line) || line[nextIdx] != '(' {
-			newRuneLine = append(newRuneLine, '(', ')')
-			cursorOffset++ // Position cursor inside the parentheses
+	var replaced [][]rune
+	if len(newLines) == 1 {
+		merged := append(before, newLines[0]...)
+		merged = append(merged, after...)
+		replaced = [][]rune{merged}
+		endY, endX = startY, startX+len(newLines[0])
+	} else {
+		replaced = make([][]rune, len(newLines))
+		replaced[0] = append(before, newLines[0]...)
+		for i := 1; i < len(newLines)-1; i++ {
+			replaced[i] = append([]rune{}, newLines[i]...)
 		}
+		last := append(append([]rune{}, newLines[len(newLines)-1]...), after...)
+		replaced[len(replaced)-1] = last
+		endY, endX = startY+len(newLines)-1, len(newLines[len(newLines)-1])
 	}
 
-	newRuneLine = append(newRuneLine, line[cursor.X:]...)
+	b.buffer = append(b.buffer[:startY], append(replaced, b.buffer[endLine+1:]...)...)
+	return endY, endX
+}
 
-	b.buffer[cursor.Y] = newRuneLine
-	cursor.X = start + cursorOffset
+// insertCompletion applies item at the cursor: its own TextEdit (or, if the
+// server didn't send one, a plain replacement of the word being completed
+// with InsertText/Label) plus any AdditionalTextEdits (e.g. the import line
+// gopls/pyright attach), so accepting a completion adds imports correctly
+// instead of just splicing text in at the cursor. AdditionalTextEdits are
+// applied first and furthest-down-the-buffer first, so earlier line
+// numbers in the main edit stay valid while it's applied.
+func (e *Editor) insertCompletion(item CompletionItem) {
+	b := e.activeBuffer()
+	if b == nil {
+		return
+	}
+	cursor := b.PrimaryCursor()
+
+	// Accepting a completion is its own undo step, distinct from the
+	// insert-mode coalescing used for ordinary typing: it can touch lines
+	// far from the cursor (AdditionalTextEdits, e.g. an auto-import), so it
+	// must not get silently folded into whatever group is already open, nor
+	// left with no undo entry of its own. Closing the group here also means
+	// the next typed character opens a fresh one instead of reusing this
+	// edit's entry.
+	e.saveState()
+	e.insertGroupOpen = false
+
+	edit := item.TextEdit
+	if edit == nil {
+		line := b.buffer[cursor.Y]
+		start := cursor.X
+		for start > 0 && e.isWordChar(line[start-1]) {
+			start--
+		}
+		insertText := item.InsertText
+		if insertText == "" {
+			insertText = item.Label
+		}
+		edit = &TextEdit{
+			Range:   Range{Start: Position{Line: cursor.Y, Character: start}, End: Position{Line: cursor.Y, Character: cursor.X}},
+			NewText: insertText,
+		}
+	}
+
+	additional := append([]TextEdit{}, item.AdditionalTextEdits...)
+	sort.Slice(additional, func(i, j int) bool {
+		if additional[i].Range.Start.Line != additional[j].Range.Start.Line {
+			return additional[i].Range.Start.Line > additional[j].Range.Start.Line
+		}
+		return additional[i].Range.Start.Character > additional[j].Range.Start.Character
+	})
+	for _, a := range additional {
+		if a.Range.Start.Line < edit.Range.Start.Line {
+			continue // Applied below the main edit; doesn't shift its line numbers.
+		}
+		e.applyLSPTextEdit(b, a)
+	}
+
+	// Snippet-format items carry $1/${1:default}/$0 markers in NewText;
+	// render them to plain text now and remember where the tabstops landed
+	// so the caller can navigate them once the edit below is applied.
+	isSnippet := item.InsertTextFormat == insertTextFormatSnippet
+	var snippetStops []snippetTabstop
+	startY, startX := edit.Range.Start.Line, edit.Range.Start.Character
+	if isSnippet {
+		rendered, stops := parseSnippet(edit.NewText)
+		edit.NewText = rendered
+		snippetStops = stops
+	}
+
+	endY, endX := e.applyLSPTextEdit(b, *edit)
+	cursor.Y, cursor.X = endY, endX
+	cursor.PreferredCol = endX
+
+	appendedParens := false
+	if isSnippet {
+		if len(snippetStops) > 0 {
+			e.beginSnippet(b, startY, startX, []rune(edit.NewText), snippetStops)
+		}
+	} else {
+		isFunction := item.Kind == 2 || item.Kind == 3 || strings.Contains(item.Detail, "func")
+		if isFunction {
+			line := b.buffer[cursor.Y]
+			if cursor.X >= len(line) || line[cursor.X] != '(' {
+				newLine := append(append(append([]rune{}, line[:cursor.X]...), '(', ')'), line[cursor.X:]...)
+				b.buffer[cursor.Y] = newLine
+				cursor.X++
+				cursor.PreferredCol = cursor.X
+				appendedParens = true
+			}
+		}
+	}
 
-	// Handle syntax update
 	if b.syntax != nil {
-		b.syntax.Reparse([]byte(b.toString()))
+		b.syntaxReparse()
+	}
+	if b.lspClient != nil {
+		b.lspClient.SendDidChange(b.toString())
+	}
+	if appendedParens {
+		// Re-trigger now that the server has the appended "()", so the
+		// user immediately sees argument hints for what they just accepted.
+		e.triggerSignatureHelp()
 	}
 
 	e.markModified()
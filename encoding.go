@@ -0,0 +1,137 @@
+package main
+
+// Pluggable file encoding detection and line-ending handling for
+// LoadFromReader/SaveFile. BOM sniffing and named-encoding lookup are
+// delegated to golang.org/x/text so the buffer model can round-trip
+// non-UTF8 files (Shift-JIS, GBK, Latin-1, UTF-16 with BOM) the same way
+// micro's buffer layer does.
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// LineEnding is the newline convention a buffer was loaded with (and will
+// be saved with, unless overridden via :set ff=).
+type LineEnding string
+
+const (
+	LineEndingLF   LineEnding = "LF"   // unix
+	LineEndingCRLF LineEnding = "CRLF" // dos
+	LineEndingCR   LineEnding = "CR"   // mac (classic, pre-OSX)
+)
+
+// bytes returns the literal newline sequence this LineEnding represents.
+// An empty/unrecognized value defaults to LF.
+func (le LineEnding) bytes() string {
+	switch le {
+	case LineEndingCRLF:
+		return "\r\n"
+	case LineEndingCR:
+		return "\r"
+	default:
+		return "\n"
+	}
+}
+
+// lineEndingFromFileFormat maps vim-style :set ff= values to a LineEnding.
+func lineEndingFromFileFormat(ff string) (LineEnding, error) {
+	switch strings.ToLower(strings.TrimSpace(ff)) {
+	case "unix":
+		return LineEndingLF, nil
+	case "dos":
+		return LineEndingCRLF, nil
+	case "mac":
+		return LineEndingCR, nil
+	default:
+		return "", fmt.Errorf("unknown fileformat %q (want unix, dos, or mac)", ff)
+	}
+}
+
+// detectLineEnding inspects raw bytes for the first newline sequence found.
+// Defaults to LF if the file has none (e.g. empty or a single line).
+func detectLineEnding(data []byte) LineEnding {
+	for i, b := range data {
+		if b == '\r' {
+			if i+1 < len(data) && data[i+1] == '\n' {
+				return LineEndingCRLF
+			}
+			return LineEndingCR
+		}
+		if b == '\n' {
+			return LineEndingLF
+		}
+	}
+	return LineEndingLF
+}
+
+// detectEncoding sniffs a UTF-8/UTF-16 byte-order mark at the start of data,
+// falling back to the configured default encoding, and finally to UTF-8.
+// The returned name is the canonical encoding name remembered on the buffer.
+func detectEncoding(data []byte, configured string) (enc encoding.Encoding, name string) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return unicode.UTF8, "utf-8"
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM), "utf-16le"
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM), "utf-16be"
+	}
+
+	if configured != "" {
+		if e, canonical, err := lookupEncoding(configured); err == nil {
+			return e, canonical
+		}
+	}
+
+	return unicode.UTF8, "utf-8"
+}
+
+// lookupEncoding resolves a user/config-supplied encoding name (e.g. from
+// :set fenc=) to its encoding.Encoding and canonical name.
+func lookupEncoding(name string) (enc encoding.Encoding, canonical string, err error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch name {
+	case "", "utf-8", "utf8":
+		return unicode.UTF8, "utf-8", nil
+	case "utf-16le":
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM), "utf-16le", nil
+	case "utf-16be":
+		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM), "utf-16be", nil
+	}
+
+	e, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, "", fmt.Errorf("unknown encoding %q: %w", name, err)
+	}
+	canonical, err = htmlindex.Name(e)
+	if err != nil || canonical == "" {
+		canonical = name
+	}
+	return e, canonical, nil
+}
+
+// decodeBytes transcodes raw file bytes into a UTF-8 string using enc,
+// stripping a leading UTF-8 BOM (the UTF-8 decoder itself is a pass-through
+// and won't remove one).
+func decodeBytes(data []byte, enc encoding.Encoding, name string) (string, error) {
+	if name == "utf-8" {
+		data = bytes.TrimPrefix(data, []byte{0xEF, 0xBB, 0xBF})
+	}
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", err
+	}
+	return string(decoded), nil
+}
+
+// encodeString transcodes a UTF-8 string into raw bytes using enc, for
+// writing back to disk in the buffer's original (or overridden) encoding.
+func encodeString(s string, enc encoding.Encoding) ([]byte, error) {
+	return enc.NewEncoder().Bytes([]byte(s))
+}
@@ -0,0 +1,126 @@
+package main
+
+// Editing the active buffer in $EDITOR: write it to a tempfile, suspend
+// termbox, run the editor synchronously against that tempfile (inheriting
+// the real terminal), then read the result back into the buffer. Unlike
+// :r!/:!  (shell.go), which stream a subprocess's output asynchronously
+// alongside the UI, this hands the terminal over entirely for the
+// subprocess's own duration, the same way quit (command.go) closes termbox
+// before handing control back to the shell.
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+// externalEditorCommand resolves which editor :edit!/Ctrl+X Ctrl+E should
+// run: the configured Config.ExternalEditor, then $EDITOR, then "vi".
+func externalEditorCommand() string {
+	if Config.ExternalEditor != "" {
+		return Config.ExternalEditor
+	}
+	if env := os.Getenv("EDITOR"); env != "" {
+		return env
+	}
+	return "vi"
+}
+
+// OpenInExternalEditor writes the active buffer to a tempfile, suspends
+// termbox, and runs $EDITOR (or Config.ExternalEditor) on it synchronously.
+// On a clean exit it replaces the buffer with the tempfile's contents,
+// preserving cursor position and undo history (one saveState is pushed
+// before the replacement, so the edit can be undone as a single change).
+func (e *Editor) OpenInExternalEditor() {
+	b := e.activeBuffer()
+	if b == nil {
+		return
+	}
+	if b.readOnly {
+		e.setMessage("File is read-only")
+		return
+	}
+
+	tmp, err := os.CreateTemp("", "qwe-edit-*.txt")
+	if err != nil {
+		e.setMessage(fmt.Sprintf("Error creating tempfile: %v", err))
+		return
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	_, err = tmp.WriteString(b.toString())
+	tmp.Close()
+	if err != nil {
+		e.setMessage(fmt.Sprintf("Error writing tempfile: %v", err))
+		return
+	}
+
+	editor := externalEditorCommand()
+	cmd := exec.Command(editor, tmpPath)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	termbox.Close()
+	runErr := cmd.Run()
+	if initErr := termbox.Init(); initErr != nil {
+		fmt.Fprintf(os.Stderr, "failed to reinit termbox: %v\n", initErr)
+		os.Exit(1)
+	}
+	termbox.SetInputMode(termbox.InputEsc | termbox.InputMouse)
+	termbox.SetOutputMode(termbox.Output256)
+
+	if runErr != nil {
+		e.setMessage(fmt.Sprintf("%s exited with error: %v", editor, runErr))
+		return
+	}
+
+	edited, err := os.ReadFile(tmpPath)
+	if err != nil {
+		e.setMessage(fmt.Sprintf("Error reading tempfile: %v", err))
+		return
+	}
+
+	ft := getFileType(b.filename)
+	lines := strings.Split(strings.TrimSuffix(string(edited), "\n"), "\n")
+	newBuffer := make([][]rune, len(lines))
+	for i, l := range lines {
+		if !ft.UseTabs {
+			l = strings.ReplaceAll(l, "\t", strings.Repeat(" ", ft.TabWidth))
+		}
+		newBuffer[i] = []rune(l)
+	}
+	if len(newBuffer) == 0 {
+		newBuffer = [][]rune{{}}
+	}
+
+	e.saveState()
+	b.buffer = newBuffer
+
+	for i := range b.cursors {
+		c := &b.cursors[i]
+		if c.Y >= len(b.buffer) {
+			c.Y = len(b.buffer) - 1
+		}
+		if c.Y < 0 {
+			c.Y = 0
+		}
+		if c.X > len(b.buffer[c.Y]) {
+			c.X = len(b.buffer[c.Y])
+		}
+	}
+
+	if b.syntax != nil {
+		b.syntaxReparse()
+	}
+	if b.lspClient != nil {
+		b.lspClient.SendDidChange(b.toString())
+	}
+
+	e.markModified()
+	e.setMessage(fmt.Sprintf("Buffer replaced from %s", editor))
+}
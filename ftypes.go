@@ -7,15 +7,20 @@ import "path/filepath"
 
 // FileType represents the configuration for a specific programming language.
 type FileType struct {
-	Name             string   // Display name of the file type.
-	Extensions       []string // File extensions (e.g., .go, .py) or filenames (e.g., Makefile).
-	UseTabs          bool     // Whether to use tabs for indentation.
-	Comment          string   // Single-line comment prefix (e.g., // or #).
-	TabWidth         int      // Number of spaces for a tab.
-	EnableLSP        bool     // Whether to enable Language Server Protocol support.
-	LSPCommand       string   // Executable name of the LSP server.
-	LSPCommandArgs   []string // Arguments to pass to the LSP server.
-	FormatterCommand string   // External command for formatting the file.
+	Name             string                 // Display name of the file type.
+	Extensions       []string               // File extensions (e.g., .go, .py) or filenames (e.g., Makefile).
+	UseTabs          bool                   // Whether to use tabs for indentation.
+	Comment          string                 // Single-line comment prefix (e.g., // or #). Empty if the language has no line-comment form.
+	CommentStart     string                 // Block-comment opening marker (e.g. /* or <!--), used alone by languages with no line comments.
+	CommentEnd       string                 // Block-comment closing marker, paired with CommentStart.
+	BracePairs       []BracePair            // Delimiters FindMatchingBrace matches; nil means defaultBracePairs ( ) [ ] { }.
+	TabWidth         int                    // Number of spaces for a tab.
+	EnableLSP        bool                   // Whether to enable Language Server Protocol support.
+	LSPCommand       string                 // Executable name of the LSP server.
+	LSPCommandArgs   []string               // Arguments to pass to the LSP server.
+	LSPRootPatterns  []string               // Filenames that mark this file type's project root.
+	LSPInitOptions   map[string]interface{} // Sent as initialize's initializationOptions; server-specific (see Config.LSPServers).
+	FormatterCommand string                 // External command for formatting the file.
 }
 
 // fileTypes is a global list of all supported languages in the editor.
@@ -30,22 +35,26 @@ var fileTypes = []*FileType{
 		LSPCommand: "gopls",
 	},
 	{
-		Name:       "C",
-		Extensions: []string{".c", ".h"},
-		UseTabs:    true,
-		Comment:    "//",
-		TabWidth:   Config.DefaultTabWidth,
-		EnableLSP:  true,
-		LSPCommand: "clangd",
+		Name:         "C",
+		Extensions:   []string{".c", ".h"},
+		UseTabs:      true,
+		Comment:      "//",
+		CommentStart: "/*",
+		CommentEnd:   "*/",
+		TabWidth:     Config.DefaultTabWidth,
+		EnableLSP:    true,
+		LSPCommand:   "clangd",
 	},
 	{
-		Name:       "C++",
-		Extensions: []string{".cpp", ".hpp", ".cc", ".hh", ".cxx", ".hxx"},
-		UseTabs:    true,
-		Comment:    "//",
-		TabWidth:   Config.DefaultTabWidth,
-		EnableLSP:  true,
-		LSPCommand: "clangd",
+		Name:         "C++",
+		Extensions:   []string{".cpp", ".hpp", ".cc", ".hh", ".cxx", ".hxx"},
+		UseTabs:      true,
+		Comment:      "//",
+		CommentStart: "/*",
+		CommentEnd:   "*/",
+		TabWidth:     Config.DefaultTabWidth,
+		EnableLSP:    true,
+		LSPCommand:   "clangd",
 	},
 	{
 		Name:           "JavaScript",
@@ -94,11 +103,12 @@ var fileTypes = []*FileType{
 		TabWidth:   Config.DefaultTabWidth,
 	},
 	{
-		Name:       "CSS",
-		Extensions: []string{".css"},
-		UseTabs:    false,
-		Comment:    "//",
-		TabWidth:   Config.DefaultTabWidth,
+		Name:         "CSS",
+		Extensions:   []string{".css"},
+		UseTabs:      false,
+		CommentStart: "/*",
+		CommentEnd:   "*/",
+		TabWidth:     Config.DefaultTabWidth,
 	},
 	{
 		Name:       "Dockerfile",
@@ -108,18 +118,21 @@ var fileTypes = []*FileType{
 		TabWidth:   Config.DefaultTabWidth,
 	},
 	{
-		Name:       "HTML",
-		Extensions: []string{".html", ".htm"},
-		UseTabs:    false,
-		Comment:    "",
-		TabWidth:   Config.DefaultTabWidth,
+		Name:         "HTML",
+		Extensions:   []string{".html", ".htm"},
+		UseTabs:      false,
+		CommentStart: "<!--",
+		CommentEnd:   "-->",
+		TabWidth:     Config.DefaultTabWidth,
 	},
 	{
-		Name:       "Lua",
-		Extensions: []string{".lua"},
-		UseTabs:    true,
-		Comment:    "--",
-		TabWidth:   Config.DefaultTabWidth,
+		Name:         "Lua",
+		Extensions:   []string{".lua"},
+		UseTabs:      true,
+		Comment:      "--",
+		CommentStart: "--[[",
+		CommentEnd:   "]]",
+		TabWidth:     Config.DefaultTabWidth,
 	},
 	{
 		Name:       "Markdown",
@@ -179,4 +192,25 @@ func InitFileTypes() {
 	for _, ft := range fileTypes {
 		ft.TabWidth = Config.DefaultTabWidth
 	}
+	applyLSPServerConfig()
+}
+
+// applyLSPServerConfig overlays Config.LSPServers onto the matching file
+// types, so a server entry customized in config.go (or eventually a user
+// config file) replaces the hard-coded gopls/clangd/etc. defaults above.
+func applyLSPServerConfig() {
+	for _, entry := range Config.LSPServers {
+		for _, name := range entry.FileTypes {
+			for _, ft := range fileTypes {
+				if ft.Name != name {
+					continue
+				}
+				ft.EnableLSP = true
+				ft.LSPCommand = entry.Command
+				ft.LSPCommandArgs = entry.Args
+				ft.LSPRootPatterns = entry.RootPatterns
+				ft.LSPInitOptions = entry.InitOptions
+			}
+		}
+	}
 }
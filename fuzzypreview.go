@@ -0,0 +1,113 @@
+package main
+
+// Preview pane for the fuzzy finder (see drawFuzzyFinder in editor.go). Shows
+// the contents of the currently selected file/buffer candidate to the right
+// of the results list, syntax-highlighted the same way an open buffer would
+// be. Previews are cached per filename with mtime invalidation so arrowing
+// quickly through results doesn't re-read/re-parse the same file on every
+// move, and only the first fuzzyPreviewMaxBytes of large files are read.
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nsf/termbox-go"
+)
+
+// fuzzyPreviewMaxBytes bounds how much of a candidate file is read for
+// preview purposes; files larger than this are read and highlighted only up
+// to this point, with the cut marked via truncated.
+const fuzzyPreviewMaxBytes = 64 * 1024
+
+// fuzzyPreviewEntry is one cached, rendered preview.
+type fuzzyPreviewEntry struct {
+	lines     []string
+	syntax    *SyntaxHighlighter
+	modTime   time.Time
+	truncated bool
+}
+
+// getFuzzyPreview returns the cached preview for path, reading and
+// re-highlighting it only if the file is new to the cache or has changed on
+// disk since it was cached. Returns nil if path can't be read (directory,
+// missing file, "[No Name]"/"[Log]" placeholders, etc.).
+func (e *Editor) getFuzzyPreview(path string) *fuzzyPreviewEntry {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return nil
+	}
+
+	if cached, ok := e.fuzzyPreviewCache[path]; ok && cached.modTime.Equal(info.ModTime()) {
+		return cached
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	buf := make([]byte, fuzzyPreviewMaxBytes)
+	n, _ := f.Read(buf)
+	content := buf[:n]
+	truncated := n == fuzzyPreviewMaxBytes
+
+	ft := getFileType(path)
+	var syntax *SyntaxHighlighter
+	if ft != nil {
+		syntax = NewSyntaxHighlighter(ft.Name, nil)
+		if syntax != nil {
+			syntax.Parse(content)
+		}
+	}
+
+	entry := &fuzzyPreviewEntry{
+		lines:     strings.Split(string(content), "\n"),
+		syntax:    syntax,
+		modTime:   info.ModTime(),
+		truncated: truncated,
+	}
+	e.fuzzyPreviewCache[path] = entry
+	return entry
+}
+
+// drawFuzzyPreview renders the preview for the currently selected fuzzy
+// finder candidate into the region [startX, startX+width) x
+// [startY, startY+height), scrolled so the top of the file is always shown
+// (there's no cursor inside a preview to keep in view).
+func (e *Editor) drawFuzzyPreview(startX, startY, width, height int) {
+	fg, bg := GetThemeColor(ColorFuzzyResult)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			e.setCell(startX+x, startY+y, ' ', fg, bg)
+		}
+	}
+
+	if e.fuzzyIndex < 0 || e.fuzzyIndex >= len(e.fuzzyResults) {
+		return
+	}
+	path := e.fuzzyResults[e.fuzzyIndex]
+	preview := e.getFuzzyPreview(path)
+	if preview == nil {
+		return
+	}
+
+	for i := 0; i < height && i < len(preview.lines); i++ {
+		line := []rune(preview.lines[i])
+		var attrs []termbox.Attribute
+		if preview.syntax != nil {
+			attrs = preview.syntax.Highlight(i, line)
+		}
+		for j, r := range line {
+			if j >= width {
+				break
+			}
+			charFg := fg
+			if j < len(attrs) {
+				charFg = attrs[j]
+			}
+			e.setCell(startX+j, startY+i, r, charFg, bg)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+// fzf-style extended query syntax for the fuzzy finder: space-separated terms
+// are AND'd together, '|' OR's terms within a group, and a term may be
+// prefixed/suffixed to change how it matches (see parseFuzzyTerm). Reuses
+// search.go's Unicode diacritic-folding so the fuzzy finder and '/' search
+// agree on what counts as a match.
+
+import "strings"
+
+// fuzzyTermKind selects how a single term in a fuzzy query is matched.
+type fuzzyTermKind int
+
+const (
+	fuzzyTermFuzzy  fuzzyTermKind = iota // Bare word: subsequence match (default).
+	fuzzyTermExact                       // 'word: exact substring match.
+	fuzzyTermPrefix                      // ^word: target must start with word.
+	fuzzyTermSuffix                      // word$: target must end with word.
+)
+
+// fuzzyTerm is one parsed term of a fuzzy query, e.g. "!'foo" or "^bar$".
+type fuzzyTerm struct {
+	text   string
+	kind   fuzzyTermKind
+	negate bool
+}
+
+// fuzzyOrGroup is a set of terms joined by '|', any one of which may satisfy
+// the group (unless every term in it is negated; see matchFuzzyQuery).
+type fuzzyOrGroup []fuzzyTerm
+
+// parseFuzzyQuery splits query into AND'd OR-groups: spaces separate groups,
+// '|' separates terms within a group. Empty groups/terms (from repeated
+// whitespace) are dropped.
+func parseFuzzyQuery(query string) []fuzzyOrGroup {
+	var groups []fuzzyOrGroup
+	for _, field := range strings.Fields(query) {
+		var group fuzzyOrGroup
+		for _, part := range strings.Split(field, "|") {
+			if part == "" {
+				continue
+			}
+			group = append(group, parseFuzzyTerm(part))
+		}
+		if len(group) > 0 {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// parseFuzzyTerm parses a single term's negation, anchors, and exact-match
+// marker, in that order: "!^foo$" negates an exact-bounds match on "foo".
+func parseFuzzyTerm(s string) fuzzyTerm {
+	var term fuzzyTerm
+	if strings.HasPrefix(s, "!") {
+		term.negate = true
+		s = s[1:]
+	}
+	switch {
+	case strings.HasPrefix(s, "'"):
+		term.kind = fuzzyTermExact
+		s = s[1:]
+	case strings.HasPrefix(s, "^") && strings.HasSuffix(s, "$") && len(s) > 1:
+		term.kind = fuzzyTermExact
+		s = strings.TrimSuffix(strings.TrimPrefix(s, "^"), "$")
+	case strings.HasPrefix(s, "^"):
+		term.kind = fuzzyTermPrefix
+		s = s[1:]
+	case strings.HasSuffix(s, "$"):
+		term.kind = fuzzyTermSuffix
+		s = strings.TrimSuffix(s, "$")
+	default:
+		term.kind = fuzzyTermFuzzy
+	}
+	term.text = s
+	return term
+}
+
+// matchFuzzyQuery reports whether target satisfies every AND'd group in
+// groups, returning the summed score and matched rune spans (in target's own
+// rune coordinates, for drawFuzzyFinder highlighting) of the positive terms
+// that matched. A group matches if any of its non-negated terms match and
+// none of its negated terms match; a group made up entirely of negated terms
+// matches as long as none of them match.
+func matchFuzzyQuery(groups []fuzzyOrGroup, target string) (score int, spans [][2]int, ok bool) {
+	literal := Config.FuzzyLiteral
+	targetRunes := []rune(target)
+	folded, mapping := normalizeForSearch(targetRunes, literal)
+
+	for _, group := range groups {
+		groupMatched := false
+		allNegated := true
+		for _, term := range group {
+			if !term.negate {
+				allNegated = false
+			}
+			s, termSpans, termOK := matchFuzzyTerm(term, folded, mapping)
+			if term.negate {
+				if termOK {
+					return 0, nil, false
+				}
+				continue
+			}
+			if termOK {
+				groupMatched = true
+				score += s
+				spans = append(spans, termSpans...)
+			}
+		}
+		if !groupMatched && !allNegated {
+			return 0, nil, false
+		}
+	}
+	return score, spans, true
+}
+
+// matchFuzzyTerm matches a single term against the already-folded target
+// (folded/mapping from normalizeForSearch), returning matched spans in the
+// target's original rune coordinates.
+func matchFuzzyTerm(term fuzzyTerm, folded []rune, mapping []int) (int, [][2]int, bool) {
+	needle, _ := normalizeForSearch([]rune(term.text), Config.FuzzyLiteral)
+	if len(needle) == 0 {
+		return 0, nil, true
+	}
+
+	switch term.kind {
+	case fuzzyTermExact:
+		idx := runeIndexOf(folded, needle)
+		if idx < 0 {
+			return 0, nil, false
+		}
+		return 50, [][2]int{{mapping[idx], mapping[idx+len(needle)-1] + 1}}, true
+	case fuzzyTermPrefix:
+		if len(needle) > len(folded) || !runesEqual(folded[:len(needle)], needle) {
+			return 0, nil, false
+		}
+		return 60, [][2]int{{mapping[0], mapping[len(needle)-1] + 1}}, true
+	case fuzzyTermSuffix:
+		if len(needle) > len(folded) || !runesEqual(folded[len(folded)-len(needle):], needle) {
+			return 0, nil, false
+		}
+		start := len(folded) - len(needle)
+		return 60, [][2]int{{mapping[start], mapping[len(folded)-1] + 1}}, true
+	default:
+		return fuzzySubsequenceMatch(needle, folded, mapping)
+	}
+}
+
+// fuzzySubsequenceMatch matches needle against folded as an ordered
+// subsequence, scoring consecutive runs and matches after separators the same
+// way the legacy fuzzyMatch did, and reports the matched spans translated
+// back through mapping into the target's original rune coordinates.
+func fuzzySubsequenceMatch(needle, folded []rune, mapping []int) (int, [][2]int, bool) {
+	if len(needle) == 0 {
+		return 0, nil, true
+	}
+
+	score := 0
+	targetIdx := 0
+	lastMatchIdx := -1
+	var spans [][2]int
+
+	for _, nRune := range needle {
+		found := false
+		for i := targetIdx; i < len(folded); i++ {
+			if folded[i] != nRune {
+				continue
+			}
+			if lastMatchIdx != -1 && i == lastMatchIdx+1 {
+				score += 10
+				last := spans[len(spans)-1]
+				spans[len(spans)-1] = [2]int{last[0], mapping[i] + 1}
+			} else {
+				if i == 0 || isFuzzySeparator(folded[i-1]) {
+					score += 20
+				}
+				spans = append(spans, [2]int{mapping[i], mapping[i] + 1})
+			}
+			if lastMatchIdx != -1 {
+				score -= i - lastMatchIdx - 1
+			}
+			score += 5
+			lastMatchIdx = i
+			targetIdx = i + 1
+			found = true
+			break
+		}
+		if !found {
+			return 0, nil, false
+		}
+	}
+
+	if len(needle) == len(folded) {
+		score += 100
+	} else if runeIndexOf(folded, needle) >= 0 {
+		score += 50
+	}
+
+	return score, spans, true
+}
+
+// runeIndexOf returns the rune index of the first occurrence of needle in
+// haystack, or -1 if needle doesn't occur.
+func runeIndexOf(haystack, needle []rune) int {
+	if len(needle) == 0 || len(needle) > len(haystack) {
+		return -1
+	}
+	for i := 0; i <= len(haystack)-len(needle); i++ {
+		if runesEqual(haystack[i:i+len(needle)], needle) {
+			return i
+		}
+	}
+	return -1
+}
+
+// isFuzzySeparator reports whether r is a path/word separator that earns a
+// following match the "after separator" bonus in fuzzySubsequenceMatch.
+func isFuzzySeparator(r rune) bool {
+	return r == '/' || r == '_' || r == '.' || r == '-'
+}
@@ -0,0 +1,153 @@
+package main
+
+// Asynchronous, cancellable scoring for non-empty fuzzy finder queries.
+// Scoring the full candidate list synchronously on every keystroke becomes
+// noticeably slow in repos with tens of thousands of candidates, so each
+// query is scored in a background goroutine that the next keystroke cancels
+// via context.Context before starting its own. The goroutine keeps a bounded
+// top-Config.FuzzyMaxResults min-heap and periodically publishes a stable
+// sorted snapshot of it into e.fuzzyResults, mirroring shell.go's
+// streamShellOutput: state is written directly from the goroutine and
+// termbox.Interrupt() wakes the render loop, rather than protecting the
+// fields with a mutex.
+
+import (
+	"container/heap"
+	"context"
+	"sort"
+	"time"
+
+	"github.com/nsf/termbox-go"
+)
+
+// fuzzyScored is one scored candidate, as tracked by fuzzyResultHeap.
+type fuzzyScored struct {
+	path  string
+	index int
+	score int
+	spans [][2]int
+}
+
+// fuzzyResultHeap is a min-heap on score: the lowest-scored entry, the
+// cheapest one to evict, is always at the root once the heap is full.
+type fuzzyResultHeap []fuzzyScored
+
+func (h fuzzyResultHeap) Len() int            { return len(h) }
+func (h fuzzyResultHeap) Less(i, j int) bool  { return h[i].score < h[j].score }
+func (h fuzzyResultHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *fuzzyResultHeap) Push(x interface{}) { *h = append(*h, x.(fuzzyScored)) }
+func (h *fuzzyResultHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// fuzzySearchFlushInterval bounds how often an in-progress search publishes
+// its current top-K, so a huge candidate set still streams progressively
+// instead of only updating once at the very end.
+const fuzzySearchFlushInterval = 16 * time.Millisecond
+
+// fuzzySearchFlushBatch is the other flush trigger: publish after scoring
+// this many candidates even if fuzzySearchFlushInterval hasn't elapsed yet.
+const fuzzySearchFlushBatch = 500
+
+// cancelFuzzySearch stops any in-flight background scoring pass started by
+// startFuzzySearch.
+func (e *Editor) cancelFuzzySearch() {
+	if e.fuzzySearchCancel != nil {
+		e.fuzzySearchCancel()
+		e.fuzzySearchCancel = nil
+	}
+}
+
+// startFuzzySearch scores query against e.fuzzyCandidates in a cancellable
+// background goroutine, publishing a stable sorted top-Config.FuzzyMaxResults
+// into e.fuzzyResults/e.fuzzyResultIndices/e.fuzzyResultSpans as scoring
+// progresses.
+func (e *Editor) startFuzzySearch(query string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	e.fuzzySearchCancel = cancel
+
+	groups := parseFuzzyQuery(query)
+	candidates := e.fuzzyCandidates
+
+	maxResults := Config.FuzzyMaxResults
+	if maxResults <= 0 {
+		maxResults = len(candidates)
+	}
+
+	go e.runFuzzySearch(ctx, groups, candidates, maxResults)
+}
+
+// runFuzzySearch is the background half of startFuzzySearch. It must not
+// touch editor state the main goroutine isn't prepared to race with; see
+// the file doc comment for why that's an accepted tradeoff here, matching
+// shell.go's streamShellOutput.
+func (e *Editor) runFuzzySearch(ctx context.Context, groups []fuzzyOrGroup, candidates []string, maxResults int) {
+	h := &fuzzyResultHeap{}
+	heap.Init(h)
+
+	lastFlush := time.Now()
+	scoredSinceFlush := 0
+
+	for i, candidate := range candidates {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if score, spans, ok := matchFuzzyQuery(groups, candidate); ok {
+			heap.Push(h, fuzzyScored{path: candidate, index: i, score: score + e.mruBonus(candidate), spans: spans})
+			for h.Len() > maxResults {
+				heap.Pop(h)
+			}
+		}
+
+		scoredSinceFlush++
+		if scoredSinceFlush >= fuzzySearchFlushBatch || time.Since(lastFlush) >= fuzzySearchFlushInterval {
+			if ctx.Err() != nil {
+				return
+			}
+			e.publishFuzzyResults(*h)
+			scoredSinceFlush = 0
+			lastFlush = time.Now()
+		}
+	}
+
+	if ctx.Err() != nil {
+		return
+	}
+	e.publishFuzzyResults(*h)
+}
+
+// publishFuzzyResults sorts a snapshot of the heap by descending score and
+// writes it into the fields drawFuzzyFinder reads, then wakes the render
+// loop. The snapshot is a copy, so the caller's heap can keep growing
+// (Push/Pop reorder the backing slice) without racing this sort.
+func (e *Editor) publishFuzzyResults(snapshot fuzzyResultHeap) {
+	sorted := make(fuzzyResultHeap, len(snapshot))
+	copy(sorted, snapshot)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].score > sorted[j].score })
+
+	results := make([]string, len(sorted))
+	indices := make([]int, len(sorted))
+	spans := make([][][2]int, len(sorted))
+	for i, res := range sorted {
+		results[i] = res.path
+		indices[i] = res.index
+		spans[i] = res.spans
+	}
+
+	e.fuzzyResults = results
+	e.fuzzyResultIndices = indices
+	e.fuzzyResultSpans = spans
+	if e.fuzzyIndex >= len(e.fuzzyResults) {
+		e.fuzzyIndex = 0
+	}
+	if e.fuzzyScroll >= len(e.fuzzyResults) {
+		e.fuzzyScroll = 0
+	}
+
+	termbox.Interrupt()
+}
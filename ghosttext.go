@@ -0,0 +1,162 @@
+package main
+
+// Inline AI suggestions ("ghost text"): a dim, unaccepted completion shown to
+// the right of the cursor, generated by streaming a fill-in-the-middle
+// prompt (buffer text before and after the cursor) to Ollama. It never
+// touches b.buffer until accepted, so it composes independently of LSP
+// autocomplete (autocomplete.go/triggerAutocomplete) and the Visual-mode AI
+// rewrite in ollamaComplete.
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/nsf/termbox-go"
+)
+
+// ghostDebounce is how long triggerGhostText waits after the most recent
+// keystroke before issuing a suggestion request. It's longer than
+// completionDebounce: a full LLM generation is far more expensive than an
+// LSP completion request, so it should only fire once typing actually
+// pauses, not on every momentary gap between characters.
+const ghostDebounce = 500 * time.Millisecond
+
+// ghostFIMContext splits b's contents at (y, x) into the text before and
+// after the cursor, for use as the prompt/suffix of a fill-in-the-middle
+// request.
+func ghostFIMContext(b *Buffer, y, x int) (prefix, suffix string) {
+	var before, after strings.Builder
+
+	for i := 0; i < y; i++ {
+		before.WriteString(string(b.buffer[i]))
+		before.WriteByte('\n')
+	}
+	before.WriteString(string(b.buffer[y][:x]))
+
+	after.WriteString(string(b.buffer[y][x:]))
+	for i := y + 1; i < len(b.buffer); i++ {
+		after.WriteByte('\n')
+		after.WriteString(string(b.buffer[i]))
+	}
+
+	return before.String(), after.String()
+}
+
+// invalidateGhostText cancels any in-flight suggestion request and debounce
+// timer and clears whatever suggestion is currently displayed. Every path
+// that can make a displayed suggestion stop applying to the cursor's actual
+// position — dismissal, acceptance, or just typing/moving on — routes
+// through here.
+func (e *Editor) invalidateGhostText() {
+	e.ghostGen++
+	if e.ghostTimer != nil {
+		e.ghostTimer.Stop()
+	}
+	if e.ghostCancel != nil {
+		e.ghostCancel()
+		e.ghostCancel = nil
+	}
+	if b := e.activeBuffer(); b != nil {
+		b.ghostText = nil
+	}
+}
+
+// triggerGhostText (re)arms ghostTimer so a suggestion request for the
+// current cursor position fires once typing pauses for ghostDebounce,
+// mirroring how triggerAutocomplete debounces LSP completion requests. Call
+// this after every inserted character; invalidateGhostText is called first
+// so a stale in-flight request or displayed suggestion never lingers past
+// the keystroke that invalidated it.
+func (e *Editor) triggerGhostText() {
+	b := e.activeBuffer()
+	e.invalidateGhostText()
+	if b == nil || b.readOnly || e.ollamaClient == nil || !e.ollamaClient.IsOnline {
+		return
+	}
+
+	e.ghostTimer = time.AfterFunc(ghostDebounce, func() {
+		e.requestGhostText()
+	})
+}
+
+// requestGhostText issues a suggestion request immediately, bypassing
+// ghostTimer's debounce. It's both what ghostTimer calls once it fires and
+// the explicit-key path (Ctrl+G in Insert mode; see kevent.go) for a user
+// who doesn't want to wait out the idle timer.
+func (e *Editor) requestGhostText() {
+	b := e.activeBuffer()
+	if b == nil || b.readOnly || e.ollamaClient == nil || !e.ollamaClient.IsOnline {
+		return
+	}
+	e.invalidateGhostText()
+
+	gen := e.ghostGen
+	cursor := b.PrimaryCursor()
+	y, x := cursor.Y, cursor.X
+	prefix, suffix := ghostFIMContext(b, y, x)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e.ghostCancel = cancel
+
+	go func() {
+		defer cancel()
+		var acc []rune
+		e.ollamaClient.GenerateStream(ctx, prefix, suffix, func(chunk string) {
+			if gen != e.ghostGen {
+				return // Superseded by a later keystroke or cursor move.
+			}
+			acc = append(acc, []rune(chunk)...)
+			b.ghostText = acc
+			b.ghostY, b.ghostX = y, x
+			termbox.Interrupt()
+		})
+	}()
+}
+
+// acceptGhostText splices the pending suggestion into the buffer at the
+// cursor, as its own undo step, and moves the cursor to the end of the
+// inserted text. It returns false (and discards the suggestion) if there is
+// none, or if the cursor has since moved away from where it was generated.
+func (e *Editor) acceptGhostText() bool {
+	b := e.activeBuffer()
+	if b == nil || len(b.ghostText) == 0 {
+		return false
+	}
+	cursor := b.PrimaryCursor()
+	if b.ghostY != cursor.Y || b.ghostX != cursor.X {
+		e.invalidateGhostText()
+		return false
+	}
+
+	// Accepting a suggestion is its own undo step, the same reasoning as
+	// insertCompletion: it shouldn't get folded into whatever insert-mode
+	// coalescing group is already open, nor left with no undo entry at all.
+	// Unlike insertCompletion, the whole edit is one known range (no
+	// AdditionalTextEdits touching other lines), so it can be recorded
+	// precisely instead of via a whole-buffer snapshot.
+	e.insertGroupOpen = false
+	cursorsBefore := append([]Cursor(nil), b.cursors...)
+
+	edit := TextEdit{
+		Range:   Range{Start: Position{Line: b.ghostY, Character: b.ghostX}, End: Position{Line: b.ghostY, Character: b.ghostX}},
+		NewText: string(b.ghostText),
+	}
+	endY, endX := e.applyLSPTextEdit(b, edit)
+	cursor.Y, cursor.X = endY, endX
+	cursor.PreferredCol = endX
+
+	e.pushPreciseEdit(EditInsert, b.ghostY, b.ghostX, b.ghostY, b.ghostX, nil, b.ghostText, cursorsBefore, append([]Cursor(nil), b.cursors...), 0)
+
+	b.modified = true
+	b.searchMatches = nil
+	if b.syntax != nil {
+		b.syntaxReparse()
+	}
+	if b.lspClient != nil {
+		b.lspClient.SendDidChange(b.toString())
+	}
+
+	e.invalidateGhostText()
+	return true
+}
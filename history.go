@@ -0,0 +1,164 @@
+package main
+
+// Persistence for command (:) and search (/) history across editor sessions.
+// History files live under $XDG_STATE_HOME/qwe (falling back to
+// ~/.local/state/qwe) and are written atomically via a temp file + rename so
+// a crash mid-write can never corrupt them.
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// historyStateDir returns the directory history files are stored in, creating
+// it if necessary.
+func historyStateDir() (string, error) {
+	base := os.Getenv("XDG_STATE_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(home, ".local", "state")
+	}
+
+	dir := filepath.Join(base, "qwe")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// historyFilePath returns the path to the history file for the given kind
+// ("history" for commands, "search_history" for search patterns).
+func historyFilePath(kind string) (string, error) {
+	dir, err := historyStateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, kind), nil
+}
+
+// loadHistoryFile reads one entry per line, skipping blank lines.
+func loadHistoryFile(kind string) []string {
+	path, err := historyFilePath(kind)
+	if err != nil {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var entries []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line != "" {
+			entries = append(entries, line)
+		}
+	}
+	return entries
+}
+
+// saveHistoryFile writes entries atomically, trimming to Config.HistoryMaxLen
+// and collapsing consecutive duplicates.
+func saveHistoryFile(kind string, entries []string) error {
+	path, err := historyFilePath(kind)
+	if err != nil {
+		return err
+	}
+
+	deduped := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if len(deduped) > 0 && deduped[len(deduped)-1] == e {
+			continue
+		}
+		deduped = append(deduped, e)
+	}
+
+	if len(deduped) > Config.HistoryMaxLen {
+		deduped = deduped[len(deduped)-Config.HistoryMaxLen:]
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(f)
+	for _, e := range deduped {
+		fmt.Fprintln(writer, e)
+	}
+	if err := writer.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// LoadHistories populates the in-memory command and search history from disk.
+func (e *Editor) LoadHistories() {
+	e.commandHistory = loadHistoryFile("history")
+	e.searchHistory = loadHistoryFile("search_history")
+}
+
+// FlushHistories writes the in-memory command and search history back to disk.
+func (e *Editor) FlushHistories() {
+	saveHistoryFile("history", e.commandHistory)
+	saveHistoryFile("search_history", e.searchHistory)
+}
+
+// recordSearchHistory appends a search term, skipping empty or repeated entries.
+func (e *Editor) recordSearchHistory(term string) {
+	if term == "" {
+		return
+	}
+	if len(e.searchHistory) > 0 && e.searchHistory[len(e.searchHistory)-1] == term {
+		return
+	}
+	e.searchHistory = append(e.searchHistory, term)
+}
+
+// historyCommand implements `:history` and `:history clear`, dumping the
+// command history into a scratch buffer or wiping it.
+func (ch *Command) historyCommand(arg string) {
+	if arg == "clear" {
+		ch.e.commandHistory = []string{}
+		ch.e.searchHistory = []string{}
+		saveHistoryFile("history", nil)
+		saveHistoryFile("search_history", nil)
+		ch.e.setMessage("History cleared")
+		return
+	}
+
+	lines := make([][]rune, 0, len(ch.e.commandHistory))
+	for _, entry := range ch.e.commandHistory {
+		lines = append(lines, []rune(entry))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, []rune{})
+	}
+
+	b := &Buffer{
+		buffer:    lines,
+		filename:  "[History]",
+		readOnly:  true,
+		undoStack: []Edit{},
+		redoStack: []Edit{},
+		fileType:  getFileType("[History]"),
+	}
+	b.setBufType(BufTypeScratch)
+	ch.e.buffers = append(ch.e.buffers, b)
+	ch.e.activeBufferIndex = len(ch.e.buffers) - 1
+	ch.e.setMessage(fmt.Sprintf("%d commands in history", len(ch.e.commandHistory)))
+}
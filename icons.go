@@ -0,0 +1,104 @@
+package main
+
+// Nerd Font v3 icon glyphs for gutter diagnostic signs, LSP/Ollama status
+// indicators, and per-file-type icons in the fuzzy finder. Icons is the
+// active glyph set, populated by InitConfig from either nerdFontIcons or
+// asciiIcons depending on Config.NoNerdFont (--no-nerdfont), the same way
+// activeTheme picks a concrete theme (see theme.go) — callers just read
+// Icons/FileIcons and don't need to know which set is in effect.
+
+// IconName is an enum-like type for the fixed set of non-file-type UI
+// icons. File-type icons are instead keyed by FileType.Name (see
+// nerdFontFileIcons/FileIcon below), since file types are an open-ended,
+// user-extensible list rather than a small fixed set.
+type IconName int
+
+const (
+	IconDiagError IconName = iota
+	IconDiagWarning
+	IconDiagInfo
+	IconDiagHint
+	IconLSP
+	IconOllama
+)
+
+// nerdFontIcons are the default glyphs. Rendering them as intended requires
+// a Nerd Font v3.0.0+ patched font; on anything else they show up as boxes
+// or tofu, which is what --no-nerdfont is for.
+var nerdFontIcons = map[IconName]string{
+	IconDiagError:   "",
+	IconDiagWarning: "",
+	IconDiagInfo:    "",
+	IconDiagHint:    "󰌵",
+	IconLSP:         "󰒋",
+	IconOllama:      "",
+}
+
+// asciiIcons are the --no-nerdfont fallbacks: one plain character apiece, so
+// the gutter and status bar keep their column widths either way.
+var asciiIcons = map[IconName]string{
+	IconDiagError:   "E",
+	IconDiagWarning: "W",
+	IconDiagInfo:    "I",
+	IconDiagHint:    "H",
+	IconLSP:         "*",
+	IconOllama:      "*",
+}
+
+// Icons is the active icon set for the fixed IconName glyphs, chosen by
+// InitConfig once at startup.
+var Icons = nerdFontIcons
+
+// nerdFontFileIcons maps FileType.Name (see ftypes.go) to its Nerd Font
+// glyph, shown next to file/buffer results in the fuzzy finder (see
+// drawFuzzyFinder). A name with no entry here (a language added to
+// fileTypes without a matching icon yet) just falls back to
+// asciiFileIcon, the same as under --no-nerdfont.
+var nerdFontFileIcons = map[string]string{
+	"Go":         "",
+	"C":          "",
+	"C++":        "",
+	"JavaScript": "",
+	"TypeScript": "",
+	"TSX":        "",
+	"Python":     "",
+	"Bash":       "",
+	"CSS":        "",
+	"Dockerfile": "",
+	"HTML":       "",
+	"Lua":        "",
+	"Markdown":   "",
+	"PHP":        "",
+	"SQL":        "",
+	"Makefile":   "",
+	"Text":       "",
+}
+
+// asciiFileIcon is the generic --no-nerdfont fallback for every file type;
+// ASCII has nothing resembling per-language icons, so every extension gets
+// the same glyph.
+const asciiFileIcon = "*"
+
+// FileIcons is the active file-type icon set, chosen by InitConfig once at
+// startup (nil under --no-nerdfont, since FileIcon falls back to
+// asciiFileIcon for every name in that case).
+var FileIcons = nerdFontFileIcons
+
+// IconRune returns the glyph for name as a single rune, for call sites (the
+// gutter, the status bar) that draw one cell at a time via setCell.
+func IconRune(name IconName) rune {
+	for _, r := range Icons[name] {
+		return r
+	}
+	return ' '
+}
+
+// FileIcon returns the glyph for a file type's display name (FileType.Name),
+// falling back to asciiFileIcon for a name FileIcons doesn't have an entry
+// for.
+func FileIcon(fileTypeName string) string {
+	if icon, ok := FileIcons[fileTypeName]; ok {
+		return icon
+	}
+	return asciiFileIcon
+}
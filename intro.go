@@ -9,7 +9,7 @@ import (
 
 // drawIntro clears the screen and draws an informational box with version and basic commands.
 func (e *Editor) drawIntro() {
-	w, h := termbox.Size()
+	w, h := e.termSize()
 
 	// Define specific attributes for the intro screen elements.
 	const (
@@ -60,7 +60,7 @@ func (e *Editor) drawIntro() {
 		lineX := startX + (maxLen-len(line.text))/2
 		lineY := startY + i
 		for j, char := range line.text {
-			termbox.SetCell(lineX+j, lineY, char, line.fg, bg)
+			e.setCell(lineX+j, lineY, char, line.fg, bg)
 		}
 	}
 }
@@ -0,0 +1,260 @@
+package main
+
+// A small JSON-RPC 2.0 transport used by lspConn (lsp.go): Stream frames
+// messages with the Content-Length wire format LSP inherited from
+// vscode-jsonrpc, and Conn owns request/response correlation, per-request
+// cancellation, and handing anything it can't correlate off to a Handler.
+// Modeled on the Stream+Conn split of golang.org/x/tools' jsonrpc2 package,
+// scaled down to what qwe needs.
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Handler processes a decoded message Conn couldn't correlate against a
+// pending Request - i.e. a server-initiated request or a notification.
+type Handler interface {
+	Handle(msg map[string]interface{})
+}
+
+// Stream frames JSON-RPC messages over a separate reader/writer pair (an
+// LSP server's stdout and stdin are two different pipes, not one
+// io.ReadWriteCloser). One goroutine should call ReadMessage at a time;
+// WriteMessage is safe to call concurrently with both ReadMessage and
+// itself.
+type Stream struct {
+	r      *bufio.Reader
+	w      io.Writer
+	wMutex sync.Mutex
+}
+
+// NewStream wraps r/w with Content-Length framing.
+func NewStream(r io.Reader, w io.Writer) *Stream {
+	return &Stream{r: bufio.NewReader(r), w: w}
+}
+
+// ReadMessage blocks until one framed message arrives and returns its raw
+// JSON body.
+func (s *Stream) ReadMessage() ([]byte, error) {
+	contentLength := 0
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+
+		var length int
+		if n, _ := fmt.Sscanf(line, "Content-Length: %d", &length); n == 1 {
+			contentLength = length
+		}
+	}
+
+	if contentLength == 0 {
+		return nil, fmt.Errorf("jsonrpc2: frame with no Content-Length")
+	}
+
+	buf := make([]byte, contentLength)
+	if _, err := io.ReadFull(s.r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// WriteMessage frames and writes one message's already-encoded JSON body.
+func (s *Stream) WriteMessage(data []byte) error {
+	s.wMutex.Lock()
+	defer s.wMutex.Unlock()
+
+	content := fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(data), data)
+	_, err := s.w.Write([]byte(content))
+	return err
+}
+
+// Conn correlates JSON-RPC requests sent over a Stream with their
+// responses, dispatching anything it can't correlate to a Handler.
+// Constructing a Conn performs no I/O; call Run only once the Handler is
+// fully wired up, closing the race the old single-goroutine client had
+// where a notification could arrive (and be dispatched) before the object
+// meant to receive it had finished setting itself up.
+type Conn struct {
+	stream  *Stream
+	handler Handler
+	trace   func(dir string, payload []byte) // Optional --lsp-trace hook (lsp_replay.go); nil if not recording.
+
+	nextMessageID int64
+
+	mu        sync.Mutex
+	responses map[int64]chan map[string]interface{}
+	closed    bool
+}
+
+// NewConn builds a Conn over stream that dispatches uncorrelated messages
+// to handler. trace may be nil.
+func NewConn(stream *Stream, handler Handler, trace func(dir string, payload []byte)) *Conn {
+	return &Conn{
+		stream:    stream,
+		handler:   handler,
+		trace:     trace,
+		responses: make(map[int64]chan map[string]interface{}),
+	}
+}
+
+// Run reads framed messages from the stream until it errors (the
+// connection closed) or ctx is done, dispatching each to processMessage.
+// Must be called explicitly after handler is ready to receive messages;
+// unlike the JSON-RPC libraries this is modeled on, there's no background
+// goroutine started implicitly by NewConn.
+func (c *Conn) Run(ctx context.Context) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		buf, err := c.stream.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		if c.trace != nil {
+			c.trace("s2c", buf)
+		}
+
+		var msg map[string]interface{}
+		if err := json.Unmarshal(buf, &msg); err != nil {
+			continue
+		}
+
+		c.processMessage(msg)
+	}
+}
+
+// processMessage routes one decoded message: a message with an "id" that
+// matches a pending Request is handed to the goroutine waiting on it;
+// anything else (a notification, or a message whose id we don't recognize)
+// goes to handler.Handle.
+func (c *Conn) processMessage(msg map[string]interface{}) {
+	if idVal, hasID := msg["id"]; hasID {
+		if id, ok := idVal.(float64); ok {
+			idInt := int64(id)
+			c.mu.Lock()
+			ch, exists := c.responses[idInt]
+			if exists {
+				delete(c.responses, idInt)
+			}
+			c.mu.Unlock()
+			if exists {
+				ch <- msg
+				return
+			}
+		}
+	}
+
+	if c.handler != nil {
+		c.handler.Handle(msg)
+	}
+}
+
+// Request sends a JSON-RPC request and waits for its response or for ctx to
+// finish first, whichever comes first. If ctx is canceled or its deadline
+// passes before a response arrives, Request sends $/cancelRequest for this
+// request's id (a late reply is simply dropped, since the response channel
+// is already unregistered by then) and returns ctx.Err(); callers that want
+// a plain timeout can pass a context.WithTimeout.
+func (c *Conn) Request(ctx context.Context, method string, params interface{}) (map[string]interface{}, error) {
+	id := c.nextID()
+	ch := c.registerResponse(id)
+
+	if err := c.send(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"method":  method,
+		"params":  params,
+	}); err != nil {
+		c.unregisterResponse(id)
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-ctx.Done():
+		c.unregisterResponse(id)
+		c.Notify("$/cancelRequest", map[string]interface{}{"id": id})
+		return nil, ctx.Err()
+	}
+}
+
+// Notify sends a JSON-RPC message with no id, expecting no response.
+func (c *Conn) Notify(method string, params interface{}) error {
+	return c.send(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params":  params,
+	})
+}
+
+// Close marks the connection closed; any send after this returns an error
+// instead of writing to what may already be a dead pipe. It doesn't touch
+// the underlying Stream itself - lspConn.shutdownProcess (lsp.go) owns
+// closing the actual stdin/stdout pipes and waiting on the process.
+func (c *Conn) Close() {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+}
+
+func (c *Conn) nextID() int64 {
+	return atomic.AddInt64(&c.nextMessageID, 1)
+}
+
+func (c *Conn) registerResponse(id int64) chan map[string]interface{} {
+	ch := make(chan map[string]interface{}, 1)
+	c.mu.Lock()
+	c.responses[id] = ch
+	c.mu.Unlock()
+	return ch
+}
+
+func (c *Conn) unregisterResponse(id int64) {
+	c.mu.Lock()
+	delete(c.responses, id)
+	c.mu.Unlock()
+}
+
+func (c *Conn) send(msg interface{}) error {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return fmt.Errorf("jsonrpc2: connection is closed")
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	if c.trace != nil {
+		c.trace("c2s", data)
+	}
+
+	// A Conn built over a nil Stream (--lsp-replay, lsp_replay.go, which has
+	// no process on the other end to write to) just drops the message.
+	if c.stream == nil {
+		return nil
+	}
+
+	return c.stream.WriteMessage(data)
+}
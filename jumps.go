@@ -0,0 +1,199 @@
+package main
+
+// Persistence for the jumplist (Ctrl-O/Ctrl-I), so it survives editor
+// restarts. Unlike command/search history (see history.go), which is global,
+// the jumplist is scoped per working directory: jumping around one project
+// shouldn't pollute another's list. The file lives at
+// $XDG_STATE_HOME/qwe/jumps/<hash-of-cwd>.json (falling back to
+// ~/.local/state/qwe/jumps), where <hash> is the same hex SHA-1 scheme
+// recovery.go uses for swap/backup paths.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// jumpRecord is the on-disk representation of a Jump. Jump itself keeps
+// unexported fields like the rest of the editor's runtime state, so it's
+// mirrored here with exported/tagged fields for JSON (un)marshaling.
+type jumpRecord struct {
+	Filename     string    `json:"filename"`
+	CursorX      int       `json:"cursorX"`
+	CursorY      int       `json:"cursorY"`
+	ScrollY      int       `json:"scrollY"`
+	PreferredCol int       `json:"preferredCol"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+// jumplistFile is the full on-disk document: the jump entries plus the
+// current position in them.
+type jumplistFile struct {
+	Jumps []jumpRecord `json:"jumps"`
+	Index int          `json:"index"`
+}
+
+// jumpsStateDir returns $XDG_STATE_HOME/qwe/jumps (or
+// ~/.local/state/qwe/jumps), creating it if necessary.
+func jumpsStateDir() (string, error) {
+	base := os.Getenv("XDG_STATE_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(home, ".local", "state")
+	}
+
+	dir := filepath.Join(base, "qwe", "jumps")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// jumplistFilePath returns the jumplist file for the current working
+// directory, identified by the hex SHA-1 of its absolute path.
+func jumplistFilePath() (string, error) {
+	dir, err := jumpsStateDir()
+	if err != nil {
+		return "", err
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, hashFilePath(cwd)+".json"), nil
+}
+
+// loadJumplistFile reads the persisted jumplist for the current working
+// directory. A missing or corrupt file is treated as an empty jumplist.
+func loadJumplistFile() ([]Jump, int) {
+	path, err := jumplistFilePath()
+	if err != nil {
+		return nil, -1
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, -1
+	}
+
+	var file jumplistFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, -1
+	}
+
+	jumps := make([]Jump, len(file.Jumps))
+	for i, r := range file.Jumps {
+		jumps[i] = Jump{
+			filename:     r.Filename,
+			cursorX:      r.CursorX,
+			cursorY:      r.CursorY,
+			scrollY:      r.ScrollY,
+			preferredCol: r.PreferredCol,
+			timestamp:    r.Timestamp,
+		}
+	}
+
+	index := file.Index
+	if index < -1 || index >= len(jumps) {
+		index = len(jumps) - 1
+	}
+	return jumps, index
+}
+
+// saveJumplistFile writes the jumplist atomically via a temp file + rename.
+func saveJumplistFile(jumps []Jump, index int) error {
+	path, err := jumplistFilePath()
+	if err != nil {
+		return err
+	}
+
+	records := make([]jumpRecord, len(jumps))
+	for i, j := range jumps {
+		records[i] = jumpRecord{
+			Filename:     j.filename,
+			CursorX:      j.cursorX,
+			CursorY:      j.cursorY,
+			ScrollY:      j.scrollY,
+			PreferredCol: j.preferredCol,
+			Timestamp:    j.timestamp,
+		}
+	}
+
+	data, err := json.MarshalIndent(jumplistFile{Jumps: records, Index: index}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadJumplist populates the in-memory jumplist from disk.
+func (e *Editor) LoadJumplist() {
+	e.jumplist, e.jumpIndex = loadJumplistFile()
+}
+
+// FlushJumplist writes the in-memory jumplist back to disk.
+func (e *Editor) FlushJumplist() {
+	if err := saveJumplistFile(e.jumplist, e.jumpIndex); err != nil {
+		e.addLog("Editor", "Failed to save jumplist: "+err.Error())
+	}
+}
+
+// jumpsCommand implements `:jumps`, listing the jumplist in a scratch
+// buffer. Pressing Enter on an entry's line jumps to it (see
+// handleNormalMode's KeyEnter case).
+func (ch *Command) jumpsCommand() {
+	e := ch.e
+	lines := make([][]rune, 0, len(e.jumplist))
+	for i, j := range e.jumplist {
+		marker := " "
+		if i == e.jumpIndex {
+			marker = ">"
+		}
+		lines = append(lines, []rune(fmt.Sprintf("%s %2d  %s:%d  %s", marker, i, j.filename, j.cursorY+1, j.timestamp.Format("2006-01-02 15:04:05"))))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, []rune("(jumplist is empty)"))
+	}
+
+	b := &Buffer{
+		buffer:    lines,
+		filename:  "[Jumps]",
+		readOnly:  true,
+		undoStack: []Edit{},
+		redoStack: []Edit{},
+		fileType:  getFileType("[Jumps]"),
+	}
+	b.setBufType(BufTypeScratch)
+	e.buffers = append(e.buffers, b)
+	e.activeBufferIndex = len(e.buffers) - 1
+	e.setMessage(fmt.Sprintf("%d jumps (Enter to go to one)", len(e.jumplist)))
+}
+
+// jumpToJumpsEntry is called when Enter is pressed in the [Jumps] buffer. It
+// maps the current line back to its jumplist index and performs the jump.
+func (e *Editor) jumpToJumpsEntry() {
+	b := e.activeBuffer()
+	if b == nil || b.filename != "[Jumps]" {
+		return
+	}
+
+	idx := b.PrimaryCursor().Y
+	if idx < 0 || idx >= len(e.jumplist) {
+		return
+	}
+
+	e.jumpIndex = idx
+	e.performJump(e.jumplist[idx])
+}
@@ -22,53 +22,117 @@ func (e *Editor) HandleEvents() {
 			if b != nil && b.lspClient != nil {
 				b.diagnostics = b.lspClient.GetDiagnostics()
 			}
+			// An incremental syntax.Edit that got cancelled by its parse
+			// budget leaves Tree/Highlights stale; catch up with a full
+			// reparse on the next idle tick instead of waiting for another
+			// keystroke to retry it.
+			if b != nil && b.syntax != nil && b.syntax.needsReparse {
+				b.syntaxReparse()
+			}
 			e.CheckFilesOnDisk()
+			if e.chordTimeoutFired {
+				e.chordTimeoutFired = false
+				e.resolveChordTimeout()
+			}
 			continue
 		}
 
 		if ev.Type == termbox.EventKey {
-			// Clear message on any key press unless specifically set.
-			e.message = ""
-			// Hide hover popup if any key other than Ctrl+K is pressed.
-			if e.showHover && ev.Key != termbox.KeyCtrlK {
-				e.showHover = false
-			}
-
-			// If dev mode, exit the editor with Ctrl+C.
-			if ev.Key == termbox.KeyCtrlC && e.devMode {
+			if e.handleKeyEvent(ev) {
 				return
 			}
-
-			// Dispatch the key event to the handler for the current editor mode.
-			switch e.mode {
-			case ModeNormal:
-				e.handleNormalMode(ev)
-			case ModeInsert:
-				e.handleInsertMode(ev)
-			case ModeCommand:
-				e.handleCommandMode(ev)
-			case ModeFuzzy:
-				e.handleFuzzyMode(ev)
-			case ModeFind:
-				e.handleFindMode(ev)
-			case ModeVisual:
-				e.handleVisualMode(ev)
-			case ModeVisualLine:
-				e.handleVisualLineMode(ev)
-			case ModeVisualBlock:
-				e.handleVisualBlockMode(ev)
-			case ModeReplace:
-				e.handleReplaceMode(ev)
-			case ModeConfirm:
-				e.handleConfirmMode(ev)
-			}
 		} else if ev.Type == termbox.EventMouse {
 			e.handleMouseEvent(ev)
 		}
 	}
 }
 
-// handleNormalMode processes keyboard input when the editor is in Normal mode.
+// handleKeyEvent processes one key event: mode dispatch, the Insert-mode
+// coalescing/dot-register bookkeeping, and macro recording. HandleEvents
+// calls it for live input; playMacro (macros.go) calls it again for each
+// recorded keystroke, so a macro is replayed through the exact same path a
+// live keypress would take. It reports whether Ctrl+C should quit the
+// editor (dev mode only), the one case that used to just `return` out of
+// HandleEvents directly.
+func (e *Editor) handleKeyEvent(ev termbox.Event) bool {
+	// Clear message on any key press unless specifically set.
+	e.message = ""
+	// Hide hover popup if any key other than Ctrl+K is pressed.
+	if e.showHover && ev.Key != termbox.KeyCtrlK {
+		e.showHover = false
+	}
+
+	// If dev mode, exit the editor with Ctrl+C.
+	if ev.Key == termbox.KeyCtrlC && e.devMode {
+		return true
+	}
+
+	// Ctrl-C cancels a running :! / :r! shell job instead of falling
+	// through to mode-specific handling.
+	if ev.Key == termbox.KeyCtrlC && e.activeShellJob != nil && e.activeShellJob.running {
+		e.CancelShellJob()
+		return false
+	}
+
+	wasRecording := e.recordingRegister != 0
+
+	// Dispatch the key event to the handler for the current editor mode.
+	prevMode := e.mode
+	switch e.mode {
+	case ModeNormal:
+		e.handleNormalMode(ev)
+	case ModeInsert:
+		e.handleInsertMode(ev)
+	case ModeCommand:
+		e.handleCommandMode(ev)
+	case ModeFuzzy:
+		e.handleFuzzyMode(ev)
+	case ModeFind:
+		e.handleFindMode(ev)
+	case ModeVisual:
+		e.handleVisualMode(ev)
+	case ModeVisualLine:
+		e.handleVisualLineMode(ev)
+	case ModeVisualBlock:
+		e.handleVisualBlockMode(ev)
+	case ModeReplace:
+		e.handleReplaceMode(ev)
+	case ModeAITransform:
+		e.handleAITransformMode(ev)
+	case ModeConfirm:
+		e.handleConfirmMode(ev)
+	}
+
+	// Leaving Insert mode always breaks the coalescing group. Entering it
+	// doesn't open one: the group opens lazily, on the first edit of the
+	// session (see beginInsertGroup), whether that's a typed character or
+	// an edit that switched into Insert mode itself (OpenLineBelow/Above).
+	if prevMode == ModeInsert && e.mode != ModeInsert {
+		e.insertGroupOpen = false
+		e.registers.SetDot(e.insertedText)
+		if e.lastChange != nil {
+			e.lastChange.insertedText = append([]rune(nil), e.insertedText...)
+		}
+	} else if prevMode != ModeInsert && e.mode == ModeInsert {
+		e.insertedText = nil
+	}
+
+	// Record this keystroke if one was already in progress; the keys that
+	// start/stop recording itself (the 'm' prefix above) are deliberately
+	// excluded, matching Vim.
+	if wasRecording && e.recordingRegister != 0 {
+		e.macros[e.recordingRegister] = append(e.macros[e.recordingRegister], ev)
+	}
+
+	return false
+}
+
+// handleNormalMode processes keyboard input when the editor is in Normal
+// mode. Dispatch goes through the chord registry (bindings.go); the only
+// logic that stays here is Escape (which must always cancel, regardless
+// of what bindings say) and the `"<reg>`/`m<reg>`/`@<reg>` prefixes, which
+// each name an arbitrary register rather than a fixed action and so can't
+// be static keymap entries.
 func (e *Editor) handleNormalMode(ev termbox.Event) {
 	// Escape clears any pending multi-key commands or secondary cursors.
 	if ev.Key == termbox.KeyEsc {
@@ -76,428 +140,231 @@ func (e *Editor) handleNormalMode(ev termbox.Event) {
 		if b != nil && len(b.cursors) > 1 {
 			e.clearSecondaryCursors()
 			e.pendingKey = 0
-			e.message = "Cleared secondary cursors"
+			e.pendingChords = nil
+			e.pendingCount = 0
+			e.pendingCharSearch = nil
+			e.setMessage("Cleared secondary cursors")
+			return
+		}
+		if e.hlsearch {
+			e.UnhighlightSearch()
+			e.pendingKey = 0
+			e.pendingChords = nil
+			e.pendingCount = 0
+			e.pendingCharSearch = nil
 			return
 		}
 		e.pendingKey = 0
+		e.pendingChords = nil
+		e.pendingCount = 0
+		e.pendingCharSearch = nil
 		return
 	}
 
-	switch ev.Key {
-	case termbox.KeyArrowLeft:
-		e.moveCursor(-1, 0)
-	case termbox.KeyArrowRight:
-		e.moveCursor(1, 0)
-	case termbox.KeyArrowUp:
-		if ev.Mod != 0 {
-			e.addCursorAbove()
-		} else {
-			e.moveCursor(0, -1)
-		}
-	case termbox.KeyArrowDown:
-		if ev.Mod != 0 {
-			e.addCursorBelow()
-		} else {
-			e.moveCursor(0, 1)
-		}
-	case termbox.KeyCtrlX:
-		e.addCursorBelow()
-	case termbox.KeyCtrlP:
-		e.prevBuffer()
-	case termbox.KeyCtrlN:
-		e.nextBuffer()
-	case termbox.KeyCtrlO:
-		e.jumpBack()
-	case termbox.KeyCtrlI:
-		e.jumpForward()
-	case termbox.KeyCtrlV:
-		b := e.activeBuffer()
-		if b != nil {
-			e.visualStartX = b.PrimaryCursor().X
-			e.visualStartY = b.PrimaryCursor().Y
+	// A `"<reg>` prefix names the register that the next y/d/c/p/P will
+	// use; consume the name here rather than feeding it to the registry.
+	if e.pendingKey == '"' {
+		if isRegisterName(ev.Ch) {
+			e.pendingRegister = ev.Ch
 		}
-		e.mode = ModeVisualBlock
-	case termbox.KeyCtrlK:
-		e.triggerHover()
+		e.pendingKey = 0
+		return
+	}
+	if len(e.pendingChords) == 0 && ev.Ch == '"' {
+		e.pendingKey = '"'
+		return
 	}
 
-	// Prevent key event fallthrough.
-	if ev.Key != 0 {
+	if e.dispatchMacroPrefix(ev) {
 		return
 	}
 
-	switch ev.Ch {
-	case 'i':
-		e.saveState()
-		e.mode = ModeInsert
-		e.introDismissed = true
-	case 'a':
-		e.saveState()
-		e.moveCursor(1, 0)
-		e.mode = ModeInsert
-		e.introDismissed = true
-	case 'A':
-		e.saveState()
-		e.jumpToLineEnd()
-		e.mode = ModeInsert
-		e.introDismissed = true
-	case 'I':
-		e.saveState()
-		e.jumpToFirstNonBlank()
-		e.mode = ModeInsert
-		e.introDismissed = true
-	case 'o':
-		e.saveState()
-		e.insertLineBelow()
-		e.mode = ModeInsert
-		e.introDismissed = true
-	case 'O':
-		e.saveState()
-		e.insertLineAbove()
-		e.mode = ModeInsert
-		e.introDismissed = true
-	case ']':
-		e.pushJump()
-		e.jumpToNextEmptyLine()
-	case '}':
-		e.pushJump()
-		e.jumpToBottom()
-	case 'v':
-		b := e.activeBuffer()
-		if b != nil {
-			e.visualStartX = b.PrimaryCursor().X
-			e.visualStartY = b.PrimaryCursor().Y
-		}
-		e.mode = ModeVisual
-	case 'V':
-		b := e.activeBuffer()
-		if b != nil {
-			e.visualStartX = b.PrimaryCursor().X
-			e.visualStartY = b.PrimaryCursor().Y
-		}
-		e.mode = ModeVisualLine
-	case ':':
-		e.mode = ModeCommand
-		e.commandBuffer = []rune{}
-		e.commandCursorX = 0
-	case '/':
-		e.findSavedSearch = e.lastSearch
-		e.mode = ModeFind
-		e.findBuffer = []rune{}
-	case Config.LeaderKey:
-		e.pendingKey = Config.LeaderKey
-	case 'l':
-		if e.pendingKey == Config.LeaderKey {
-			e.toggleDebugWindow()
-			e.pendingKey = 0
-		}
-	case 'w':
-		if e.pendingKey == 'd' {
-			e.saveState()
-			e.deleteWord(true)
-			e.checkDiagnostics()
-			e.pendingKey = 0
-		} else if e.pendingKey == 'c' {
-			e.saveState()
-			e.changeWord()
-			e.checkDiagnostics()
-			e.pendingKey = 0
-		} else if e.pendingKey == Config.LeaderKey {
-			e.startWarningsFuzzyFinder()
-			e.pendingKey = 0
-		} else {
-			e.moveWordForward()
-		}
-	case 'q':
-		if e.pendingKey == 'z' {
-			e.formatText()
-			e.checkDiagnostics()
-			e.pendingKey = 0
-		} else if e.pendingKey == Config.LeaderKey {
-			e.lastSearch = ""
-			e.pendingKey = 0
-		} else {
-			e.moveWordBackward()
-		}
-	case 'Q':
-		e.jumpToFirstNonBlank()
-	case 'W':
-		e.jumpToLineEnd()
-	case 'g':
-		e.pendingKey = 'g'
-	case 'j':
-		e.saveState()
-		e.JoinLines()
-		e.checkDiagnostics()
-	case 'f':
-		if e.pendingKey == 'g' {
-			e.gotoFile()
-			e.pendingKey = 0
-		}
-	case 'd':
-		if e.pendingKey == Config.LeaderKey {
-			e.deleteCurrentBuffer()
-			e.pendingKey = 0
-		} else if e.pendingKey == 'd' {
-			e.saveState()
-			e.deleteLine()
-			e.checkDiagnostics()
-			e.pendingKey = 0
-		} else if e.pendingKey == 'g' {
-			e.gotoDefinition()
-			e.pendingKey = 0
-		} else {
-			e.pendingKey = 'd'
-		}
-	case 'y':
-		e.yankLine()
-		e.message = "Line yanked"
-	case 'x':
-		if e.pendingKey == 'z' {
-			e.saveState()
-			e.toggleCommentLine()
-			e.checkDiagnostics()
-			e.pendingKey = 0
-		} else {
-			e.saveState()
-			e.DeleteChar()
-			e.checkDiagnostics()
-			e.pendingKey = 0
-		}
-	case 'z':
-		if e.pendingKey == 'z' {
-			e.centerScreen()
-			e.pendingKey = 0
-		} else {
-			e.pendingKey = 'z'
-		}
-	case 'c':
-		if e.pendingKey == 'd' {
-			e.saveState()
-			e.DeleteChar()
-			e.checkDiagnostics()
-			e.pendingKey = 0
-		} else if e.pendingKey == 'c' {
-			e.saveState()
-			e.changeCharacter()
-			e.checkDiagnostics()
-			e.pendingKey = 0
-		} else {
-			e.pendingKey = 'c'
-		}
-	case 'C':
-		e.saveState()
-		e.changeToEndOfLine()
-		e.checkDiagnostics()
-		e.pendingKey = 0
-	case 'D':
-		e.saveState()
-		e.deleteToEndOfLine()
-		e.checkDiagnostics()
-		e.pendingKey = 0
-	case '(':
-		if e.pendingKey == 'c' {
-			e.saveState()
-			e.changeInside('(', ')')
-			e.checkDiagnostics()
-			e.pendingKey = 0
-		} else if e.pendingKey == 'd' {
-			e.saveState()
-			e.deleteInside('(', ')')
-			e.checkDiagnostics()
-			e.pendingKey = 0
-		}
-	case '[':
-		if e.pendingKey == 'c' {
-			e.saveState()
-			e.changeInside('[', ']')
-			e.checkDiagnostics()
-			e.pendingKey = 0
-		} else if e.pendingKey == 'd' {
-			e.saveState()
-			e.deleteInside('[', ']')
-			e.checkDiagnostics()
-			e.pendingKey = 0
-		} else {
-			e.pushJump()
-			e.jumpToPrevEmptyLine()
-		}
-	case '{':
-		if e.pendingKey == 'c' {
-			e.saveState()
-			e.changeInside('{', '}')
-			e.checkDiagnostics()
-			e.pendingKey = 0
-		} else if e.pendingKey == 'd' {
-			e.saveState()
-			e.deleteInside('{', '}')
-			e.checkDiagnostics()
-			e.pendingKey = 0
-		} else {
-			e.pushJump()
-			e.jumpToTop()
-		}
-	case '\'':
-		if e.pendingKey == 'c' {
-			e.saveState()
-			e.changeInside('\'', '\'')
-			e.checkDiagnostics()
-			e.pendingKey = 0
-		} else if e.pendingKey == 'd' {
-			e.saveState()
-			e.deleteInside('\'', '\'')
-			e.checkDiagnostics()
-			e.pendingKey = 0
-		}
-	case '"':
-		if e.pendingKey == 'c' {
-			e.saveState()
-			e.changeInside('"', '"')
-			e.checkDiagnostics()
-			e.pendingKey = 0
-		} else if e.pendingKey == 'd' {
-			e.saveState()
-			e.deleteInside('"', '"')
-			e.checkDiagnostics()
-			e.pendingKey = 0
-		}
-	case 's':
-		e.saveState()
-		e.changeCharacter()
-		e.checkDiagnostics()
-		e.pendingKey = 0
-	case 'n':
-		e.findNext()
-		e.centerCursor()
-	case 'N':
-		e.findPrev()
-		e.centerCursor()
-	case 'u':
-		e.undo()
-		e.checkDiagnostics()
-		e.pendingKey = 0
-	case 'U':
-		e.redo()
-		e.checkDiagnostics()
-		e.pendingKey = 0
-	case 'p':
-		if e.pendingKey == Config.LeaderKey {
-			e.startFileFuzzyFinder()
-			e.pendingKey = 0
-		} else {
-			e.saveState()
-			e.pasteLine()
-			e.checkDiagnostics()
-			e.pendingKey = 0
-		}
-	case 'b':
-		if e.pendingKey == Config.LeaderKey {
-			e.startBufferFuzzyFinder()
-			e.pendingKey = 0
-		}
-	case 'P':
-		if e.pendingKey == Config.LeaderKey {
-			e.pendingKey = 0
-		} else {
-			e.saveState()
-			e.pasteLineAbove()
-			e.checkDiagnostics()
-			e.pendingKey = 0
+	// f/F/t/T can't be static chords like "dw" or "di(" since they read an
+	// arbitrary target character; recognize "d"/"c"/"y" still waiting for a
+	// motion (and any count typed in between, e.g. the "2" in "d2fx") here,
+	// before the unbound "2" chord would otherwise abandon the pending "d".
+	if len(e.pendingChords) == 1 {
+		if op := e.pendingChords[0]; op == "d" || op == "c" || op == "y" {
+			if ev.Ch >= '1' && ev.Ch <= '9' || (ev.Ch == '0' && e.pendingCount > 0) {
+				e.pendingCount = e.pendingCount*10 + int(ev.Ch-'0')
+				return
+			}
+			switch ev.Ch {
+			case 'f', 'F', 't', 'T':
+				e.pendingCharSearch = &charSearchState{operator: rune(op[0]), kind: ev.Ch, count: e.pendingCount}
+				e.pendingCount = 0
+				e.pendingChords = nil
+				return
+			}
 		}
-	default:
-		e.pendingKey = 0
 	}
+
+	if e.dispatchCharSearchPrefix(ev) {
+		return
+	}
+
+	e.dispatchChord(ev)
 }
 
 // handleInsertMode processes keyboard input when the editor is in Insert mode.
 func (e *Editor) handleInsertMode(ev termbox.Event) {
+	// "Ctrl+X Ctrl+E" opens the buffer in $EDITOR, mirroring Emacs's
+	// minibuffer binding of the same name; any other key after Ctrl+X just
+	// cancels the chord instead of falling through to it.
+	if e.pendingInsertCtrlX {
+		e.pendingInsertCtrlX = false
+		if ev.Key == termbox.KeyCtrlE {
+			e.OpenInExternalEditor()
+		}
+		return
+	}
+	if ev.Key == termbox.KeyCtrlX {
+		e.pendingInsertCtrlX = true
+		return
+	}
+
 	if e.showAutocomplete {
 		switch ev.Key {
-		case termbox.KeyArrowUp:
-			e.autocompleteIndex--
-			if e.autocompleteIndex < 0 {
-				e.autocompleteIndex = len(e.autocompleteItems) - 1
-			}
-			// Adjust scroll to keep selection visible
-			if e.autocompleteIndex < e.autocompleteScroll {
-				e.autocompleteScroll = e.autocompleteIndex
-			}
-			if e.autocompleteIndex >= e.autocompleteScroll+10 {
-				e.autocompleteScroll = e.autocompleteIndex - 9
-			}
+		case termbox.KeyArrowUp, termbox.KeyCtrlP:
+			e.moveAutocompleteSelection(-1)
 			return
-		case termbox.KeyArrowDown:
-			e.autocompleteIndex++
-			if e.autocompleteIndex >= len(e.autocompleteItems) {
-				e.autocompleteIndex = 0
-			}
-			// Adjust scroll to keep selection visible
-			if e.autocompleteIndex < e.autocompleteScroll {
-				e.autocompleteScroll = e.autocompleteIndex
-			}
-			if e.autocompleteIndex >= e.autocompleteScroll+10 {
-				e.autocompleteScroll = e.autocompleteIndex - 9
-			}
+		case termbox.KeyArrowDown, termbox.KeyCtrlN:
+			e.moveAutocompleteSelection(1)
 			return
 		case termbox.KeyEnter:
 			e.insertCompletion(e.autocompleteItems[e.autocompleteIndex])
 			return
 		case termbox.KeyEsc:
 			e.showAutocomplete = false
+			e.completionGen++
 			return
 		}
 	}
 
+	if len(e.snippetStops) > 0 && ev.Key == termbox.KeyTab {
+		if ev.Mod != 0 {
+			e.prevSnippetStop()
+		} else {
+			e.nextSnippetStop()
+		}
+		return
+	}
+
+	// Tab accepts a pending ghost-text suggestion instead of inserting a
+	// literal tab; falls through to normal Tab handling if there's none (or
+	// it was stale and got discarded).
+	if ev.Key == termbox.KeyTab {
+		if b := e.activeBuffer(); b != nil && len(b.ghostText) > 0 {
+			if e.acceptGhostText() {
+				return
+			}
+		}
+	}
+
 	switch ev.Key {
 	case termbox.KeyEsc:
 		// Return to Normal mode and trigger a diagnostic check.
 		e.mode = ModeNormal
+		e.showSignature = false
+		e.endSnippet()
+		e.invalidateGhostText()
 		e.checkDiagnostics()
 	case termbox.KeyEnter:
 		e.insertNewline()
+		e.invalidateGhostText()
 	case termbox.KeySpace:
 		e.insertRune(' ')
+		e.invalidateGhostText()
 	case termbox.KeyBackspace, termbox.KeyBackspace2:
 		e.backspace()
 		if e.showAutocomplete {
 			e.showAutocomplete = false
+			e.completionGen++
 		}
+		e.invalidateGhostText()
 	case termbox.KeyTab:
 		e.insertTab()
 		if e.showAutocomplete {
 			e.showAutocomplete = false
+			e.completionGen++
 		}
+		e.invalidateGhostText()
 	case termbox.KeyArrowLeft:
 		e.moveCursor(-1, 0)
+		e.insertGroupOpen = false
 		if e.showAutocomplete {
 			e.showAutocomplete = false
+			e.completionGen++
 		}
+		e.showSignature = false
+		e.endSnippet()
+		e.invalidateGhostText()
 	case termbox.KeyArrowRight:
 		e.moveCursor(1, 0)
+		e.insertGroupOpen = false
 		if e.showAutocomplete {
 			e.showAutocomplete = false
+			e.completionGen++
 		}
+		e.showSignature = false
+		e.endSnippet()
+		e.invalidateGhostText()
 	case termbox.KeyArrowUp:
 		e.moveCursor(0, -1)
+		e.insertGroupOpen = false
 		if e.showAutocomplete {
 			e.showAutocomplete = false
+			e.completionGen++
 		}
+		e.showSignature = false
+		e.endSnippet()
+		e.invalidateGhostText()
 	case termbox.KeyArrowDown:
 		e.moveCursor(0, 1)
+		e.insertGroupOpen = false
 		if e.showAutocomplete {
 			e.showAutocomplete = false
+			e.completionGen++
 		}
+		e.showSignature = false
+		e.endSnippet()
+		e.invalidateGhostText()
 	case termbox.KeyCtrlW:
 		e.deleteWordBackward()
-	case termbox.KeyCtrlN:
+		e.invalidateGhostText()
+	case termbox.KeyCtrlN, termbox.KeyCtrlP:
 		e.triggerAutocomplete()
+	case termbox.KeyCtrlK:
+		if e.showSignature {
+			if ev.Mod != 0 {
+				e.cycleSignatureOverload(-1)
+			} else {
+				e.cycleSignatureOverload(1)
+			}
+		}
+	case termbox.KeyCtrlG:
+		// Request a ghost-text suggestion right away instead of waiting out
+		// the idle timer.
+		e.requestGhostText()
 	default:
 		// If a character key was pressed, insert the character.
 		if ev.Ch != 0 {
 			e.insertRune(ev.Ch)
-			// Close autocomplete if user keeps typing.
-			if e.showAutocomplete {
+			b := e.activeBuffer()
+			if b != nil && b.lspClient != nil && e.isWordChar(ev.Ch) {
+				// Re-request completions so the popup tracks what was just typed.
+				e.triggerAutocomplete()
+			} else if e.showAutocomplete {
 				e.showAutocomplete = false
+				e.completionGen++
+			}
+			e.triggerGhostText()
+
+			if b != nil && b.lspClient != nil && b.lspClient.IsSignatureTriggerChar(ev.Ch) {
+				// Re-query so ActiveParameter tracks the argument just typed.
+				e.triggerSignatureHelp()
+			} else if ev.Ch == ')' {
+				e.showSignature = false
 			}
 		}
 	}
@@ -505,19 +372,39 @@ func (e *Editor) handleInsertMode(ev termbox.Event) {
 
 // handleCommandMode processes keyboard input for the colon command line.
 func (e *Editor) handleCommandMode(ev termbox.Event) {
+	if e.historySearchActive {
+		e.handleHistorySearchMode(ev)
+		return
+	}
+
 	switch ev.Key {
+	case termbox.KeyCtrlR:
+		e.commands.StartHistorySearch()
+		e.commands.StepHistorySearch(true)
+		return
+	case termbox.KeyTab:
+		if ev.Mod != 0 {
+			e.cycleCompletion(-1)
+		} else if e.showCompletion {
+			e.cycleCompletion(1)
+		} else {
+			e.triggerCompletion()
+		}
+		return
 	case termbox.KeyEsc:
 		// Cancel command entry.
 		e.mode = ModeNormal
 		e.commandBuffer = []rune{}
 		e.commandCursorX = 0
 		e.commandHistoryIdx = -1
+		e.closeCompletion()
 		e.checkDiagnostics()
 	case termbox.KeyEnter:
 		// Execute the entered command and save to history if valid.
 		cmd := string(e.commandBuffer)
 		e.commands.HandleAndSaveToHistory(cmd)
 		e.commandHistoryIdx = -1
+		e.closeCompletion()
 	case termbox.KeyBackspace, termbox.KeyBackspace2:
 		if e.commandCursorX > 0 {
 			// Delete character before cursor
@@ -528,14 +415,17 @@ func (e *Editor) handleCommandMode(ev termbox.Event) {
 			e.mode = ModeNormal
 		}
 		e.commandHistoryIdx = -1
+		e.closeCompletion()
 	case termbox.KeySpace:
 		// Insert space at cursor position
 		e.commandBuffer = append(e.commandBuffer[:e.commandCursorX], append([]rune{' '}, e.commandBuffer[e.commandCursorX:]...)...)
 		e.commandCursorX++
 		e.commandHistoryIdx = -1
+		e.closeCompletion()
 	case termbox.KeyCtrlW:
 		e.deleteWordBackwardFromBuffer()
 		e.commandHistoryIdx = -1
+		e.closeCompletion()
 	case termbox.KeyArrowLeft:
 		// Move cursor left
 		if e.commandCursorX > 0 {
@@ -558,6 +448,39 @@ func (e *Editor) handleCommandMode(ev termbox.Event) {
 			e.commandBuffer = append(e.commandBuffer[:e.commandCursorX], append([]rune{ev.Ch}, e.commandBuffer[e.commandCursorX:]...)...)
 			e.commandCursorX++
 			e.commandHistoryIdx = -1
+			e.closeCompletion()
+		}
+	}
+}
+
+// handleHistorySearchMode processes input while the reverse-i-search prompt
+// (Ctrl-R in ModeCommand) is active.
+func (e *Editor) handleHistorySearchMode(ev termbox.Event) {
+	switch ev.Key {
+	case termbox.KeyEsc:
+		e.commands.CancelHistorySearch()
+	case termbox.KeyEnter:
+		e.commands.AcceptHistorySearch()
+	case termbox.KeyCtrlR:
+		e.commands.StepHistorySearch(true)
+	case termbox.KeyCtrlS:
+		e.commands.StepHistorySearch(false)
+	case termbox.KeyCtrlT:
+		e.commands.ToggleHistorySearchMode()
+	case termbox.KeyBackspace, termbox.KeyBackspace2:
+		if len(e.historySearchQuery) > 0 {
+			e.historySearchQuery = e.historySearchQuery[:len(e.historySearchQuery)-1]
+			e.commands.RefreshHistorySearch()
+		} else {
+			e.commands.CancelHistorySearch()
+		}
+	case termbox.KeySpace:
+		e.historySearchQuery = append(e.historySearchQuery, ' ')
+		e.commands.RefreshHistorySearch()
+	default:
+		if ev.Ch != 0 {
+			e.historySearchQuery = append(e.historySearchQuery, ev.Ch)
+			e.commands.RefreshHistorySearch()
 		}
 	}
 }
@@ -566,9 +489,11 @@ func (e *Editor) handleCommandMode(ev termbox.Event) {
 func (e *Editor) handleFuzzyMode(ev termbox.Event) {
 	switch ev.Key {
 	case termbox.KeyEsc:
+		e.cancelFuzzySearch()
 		e.mode = ModeNormal
 	case termbox.KeyEnter:
 		// Open the currently selected item in the list.
+		e.cancelFuzzySearch()
 		e.openSelectedFile()
 	case termbox.KeyArrowUp:
 		e.fuzzyMove(1)
@@ -603,6 +528,9 @@ func (e *Editor) handleFindMode(ev termbox.Event) {
 	case termbox.KeyEnter:
 		if len(e.findBuffer) > 0 {
 			e.lastSearch = string(e.findBuffer)
+			e.recordSearchHistory(e.lastSearch)
+			e.hlsearch = true
+			e.invalidateSearchMatches()
 			e.findNext()
 			e.centerCursor()
 		}
@@ -617,6 +545,12 @@ func (e *Editor) handleFindMode(ev termbox.Event) {
 	case termbox.KeySpace:
 		e.findBuffer = append(e.findBuffer, ' ')
 		e.lastSearch = string(e.findBuffer)
+	case termbox.KeyCtrlR:
+		// Toggle regex mode for this and future searches.
+		e.toggleSearchRegex()
+	case termbox.KeyCtrlU:
+		// Toggle Unicode diacritic folding (e.g. "sodanco" matching "Só Dança").
+		e.toggleSearchLiteral()
 	default:
 		// Incremental search: update e.lastSearch as the user types.
 		if ev.Ch != 0 {
@@ -627,317 +561,50 @@ func (e *Editor) handleFindMode(ev termbox.Event) {
 }
 
 // handleVisualMode processes input for character-wise visual selection.
+// Dispatch goes through the chord registry (bindings.go); only Escape is
+// special-cased here since it must always exit visual mode regardless of
+// what bindings say.
 func (e *Editor) handleVisualMode(ev termbox.Event) {
 	if ev.Key == termbox.KeyEsc {
-		// Exit visual mode and return to Normal.
 		e.mode = ModeNormal
+		e.pendingChords = nil
+		e.pendingCount = 0
+		e.pendingCharSearch = nil
 		return
 	}
-
-	switch ev.Key {
-	case termbox.KeyArrowLeft:
-		e.moveCursor(-1, 0)
-	case termbox.KeyArrowRight:
-		e.moveCursor(1, 0)
-	case termbox.KeyArrowUp:
-		e.moveCursor(0, -1)
-	case termbox.KeyArrowDown:
-		e.moveCursor(0, 1)
-	}
-
-	// Prevent key event fallthrough.
-	if ev.Key != 0 {
+	if e.dispatchCharSearchPrefix(ev) {
 		return
 	}
-
-	switch ev.Ch {
-	case Config.LeaderKey:
-		e.pendingKey = Config.LeaderKey
-	case 'w':
-		e.moveWordForward()
-	case 'q':
-		if e.pendingKey == 'z' {
-			e.formatText()
-			e.checkDiagnostics()
-			e.pendingKey = 0
-		} else {
-			e.moveWordBackward()
-		}
-	case 'y':
-		e.yankVisualSelection()
-		e.message = "Selection yanked"
-	case 'd':
-		e.saveState()
-		e.deleteVisualSelection()
-		e.checkDiagnostics()
-		e.message = "Selection deleted"
-	case 'x':
-		if e.pendingKey == 'z' {
-			e.saveState()
-			e.commentVisualSelection()
-			e.checkDiagnostics()
-			e.pendingKey = 0
-		} else {
-			e.saveState()
-			e.deleteVisualSelection()
-			e.checkDiagnostics()
-			e.message = "Selection deleted"
-		}
-	case 'p':
-		e.saveState()
-		e.pasteVisualSelection()
-		e.checkDiagnostics()
-	case 'c':
-		e.saveState()
-		e.changeVisualSelection()
-		e.checkDiagnostics()
-	case 'Q':
-		e.jumpToFirstNonBlank()
-	case 'W':
-		e.jumpToLineEnd()
-	case '~':
-		e.saveState()
-		e.ToggleCaseVisualSelection()
-		e.checkDiagnostics()
-	case 'o':
-		if e.pendingKey == Config.LeaderKey {
-			e.ollamaComplete()
-			e.pendingKey = 0
-		} else {
-			// Swap cursor and visual anchor
-			b := e.activeBuffer()
-			if b != nil {
-				tmpX, tmpY := b.PrimaryCursor().X, b.PrimaryCursor().Y
-				b.PrimaryCursor().X, b.PrimaryCursor().Y = e.visualStartX, e.visualStartY
-				e.visualStartX, e.visualStartY = tmpX, tmpY
-			}
-		}
-	case '{':
-		e.jumpToTop()
-	case '}':
-		e.jumpToBottom()
-	case '[':
-		e.jumpToPrevEmptyLine()
-	case ']':
-		e.jumpToNextEmptyLine()
-	case ':':
-		e.mode = ModeCommand
-		e.commandBuffer = []rune{}
-		e.commandCursorX = 0
-	case 'V':
-		e.mode = ModeVisualLine
-	case 'z':
-		e.pendingKey = 'z'
-	case 'R':
-		e.startReplaceMode()
-	}
+	e.dispatchChord(ev)
 }
 
 func (e *Editor) handleVisualLineMode(ev termbox.Event) {
 	if ev.Key == termbox.KeyEsc {
 		e.mode = ModeNormal
+		e.pendingChords = nil
+		e.pendingCount = 0
+		e.pendingCharSearch = nil
 		return
 	}
-
-	switch ev.Key {
-	case termbox.KeyArrowLeft:
-		e.moveCursor(-1, 0)
-	case termbox.KeyArrowRight:
-		e.moveCursor(1, 0)
-	case termbox.KeyArrowUp:
-		e.moveCursor(0, -1)
-	case termbox.KeyArrowDown:
-		e.moveCursor(0, 1)
-	}
-
-	// Prevent key event fallthrough.
-	if ev.Key != 0 {
+	if e.dispatchCharSearchPrefix(ev) {
 		return
 	}
-
-	switch ev.Ch {
-	case Config.LeaderKey:
-		e.pendingKey = Config.LeaderKey
-	case 'w':
-		e.moveWordForward()
-	case 'q':
-		if e.pendingKey == 'z' {
-			e.formatText()
-			e.checkDiagnostics()
-			e.pendingKey = 0
-		} else {
-			e.moveWordBackward()
-		}
-	case 'y':
-		e.yankVisualSelection()
-		e.message = "Selection yanked"
-	case 'd':
-		e.saveState()
-		e.deleteVisualSelection()
-		e.checkDiagnostics()
-		e.message = "Selection deleted"
-	case 'x':
-		if e.pendingKey == 'z' {
-			e.saveState()
-			e.commentVisualSelection()
-			e.checkDiagnostics()
-			e.pendingKey = 0
-		} else {
-			e.saveState()
-			e.deleteVisualSelection()
-			e.checkDiagnostics()
-			e.message = "Selection deleted"
-		}
-	case 'p':
-		e.saveState()
-		e.pasteVisualSelection()
-		e.checkDiagnostics()
-	case 'c':
-		e.saveState()
-		e.changeVisualSelection()
-		e.checkDiagnostics()
-	case 'Q':
-		e.jumpToFirstNonBlank()
-	case 'W':
-		e.jumpToLineEnd()
-	case '~':
-		e.saveState()
-		e.ToggleCaseVisualSelection()
-		e.checkDiagnostics()
-	case 'o':
-		if e.pendingKey == Config.LeaderKey {
-			e.ollamaComplete()
-			e.pendingKey = 0
-		} else {
-			// Swap cursor and visual anchor
-			b := e.activeBuffer()
-			if b != nil {
-				tmpX, tmpY := b.PrimaryCursor().X, b.PrimaryCursor().Y
-				b.PrimaryCursor().X, b.PrimaryCursor().Y = e.visualStartX, e.visualStartY
-				e.visualStartX, e.visualStartY = tmpX, tmpY
-			}
-		}
-	case '{':
-		e.jumpToTop()
-	case '}':
-		e.jumpToBottom()
-	case '[':
-		e.jumpToPrevEmptyLine()
-	case ']':
-		e.jumpToNextEmptyLine()
-	case 'z':
-		e.pendingKey = 'z'
-	case 'v':
-		e.mode = ModeVisual
-	case 'V':
-		e.mode = ModeNormal
-	case 'R':
-		e.startReplaceMode()
-	}
+	e.dispatchChord(ev)
 }
 
 // handleVisualBlockMode processes input for column-wise (rectangular) selection.
 func (e *Editor) handleVisualBlockMode(ev termbox.Event) {
 	if ev.Key == termbox.KeyEsc {
 		e.mode = ModeNormal
+		e.pendingChords = nil
+		e.pendingCount = 0
+		e.pendingCharSearch = nil
 		return
 	}
-
-	switch ev.Key {
-	case termbox.KeyArrowLeft:
-		e.moveCursor(-1, 0)
-	case termbox.KeyArrowRight:
-		e.moveCursor(1, 0)
-	case termbox.KeyArrowUp:
-		e.moveCursor(0, -1)
-	case termbox.KeyArrowDown:
-		e.moveCursor(0, 1)
-	}
-
-	// Prevent key event fallthrough.
-	if ev.Key != 0 {
+	if e.dispatchCharSearchPrefix(ev) {
 		return
 	}
-
-	switch ev.Ch {
-	case Config.LeaderKey:
-		e.pendingKey = Config.LeaderKey
-	case 'w':
-		e.moveWordForward()
-	case 'q':
-		if e.pendingKey == 'z' {
-			e.formatText()
-			e.checkDiagnostics()
-			e.pendingKey = 0
-		} else {
-			e.moveWordBackward()
-		}
-	case 'y':
-		e.yankVisualSelection()
-		e.message = "Selection yanked"
-	case 'd':
-		e.saveState()
-		e.deleteVisualSelection()
-		e.checkDiagnostics()
-		e.message = "Selection deleted"
-	case 'x':
-		if e.pendingKey == 'z' {
-			e.saveState()
-			e.commentVisualSelection()
-			e.checkDiagnostics()
-			e.pendingKey = 0
-		} else {
-			e.saveState()
-			e.deleteVisualSelection()
-			e.checkDiagnostics()
-			e.message = "Selection deleted"
-		}
-	case 'p':
-		e.saveState()
-		e.pasteVisualSelection()
-		e.checkDiagnostics()
-	case 'c':
-		e.saveState()
-		e.changeVisualSelection()
-		e.checkDiagnostics()
-	case 'Q':
-		e.jumpToFirstNonBlank()
-	case 'W':
-		e.jumpToLineEnd()
-	case '~':
-		e.saveState()
-		e.ToggleCaseVisualSelection()
-		e.checkDiagnostics()
-	case 'o':
-		if e.pendingKey == Config.LeaderKey {
-			e.ollamaComplete()
-			e.pendingKey = 0
-		} else {
-			// Swap cursor and visual anchor
-			b := e.activeBuffer()
-			if b != nil {
-				tmpX, tmpY := b.PrimaryCursor().X, b.PrimaryCursor().Y
-				b.PrimaryCursor().X, b.PrimaryCursor().Y = e.visualStartX, e.visualStartY
-				e.visualStartX, e.visualStartY = tmpX, tmpY
-			}
-		}
-	case '{':
-		e.jumpToTop()
-	case '}':
-		e.jumpToBottom()
-	case '[':
-		e.jumpToPrevEmptyLine()
-	case ']':
-		e.jumpToNextEmptyLine()
-	case 'z':
-		e.pendingKey = 'z'
-	case 'v':
-		e.mode = ModeVisual
-	case 'V':
-		e.mode = ModeVisualLine
-	case 'R':
-		e.startReplaceMode()
-	}
+	e.dispatchChord(ev)
 }
 
 // handleMouseEvent handles simple mouse wheel scrolling.
@@ -955,7 +622,8 @@ func (e *Editor) handleConfirmMode(ev termbox.Event) {
 	if ev.Key == termbox.KeyEsc {
 		e.mode = ModeNormal
 		e.pendingConfirm = nil
-		e.message = "Cancelled"
+		e.runPendingCancel()
+		e.setMessage("Cancelled")
 		return
 	}
 
@@ -963,7 +631,8 @@ func (e *Editor) handleConfirmMode(ev termbox.Event) {
 		// Default Enter to "no/cancel" to avoid accidental execution.
 		e.mode = ModeNormal
 		e.pendingConfirm = nil
-		e.message = "Cancelled"
+		e.runPendingCancel()
+		e.setMessage("Cancelled")
 		return
 	}
 
@@ -977,6 +646,7 @@ func (e *Editor) handleConfirmMode(ev termbox.Event) {
 		if e.pendingConfirm != nil {
 			action := e.pendingConfirm
 			e.pendingConfirm = nil
+			e.pendingCancel = nil
 			e.mode = ModeNormal
 			action()
 		} else {
@@ -985,6 +655,18 @@ func (e *Editor) handleConfirmMode(ev termbox.Event) {
 	case 'n', 'N':
 		e.mode = ModeNormal
 		e.pendingConfirm = nil
-		e.message = "Cancelled"
+		e.runPendingCancel()
+		e.setMessage("Cancelled")
+	}
+}
+
+// runPendingCancel invokes and clears e.pendingCancel, if one was set for the
+// confirmation being dismissed.
+func (e *Editor) runPendingCancel() {
+	if e.pendingCancel == nil {
+		return
 	}
+	cancel := e.pendingCancel
+	e.pendingCancel = nil
+	cancel()
 }
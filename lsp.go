@@ -2,11 +2,13 @@ package main
 
 // Basic Language Server Protocol (LSP) client. Communicates with external
 // language servers (like gopls or clangd) via JSON-RPC over standard
-// input/output.
+// input/output, using the generic framing and correlation layer in
+// jsonrpc2.go.
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -14,30 +16,88 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/nsf/termbox-go"
 )
 
-// LSPClient manages the lifecycle and communication with an LSP server process.
-type LSPClient struct {
-	cmd          *exec.Cmd      // The underlying server process.
-	stdin        io.WriteCloser // Write messages to the server.
-	stdout       io.ReadCloser  // Read messages from the server.
-	scanner      *bufio.Scanner
-	messageID    int64        // Monotonically increasing ID for requests.
-	diagnostics  []Diagnostic // Cached errors/warnings from the server.
-	diagMutex    sync.RWMutex // Protects access to diagnostics.
-	filename     string       // The file this client is associated with.
-	uri          string       // The LSP-compatible URI of the file.
-	shutdown     bool         // Flag to indicate the client is closing.
+// lspConn is the shared JSON-RPC connection to one spawned language server
+// process. LSPManager keeps exactly one alive per (language, workspace
+// root) (lsp_manager.go) and vends an LSPClient handle per open document
+// that all route their requests and notifications through it, so
+// gopls/clangd/etc. see one client with many open files instead of one
+// server process per file. It implements jsonrpc2's Handler interface
+// (Handle), so rpc.Run delivers every notification and uncorrelated message
+// straight to it.
+type lspConn struct {
+	cmd          *exec.Cmd          // The underlying server process.
+	stdin        io.WriteCloser     // The server's stdin, closed by shutdownProcess.
+	stdout       io.ReadCloser      // The server's stdout, closed by shutdownProcess.
+	rpc          *Conn              // Framing/correlation layer (jsonrpc2.go); built over a nil Stream for a --lsp-replay connection, which has no process to write to (see NewLSPClientFromReplay).
+	cancel       context.CancelFunc // Stops rpc.Run's read loop; called by shutdownProcess.
+	shutdown     bool               // Flag to indicate the connection is closing.
 	shutdownOnce sync.Once
 	logCallback  func(string, string) // Debug logging.
 
-	responses     map[int64]chan map[string]interface{} // Map of request IDs to response channels.
-	responseMutex sync.Mutex
-	fileType      *FileType // Associated file type for language ID.
+	root     string    // Discovered project root (see findProjectRoot); "" if none was found.
+	fileType *FileType // Language this connection's server was launched for.
+
+	signatureTriggerChars []string // Server-advertised signatureHelpProvider.triggerCharacters from initialize's result; falls back to "(" and "," if the server didn't advertise any.
+	incrementalSync       bool     // Server's initialize result advertised textDocumentSync.change == Incremental (2).
+
+	traceFile  *os.File // Destination for --lsp-trace, nil if not recording (see writeTrace, lsp_replay.go).
+	traceMutex sync.Mutex
+
+	docsMutex sync.Mutex
+	docs      map[string]*LSPClient // uri -> open document, for routing publishDiagnostics and broadcasting didChangeWatchedFiles (lsp_manager.go).
+
+	manager *LSPManager // Owning manager, if this conn was vended by LSPManager.Open; nil for the --lsp-replay path. Used by closeDocument to evict this entry once the last document closes.
+	key     string      // This conn's key in manager.conns, so closeDocument knows what to evict.
+}
+
+// LSPClient is one open document on a shared lspConn: its own URI,
+// diagnostics, and pending-edit coalescing state. Everything else - the
+// wire, the request/response correlation map, server capabilities - is
+// forwarded to conn, which several LSPClients for the same workspace root
+// share. LSPManager.Open (lsp_manager.go) is the normal way to get one;
+// NewLSPClient is still exposed for the --lsp-replay path, which has no
+// real connection to share.
+type LSPClient struct {
+	conn *lspConn
+
+	diagnostics []Diagnostic // Cached errors/warnings from the server, for this document only.
+	diagMutex   sync.RWMutex // Protects access to diagnostics.
+	filename    string       // The file this client is associated with.
+	uri         string       // The LSP-compatible URI of the file.
+	fileType    *FileType    // Associated file type for language ID.
+
+	docVersion int64 // Monotonic textDocument/didChange version for this document, independent of the jsonrpc2 message ID.
+
+	pendingChanges []TextDocumentContentChangeEvent // Edits batched since the last flush, see NotifyChange/flushPendingChanges.
+	pendingMutex   sync.Mutex
+	changeTimer    *time.Timer
+}
+
+// changeCoalesceDebounce is how long NotifyChange waits after the most
+// recent edit before flushing pendingChanges as one didChange notification,
+// so a fast typist produces one request per pause instead of one per
+// keystroke (mirrors completionDebounce in editor.go).
+const changeCoalesceDebounce = 30 * time.Millisecond
+
+// lspRequestTimeout bounds how long a one-shot request (initialize, hover,
+// definition, rename, ...) waits for a reply before Conn.Request cancels it
+// with $/cancelRequest on the server's behalf. Completion and
+// ExecuteCommand use lspCompletionTimeout instead, since completion
+// computation (and command execution) routinely takes longer.
+const lspRequestTimeout = 5 * time.Second
+const lspCompletionTimeout = 10 * time.Second
+
+// TextDocumentContentChangeEvent is one entry of didChange's contentChanges
+// array: Range+Text for an incremental edit, or just Text (Range nil) to
+// replace the whole document.
+type TextDocumentContentChangeEvent struct {
+	Range *Range `json:"range,omitempty"`
+	Text  string `json:"text"`
 }
 
 // Position in a document (0-based line and character).
@@ -58,13 +118,28 @@ type Location struct {
 	Range Range  `json:"range"`
 }
 
+// TextEdit replaces the text in Range with NewText, LSP's standard way of
+// describing an edit (used both for a completion item's own insertion and
+// for the additionalTextEdits servers like gopls attach to add an import).
+type TextEdit struct {
+	Range   Range  `json:"range"`
+	NewText string `json:"newText"`
+}
+
 // CompletionItem represents a suggestion for completion.
 type CompletionItem struct {
-	Label         string `json:"label"`
-	Kind          int    `json:"kind"`
-	Detail        string `json:"detail"`
-	Documentation string `json:"documentation"`
-	InsertText    string `json:"insertText"`
+	Label               string          `json:"label"`
+	Kind                int             `json:"kind"`
+	Detail              string          `json:"detail"`
+	Documentation       string          `json:"documentation"`
+	InsertText          string          `json:"insertText"`
+	InsertTextFormat    int             `json:"insertTextFormat"` // 1 = PlainText, 2 = Snippet (see snippet.go).
+	TextEdit            *TextEdit       `json:"textEdit"`
+	AdditionalTextEdits []TextEdit      `json:"additionalTextEdits"`
+	SortText            string          `json:"sortText"`
+	FilterText          string          `json:"filterText"`
+	CommitCharacters    []string        `json:"commitCharacters"`
+	Data                json.RawMessage `json:"data"` // Opaque, server-owned; round-tripped back on completionItem/resolve (see ResolveCompletionItem).
 }
 
 // CompletionList represents a collection of completion items.
@@ -73,6 +148,31 @@ type CompletionList struct {
 	Items        []CompletionItem `json:"items"`
 }
 
+// ParamInfo describes one parameter of a signature as a [Start, End) rune
+// range into the owning SignatureInfo's Label, plus any documentation the
+// server attached to that parameter specifically.
+type ParamInfo struct {
+	Start int
+	End   int
+	Doc   string
+}
+
+// SignatureInfo describes the signature of the call enclosing the cursor,
+// as returned by textDocument/signatureHelp, along with which parameter the
+// cursor currently sits on. Overloads carries every signature candidate the
+// server returned (e.g. gopls listing each overloaded method), in case the
+// server picked the wrong one as ActiveSignature and the user wants to cycle
+// through the rest; Label/Parameters/ActiveParameter always describe
+// Overloads[ActiveSignature].
+type SignatureInfo struct {
+	Label           string
+	Parameters      []ParamInfo
+	ActiveParameter int
+
+	Overloads       []SignatureInfo
+	ActiveSignature int
+}
+
 // Diagnostic represents an error, warning, or hint from the language server.
 type Diagnostic struct {
 	Range struct {
@@ -89,205 +189,196 @@ type Diagnostic struct {
 	Message  string `json:"message"`
 }
 
-// NewLSPClient starts a new LSP server process for the given file type.
+// NewLSPClient starts a new LSP server process for the given file type and
+// opens filename as its only document. This is the standalone path used by
+// --lsp-replay (which has no real connection to share) and as the single
+// building block newLSPConn/openDocument are split out of; ordinary
+// buffers go through LSPManager.Open (lsp_manager.go) instead, so that
+// several files under the same workspace root share one server process.
 func NewLSPClient(filename string, fileContent string, logCallback func(string, string), ft *FileType) (*LSPClient, error) {
 	absPath, err := filepath.Abs(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	client := &LSPClient{
-		filename:    absPath,
-		uri:         "file://" + absPath,
-		diagnostics: []Diagnostic{},
-		logCallback: logCallback,
-		responses:   make(map[int64]chan map[string]interface{}),
-		fileType:    ft,
+	// --lsp-replay feeds a recorded --lsp-trace log back through
+	// processMessage instead of spawning a real server; see lsp_replay.go.
+	if Config.LSPReplayPath != "" {
+		return NewLSPClientFromReplay(Config.LSPReplayPath, absPath, logCallback, ft)
 	}
 
-	// Launch the language server's executable.
-	client.cmd = exec.Command(ft.LSPCommand, ft.LSPCommandArgs...)
-
-	// Suppress the server's own internal log messages (stderr).
-	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
-	if err == nil {
-		client.cmd.Stderr = devNull
-	}
+	root := findProjectRoot(absPath, ft.LSPRootPatterns)
 
-	client.stdin, err = client.cmd.StdinPipe()
+	conn, err := newLSPConn(ft, root, logCallback)
 	if err != nil {
 		return nil, err
 	}
 
-	client.stdout, err = client.cmd.StdoutPipe()
+	client, err := conn.openDocument(absPath, fileContent)
 	if err != nil {
+		conn.shutdownProcess()
 		return nil, err
 	}
 
-	if err := client.cmd.Start(); err != nil {
-		return nil, err
-	}
-
-	// Start a background goroutine to read messages from the server's stdout.
-	go client.readMessages()
+	return client, nil
+}
 
-	// Perform the LSP handshake: Initialize and Notify Open.
-	if err := client.initialize(); err != nil {
-		client.Shutdown()
-		return nil, err
+// newLSPConn launches a language server's executable for ft, rooted (if
+// root is non-empty) at that directory, and performs the initialize
+// handshake. It has no open documents yet; call openDocument to add one.
+func newLSPConn(ft *FileType, root string, logCallback func(string, string)) (*lspConn, error) {
+	conn := &lspConn{
+		logCallback: logCallback,
+		fileType:    ft,
+		root:        root,
+		docs:        make(map[string]*LSPClient),
 	}
 
-	if err := client.sendDidOpen(fileContent); err != nil {
-		client.Shutdown()
-		return nil, err
+	if Config.LSPTracePath != "" {
+		traceFile, err := os.OpenFile(Config.LSPTracePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("opening lsp-trace file: %w", err)
+		}
+		conn.traceFile = traceFile
 	}
 
-	return client, nil
-}
+	conn.cmd = exec.Command(ft.LSPCommand, ft.LSPCommandArgs...)
+	if conn.root != "" {
+		conn.cmd.Dir = conn.root
+	}
 
-// nextID increments and returns the next request ID.
-func (c *LSPClient) nextID() int64 {
-	return atomic.AddInt64(&c.messageID, 1)
-}
+	// Suppress the server's own internal log messages (stderr).
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err == nil {
+		conn.cmd.Stderr = devNull
+	}
 
-// sendRequest sends a JSON-RPC request and expects a response.
-func (c *LSPClient) sendRequest(method string, params interface{}) error {
-	id := c.nextID()
-	request := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"id":      id,
-		"method":  method,
-		"params":  params,
+	var stdinErr, stdoutErr error
+	conn.stdin, stdinErr = conn.cmd.StdinPipe()
+	if stdinErr != nil {
+		return nil, stdinErr
 	}
-	return c.sendMessage(request)
-}
 
-// sendNotification sends a JSON-RPC message without expecting a response.
-func (c *LSPClient) sendNotification(method string, params interface{}) error {
-	notification := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"method":  method,
-		"params":  params,
+	conn.stdout, stdoutErr = conn.cmd.StdoutPipe()
+	if stdoutErr != nil {
+		return nil, stdoutErr
 	}
-	return c.sendMessage(notification)
-}
 
-// sendMessage writes a JSON-encoded message to the server's stdin.
-func (c *LSPClient) sendMessage(msg interface{}) error {
-	if c.shutdown {
-		return fmt.Errorf("client is shutdown")
+	if err := conn.cmd.Start(); err != nil {
+		return nil, err
 	}
 
-	data, err := json.Marshal(msg)
-	if err != nil {
-		return err
+	// conn (the Handler) is fully built before rpc.Run ever starts reading,
+	// so a notification can't race a still-being-constructed receiver the
+	// way a single do-everything client previously could.
+	conn.rpc = NewConn(NewStream(conn.stdout, conn.stdin), conn, conn.writeTrace)
+	ctx, cancel := context.WithCancel(context.Background())
+	conn.cancel = cancel
+	go conn.rpc.Run(ctx)
+
+	if err := conn.initialize(); err != nil {
+		conn.shutdownProcess()
+		return nil, err
 	}
 
-	// LSP messages use a header similar to HTTP: Content-Length followed by \r\n\r\n.
-	content := fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(data), data)
-	_, err = c.stdin.Write([]byte(content))
-	return err
+	return conn, nil
 }
 
-// readMessages loops forever, parsing messages from the server's stdout.
-func (c *LSPClient) readMessages() {
-	reader := bufio.NewReader(c.stdout)
+// openDocument sends textDocument/didOpen for absPath on this connection and
+// registers the resulting LSPClient in conn.docs, so publishDiagnostics and
+// didChangeWatchedFiles broadcasts (lsp_manager.go) can find it by URI.
+func (conn *lspConn) openDocument(absPath string, content string) (*LSPClient, error) {
+	client := &LSPClient{
+		conn:        conn,
+		filename:    absPath,
+		uri:         "file://" + absPath,
+		diagnostics: []Diagnostic{},
+		fileType:    conn.fileType,
+	}
 
-	for {
-		if c.shutdown {
-			return
-		}
+	if err := client.sendDidOpen(content); err != nil {
+		return nil, err
+	}
 
-		// Parse the Content-Length header to know how many bytes to read next.
-		contentLength := 0
-		for {
-			line, err := reader.ReadString('\n')
-			if err != nil {
-				return
-			}
+	conn.docsMutex.Lock()
+	conn.docs[client.uri] = client
+	conn.docsMutex.Unlock()
 
-			line = strings.TrimSpace(line)
-			if line == "" {
-				break
-			}
+	return client, nil
+}
 
-			var length int
-			if n, _ := fmt.Sscanf(line, "Content-Length: %d", &length); n == 1 {
-				contentLength = length
+// findProjectRoot walks up from the directory containing absPath looking
+// for any of patterns, and returns the first directory that contains a
+// match. Returns "" if patterns is empty or no match is found before
+// reaching the filesystem root, in which case the caller falls back to
+// the server's own working directory.
+func findProjectRoot(absPath string, patterns []string) string {
+	if len(patterns) == 0 {
+		return ""
+	}
+	dir := filepath.Dir(absPath)
+	for {
+		for _, pattern := range patterns {
+			if _, err := os.Stat(filepath.Join(dir, pattern)); err == nil {
+				return dir
 			}
 		}
-
-		if contentLength == 0 {
-			continue
-		}
-
-		// Read the JSON body.
-		buf := make([]byte, contentLength)
-		_, err := io.ReadFull(reader, buf)
-		if err != nil {
-			return
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
 		}
+		dir = parent
+	}
+}
 
-		var msg map[string]interface{}
-		if err := json.Unmarshal(buf, &msg); err != nil {
-			continue
-		}
+// request issues method via conn.rpc, bounded by timeout (or by ctx, if the
+// caller passed one that finishes first), and maps a canceled/timed-out
+// context into the same "LSP request timeout"/"LSP request canceled" errors
+// every request method returned before Conn.Request existed.
+func (conn *lspConn) request(ctx context.Context, method string, params interface{}, timeout time.Duration) (map[string]interface{}, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-		// If the message has an "id", it's a response to a request we sent.
-		if idVal, hasID := msg["id"]; hasID {
-			if c.logCallback != nil {
-				c.logCallback("LSP", fmt.Sprintf("Received response with ID: %v (type: %T)", idVal, idVal))
-			}
-			if id, ok := idVal.(float64); ok {
-				idInt := int64(id)
-				if c.logCallback != nil {
-					c.logCallback("LSP", fmt.Sprintf("Looking for response channel with ID=%d", idInt))
-				}
-				c.responseMutex.Lock()
-				ch, exists := c.responses[idInt]
-				if exists {
-					if c.logCallback != nil {
-						c.logCallback("LSP", fmt.Sprintf("Found channel for ID=%d, sending response", idInt))
-					}
-					delete(c.responses, idInt)
-					c.responseMutex.Unlock()
-					ch <- msg // Send response to the goroutine waiting for it.
-				} else {
-					if c.logCallback != nil {
-						c.logCallback("LSP", fmt.Sprintf("No channel found for ID=%d", idInt))
-					}
-					c.responseMutex.Unlock()
-				}
-			} else {
-				if c.logCallback != nil {
-					c.logCallback("LSP", fmt.Sprintf("Failed to convert ID to int64: %v", idVal))
-				}
-			}
+	resp, err := conn.rpc.Request(reqCtx, method, params)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, fmt.Errorf("LSP request timeout")
 		}
-
-		// If it has no "id", it's an asynchronous notification (like updated diagnostics).
-		if _, hasID := msg["id"]; !hasID {
-			c.handleNotification(msg)
+		if errors.Is(err, context.Canceled) {
+			return nil, fmt.Errorf("LSP request canceled")
 		}
+		return nil, err
 	}
+	return resp, nil
+}
+
+func (c *LSPClient) request(ctx context.Context, method string, params interface{}, timeout time.Duration) (map[string]interface{}, error) {
+	return c.conn.request(ctx, method, params, timeout)
 }
 
-// handleNotification processes messages initiated by the server.
-func (c *LSPClient) handleNotification(msg map[string]interface{}) {
+// Handle implements jsonrpc2's Handler: it's called by conn.rpc.Run for
+// every message that isn't a reply to a pending Request - i.e. server-
+// initiated requests and notifications. Diagnostics are routed by URI to
+// the right LSPClient; window/logMessage and window/showMessage are
+// forwarded into the editor's debug log.
+func (conn *lspConn) Handle(msg map[string]interface{}) {
 	method, ok := msg["method"].(string)
 	if !ok {
 		return
 	}
 
-	// Server is sending updated errors/warnings for the file.
-	if method == "textDocument/publishDiagnostics" {
+	switch method {
+	case "textDocument/publishDiagnostics":
 		params, ok := msg["params"].(map[string]interface{})
 		if !ok {
 			return
 		}
 
 		uri, _ := params["uri"].(string)
-		if uri != c.uri {
+		conn.docsMutex.Lock()
+		doc, exists := conn.docs[uri]
+		conn.docsMutex.Unlock()
+		if !exists {
 			return
 		}
 
@@ -305,21 +396,53 @@ func (c *LSPClient) handleNotification(msg map[string]interface{}) {
 			}
 		}
 
-		c.diagMutex.Lock()
-		c.diagnostics = diags
-		c.diagMutex.Unlock()
+		doc.diagMutex.Lock()
+		doc.diagnostics = diags
+		doc.diagMutex.Unlock()
 
 		// Tell termbox to refresh the UI so signs appear in the gutter.
 		termbox.Interrupt()
+
+	case "window/logMessage", "window/showMessage":
+		if conn.logCallback == nil {
+			return
+		}
+		params, ok := msg["params"].(map[string]interface{})
+		if !ok {
+			return
+		}
+		text, _ := params["message"].(string)
+		conn.logCallback("LSP", text)
 	}
 }
 
-// initialize sends the initial 'initialize' request to the server.
-func (c *LSPClient) initialize() error {
-	rootURI := "file://" + filepath.Dir(c.filename)
+// initialize sends the initial 'initialize' request to the server. rootUri
+// (and the deprecated but still widely read rootPath) prefer the project
+// root discovered by findProjectRoot over just the file's own directory, so
+// servers like gopls/clangd see the whole module/compilation database
+// instead of a single-file workspace. workspaceFolders mirrors the same
+// root in the newer, preferred shape.
+//
+// Unlike sendDidOpen/SendDidChange (fire-and-forget notifications),
+// initialize waits for the server's response the same way Definition/Hover
+// do, because the result carries capabilities.signatureHelpProvider.
+// triggerCharacters — the characters ('(' and ',' for most languages, but
+// e.g. ':' for Lua method calls) that should re-query signature help as the
+// user types. See signatureTriggerChars.
+func (conn *lspConn) initialize() error {
+	rootDir := conn.root
+	if rootDir == "" {
+		rootDir, _ = os.Getwd()
+	}
+	rootURI := "file://" + rootDir
+
 	params := map[string]interface{}{
 		"processId": os.Getpid(),
 		"rootUri":   rootURI,
+		"rootPath":  rootDir,
+		"workspaceFolders": []map[string]interface{}{
+			{"uri": rootURI, "name": filepath.Base(rootDir)},
+		},
 		"capabilities": map[string]interface{}{
 			"textDocument": map[string]interface{}{
 				"publishDiagnostics": map[string]interface{}{},
@@ -328,18 +451,105 @@ func (c *LSPClient) initialize() error {
 				},
 				"completion": map[string]interface{}{
 					"completionItem": map[string]interface{}{
-						"snippetSupport": false,
+						"snippetSupport": true,
+						"resolveSupport": map[string]interface{}{
+							"properties": []string{"documentation", "detail", "additionalTextEdits"},
+						},
+					},
+				},
+				// labelOffsetSupport asks the server for [start, end) rune
+				// offsets into a signature's label instead of (or in addition
+				// to) the literal parameter substring, which SignatureHelp
+				// already knows how to consume (see its []interface{} case)
+				// and is more reliable when a parameter's text repeats
+				// elsewhere in the signature.
+				"signatureHelp": map[string]interface{}{
+					"signatureInformation": map[string]interface{}{
+						"parameterInformation": map[string]interface{}{
+							"labelOffsetSupport": true,
+						},
 					},
 				},
 			},
 		},
 	}
 
-	if err := c.sendRequest("initialize", params); err != nil {
+	if len(conn.fileType.LSPInitOptions) > 0 {
+		params["initializationOptions"] = conn.fileType.LSPInitOptions
+	}
+
+	resp, err := conn.request(context.Background(), "initialize", params, lspRequestTimeout)
+	if err != nil {
 		return err
 	}
 
-	return c.sendNotification("initialized", map[string]interface{}{})
+	conn.signatureTriggerChars = extractTriggerCharacters(resp["result"])
+	conn.incrementalSync = extractIncrementalSync(resp["result"])
+
+	return conn.rpc.Notify("initialized", map[string]interface{}{})
+}
+
+// extractTriggerCharacters digs capabilities.signatureHelpProvider.
+// triggerCharacters out of an initialize result of unknown shape (the
+// result is decoded as map[string]interface{}, so every level needs its own
+// type assertion). Returns nil if the server didn't advertise any, in which
+// case callers fall back to a hardcoded "(" and ",".
+func extractTriggerCharacters(result interface{}) []string {
+	resMap, ok := result.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	caps, ok := resMap["capabilities"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	sigProvider, ok := caps["signatureHelpProvider"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	raw, ok := sigProvider["triggerCharacters"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	chars := make([]string, 0, len(raw))
+	for _, c := range raw {
+		if s, ok := c.(string); ok {
+			chars = append(chars, s)
+		}
+	}
+	return chars
+}
+
+// extractIncrementalSync digs capabilities.textDocumentSync out of an
+// initialize result, reporting whether the server asked for Incremental
+// (TextDocumentSyncKind 2) change notifications. textDocumentSync can
+// legally be either a bare number or a {openClose, change, ...} object, per
+// the LSP spec's "TextDocumentSyncOptions | number" union; a server that
+// omits it entirely defaults to Full (1) sync, same as the LSP spec.
+func extractIncrementalSync(result interface{}) bool {
+	resMap, ok := result.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	caps, ok := resMap["capabilities"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	sync, ok := caps["textDocumentSync"]
+	if !ok {
+		return false
+	}
+
+	if kind, ok := sync.(float64); ok {
+		return int(kind) == 2
+	}
+	if syncObj, ok := sync.(map[string]interface{}); ok {
+		if kind, ok := syncObj["change"].(float64); ok {
+			return int(kind) == 2
+		}
+	}
+	return false
 }
 
 // sendDidOpen notifies the server that a file has been opened.
@@ -353,23 +563,104 @@ func (c *LSPClient) sendDidOpen(content string) error {
 			"text":       content,
 		},
 	}
-	return c.sendNotification("textDocument/didOpen", params)
+	return c.conn.rpc.Notify("textDocument/didOpen", params)
 }
 
-// SendDidChange notifies the server of changes to the document content.
+// SendDidChange notifies the server that the document's content is now
+// content in its entirety: a Full-sync didChange, sent immediately rather
+// than through NotifyChange's coalescing. Used for wholesale rewrites
+// (reload from disk, external-editor round-trip, formatting, applying a
+// WorkspaceEdit) where there's no single edit range to describe and the
+// cost of shipping the whole buffer once isn't the problem NotifyChange's
+// per-keystroke coalescing exists to solve. Drops any incremental edits
+// NotifyChange was still batching, since this content already supersedes
+// them.
 func (c *LSPClient) SendDidChange(content string) error {
+	c.pendingMutex.Lock()
+	if c.changeTimer != nil {
+		c.changeTimer.Stop()
+	}
+	c.pendingChanges = nil
+	c.pendingMutex.Unlock()
+
+	c.docVersion++
 	params := map[string]interface{}{
 		"textDocument": map[string]interface{}{
 			"uri":     c.uri,
-			"version": c.nextID(),
+			"version": c.docVersion,
 		},
-		"contentChanges": []interface{}{
-			map[string]interface{}{
-				"text": content,
+		"contentChanges": []TextDocumentContentChangeEvent{{Text: content}},
+	}
+	return c.conn.rpc.Notify("textDocument/didChange", params)
+}
+
+// NotifyChange queues an edit for textDocument/didChange, coalescing it with
+// any other edits that land within changeCoalesceDebounce (see
+// pendingChanges) into a single notification instead of one per keystroke.
+// When the server advertised Incremental sync (c.conn.incrementalSync), the
+// edit is queued as a Range+Text change; otherwise fullContent() is called
+// (only when actually needed, so callers like insertRune can pass a closure
+// over b.toString() instead of paying for it on every keystroke against a
+// Full-sync server) and the whole document replaces any previously queued
+// changes, since a Full-sync server only ever wants the latest snapshot.
+func (c *LSPClient) NotifyChange(startLine, startCh, endLine, endCh int, text string, fullContent func() string) {
+	c.pendingMutex.Lock()
+	if c.conn.incrementalSync {
+		c.pendingChanges = append(c.pendingChanges, TextDocumentContentChangeEvent{
+			Range: &Range{
+				Start: Position{Line: startLine, Character: startCh},
+				End:   Position{Line: endLine, Character: endCh},
 			},
+			Text: text,
+		})
+	} else {
+		c.pendingChanges = []TextDocumentContentChangeEvent{{Text: fullContent()}}
+	}
+
+	if c.changeTimer != nil {
+		c.changeTimer.Stop()
+	}
+	c.changeTimer = time.AfterFunc(changeCoalesceDebounce, c.flushPendingChanges)
+	c.pendingMutex.Unlock()
+}
+
+// flushPendingChanges sends every change NotifyChange has queued since the
+// last flush as one textDocument/didChange notification, in the order they
+// were made (LSP applies contentChanges sequentially).
+func (c *LSPClient) flushPendingChanges() {
+	c.pendingMutex.Lock()
+	changes := c.pendingChanges
+	c.pendingChanges = nil
+	c.pendingMutex.Unlock()
+	if len(changes) == 0 {
+		return
+	}
+
+	c.docVersion++
+	params := map[string]interface{}{
+		"textDocument": map[string]interface{}{
+			"uri":     c.uri,
+			"version": c.docVersion,
 		},
+		"contentChanges": changes,
 	}
-	return c.sendNotification("textDocument/didChange", params)
+	c.conn.rpc.Notify("textDocument/didChange", params)
+}
+
+// NotifyWatchedFileChanged sends workspace/didChangeWatchedFiles for uri
+// with the given FileChangeType (1=Created, 2=Changed, 3=Deleted), so the
+// server picks up an out-of-band edit (e.g. a file touched by `go generate`
+// or another editor) to a file it wasn't necessarily told about via
+// didOpen. Called for every document on this connection whenever
+// CheckFilesOnDisk (editor.go) notices a change, since the server has no
+// way to watch the filesystem itself.
+func (conn *lspConn) NotifyWatchedFileChanged(uri string, changeType int) error {
+	params := map[string]interface{}{
+		"changes": []map[string]interface{}{
+			{"uri": uri, "type": changeType},
+		},
+	}
+	return conn.rpc.Notify("workspace/didChangeWatchedFiles", params)
 }
 
 // GetDiagnostics returns a copy of the current file diagnostics.
@@ -382,9 +673,12 @@ func (c *LSPClient) GetDiagnostics() []Diagnostic {
 	return result
 }
 
-// Definition requests the location of the definition of the symbol at cursor.
-func (c *LSPClient) Definition(line, character int) ([]Location, error) {
-	id := c.nextID()
+// Definition requests the location of the definition of the symbol at
+// cursor. ctx lets a caller cancel the request before it completes, sending
+// $/cancelRequest instead of leaving it to run out lspRequestTimeout; gotoDefinition
+// (editor.go) runs synchronously on the single event-loop goroutine and has
+// nothing else to supersede it with, so it just passes context.Background().
+func (c *LSPClient) Definition(ctx context.Context, line, character int) ([]Location, error) {
 	params := map[string]interface{}{
 		"textDocument": map[string]interface{}{
 			"uri": c.uri,
@@ -395,54 +689,40 @@ func (c *LSPClient) Definition(line, character int) ([]Location, error) {
 		},
 	}
 
-	responseChan := make(chan map[string]interface{}, 1)
-	c.responseMutex.Lock()
-	c.responses[id] = responseChan
-	c.responseMutex.Unlock()
-
-	if err := c.sendRequestWithID(id, "textDocument/definition", params); err != nil {
-		c.responseMutex.Lock()
-		delete(c.responses, id)
-		c.responseMutex.Unlock()
+	resp, err := c.request(ctx, "textDocument/definition", params, lspRequestTimeout)
+	if err != nil {
 		return nil, err
 	}
+	if lspErr, ok := resp["error"]; ok {
+		return nil, fmt.Errorf("LSP error: %v", lspErr)
+	}
 
-	select {
-	case resp := <-responseChan:
-		if err, ok := resp["error"]; ok {
-			return nil, fmt.Errorf("LSP error: %v", err)
-		}
-
-		result := resp["result"]
-		if result == nil {
-			return nil, nil
-		}
-
-		resJSON, _ := json.Marshal(result)
+	result := resp["result"]
+	if result == nil {
+		return nil, nil
+	}
 
-		// Definition can return a single Location or an array of them.
-		var loc Location
-		if err := json.Unmarshal(resJSON, &loc); err == nil && loc.URI != "" {
-			return []Location{loc}, nil
-		}
+	resJSON, _ := json.Marshal(result)
 
-		var locs []Location
-		if err := json.Unmarshal(resJSON, &locs); err == nil {
-			return locs, nil
-		}
+	// Definition can return a single Location or an array of them.
+	var loc Location
+	if err := json.Unmarshal(resJSON, &loc); err == nil && loc.URI != "" {
+		return []Location{loc}, nil
+	}
 
-		return nil, nil
-	case <-time.After(5 * time.Second):
-		c.responseMutex.Lock()
-		delete(c.responses, id)
-		c.responseMutex.Unlock()
-		return nil, fmt.Errorf("LSP request timeout")
+	var locs []Location
+	if err := json.Unmarshal(resJSON, &locs); err == nil {
+		return locs, nil
 	}
+
+	return nil, nil
 }
 
-// Hover requests documentation information for the symbol at cursor.
-func (c *LSPClient) Hover(line, character int) (string, error) {
-	id := c.nextID()
+// Hover requests documentation information for the symbol at cursor. ctx
+// lets a caller cancel the request the same way Completion's does; like
+// gotoDefinition, triggerHover (editor.go) runs synchronously with nothing
+// to supersede it, so it just passes context.Background().
+func (c *LSPClient) Hover(ctx context.Context, line, character int) (string, error) {
 	params := map[string]interface{}{
 		"textDocument": map[string]interface{}{
 			"uri": c.uri,
@@ -453,82 +733,69 @@ func (c *LSPClient) Hover(line, character int) (string, error) {
 		},
 	}
 
-	responseChan := make(chan map[string]interface{}, 1)
-	c.responseMutex.Lock()
-	c.responses[id] = responseChan
-	c.responseMutex.Unlock()
-
-	if err := c.sendRequestWithID(id, "textDocument/hover", params); err != nil {
-		c.responseMutex.Lock()
-		delete(c.responses, id)
-		c.responseMutex.Unlock()
+	resp, err := c.request(ctx, "textDocument/hover", params, lspRequestTimeout)
+	if err != nil {
 		return "", err
 	}
+	if lspErr, ok := resp["error"]; ok {
+		return "", fmt.Errorf("LSP error: %v", lspErr)
+	}
 
-	select {
-	case resp := <-responseChan:
-		if err, ok := resp["error"]; ok {
-			return "", fmt.Errorf("LSP error: %v", err)
-		}
-
-		result := resp["result"]
-		if result == nil {
-			return "", nil
-		}
+	result := resp["result"]
+	if result == nil {
+		return "", nil
+	}
 
-		// Hover responses are complex: they can be strings, objects, or arrays.
-		resMap, ok := result.(map[string]interface{})
-		if !ok {
-			return "", nil
-		}
+	// Hover responses are complex: they can be strings, objects, or arrays.
+	resMap, ok := result.(map[string]interface{})
+	if !ok {
+		return "", nil
+	}
 
-		contents := resMap["contents"]
-		if contents == nil {
-			return "", nil
-		}
+	contents := resMap["contents"]
+	if contents == nil {
+		return "", nil
+	}
 
-		if mc, ok := contents.(map[string]interface{}); ok {
-			if val, ok := mc["value"].(string); ok {
-				return stripMarkdown(val), nil
-			}
+	if mc, ok := contents.(map[string]interface{}); ok {
+		if val, ok := mc["value"].(string); ok {
+			return val, nil
 		}
+	}
 
-		if s, ok := contents.(string); ok {
-			return stripMarkdown(s), nil
-		}
+	if s, ok := contents.(string); ok {
+		return s, nil
+	}
 
-		if ss, ok := contents.([]interface{}); ok {
-			var result strings.Builder
-			for i, s := range ss {
-				if str, ok := s.(string); ok {
-					result.WriteString(stripMarkdown(str))
+	if ss, ok := contents.([]interface{}); ok {
+		var result strings.Builder
+		for i, s := range ss {
+			if str, ok := s.(string); ok {
+				result.WriteString(str)
+				if i < len(ss)-1 {
+					result.WriteString("\n")
+				}
+			} else if m, ok := s.(map[string]interface{}); ok {
+				if val, ok := m["value"].(string); ok {
+					result.WriteString(val)
 					if i < len(ss)-1 {
 						result.WriteString("\n")
 					}
-				} else if m, ok := s.(map[string]interface{}); ok {
-					if val, ok := m["value"].(string); ok {
-						result.WriteString(stripMarkdown(val))
-						if i < len(ss)-1 {
-							result.WriteString("\n")
-						}
-					}
 				}
 			}
-			return strings.TrimSpace(result.String()), nil
 		}
-
-		return "", nil
-	case <-time.After(5 * time.Second):
-		c.responseMutex.Lock()
-		delete(c.responses, id)
-		c.responseMutex.Unlock()
-		return "", fmt.Errorf("LSP request timeout")
+		return strings.TrimSpace(result.String()), nil
 	}
+
+	return "", nil
 }
 
 // Completion requests a list of completion items for the symbol at cursor.
-func (c *LSPClient) Completion(line, character int) ([]CompletionItem, error) {
-	id := c.nextID()
+// ctx is canceled by triggerAutocomplete (editor.go) if the cursor moves
+// again before the server replies, so a fast typist doesn't leave a pending
+// request (and its eventual, now-useless response) running for the full
+// lspCompletionTimeout.
+func (c *LSPClient) Completion(ctx context.Context, line, character int) ([]CompletionItem, error) {
 	params := map[string]interface{}{
 		"textDocument": map[string]interface{}{
 			"uri": c.uri,
@@ -539,89 +806,401 @@ func (c *LSPClient) Completion(line, character int) ([]CompletionItem, error) {
 		},
 	}
 
-	if c.logCallback != nil {
-		c.logCallback("LSP", fmt.Sprintf("Requesting completion at %d:%d (ID=%d)", line, character, id))
+	if c.conn.logCallback != nil {
+		c.conn.logCallback("LSP", fmt.Sprintf("Requesting completion at %d:%d", line, character))
 	}
 
-	responseChan := make(chan map[string]interface{}, 1)
-	c.responseMutex.Lock()
-	c.responses[id] = responseChan
-	c.responseMutex.Unlock()
-
-	if err := c.sendRequestWithID(id, "textDocument/completion", params); err != nil {
-		c.responseMutex.Lock()
-		delete(c.responses, id)
-		c.responseMutex.Unlock()
+	resp, err := c.request(ctx, "textDocument/completion", params, lspCompletionTimeout)
+	if err != nil {
+		if c.conn.logCallback != nil {
+			c.conn.logCallback("LSP", fmt.Sprintf("Completion request did not complete: %v", err))
+		}
 		return nil, err
 	}
+	if c.conn.logCallback != nil {
+		c.conn.logCallback("LSP", "Received completion response")
+	}
+	if lspErr, ok := resp["error"]; ok {
+		return nil, fmt.Errorf("LSP error: %v", lspErr)
+	}
 
-	select {
-	case resp := <-responseChan:
-		if c.logCallback != nil {
-			c.logCallback("LSP", fmt.Sprintf("Received completion response (ID=%d)", id))
-		}
-		if err, ok := resp["error"]; ok {
-			return nil, fmt.Errorf("LSP error: %v", err)
-		}
+	result := resp["result"]
+	if result == nil {
+		return nil, nil
+	}
+
+	resJSON, _ := json.Marshal(result)
+
+	// Completion can return a CompletionList or an array of CompletionItems.
+	var compList CompletionList
+	if err := json.Unmarshal(resJSON, &compList); err == nil {
+		return compList.Items, nil
+	}
+
+	var compItems []CompletionItem
+	if err := json.Unmarshal(resJSON, &compItems); err == nil {
+		return compItems, nil
+	}
+
+	return nil, nil
+}
+
+// ResolveCompletionItem issues completionItem/resolve for item, asking the
+// server to fill in the documentation/detail/additionalTextEdits it omitted
+// from the original textDocument/completion response to keep that response
+// cheap (gopls in particular only computes these lazily). item.Data is what
+// lets the server find the completion candidate again; an item with no Data
+// is returned unchanged since there's nothing to resolve against.
+func (c *LSPClient) ResolveCompletionItem(item CompletionItem) (CompletionItem, error) {
+	if len(item.Data) == 0 {
+		return item, nil
+	}
 
-		result := resp["result"]
-		if result == nil {
-			return nil, nil
+	resp, err := c.request(context.Background(), "completionItem/resolve", item, lspRequestTimeout)
+	if err != nil {
+		return item, err
+	}
+	if lspErr, ok := resp["error"]; ok {
+		return item, fmt.Errorf("LSP error: %v", lspErr)
+	}
+	if resp["result"] == nil {
+		return item, nil
+	}
+	resJSON, _ := json.Marshal(resp["result"])
+	var resolved CompletionItem
+	if err := json.Unmarshal(resJSON, &resolved); err != nil {
+		return item, err
+	}
+	return resolved, nil
+}
+
+// IsSignatureTriggerChar reports whether ch should re-request signature
+// help while typing, preferring the server-advertised
+// signatureTriggerChars and falling back to the common "(" and "," if the
+// server didn't advertise any (or initialize hasn't completed yet).
+func (c *LSPClient) IsSignatureTriggerChar(ch rune) bool {
+	if len(c.conn.signatureTriggerChars) == 0 {
+		return ch == '(' || ch == ','
+	}
+	s := string(ch)
+	for _, t := range c.conn.signatureTriggerChars {
+		if t == s {
+			return true
 		}
+	}
+	return false
+}
+
+// SignatureHelp requests the signature of the function call enclosing the
+// cursor, identifying which parameter is currently being typed so the
+// caller can highlight it.
+func (c *LSPClient) SignatureHelp(line, character int) (SignatureInfo, error) {
+	params := map[string]interface{}{
+		"textDocument": map[string]interface{}{
+			"uri": c.uri,
+		},
+		"position": map[string]interface{}{
+			"line":      line,
+			"character": character,
+		},
+	}
+
+	resp, err := c.request(context.Background(), "textDocument/signatureHelp", params, lspRequestTimeout)
+	if err != nil {
+		return SignatureInfo{}, err
+	}
+	if lspErr, ok := resp["error"]; ok {
+		return SignatureInfo{}, fmt.Errorf("LSP error: %v", lspErr)
+	}
 
-		resJSON, _ := json.Marshal(result)
+	result := resp["result"]
+	if result == nil {
+		return SignatureInfo{}, nil
+	}
+
+	var raw struct {
+		Signatures []struct {
+			Label      string `json:"label"`
+			Parameters []struct {
+				Label         interface{} `json:"label"`
+				Documentation interface{} `json:"documentation"`
+			} `json:"parameters"`
+		} `json:"signatures"`
+		ActiveSignature int `json:"activeSignature"`
+		ActiveParameter int `json:"activeParameter"`
+	}
+	resJSON, _ := json.Marshal(result)
+	if err := json.Unmarshal(resJSON, &raw); err != nil {
+		return SignatureInfo{}, err
+	}
+	if len(raw.Signatures) == 0 {
+		return SignatureInfo{}, nil
+	}
 
-		// Completion can return a CompletionList or an array of CompletionItems.
-		var compList CompletionList
-		if err := json.Unmarshal(resJSON, &compList); err == nil {
-			return compList.Items, nil
+	sigIdx := raw.ActiveSignature
+	if sigIdx < 0 || sigIdx >= len(raw.Signatures) {
+		sigIdx = 0
+	}
+
+	overloads := make([]SignatureInfo, len(raw.Signatures))
+	for i, sig := range raw.Signatures {
+		overloads[i] = SignatureInfo{Label: sig.Label}
+		if i == sigIdx {
+			overloads[i].ActiveParameter = raw.ActiveParameter
 		}
 
-		var compItems []CompletionItem
-		if err := json.Unmarshal(resJSON, &compItems); err == nil {
-			return compItems, nil
+		// Parameter labels come back either as a [start, end) offset
+		// pair into Label or as the literal substring; resolve both to
+		// offsets so the popup can highlight the active one without
+		// re-parsing.
+		searchFrom := 0
+		for _, p := range sig.Parameters {
+			var start, end int
+			switch lbl := p.Label.(type) {
+			case []interface{}:
+				if len(lbl) == 2 {
+					if s, ok := lbl[0].(float64); ok {
+						start = int(s)
+					}
+					if en, ok := lbl[1].(float64); ok {
+						end = int(en)
+					}
+				}
+			case string:
+				if idx := strings.Index(sig.Label[searchFrom:], lbl); idx != -1 {
+					start = searchFrom + idx
+					end = start + len(lbl)
+					searchFrom = end
+				}
+			}
+
+			doc := ""
+			switch d := p.Documentation.(type) {
+			case string:
+				doc = stripMarkdown(d)
+			case map[string]interface{}:
+				if v, ok := d["value"].(string); ok {
+					doc = stripMarkdown(v)
+				}
+			}
+
+			overloads[i].Parameters = append(overloads[i].Parameters, ParamInfo{Start: start, End: end, Doc: doc})
 		}
+	}
+
+	info := overloads[sigIdx]
+	info.Overloads = overloads
+	info.ActiveSignature = sigIdx
+
+	return info, nil
+}
 
+// WorkspaceEdit is the LSP WorkspaceEdit type returned by rename and code
+// actions: a set of per-file edits, expressed either as a flat URI->TextEdit[]
+// map (Changes) or as an ordered DocumentChanges list. qwe only applies the
+// edit side of DocumentChanges; create/rename/delete entries (which carry no
+// "edits" field) are skipped by applyWorkspaceEdit (workspaceedit.go).
+type WorkspaceEdit struct {
+	Changes         map[string][]TextEdit `json:"changes"`
+	DocumentChanges []struct {
+		TextDocument struct {
+			URI string `json:"uri"`
+		} `json:"textDocument"`
+		Edits []TextEdit `json:"edits"`
+	} `json:"documentChanges"`
+}
+
+// LSPCommand is an arbitrary server-defined command, as returned in a
+// CodeAction's Command field or standalone from a client-side command UI.
+type LSPCommand struct {
+	Title     string        `json:"title"`
+	Command   string        `json:"command"`
+	Arguments []interface{} `json:"arguments"`
+}
+
+// CodeAction is one entry of a textDocument/codeAction response: either Edit
+// or Command is set (rarely both), matching the LSP spec.
+type CodeAction struct {
+	Title   string         `json:"title"`
+	Kind    string         `json:"kind"`
+	Edit    *WorkspaceEdit `json:"edit"`
+	Command *LSPCommand    `json:"command"`
+}
+
+// PrepareRename checks whether the symbol at (line, character) can be
+// renamed, per textDocument/prepareRename. A nil result with no error means
+// the server understood the request but found nothing renameable there.
+func (c *LSPClient) PrepareRename(line, character int) (bool, error) {
+	params := map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": c.uri},
+		"position":     map[string]interface{}{"line": line, "character": character},
+	}
+
+	resp, err := c.request(context.Background(), "textDocument/prepareRename", params, lspRequestTimeout)
+	if err != nil {
+		return false, err
+	}
+	if lspErr, ok := resp["error"]; ok {
+		return false, fmt.Errorf("LSP error: %v", lspErr)
+	}
+	return resp["result"] != nil, nil
+}
+
+// Rename requests a project-wide rename of the symbol at (line, character)
+// to newName, returning the WorkspaceEdit the server wants applied; see
+// applyWorkspaceEdit (workspaceedit.go).
+func (c *LSPClient) Rename(line, character int, newName string) (*WorkspaceEdit, error) {
+	params := map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": c.uri},
+		"position":     map[string]interface{}{"line": line, "character": character},
+		"newName":      newName,
+	}
+
+	resp, err := c.request(context.Background(), "textDocument/rename", params, lspCompletionTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if lspErr, ok := resp["error"]; ok {
+		return nil, fmt.Errorf("LSP error: %v", lspErr)
+	}
+	if resp["result"] == nil {
 		return nil, nil
-	case <-time.After(10 * time.Second):
-		if c.logCallback != nil {
-			c.logCallback("LSP", fmt.Sprintf("Completion request timed out (ID=%d)", id))
-		}
-		c.responseMutex.Lock()
-		delete(c.responses, id)
-		c.responseMutex.Unlock()
-		return nil, fmt.Errorf("LSP request timeout")
 	}
+	resJSON, _ := json.Marshal(resp["result"])
+	var we WorkspaceEdit
+	if err := json.Unmarshal(resJSON, &we); err != nil {
+		return nil, err
+	}
+	return &we, nil
 }
 
-// sendRequestWithID helper to send a request with a pre-generated ID.
-func (c *LSPClient) sendRequestWithID(id int64, method string, params interface{}) error {
-	request := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"id":      id,
-		"method":  method,
-		"params":  params,
+// CodeAction requests the actions (quick-fixes, refactors, source actions
+// like organize-imports) the server offers for the range [startLine,startCh]
+// to [endLine,endCh], e.g. the current selection or just the cursor line.
+func (c *LSPClient) CodeAction(startLine, startCh, endLine, endCh int, diagnostics []Diagnostic) ([]CodeAction, error) {
+	if diagnostics == nil {
+		diagnostics = []Diagnostic{}
+	}
+	params := map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": c.uri},
+		"range": map[string]interface{}{
+			"start": map[string]interface{}{"line": startLine, "character": startCh},
+			"end":   map[string]interface{}{"line": endLine, "character": endCh},
+		},
+		"context": map[string]interface{}{"diagnostics": diagnostics},
+	}
+
+	resp, err := c.request(context.Background(), "textDocument/codeAction", params, lspRequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if lspErr, ok := resp["error"]; ok {
+		return nil, fmt.Errorf("LSP error: %v", lspErr)
+	}
+	if resp["result"] == nil {
+		return nil, nil
+	}
+	resJSON, _ := json.Marshal(resp["result"])
+	var actions []CodeAction
+	if err := json.Unmarshal(resJSON, &actions); err != nil {
+		return nil, err
 	}
-	return c.sendMessage(request)
+	return actions, nil
 }
 
-// Shutdown gracefully closes the LSP client and stops the server process.
+// ExecuteCommand forwards a server-defined command (a CodeAction's Command
+// field, e.g. gopls's "gopls.fill_struct") via workspace/executeCommand. Some
+// servers apply the resulting edit themselves via a workspace/applyEdit
+// request rather than returning one here; qwe doesn't yet answer that
+// request (Handle only routes notifications, not server-initiated
+// requests), so such commands silently have no visible effect in the buffer
+// today.
+func (c *LSPClient) ExecuteCommand(command string, arguments []interface{}) error {
+	params := map[string]interface{}{
+		"command":   command,
+		"arguments": arguments,
+	}
+
+	resp, err := c.request(context.Background(), "workspace/executeCommand", params, lspCompletionTimeout)
+	if err != nil {
+		return err
+	}
+	if lspErr, ok := resp["error"]; ok {
+		return fmt.Errorf("LSP error: %v", lspErr)
+	}
+	return nil
+}
+
+// Shutdown closes this document: it notifies the server with
+// textDocument/didClose, stops any pending NotifyChange timer, and removes
+// itself from conn.docs. The underlying server process keeps running for
+// any other open document on the same connection; once the last one closes,
+// conn.closeDocument tears the process down itself.
 func (c *LSPClient) Shutdown() {
-	c.shutdownOnce.Do(func() {
-		c.shutdown = true
+	c.pendingMutex.Lock()
+	if c.changeTimer != nil {
+		c.changeTimer.Stop()
+	}
+	c.pendingMutex.Unlock()
 
-		c.sendRequest("shutdown", nil)
-		c.sendNotification("exit", nil)
+	c.conn.rpc.Notify("textDocument/didClose", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": c.uri},
+	})
 
-		if c.stdin != nil {
-			c.stdin.Close()
+	c.conn.closeDocument(c.uri)
+}
+
+// closeDocument removes uri from conn.docs and, if that was the last
+// document open on this connection, tears down the server process via
+// shutdownProcess and evicts conn from its owning manager - otherwise the
+// manager would keep handing out this now-dead connection to every later
+// Open() for the same (language, root), with no way to ever recover.
+func (conn *lspConn) closeDocument(uri string) {
+	conn.docsMutex.Lock()
+	delete(conn.docs, uri)
+	remaining := len(conn.docs)
+	conn.docsMutex.Unlock()
+
+	if remaining == 0 {
+		conn.shutdownProcess()
+		if conn.manager != nil {
+			conn.manager.evict(conn.key, conn)
 		}
-		if c.stdout != nil {
-			c.stdout.Close()
+	}
+}
+
+// shutdownProcess gracefully closes the connection and stops the server
+// process. Safe to call more than once; only the first call has any effect.
+func (conn *lspConn) shutdownProcess() {
+	conn.shutdownOnce.Do(func() {
+		conn.shutdown = true
+		if conn.rpc != nil {
+			// Notify first, then Close: a well-behaved server flushes
+			// caches/state on a clean shutdown+exit handshake, but
+			// Conn.send bails out as soon as Close has run, so closing
+			// first would silently drop both notifications.
+			conn.rpc.Notify("shutdown", nil)
+			conn.rpc.Notify("exit", nil)
+			conn.rpc.Close()
+		}
+
+		if conn.stdin != nil {
+			conn.stdin.Close()
+		}
+		if conn.stdout != nil {
+			conn.stdout.Close()
+		}
+
+		if conn.cmd != nil && conn.cmd.Process != nil {
+			conn.cmd.Wait()
+		}
+
+		if conn.cancel != nil {
+			conn.cancel()
 		}
 
-		if c.cmd != nil && c.cmd.Process != nil {
-			c.cmd.Wait()
+		if conn.traceFile != nil {
+			conn.traceFile.Close()
 		}
 	})
 }
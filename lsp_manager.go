@@ -0,0 +1,109 @@
+package main
+
+// LSPManager keeps a single lspConn alive per (language, workspace root)
+// tuple, so several buffers under the same project share one server
+// process instead of each spawning its own (see lsp.go's NewLSPClient,
+// which is now just the standalone building block this wraps).
+
+import (
+	"path/filepath"
+	"sync"
+)
+
+// LSPManager routes LoadFile's LSP requests to a shared lspConn per
+// (ft.Name, effective root) pair, where effective root falls back to the
+// file's own directory when findProjectRoot finds no marker - preserving
+// the old one-process-per-file behavior for files with no detectable
+// project, while genuinely sharing a connection for files that do.
+type LSPManager struct {
+	mu    sync.Mutex
+	conns map[string]*lspConn // key: ft.Name + "\x00" + effective root
+}
+
+// NewLSPManager returns an empty manager; Editor creates one at startup.
+func NewLSPManager() *LSPManager {
+	return &LSPManager{conns: make(map[string]*lspConn)}
+}
+
+// connKey builds the map key an (ft, effective root) pair resolves to.
+func connKey(ft *FileType, effectiveRoot string) string {
+	return ft.Name + "\x00" + effectiveRoot
+}
+
+// Open returns the LSPClient for filename, spawning a new server process
+// only if no connection already covers its (language, workspace root).
+func (m *LSPManager) Open(filename string, content string, logCallback func(string, string), ft *FileType) (*LSPClient, error) {
+	absPath, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if Config.LSPReplayPath != "" {
+		return NewLSPClientFromReplay(Config.LSPReplayPath, absPath, logCallback, ft)
+	}
+
+	root := findProjectRoot(absPath, ft.LSPRootPatterns)
+	effectiveRoot := root
+	if effectiveRoot == "" {
+		effectiveRoot = filepath.Dir(absPath)
+	}
+	key := connKey(ft, effectiveRoot)
+
+	m.mu.Lock()
+	conn, exists := m.conns[key]
+	if !exists {
+		conn, err = newLSPConn(ft, root, logCallback)
+		if err != nil {
+			m.mu.Unlock()
+			return nil, err
+		}
+		conn.manager = m
+		conn.key = key
+		m.conns[key] = conn
+	}
+	m.mu.Unlock()
+
+	return conn.openDocument(absPath, content)
+}
+
+// evict removes key from m.conns, but only if it still maps to conn - a
+// closeDocument racing a fresh Open() that already replaced the entry with
+// a new conn for the same key must not clobber that new one.
+func (m *LSPManager) evict(key string, conn *lspConn) {
+	m.mu.Lock()
+	if m.conns[key] == conn {
+		delete(m.conns, key)
+	}
+	m.mu.Unlock()
+}
+
+// ShutdownAll tears down every connection this manager is holding open,
+// regardless of how many documents are still registered on them.
+func (m *LSPManager) ShutdownAll() {
+	m.mu.Lock()
+	conns := m.conns
+	m.conns = make(map[string]*lspConn)
+	m.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.shutdownProcess()
+	}
+}
+
+// NotifyWatchedFileChanged broadcasts workspace/didChangeWatchedFiles for
+// uri to every connection this manager holds (not just the one that owns
+// the document, since the LSP spec lets a server register watchers for
+// patterns beyond its own open files). Called by CheckFilesOnDisk
+// (editor.go) whenever it notices a buffer's file changed on disk.
+func (m *LSPManager) NotifyWatchedFileChanged(uri string, changeType int) {
+	m.mu.Lock()
+	conns := make([]*lspConn, 0, len(m.conns))
+	for _, conn := range m.conns {
+		conns = append(conns, conn)
+	}
+	m.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.NotifyWatchedFileChanged(uri, changeType)
+	}
+}
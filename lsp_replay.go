@@ -0,0 +1,122 @@
+package main
+
+// Session recording and replay for LSP traffic, enabled by --lsp-trace and
+// --lsp-replay (see config.go). A trace is a newline-delimited log of every
+// framed JSON-RPC message a connection's server process exchanged, each
+// line tagged with direction and a timestamp (see traceEnvelope). Replaying
+// one feeds its s2c frames through the same Conn.processMessage (jsonrpc2.go)
+// a live Conn.Run dispatches through, without spawning a real process, so a
+// recorded gopls/clangd session reproduces a bug, or drives Definition,
+// Hover, Completion, and diagnostics in a test, with nothing but the log
+// file.
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// traceEnvelope is one line of a --lsp-trace log: a single framed JSON-RPC
+// message plus which direction it traveled and when.
+type traceEnvelope struct {
+	Ts      time.Time       `json:"ts"`
+	Dir     string          `json:"dir"` // "c2s" (client sent it) or "s2c" (server sent it).
+	Payload json.RawMessage `json:"payload"`
+}
+
+// writeTrace appends one traceEnvelope to conn.traceFile, if --lsp-trace
+// gave this connection a destination. Passed to NewConn as its trace hook
+// (jsonrpc2.go), which calls it with dir "c2s" for every message sent and
+// "s2c" for every message Run reads back.
+func (conn *lspConn) writeTrace(dir string, payload []byte) {
+	if conn.traceFile == nil {
+		return
+	}
+	conn.traceMutex.Lock()
+	defer conn.traceMutex.Unlock()
+
+	data, err := json.Marshal(traceEnvelope{Ts: time.Now(), Dir: dir, Payload: json.RawMessage(payload)})
+	if err != nil {
+		return
+	}
+	conn.traceFile.Write(append(data, '\n'))
+}
+
+// NewLSPClientFromReplay builds an LSPClient on a connection that never
+// spawns a server process: it reads a --lsp-trace log from path instead and
+// feeds every s2c frame into conn.rpc.processMessage on a background
+// goroutine (see replayMessages), so Handle, response channels, and
+// diagnostics all fire exactly as they would against the real server that
+// produced the log.
+func NewLSPClientFromReplay(path string, absPath string, logCallback func(string, string), ft *FileType) (*LSPClient, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &lspConn{
+		logCallback: logCallback,
+		fileType:    ft,
+		docs:        make(map[string]*LSPClient),
+	}
+	// No real process, so no Stream to read from or write to; replayMessages
+	// drives conn.rpc.processMessage directly instead of ever calling Run.
+	conn.rpc = NewConn(nil, conn, nil)
+
+	client := &LSPClient{
+		conn:        conn,
+		filename:    absPath,
+		uri:         "file://" + absPath,
+		diagnostics: []Diagnostic{},
+		fileType:    ft,
+	}
+	conn.docs[client.uri] = client
+
+	go conn.replayMessages(f)
+
+	return client, nil
+}
+
+// replayMessages parses every line of f as a traceEnvelope and dispatches
+// the s2c ones through processMessage, in recorded order. Unless
+// Config.LSPReplayFast is set, it sleeps between frames to reproduce the
+// gaps between the original timestamps, so slow-to-respond servers replay
+// at the same pace they ran at; c2s frames are skipped, since there's no
+// process on the other end to send them to.
+func (conn *lspConn) replayMessages(f *os.File) {
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+
+	var lastTs time.Time
+	haveLastTs := false
+	for scanner.Scan() {
+		if conn.shutdown {
+			return
+		}
+
+		var env traceEnvelope
+		if err := json.Unmarshal(scanner.Bytes(), &env); err != nil {
+			continue
+		}
+		if env.Dir != "s2c" {
+			continue
+		}
+
+		if !Config.LSPReplayFast && haveLastTs {
+			if wait := env.Ts.Sub(lastTs); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+		lastTs = env.Ts
+		haveLastTs = true
+
+		var msg map[string]interface{}
+		if err := json.Unmarshal(env.Payload, &msg); err != nil {
+			continue
+		}
+		conn.rpc.processMessage(msg)
+	}
+}
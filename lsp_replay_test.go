@@ -0,0 +1,174 @@
+package main
+
+// Exercises the --lsp-replay path end to end: a small recorded trace fixture
+// (see replayFixture below) is fed through NewLSPClientFromReplay, and the
+// resulting LSPClient is driven through Definition, Hover, Completion, and a
+// publishDiagnostics notification - exactly the scenario lsp_replay.go's own
+// doc comment describes the recording/replay machinery as existing for.
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// replayFrameGap is the spacing replayFixture gives each recorded frame.
+// replayMessages (lsp_replay.go) honors the recorded timestamps rather than
+// firing every frame at once (Config.LSPReplayFast is left false for this
+// test), so each s2c response lands only after this much of a pause - long
+// enough that the test's own goroutine has already issued the matching
+// request and registered to receive it, the same way a real recording's
+// gaps give a real caller time to react between server messages.
+const replayFrameGap = 50 * time.Millisecond
+
+// replayFixture writes a --lsp-trace-shaped log to a temp file and returns
+// its path. Its four frames are spaced replayFrameGap apart: a throwaway
+// notification first (so the real id=1 response isn't the very first frame,
+// which replayMessages always fires immediately with no wait), then the
+// three s2c responses with ids 1, 2, 3 - matching the order
+// NewLSPClientFromReplay's Conn hands out request ids in this test
+// (Definition, then Hover, then Completion) - and finally a
+// publishDiagnostics notification, which has no id and is dispatched to
+// Handle instead of correlated to a request.
+func replayFixture(t *testing.T, uri string) string {
+	t.Helper()
+
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	frame := func(dir string, payload map[string]interface{}, offset int) string {
+		payloadJSON, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatalf("marshaling payload: %v", err)
+		}
+		env := traceEnvelope{Ts: base.Add(time.Duration(offset) * replayFrameGap), Dir: dir, Payload: payloadJSON}
+		envJSON, err := json.Marshal(env)
+		if err != nil {
+			t.Fatalf("marshaling envelope: %v", err)
+		}
+		return string(envJSON)
+	}
+
+	lines := []string{
+		frame("s2c", map[string]interface{}{
+			"jsonrpc": "2.0",
+			"method":  "window/logMessage",
+			"params":  map[string]interface{}{"type": 3, "message": "server ready"},
+		}, 0),
+		frame("s2c", map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      1,
+			"result": map[string]interface{}{
+				"uri": "file:///repo/foo.go",
+				"range": map[string]interface{}{
+					"start": map[string]interface{}{"line": 10, "character": 2},
+					"end":   map[string]interface{}{"line": 10, "character": 5},
+				},
+			},
+		}, 1),
+		frame("s2c", map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      2,
+			"result": map[string]interface{}{
+				"contents": map[string]interface{}{
+					"kind":  "markdown",
+					"value": "`foo` is a function",
+				},
+			},
+		}, 2),
+		frame("s2c", map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      3,
+			"result": map[string]interface{}{
+				"isIncomplete": false,
+				"items": []map[string]interface{}{
+					{"label": "foo", "kind": 3, "detail": "func foo()", "insertText": "foo"},
+				},
+			},
+		}, 3),
+		frame("s2c", map[string]interface{}{
+			"jsonrpc": "2.0",
+			"method":  "textDocument/publishDiagnostics",
+			"params": map[string]interface{}{
+				"uri": uri,
+				"diagnostics": []map[string]interface{}{
+					{
+						"range": map[string]interface{}{
+							"start": map[string]interface{}{"line": 3, "character": 0},
+							"end":   map[string]interface{}{"line": 3, "character": 8},
+						},
+						"severity": 2,
+						"message":  "unused variable",
+					},
+				},
+			},
+		}, 4),
+	}
+
+	path := filepath.Join(t.TempDir(), "trace.log")
+	content := strings.Join(lines, "\n") + "\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("writing fixture: %v", err)
+	}
+	return path
+}
+
+func TestLSPReplayDrivesRequestsAndDiagnostics(t *testing.T) {
+	oldFast := Config.LSPReplayFast
+	Config.LSPReplayFast = false
+	defer func() { Config.LSPReplayFast = oldFast }()
+
+	absPath := filepath.Join(t.TempDir(), "foo.go")
+	uri := "file://" + absPath
+
+	path := replayFixture(t, uri)
+	ft := &FileType{Name: "go"}
+
+	client, err := NewLSPClientFromReplay(path, absPath, nil, ft)
+	if err != nil {
+		t.Fatalf("NewLSPClientFromReplay: %v", err)
+	}
+
+	ctx := context.Background()
+
+	locs, err := client.Definition(ctx, 1, 2)
+	if err != nil {
+		t.Fatalf("Definition: %v", err)
+	}
+	if len(locs) != 1 || locs[0].URI != "file:///repo/foo.go" {
+		t.Fatalf("Definition returned %+v", locs)
+	}
+
+	hover, err := client.Hover(ctx, 1, 2)
+	if err != nil {
+		t.Fatalf("Hover: %v", err)
+	}
+	if hover != "`foo` is a function" {
+		t.Fatalf("Hover returned %q", hover)
+	}
+
+	items, err := client.Completion(ctx, 1, 2)
+	if err != nil {
+		t.Fatalf("Completion: %v", err)
+	}
+	if len(items) != 1 || items[0].Label != "foo" {
+		t.Fatalf("Completion returned %+v", items)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		diags := client.GetDiagnostics()
+		if len(diags) == 1 {
+			if diags[0].Message != "unused variable" {
+				t.Fatalf("diagnostic message = %q", diags[0].Message)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("diagnostics never arrived")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
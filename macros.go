@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/nsf/termbox-go"
+)
+
+// Macro recording and playback: `m{a-z}` starts recording every keystroke
+// into that register, `m` again stops it, and `@{a-z}` replays a recorded
+// register once; `@@` repeats whichever register was last played. Recorded
+// and played-back keystrokes go through handleKeyEvent (kevent.go), the
+// same path a live keypress takes, so a macro sees the same mode
+// transitions typing it would. Macros use their own a-z namespace (held in
+// Editor.macros) rather than Registers: a macro is a sequence of
+// keystrokes, not text, so it doesn't belong in registers.go's yank/delete
+// registers, which already cover named yanking ("ay/"ap) on their own.
+
+// maxMacroDepth caps how many playMacro calls may be nested at once,
+// turning a macro that invokes itself (directly or via @@) into a message
+// instead of a hang.
+const maxMacroDepth = 100
+
+// isMacroRegister reports whether r names a macro register.
+func isMacroRegister(r rune) bool {
+	return r >= 'a' && r <= 'z'
+}
+
+// startRecording begins capturing keystrokes into reg, discarding whatever
+// it held before.
+func (e *Editor) startRecording(reg rune) {
+	e.recordingRegister = reg
+	e.macros[reg] = nil
+	e.setMessage(fmt.Sprintf("Recording @%c", reg))
+}
+
+// stopRecording ends the in-progress recording started by startRecording.
+func (e *Editor) stopRecording() {
+	reg := e.recordingRegister
+	e.recordingRegister = 0
+	e.setMessage(fmt.Sprintf("Recorded @%c, %d keys", reg, len(e.macros[reg])))
+}
+
+// playMacro replays the keystrokes recorded in reg by feeding each one back
+// through handleKeyEvent, and remembers reg so a later @@ repeats it.
+func (e *Editor) playMacro(reg rune) {
+	events := e.macros[reg]
+	if len(events) == 0 {
+		return
+	}
+	if e.macroDepth >= maxMacroDepth {
+		e.setMessage(fmt.Sprintf("@%c: macro recursion limit reached", reg))
+		return
+	}
+
+	e.lastMacroRegister = reg
+	e.macroDepth++
+	defer func() { e.macroDepth-- }()
+
+	for _, ev := range events {
+		if e.handleKeyEvent(ev) {
+			// A recorded Ctrl+C-in-dev-mode quit keystroke; let it
+			// propagate to HandleEvents the same way a live one would.
+			return
+		}
+	}
+}
+
+// dispatchMacroPrefix handles the 'm'/'@' register-name prefixes that
+// start/stop recording and trigger playback, called from handleNormalMode
+// before the chord registry sees the event. It reports whether ev was
+// consumed as part of one of these prefixes.
+func (e *Editor) dispatchMacroPrefix(ev termbox.Event) bool {
+	switch e.pendingKey {
+	case 'm':
+		if isMacroRegister(ev.Ch) {
+			e.startRecording(ev.Ch)
+		}
+		e.pendingKey = 0
+		return true
+	case '@':
+		switch {
+		case ev.Ch == '@':
+			if e.lastMacroRegister != 0 {
+				e.playMacro(e.lastMacroRegister)
+			}
+		case isMacroRegister(ev.Ch):
+			e.playMacro(ev.Ch)
+		}
+		e.pendingKey = 0
+		return true
+	}
+
+	if len(e.pendingChords) != 0 {
+		return false
+	}
+	switch ev.Ch {
+	case 'm':
+		if e.recordingRegister != 0 {
+			e.stopRecording()
+		} else {
+			e.pendingKey = 'm'
+		}
+		return true
+	case '@':
+		e.pendingKey = '@'
+		return true
+	}
+	return false
+}
@@ -40,6 +40,11 @@ func main() {
 		return
 	}
 
+	// Detect whether the terminal has a light or dark background (see
+	// background.go) before termbox takes over stdin, so :theme and the
+	// builtin theme start out on the right side of :set background=.
+	activeVariant = DetectBackgroundVariant()
+
 	// Initialize termbox for TUI handling.
 	err := termbox.Init()
 	if err != nil {
@@ -0,0 +1,239 @@
+package main
+
+// Lightweight Markdown rendering shared by the LSP hover popup and the
+// autocomplete detail pane (see drawHoverPopup/drawAutocompletePopup in
+// editor.go). Handles the subset LSP servers actually send back in hover
+// and completion-item documentation: **bold**, *italic*, inline `code`,
+// fenced ```code``` blocks (syntax-highlighted when the fence language
+// matches the active buffer's), ATX headings, and "-"/"*" bullet lists.
+// Anything else passes through as plain text.
+
+import (
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+// StyledCell is one rendered character plus the attributes it should be
+// drawn with; renderMarkdown returns lines of these for a popup to blit
+// directly via Editor.setCell.
+type StyledCell struct {
+	Ch rune
+	Fg termbox.Attribute
+	Bg termbox.Attribute
+}
+
+// renderMarkdown parses source as the Markdown subset described above and
+// word-wraps it to width, returning one []StyledCell per rendered line. buf,
+// if non-nil, supplies the active buffer's syntax highlighter so a fenced
+// code block whose language matches it renders with real syntax colors
+// instead of a flat ColorHoverCode.
+func renderMarkdown(source string, width int, buf *Buffer) [][]StyledCell {
+	if width < 1 {
+		width = 1
+	}
+
+	baseFg, baseBg := GetThemeColor(ColorHoverWindow)
+	codeFg, codeBg := GetThemeColor(ColorHoverCode)
+	headingFg, _ := GetThemeColor(ColorHoverHeading)
+	boldFg, _ := GetThemeColor(ColorHoverBold)
+
+	var out [][]StyledCell
+	inFence := false
+	fenceLang := ""
+	var fenceLines []string
+
+	flushFence := func() {
+		if fenceLines == nil {
+			return
+		}
+		out = append(out, highlightCodeBlock(fenceLang, fenceLines, buf, codeFg, codeBg)...)
+		fenceLines = nil
+	}
+
+	for _, raw := range strings.Split(source, "\n") {
+		line := strings.TrimRight(raw, "\r")
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "```") {
+			if inFence {
+				flushFence()
+				inFence = false
+				fenceLang = ""
+			} else {
+				inFence = true
+				fenceLang = strings.TrimSpace(strings.TrimPrefix(trimmed, "```"))
+				fenceLines = []string{}
+			}
+			continue
+		}
+
+		if inFence {
+			fenceLines = append(fenceLines, line)
+			continue
+		}
+
+		out = append(out, renderMarkdownLine(line, width, baseFg, baseBg, headingFg, boldFg, codeFg, codeBg)...)
+	}
+	flushFence()
+
+	return out
+}
+
+// renderMarkdownLine renders one logical (pre-wrap) Markdown line, handling
+// ATX headings and bullet-list markers before delegating the rest to
+// renderInline, then word-wraps the result to width.
+func renderMarkdownLine(line string, width int, baseFg, baseBg, headingFg, boldFg, codeFg, codeBg termbox.Attribute) [][]StyledCell {
+	fg := baseFg
+	bold := false
+	prefix := ""
+
+	rest := strings.TrimLeft(line, " ")
+	switch {
+	case strings.HasPrefix(rest, "### "):
+		rest, fg, bold = strings.TrimPrefix(rest, "### "), headingFg, true
+	case strings.HasPrefix(rest, "## "):
+		rest, fg, bold = strings.TrimPrefix(rest, "## "), headingFg, true
+	case strings.HasPrefix(rest, "# "):
+		rest, fg, bold = strings.TrimPrefix(rest, "# "), headingFg, true
+	case strings.HasPrefix(rest, "- "), strings.HasPrefix(rest, "* "):
+		prefix, rest = "• ", rest[2:]
+	}
+
+	cells := renderInline(rest, fg, baseBg, boldFg, codeFg, codeBg, bold)
+	if prefix != "" {
+		prefixCells := make([]StyledCell, 0, len(prefix))
+		for _, r := range prefix {
+			prefixCells = append(prefixCells, StyledCell{Ch: r, Fg: fg, Bg: baseBg})
+		}
+		cells = append(prefixCells, cells...)
+	}
+
+	return wrapCells(cells, width)
+}
+
+// renderInline walks s applying **bold**/*italic*/`code` spans, returning
+// one StyledCell per rune with Markdown's own delimiter characters removed.
+// forceBold makes the whole span bold regardless of **markers (used for
+// heading lines).
+func renderInline(s string, fg, bg, boldFg, codeFg, codeBg termbox.Attribute, forceBold bool) []StyledCell {
+	runes := []rune(s)
+	var out []StyledCell
+	bold := forceBold
+	italic := false
+
+	for i := 0; i < len(runes); {
+		switch {
+		case runes[i] == '\\' && i+1 < len(runes):
+			out = append(out, styledRune(runes[i+1], fg, bg, boldFg, bold, italic))
+			i += 2
+		case i+1 < len(runes) && runes[i] == '*' && runes[i+1] == '*':
+			bold = !bold
+			i += 2
+		case runes[i] == '*':
+			italic = !italic
+			i++
+		case runes[i] == '`':
+			j := i + 1
+			for j < len(runes) && runes[j] != '`' {
+				j++
+			}
+			for _, r := range runes[i+1 : j] {
+				out = append(out, StyledCell{Ch: r, Fg: codeFg, Bg: codeBg})
+			}
+			if j < len(runes) {
+				j++
+			}
+			i = j
+		default:
+			out = append(out, styledRune(runes[i], fg, bg, boldFg, bold, italic))
+			i++
+		}
+	}
+	return out
+}
+
+// styledRune applies bold/italic to a single plain-text rune. termbox has no
+// italic attribute, so italic spans are rendered underlined instead.
+func styledRune(r rune, fg, bg, boldFg termbox.Attribute, bold, italic bool) StyledCell {
+	attr := fg
+	if bold {
+		attr = boldFg | termbox.AttrBold
+	}
+	if italic {
+		attr |= termbox.AttrUnderline
+	}
+	return StyledCell{Ch: r, Fg: attr, Bg: bg}
+}
+
+// highlightCodeBlock renders the lines of a fenced code block. When lang
+// names the active buffer's own language, it spins up a throwaway
+// SyntaxHighlighter of that language to color the block like the buffer
+// itself; otherwise every rune falls back to fallbackFg/fallbackBg.
+func highlightCodeBlock(lang string, lines []string, buf *Buffer, fallbackFg, fallbackBg termbox.Attribute) [][]StyledCell {
+	var hl *SyntaxHighlighter
+	if lang != "" && buf != nil && buf.syntax != nil && buf.fileType != nil && strings.EqualFold(lang, buf.syntax.Language) {
+		hl = NewSyntaxHighlighter(buf.fileType.Name, nil)
+		if hl != nil {
+			hl.Parse([]byte(strings.Join(lines, "\n")))
+		}
+	}
+
+	out := make([][]StyledCell, len(lines))
+	for i, line := range lines {
+		runes := []rune(line)
+		var attrs []termbox.Attribute
+		if hl != nil {
+			attrs = hl.Highlight(i, runes)
+		}
+		cells := make([]StyledCell, len(runes))
+		for j, r := range runes {
+			fg := fallbackFg
+			if j < len(attrs) {
+				fg = attrs[j]
+			}
+			cells[j] = StyledCell{Ch: r, Fg: fg, Bg: fallbackBg}
+		}
+		out[i] = cells
+	}
+	return out
+}
+
+// wrapCells greedily word-wraps cells (splitting on literal space runes) to
+// width, hard-breaking any single word that doesn't fit on its own line.
+func wrapCells(cells []StyledCell, width int) [][]StyledCell {
+	var lines [][]StyledCell
+	var current, word []StyledCell
+
+	flushWord := func() {
+		if len(word) == 0 {
+			return
+		}
+		if len(current) > 0 && len(current)+1+len(word) > width {
+			lines = append(lines, current)
+			current = nil
+		} else if len(current) > 0 {
+			current = append(current, StyledCell{Ch: ' ', Fg: word[0].Fg, Bg: word[0].Bg})
+		}
+		for len(word) > width {
+			lines = append(lines, append([]StyledCell{}, word[:width]...))
+			word = word[width:]
+		}
+		current = append(current, word...)
+		word = nil
+	}
+
+	for _, c := range cells {
+		if c.Ch == ' ' {
+			flushWord()
+			continue
+		}
+		word = append(word, c)
+	}
+	flushWord()
+
+	if len(current) > 0 || len(lines) == 0 {
+		lines = append(lines, current)
+	}
+	return lines
+}
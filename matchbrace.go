@@ -0,0 +1,135 @@
+package main
+
+// Brace-pair matching shared by the '%' JumpToMatchingBrace action and the
+// brace highlight drawn under the cursor (see draw() in editor.go), so the
+// two always agree on the same pair.
+
+// BracePair is one matched-delimiter kind recognized by FindMatchingBrace.
+type BracePair struct {
+	Open  rune
+	Close rune
+}
+
+// defaultBracePairs is used for filetypes that don't override FileType.BracePairs.
+var defaultBracePairs = []BracePair{
+	{'(', ')'},
+	{'[', ']'},
+	{'{', '}'},
+}
+
+// bracePairsFor returns the brace pairs to match in b, honoring a per-filetype
+// override.
+func bracePairsFor(b *Buffer) []BracePair {
+	if b != nil && b.fileType != nil && len(b.fileType.BracePairs) > 0 {
+		return b.fileType.BracePairs
+	}
+	return defaultBracePairs
+}
+
+// inStringOrComment reports whether (y, x) in b falls inside a string or
+// comment token, per the buffer's cached tree-sitter highlights. Buffers with
+// no syntax highlighter (or no highlight recorded at that position) are never
+// excluded, so brace matching still works without tree-sitter support.
+func inStringOrComment(b *Buffer, y, x int) bool {
+	if b == nil || b.syntax == nil {
+		return false
+	}
+	lineHighlights, ok := b.syntax.Highlights[y]
+	if !ok {
+		return false
+	}
+	attr, ok := lineHighlights[x]
+	if !ok {
+		return false
+	}
+	strFg, _ := GetThemeColor(ColorTSString)
+	commentFg, _ := GetThemeColor(ColorTSComment)
+	return attr == strFg || attr == commentFg
+}
+
+// FindMatchingBrace finds the innermost brace pair (see bracePairsFor)
+// enclosing or starting at (y, x), skipping braces that appear inside
+// strings/comments. When more than one pair kind encloses the position (e.g.
+// the cursor on '[' in "([foo]bar)"), the innermost one wins, so highlight
+// and JumpToMatchingBrace always agree on the same pair.
+func FindMatchingBrace(b *Buffer, y, x int) (openY, openX, closeY, closeX int, ok bool) {
+	if b == nil || y < 0 || y >= len(b.buffer) {
+		return 0, 0, 0, 0, false
+	}
+
+	flat, cursorOffset := textObjectFlat(b, y, x)
+
+	// Precompute the (row, col) of every flat offset once, rather than
+	// re-deriving it per bracket kind.
+	rows := make([]int, len(flat))
+	cols := make([]int, len(flat))
+	row, col := 0, 0
+	for i, r := range flat {
+		rows[i], cols[i] = row, col
+		if r == '\n' {
+			row++
+			col = 0
+		} else {
+			col++
+		}
+	}
+
+	var best [2]int
+	found := false
+	for _, bp := range bracePairsFor(b) {
+		var stack []int
+		for i, r := range flat {
+			if inStringOrComment(b, rows[i], cols[i]) {
+				continue
+			}
+			switch r {
+			case bp.Open:
+				stack = append(stack, i)
+			case bp.Close:
+				if len(stack) == 0 {
+					continue
+				}
+				s := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				if cursorOffset < s || cursorOffset > i {
+					continue
+				}
+				// Innermost = the pair starting closest to (at or before) the cursor.
+				if !found || s > best[0] {
+					best = [2]int{s, i}
+					found = true
+				}
+			}
+		}
+	}
+
+	if !found {
+		return 0, 0, 0, 0, false
+	}
+
+	openY, openX = offsetToPos(b, best[0])
+	closeY, closeX = offsetToPos(b, best[1])
+	return openY, openX, closeY, closeX, true
+}
+
+// JumpToMatchingBrace moves the primary cursor to the other half of the
+// brace pair under it (vim's '%'), preferring the innermost enclosing pair.
+func (e *Editor) JumpToMatchingBrace() {
+	b := e.activeBuffer()
+	if b == nil || len(b.buffer) == 0 {
+		return
+	}
+
+	cy, cx := b.PrimaryCursor().Y, b.PrimaryCursor().X
+	openY, openX, closeY, closeX, ok := FindMatchingBrace(b, cy, cx)
+	if !ok {
+		return
+	}
+
+	e.pushJump()
+	if cy == openY && cx == openX {
+		b.PrimaryCursor().Y, b.PrimaryCursor().X = closeY, closeX
+	} else {
+		b.PrimaryCursor().Y, b.PrimaryCursor().X = openY, openX
+	}
+}
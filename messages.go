@@ -0,0 +1,51 @@
+package main
+
+import "fmt"
+
+// messagesCommand implements `:messages` and `:messages clear`, dumping the
+// status message log into a read-only scratch buffer or wiping it. Severity
+// is rendered by drawBuffer via a lookup on [Messages]'s line contents, so
+// the formatted text here doubles as the color cue.
+func (ch *Command) messagesCommand(arg string) {
+	if arg == "clear" {
+		ch.e.messageLog = []Message{}
+		ch.e.setMessage("Messages cleared")
+		return
+	}
+
+	lines := make([][]rune, 0, len(ch.e.messageLog))
+	for _, m := range ch.e.messageLog {
+		t := m.Timestamp
+		timestamp := fmt.Sprintf("%02d:%02d:%02d", t.Hour(), t.Minute(), t.Second())
+		lines = append(lines, []rune(fmt.Sprintf("%s [%s] %s", timestamp, severityLabel(m.Severity), m.Text)))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, []rune{})
+	}
+
+	b := &Buffer{
+		buffer:    lines,
+		filename:  "[Messages]",
+		readOnly:  true,
+		undoStack: []Edit{},
+		redoStack: []Edit{},
+		fileType:  getFileType("[Messages]"),
+	}
+	b.setBufType(BufTypeScratch)
+	ch.e.buffers = append(ch.e.buffers, b)
+	ch.e.activeBufferIndex = len(ch.e.buffers) - 1
+	ch.e.setMessage(fmt.Sprintf("%d messages", len(ch.e.messageLog)))
+}
+
+// severityLabel returns the fixed-width tag used both in the [Messages]
+// buffer text and to recognize a line's severity when coloring it.
+func severityLabel(s MessageSeverity) string {
+	switch s {
+	case MessageError:
+		return "ERROR"
+	case MessageWarning:
+		return "WARN"
+	default:
+		return "INFO"
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+// Persistence for the most-recently-used file list shown by the fuzzy
+// finder. Unlike command/search history (see history.go), the MRU list is
+// stored as JSON under ~/.qwe since it needs a fixed on-disk path that
+// other tooling (shell prompts, scripts) can read regardless of
+// $XDG_STATE_HOME.
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// mruMaxEntries caps how many recently opened files are remembered.
+const mruMaxEntries = 100
+
+// mruFilePath returns ~/.qwe/mru.json, creating the ~/.qwe directory if
+// necessary.
+func mruFilePath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(home, ".qwe")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "mru.json"), nil
+}
+
+// loadMRUFile reads the persisted MRU list, most-recent first. A missing or
+// corrupt file is treated as an empty list.
+func loadMRUFile() []string {
+	path, err := mruFilePath()
+	if err != nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	var entries []string
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil
+	}
+	return entries
+}
+
+// saveMRUFile writes the MRU list atomically via a temp file + rename.
+func saveMRUFile(entries []string) error {
+	path, err := mruFilePath()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadMRU populates the in-memory MRU list from disk.
+func (e *Editor) LoadMRU() {
+	e.mruFiles = loadMRUFile()
+}
+
+// recordMRU moves filename to the front of the MRU list, dedupes it, trims
+// the list to mruMaxEntries, and flushes it to disk.
+func (e *Editor) recordMRU(filename string) {
+	if filename == "" {
+		return
+	}
+
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		abs = filename
+	}
+
+	updated := make([]string, 0, len(e.mruFiles)+1)
+	updated = append(updated, abs)
+	for _, f := range e.mruFiles {
+		if f != abs {
+			updated = append(updated, f)
+		}
+	}
+	if len(updated) > mruMaxEntries {
+		updated = updated[:mruMaxEntries]
+	}
+	e.mruFiles = updated
+
+	saveMRUFile(e.mruFiles)
+}
+
+// mruRank returns the position of path in the MRU list (0 = most recent) and
+// whether it was found.
+func (e *Editor) mruRank(path string) (int, bool) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	for i, f := range e.mruFiles {
+		if f == abs {
+			return i, true
+		}
+	}
+	return 0, false
+}
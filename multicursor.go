@@ -0,0 +1,342 @@
+package main
+
+// Multi-cursor support beyond the basic add/clear/move primitives in
+// editor.go: keeping sibling cursors correctly positioned across edits
+// (adjustCursorsForEdit), Sublime-style occurrence selection
+// (AddCursorAtNextMatch/selectAllOccurrences and their SkipMultiCursor/
+// SkipMultiCursorBack follow-ups), and turning a visual-block selection
+// into one cursor per row (materializeBlockCursors).
+
+import "fmt"
+
+// adjustCursorsForEdit shifts every cursor other than excl to account for an
+// edit on line y: deltaRunes is added to the column of any cursor on line y
+// at or after column x (positive for insertions, negative for deletions;
+// cursors that land inside a deletion are clamped to x), and deltaLines is
+// added to the line of every cursor strictly below y (positive for
+// inserted lines, negative for removed lines). Called from every edit that
+// can move more than one cursor at once (insertRune, backspace,
+// insertNewline, deleteWord, deleteToEndOfLine, applyTextObjectDelete) so
+// multi-cursor edits don't drift apart.
+func (b *Buffer) adjustCursorsForEdit(excl *Cursor, y, x, deltaRunes, deltaLines int) {
+	for i := range b.cursors {
+		c := &b.cursors[i]
+		if c == excl {
+			continue
+		}
+		if deltaRunes != 0 && c.Y == y && c.X >= x {
+			c.X += deltaRunes
+			if c.X < x {
+				c.X = x
+			}
+			c.PreferredCol = c.X
+		}
+		if deltaLines != 0 && c.Y > y {
+			c.Y += deltaLines
+		}
+	}
+}
+
+// wordBoundsAt returns the [start, end) column range of the run of word
+// characters touching column x on line, or ok=false if x isn't on one.
+// Shared by getWordUnderCursor and the occurrence search below.
+func wordBoundsAt(e *Editor, line []rune, x int) (start, end int, ok bool) {
+	if x < 0 || x >= len(line) || !e.isWordChar(line[x]) {
+		return 0, 0, false
+	}
+	start, end = x, x
+	for start > 0 && e.isWordChar(line[start-1]) {
+		start--
+	}
+	for end < len(line) && e.isWordChar(line[end]) {
+		end++
+	}
+	return start, end, true
+}
+
+// lastCursorPos returns the position of the bottom-most, then right-most
+// cursor, the anchor AddCursorAtNextMatch searches forward from.
+func (e *Editor) lastCursorPos() (y, x int) {
+	b := e.activeBuffer()
+	y, x = -1, -1
+	if b == nil {
+		return y, x
+	}
+	for _, c := range b.cursors {
+		if c.Y > y || (c.Y == y && c.X > x) {
+			y, x = c.Y, c.X
+		}
+	}
+	return y, x
+}
+
+// nextOccurrence searches forward from just after (afterY, afterX), wrapping
+// around the end of the buffer, for the next match of text and returns the
+// column its first character starts at. wholeWord restricts matches to
+// whole-word boundaries (vim/Sublime Ctrl-D on the word under the cursor);
+// AddCursorAtNextMatch passes wholeWord=false when text came from an
+// arbitrary visual selection instead, so a selection straddling word
+// boundaries still matches itself exactly.
+func (e *Editor) nextOccurrence(text string, wholeWord bool, afterY, afterX int) (y, x int, ok bool) {
+	b := e.activeBuffer()
+	if b == nil || len(b.buffer) == 0 || text == "" {
+		return 0, 0, false
+	}
+	n := len(b.buffer)
+	runes := []rune(text)
+	for i := 0; i <= n; i++ {
+		row := (afterY + i) % n
+		line := b.buffer[row]
+		minCol := 0
+		if i == 0 {
+			minCol = afterX + 1
+		}
+		for col := minCol; col+len(runes) <= len(line); col++ {
+			if wholeWord {
+				start, end, ok := wordBoundsAt(e, line, col)
+				if !ok || start != col || end-start != len(runes) {
+					continue
+				}
+			}
+			if string(line[col:col+len(runes)]) == text {
+				return row, col, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// prevOccurrence is the backward twin of nextOccurrence: it searches from
+// just before (beforeY, beforeX) towards the start of the buffer, wrapping
+// around the top, for the previous match of text. Used by
+// SkipMultiCursorBack to walk a multi-cursor match session in reverse.
+func (e *Editor) prevOccurrence(text string, wholeWord bool, beforeY, beforeX int) (y, x int, ok bool) {
+	b := e.activeBuffer()
+	if b == nil || len(b.buffer) == 0 || text == "" {
+		return 0, 0, false
+	}
+	n := len(b.buffer)
+	runes := []rune(text)
+	for i := 0; i <= n; i++ {
+		row := ((beforeY-i)%n + n) % n
+		line := b.buffer[row]
+		maxCol := len(line) - len(runes)
+		if i == 0 && beforeX-1 < maxCol {
+			maxCol = beforeX - 1
+		}
+		for col := maxCol; col >= 0; col-- {
+			if wholeWord {
+				start, end, ok := wordBoundsAt(e, line, col)
+				if !ok || start != col || end-start != len(runes) {
+					continue
+				}
+			}
+			if string(line[col:col+len(runes)]) == text {
+				return row, col, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// selectAllOccurrences replaces the current cursor set with one cursor per
+// occurrence of the word under the primary cursor (Sublime's "Select All
+// Occurrences").
+func (e *Editor) selectAllOccurrences() {
+	b := e.activeBuffer()
+	if b == nil {
+		return
+	}
+	word := e.getWordUnderCursor()
+	if word == "" {
+		e.setMessage("No word under cursor")
+		return
+	}
+
+	var cursors []Cursor
+	for y, line := range b.buffer {
+		for x := 0; x < len(line); x++ {
+			start, end, ok := wordBoundsAt(e, line, x)
+			if !ok || start != x {
+				continue
+			}
+			if string(line[start:end]) == word {
+				cursors = append(cursors, Cursor{X: start, Y: y, PreferredCol: start})
+			}
+			x = end - 1
+		}
+	}
+	if len(cursors) == 0 {
+		e.setMessage("No occurrences of " + word)
+		return
+	}
+
+	b.cursors = cursors
+	e.setMessage(fmt.Sprintf("%d occurrences selected", len(cursors)))
+}
+
+// materializeBlockCursors converts the current visual-block selection into
+// one Cursor per row, at the block's left edge (insertAtEnd=false, vim's
+// block "I") or one column past its right edge (insertAtEnd=true, block
+// "A"), then drops into Insert mode. Typing afterward goes through the
+// normal per-cursor insertRune path, landing on every row at once;
+// PreferredCol is set to the block column so vertical motion out of the
+// block stays rectangular instead of snapping to each line's own length.
+func (e *Editor) materializeBlockCursors(insertAtEnd bool) {
+	b := e.activeBuffer()
+	if b == nil {
+		return
+	}
+	if b.readOnly {
+		e.setMessage("File is read-only")
+		return
+	}
+
+	y1, x1, y2, x2 := e.getSelectionBounds()
+	if x1 > x2 {
+		x1, x2 = x2, x1
+	}
+	col := x1
+	if insertAtEnd {
+		col = x2 + 1
+	}
+
+	var cursors []Cursor
+	for y := y1; y <= y2 && y < len(b.buffer); y++ {
+		x := col
+		if x > len(b.buffer[y]) {
+			if !insertAtEnd {
+				continue // Short line has no column x1 to insert before.
+			}
+			x = len(b.buffer[y])
+		}
+		cursors = append(cursors, Cursor{X: x, Y: y, PreferredCol: x})
+	}
+	if len(cursors) == 0 {
+		return
+	}
+
+	e.saveState()
+	b.cursors = cursors
+	e.mode = ModeInsert
+}
+
+// multiCursorMatchText returns the text AddCursorAtNextMatch should hunt for
+// next: the current visual selection verbatim (wholeWord=false, so a
+// selection that starts or ends mid-word still matches itself exactly), or,
+// outside Visual mode, the whole word under the primary cursor
+// (wholeWord=true, vim/Sublime Ctrl-D's usual behavior). Selections that
+// span more than one line have no single-line "occurrence" to repeat and are
+// rejected.
+func (e *Editor) multiCursorMatchText() (text string, wholeWord bool) {
+	b := e.activeBuffer()
+	if b == nil {
+		return "", false
+	}
+	if e.mode == ModeVisual || e.mode == ModeVisualLine {
+		y1, x1, y2, x2 := e.getSelectionBounds()
+		if y1 != y2 {
+			return "", false
+		}
+		line := b.buffer[y1]
+		end := x2 + 1
+		if end > len(line) {
+			end = len(line)
+		}
+		if x1 >= end {
+			return "", false
+		}
+		return string(line[x1:end]), false
+	}
+	return e.getWordUnderCursor(), true
+}
+
+// AddCursorAtNextMatch is the Sublime/micro "spawn a cursor at the next
+// match" action: it takes the word under the primary cursor, or the current
+// visual selection if one is active, and adds a cursor at the next
+// occurrence after the bottom-most cursor, same as Sublime's Ctrl-D, and
+// remembers the match as a multi-cursor session so
+// SkipMultiCursor/SkipMultiCursorBack can walk it forward and backward.
+func (e *Editor) AddCursorAtNextMatch() {
+	b := e.activeBuffer()
+	if b == nil {
+		return
+	}
+
+	text, wholeWord := e.multiCursorMatchText()
+	if text == "" {
+		e.setMessage("No word or selection to match")
+		return
+	}
+	if text != e.multiCursorMatch || wholeWord != e.multiCursorWholeWord {
+		e.multiCursorMatch = text
+		e.multiCursorWholeWord = wholeWord
+		e.multiCursorHistory = nil
+	}
+	if e.mode == ModeVisual || e.mode == ModeVisualLine {
+		e.mode = ModeNormal
+	}
+
+	afterY, afterX := e.lastCursorPos()
+	y, x, ok := e.nextOccurrence(text, wholeWord, afterY, afterX)
+	if !ok {
+		e.setMessage("No more occurrences of " + text)
+		return
+	}
+
+	b.AddCursor(x, y)
+	b.cursors[len(b.cursors)-1].PreferredCol = x
+	e.multiCursorHistory = append(e.multiCursorHistory, Cursor{X: x, Y: y, PreferredCol: x})
+}
+
+// SkipMultiCursor drops the cursor most recently added by
+// AddCursorAtNextMatch or a previous Skip, and places a new one at the next
+// occurrence after it, so a cursor landed on an unwanted match can be passed
+// over without restarting the whole selection.
+func (e *Editor) SkipMultiCursor() {
+	e.skipMultiCursor(false)
+}
+
+// SkipMultiCursorBack is the backwards twin of SkipMultiCursor, as added to
+// micro in PR #3404: it walks the current multi-cursor match session one
+// step back instead of forward.
+func (e *Editor) SkipMultiCursorBack() {
+	e.skipMultiCursor(true)
+}
+
+// skipMultiCursor implements SkipMultiCursor/SkipMultiCursorBack: it removes
+// the most recently added cursor from the current AddCursorAtNextMatch
+// session and re-adds one at the next (or, if backward, the previous)
+// occurrence of the session's match text, keeping multiCursorHistory in
+// sync so repeated skips keep walking in the same direction.
+func (e *Editor) skipMultiCursor(backward bool) {
+	b := e.activeBuffer()
+	if b == nil {
+		return
+	}
+	if e.multiCursorMatch == "" || len(e.multiCursorHistory) == 0 {
+		e.setMessage("No multi-cursor match to skip")
+		return
+	}
+
+	last := e.multiCursorHistory[len(e.multiCursorHistory)-1]
+	e.multiCursorHistory = e.multiCursorHistory[:len(e.multiCursorHistory)-1]
+	b.removeCursorAt(last.Y, last.X)
+
+	var y, x int
+	var ok bool
+	if backward {
+		y, x, ok = e.prevOccurrence(e.multiCursorMatch, e.multiCursorWholeWord, last.Y, last.X)
+	} else {
+		y, x, ok = e.nextOccurrence(e.multiCursorMatch, e.multiCursorWholeWord, last.Y, last.X)
+	}
+	if !ok {
+		e.setMessage("No more occurrences of " + e.multiCursorMatch)
+		return
+	}
+
+	b.AddCursor(x, y)
+	b.cursors[len(b.cursors)-1].PreferredCol = x
+	e.multiCursorHistory = append(e.multiCursorHistory, Cursor{X: x, Y: y, PreferredCol: x})
+	e.mergeCursors()
+}
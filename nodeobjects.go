@@ -0,0 +1,265 @@
+package main
+
+// Semantic text objects: when the active buffer has a tree-sitter parse
+// tree (b.syntax != nil), "if"/"af", "ic"/"ac", "ib"/"ab" resolve to the
+// smallest node of the right kind (a function, a class, or a
+// block/braced body) enclosing the primary cursor by walking the parse
+// tree, rather than hunting for delimiters textually the way
+// deleteInside/deleteAround do. Which node kinds count as a "function"
+// etc. is per-language, since tree-sitter grammars don't agree on node
+// names (Go calls a function's body "block"; Python calls the whole
+// function "function_definition"). nodeObjectKinds seeds built-in
+// defaults for the languages syntax.go ships queries for; textobjects.rules
+// (see LoadNodeObjectRules) lets users override those or add entirely new
+// letters, e.g. "it"/"at" for an HTML element.
+//
+// textobjects.rules is a sequence of blocks separated by blank lines, one
+// block per language:
+//
+//	lang go
+//	kind f function_declaration,method_declaration
+//	kind b block
+//
+// "lang" must come first in a block and is matched case-insensitively
+// against FileType.Name, same convention as plumb.rules' lang filter.
+// Each "kind" line maps one text-object letter to a comma-separated list
+// of tree-sitter node kinds, tried in that order; a letter already in
+// nodeObjectKinds is overridden rather than merged.
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	sitter "github.com/mitjafelicijan/go-tree-sitter"
+)
+
+// nodeObjectKinds maps a lowercased language name to a table of
+// text-object letter -> the tree-sitter node kinds that count as that
+// object.
+var nodeObjectKinds = map[string]map[rune][]string{
+	"go": {
+		'f': {"function_declaration", "method_declaration"},
+		'c': {"type_declaration"},
+		'b': {"block"},
+	},
+	"python": {
+		'f': {"function_definition"},
+		'c': {"class_definition"},
+		'b': {"block"},
+	},
+	"javascript": {
+		'f': {"function_declaration", "method_definition", "arrow_function", "function"},
+		'c': {"class_declaration"},
+		'b': {"statement_block"},
+	},
+	"typescript": {
+		'f': {"function_declaration", "method_definition", "arrow_function", "function"},
+		'c': {"class_declaration"},
+		'b': {"statement_block"},
+	},
+	"tsx": {
+		'f': {"function_declaration", "method_definition", "arrow_function", "function"},
+		'c': {"class_declaration"},
+		'b': {"statement_block"},
+	},
+	"c": {
+		'f': {"function_definition"},
+		'b': {"compound_statement"},
+	},
+	"c++": {
+		'f': {"function_definition"},
+		'c': {"class_specifier", "struct_specifier"},
+		'b': {"compound_statement"},
+	},
+	"lua": {
+		'f': {"function_declaration", "function_definition"},
+		'b': {"block"},
+	},
+	"php": {
+		'f': {"function_definition", "method_declaration"},
+		'c': {"class_declaration"},
+		'b': {"compound_statement"},
+	},
+}
+
+// textObjectRulesPath returns the path to textobjects.rules, reusing
+// plumb.rules' config directory.
+func textObjectRulesPath() (string, error) {
+	dir, err := plumbConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return dir + "/textobjects.rules", nil
+}
+
+// LoadNodeObjectRules reads textobjects.rules and merges its per-language
+// letter -> node-kind mappings on top of nodeObjectKinds. A missing file
+// is not an error: the built-in defaults above still apply. Malformed
+// blocks are skipped with a log entry rather than aborting the whole file.
+func (e *Editor) LoadNodeObjectRules() {
+	path, err := textObjectRulesPath()
+	if err != nil {
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	var block []string
+	flush := func() {
+		if len(block) == 0 {
+			return
+		}
+		if err := mergeNodeObjectBlock(block); err != nil {
+			e.addLog("TextObjects", fmt.Sprintf("skipping block: %v", err))
+		}
+		block = nil
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			flush()
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		block = append(block, line)
+	}
+	flush()
+}
+
+// mergeNodeObjectBlock parses one textobjects.rules block and merges its
+// "kind" lines into nodeObjectKinds[lang].
+func mergeNodeObjectBlock(lines []string) error {
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "lang ") {
+		return fmt.Errorf("block does not start with \"lang\"")
+	}
+	lang := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(lines[0], "lang")))
+	if lang == "" {
+		return fmt.Errorf("empty lang")
+	}
+
+	kinds := nodeObjectKinds[lang]
+	if kinds == nil {
+		kinds = make(map[rune][]string)
+	}
+
+	for _, line := range lines[1:] {
+		key, value, _ := strings.Cut(line, " ")
+		if key != "kind" {
+			return fmt.Errorf("unknown key %q", key)
+		}
+		letter, nodeKinds, ok := strings.Cut(strings.TrimSpace(value), " ")
+		if !ok || letter == "" || nodeKinds == "" {
+			return fmt.Errorf("malformed kind line %q", line)
+		}
+		letters := []rune(letter)
+		if len(letters) != 1 {
+			return fmt.Errorf("kind letter %q must be a single character", letter)
+		}
+		kinds[letters[0]] = strings.Split(strings.TrimSpace(nodeKinds), ",")
+	}
+
+	nodeObjectKinds[lang] = kinds
+	return nil
+}
+
+// nodeObjectKindsFor returns the letter -> node-kind table for b's
+// language, or nil if b has no file type or the language has no entry.
+func nodeObjectKindsFor(b *Buffer) map[rune][]string {
+	if b.fileType == nil {
+		return nil
+	}
+	return nodeObjectKinds[strings.ToLower(b.fileType.Name)]
+}
+
+// nodeContainsPoint reports whether (row, col) falls within n's
+// [StartPoint, EndPoint] span.
+func nodeContainsPoint(n *sitter.Node, row, col uint32) bool {
+	start, end := n.StartPoint(), n.EndPoint()
+	if row < start.Row || (row == start.Row && col < start.Column) {
+		return false
+	}
+	if row > end.Row || (row == end.Row && col > end.Column) {
+		return false
+	}
+	return true
+}
+
+// smallestNodeAt returns the smallest named node under n (inclusive)
+// containing (row, col), or nil if n itself doesn't. nodeObjectRange walks
+// upward from the result via Parent() to find the nearest enclosing node
+// of a wanted kind.
+func smallestNodeAt(n *sitter.Node, row, col uint32) *sitter.Node {
+	if n == nil || !nodeContainsPoint(n, row, col) {
+		return nil
+	}
+	for i := 0; i < int(n.NamedChildCount()); i++ {
+		if found := smallestNodeAt(n.NamedChild(i), row, col); found != nil {
+			return found
+		}
+	}
+	return n
+}
+
+// nodeObjectRange returns the flat offset range (see textObjectFlat) of
+// the smallest node of one of kinds enclosing (y, x). The "around" range
+// is the whole node; the "inner" range additionally peels off one leading
+// and trailing brace-like character when the node starts and ends with a
+// matching pair, mirroring how deleteInside peels delimiters off
+// deleteAround (see deleteDelimiterPair) — tree-sitter rarely has a
+// separate node for "everything but the braces".
+func nodeObjectRange(b *Buffer, y, x int, kinds []string, around bool) (start, end int, ok bool) {
+	if b.syntax == nil || b.syntax.Tree == nil || len(kinds) == 0 {
+		return 0, 0, false
+	}
+
+	leaf := smallestNodeAt(b.syntax.Tree.RootNode(), uint32(y), uint32(x))
+	if leaf == nil {
+		return 0, 0, false
+	}
+
+	want := make(map[string]bool, len(kinds))
+	for _, k := range kinds {
+		want[k] = true
+	}
+
+	var node *sitter.Node
+	for n := leaf; n != nil; n = n.Parent() {
+		if want[n.Type()] {
+			node = n
+			break
+		}
+	}
+	if node == nil {
+		return 0, 0, false
+	}
+
+	sp, ep := node.StartPoint(), node.EndPoint()
+	flat, startOffset := textObjectFlat(b, int(sp.Row), int(sp.Column))
+	_, endOffsetExcl := textObjectFlat(b, int(ep.Row), int(ep.Column))
+	endOffset := endOffsetExcl - 1
+	if endOffset < startOffset || endOffset >= len(flat) {
+		return 0, 0, false
+	}
+
+	if !around {
+		pairs := map[rune]rune{'{': '}', '(': ')', '[': ']'}
+		if close, ok := pairs[flat[startOffset]]; ok && flat[endOffset] == close && endOffset > startOffset {
+			startOffset++
+			endOffset--
+		}
+	}
+	if endOffset < startOffset {
+		return 0, 0, false
+	}
+	return startOffset, endOffset, true
+}
@@ -4,11 +4,13 @@ package main
 // availability and generate text completions.
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/nsf/termbox-go"
@@ -20,10 +22,14 @@ type OllamaClient struct {
 	URL      string // Base API endpoint for status checks.
 }
 
-// GenerateRequest defines the payload for text generation.
+// GenerateRequest defines the payload for text generation. Suffix is only
+// meaningful to fill-in-the-middle-capable models: it's the text that
+// follows the cursor, so the model completes the gap between Prompt and
+// Suffix instead of just continuing Prompt.
 type GenerateRequest struct {
 	Model  string `json:"model"`
 	Prompt string `json:"prompt"`
+	Suffix string `json:"suffix,omitempty"`
 	Stream bool   `json:"stream"`
 }
 
@@ -79,39 +85,70 @@ func (c *OllamaClient) CheckStatus() bool {
 	return c.IsOnline
 }
 
-// Generate sends a prompt to the LLM and returns the generated text.
+// Generate sends a prompt to the LLM and returns the generated text. It's a
+// thin wrapper around GenerateStream that accumulates every chunk instead of
+// reporting them as they arrive, for callers (ollamaComplete) that just want
+// the final result.
 func (c *OllamaClient) Generate(prompt string) (string, error) {
+	var out strings.Builder
+	err := c.GenerateStream(context.Background(), prompt, "", func(chunk string) {
+		out.WriteString(chunk)
+	})
+	return out.String(), err
+}
+
+// GenerateStream sends a (prompt, suffix) fill-in-the-middle request with
+// Stream: true and invokes onChunk with each partial response as it arrives
+// off the wire, until the server reports Done or ctx is cancelled. Ollama's
+// streaming /api/generate emits one JSON object per line (NDJSON), so each
+// line is decoded independently rather than as a single JSON document.
+func (c *OllamaClient) GenerateStream(ctx context.Context, prompt, suffix string, onChunk func(string)) error {
 	url := fmt.Sprintf("%s/api/generate", Config.OllamaURL)
 	reqBody := GenerateRequest{
 		Model:  Config.OllamaModel,
 		Prompt: prompt,
-		Stream: false, // We want the full result at once for simplified handling.
+		Suffix: suffix,
+		Stream: true,
 	}
 
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", err
+		return err
 	}
-	defer resp.Body.Close()
+	req.Header.Set("Content-Type", "application/json")
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("ollama error: %s", resp.Status)
-	}
-
-	body, err := io.ReadAll(resp.Body)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return "", err
+		return err
 	}
+	defer resp.Body.Close()
 
-	var genResp GenerateResponse
-	if err := json.Unmarshal(body, &genResp); err != nil {
-		return "", err
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ollama error: %s", resp.Status)
 	}
 
-	return genResp.Response, nil
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var chunk GenerateResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return err
+		}
+		if chunk.Response != "" {
+			onChunk(chunk.Response)
+		}
+		if chunk.Done {
+			return nil
+		}
+	}
+	return scanner.Err()
 }
@@ -0,0 +1,545 @@
+package main
+
+// Plumber: a pluggable rule engine, modeled on the Plan 9 / acme plumber.
+// `gf` (gotoFile, see editor.go) runs the text under the cursor through it
+// to decide what to do; `gp` (Plumb, below) does the same for the current
+// visual selection, or the WORD under the cursor outside Visual mode, so
+// any text can be routed to a rule rather than just a path-shaped one.
+// Rules live in $XDG_CONFIG_HOME/qwe/plumb.rules (falling back to
+// ~/.config/qwe/plumb.rules) and are tried in file order; the first rule
+// whose pattern matches the extracted text, and whose optional
+// ext/dir/lang filters all pass, wins. If no rule matches gotoFile falls
+// back to plumbFallback's built-in file/URL heuristic; Plumb, having no
+// cursor-shaped text to fall back to interpreting, just reports no match.
+//
+// plumb.rules is a sequence of blocks separated by blank lines. Each
+// non-empty, non-comment ('#') line in a block is "key value...":
+//
+//	pattern <regexp>       required; matched against the extracted text
+//	chars   word|path|url  optional; overrides the character class used to
+//	                        extract the text under the cursor (default: the
+//	                        same path class getPathUnderCursor uses)
+//	ext     .go,.py         optional; only applies to buffers whose filename
+//	                        has one of these extensions
+//	dir     <glob>          optional; only applies when the current working
+//	                        directory matches this glob (filepath.Match)
+//	lang    go              optional; only applies when the buffer's file
+//	                        type name matches, case-insensitively
+//	action  open-file <template>
+//	action  open-url <template>
+//	action  run-command <argv...>
+//	action  pipe-through <argv...>
+//	action  insert-into-buffer <template>
+//	action  send-to-lsp
+//
+// Templates and run-command arguments may reference the pattern's capture
+// groups as $1, $2, etc. (see regexp.Regexp.Expand).
+//
+// Example block that turns `go test` failure locations into jumps:
+//
+//	pattern ([\w./-]+\.go):(\d+)(?::(\d+))?
+//	action open-file $1:$2:$3
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PlumbAction identifies what a matched PlumbRule does with its expanded
+// template(s).
+type PlumbAction int
+
+const (
+	PlumbOpenFile PlumbAction = iota
+	PlumbOpenURL
+	PlumbRunCommand
+	PlumbPipeThrough
+	PlumbInsertIntoBuffer
+	PlumbSendToLSP
+)
+
+// PlumbRule is one block of plumb.rules: a pattern plus optional context
+// filters and the action to dispatch on match.
+type PlumbRule struct {
+	pattern *regexp.Regexp
+	chars   string // "", "word", "path", or "url"; "" means the default path class.
+	exts    []string
+	dir     string
+	lang    string
+	action  PlumbAction
+	args    []string // A single template for most actions; argv for run-command.
+}
+
+// plumbConfigDir returns $XDG_CONFIG_HOME/qwe (falling back to
+// ~/.config/qwe), creating it if necessary.
+func plumbConfigDir() (string, error) {
+	base := os.Getenv("XDG_CONFIG_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(home, ".config")
+	}
+
+	dir := filepath.Join(base, "qwe")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// plumbRulesPath returns the path to plumb.rules.
+func plumbRulesPath() (string, error) {
+	dir, err := plumbConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "plumb.rules"), nil
+}
+
+// LoadPlumbRules reads and compiles plumb.rules into e.plumbRules. A
+// missing file is not an error: the plumber simply has no rules and
+// gotoFile falls back to plumbFallback. Malformed blocks are skipped with
+// a log entry rather than aborting the whole file.
+func (e *Editor) LoadPlumbRules() {
+	path, err := plumbRulesPath()
+	if err != nil {
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	var rules []PlumbRule
+	var block []string
+	flush := func() {
+		if len(block) == 0 {
+			return
+		}
+		rule, err := parsePlumbBlock(block)
+		if err != nil {
+			e.addLog("Plumber", fmt.Sprintf("skipping rule: %v", err))
+		} else {
+			rules = append(rules, rule)
+		}
+		block = nil
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			flush()
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		block = append(block, line)
+	}
+	flush()
+
+	e.plumbRules = rules
+}
+
+// parsePlumbBlock compiles a single plumb.rules block into a PlumbRule.
+func parsePlumbBlock(lines []string) (PlumbRule, error) {
+	var rule PlumbRule
+	haveAction := false
+
+	for _, line := range lines {
+		key, value, _ := strings.Cut(line, " ")
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "pattern":
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return PlumbRule{}, fmt.Errorf("pattern %q: %w", value, err)
+			}
+			rule.pattern = re
+		case "chars":
+			rule.chars = value
+		case "ext":
+			rule.exts = strings.Split(value, ",")
+		case "dir":
+			rule.dir = value
+		case "lang":
+			rule.lang = value
+		case "action":
+			action, args, err := parsePlumbAction(value)
+			if err != nil {
+				return PlumbRule{}, err
+			}
+			rule.action, rule.args = action, args
+			haveAction = true
+		default:
+			return PlumbRule{}, fmt.Errorf("unknown key %q", key)
+		}
+	}
+
+	if rule.pattern == nil {
+		return PlumbRule{}, fmt.Errorf("rule has no pattern")
+	}
+	if !haveAction {
+		return PlumbRule{}, fmt.Errorf("rule has no action")
+	}
+	return rule, nil
+}
+
+// parsePlumbAction splits an "action" line's value into its PlumbAction and
+// arguments.
+func parsePlumbAction(value string) (PlumbAction, []string, error) {
+	kind, rest, _ := strings.Cut(value, " ")
+	rest = strings.TrimSpace(rest)
+
+	switch kind {
+	case "open-file":
+		return PlumbOpenFile, []string{rest}, nil
+	case "open-url":
+		return PlumbOpenURL, []string{rest}, nil
+	case "insert-into-buffer":
+		return PlumbInsertIntoBuffer, []string{rest}, nil
+	case "run-command":
+		return PlumbRunCommand, strings.Fields(rest), nil
+	case "pipe-through":
+		return PlumbPipeThrough, strings.Fields(rest), nil
+	case "send-to-lsp":
+		return PlumbSendToLSP, nil, nil
+	default:
+		return 0, nil, fmt.Errorf("unknown action %q", kind)
+	}
+}
+
+// plumbExtractor returns the character-class predicate used to pull text
+// out from under the cursor for a rule, honoring its chars override.
+func (e *Editor) plumbExtractor(chars string) func(rune) bool {
+	switch chars {
+	case "word":
+		return e.isWordChar
+	case "url":
+		return func(r rune) bool {
+			return e.isPathChar(r) || r == '?' || r == '&' || r == '=' || r == '%' || r == '#'
+		}
+	default:
+		return e.isPathChar
+	}
+}
+
+// plumbRuleApplies reports whether rule's optional ext/dir/lang filters all
+// pass for the active buffer.
+func (e *Editor) plumbRuleApplies(rule PlumbRule, b *Buffer) bool {
+	if len(rule.exts) > 0 {
+		ext := filepath.Ext(b.filename)
+		matched := false
+		for _, want := range rule.exts {
+			if strings.EqualFold(strings.TrimSpace(want), ext) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if rule.dir != "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return false
+		}
+		ok, err := filepath.Match(rule.dir, cwd)
+		if err != nil || !ok {
+			return false
+		}
+	}
+
+	if rule.lang != "" {
+		if b.fileType == nil || !strings.EqualFold(b.fileType.Name, rule.lang) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// dispatchPlumbRule expands rule's template(s) against text using the
+// submatch indices FindStringSubmatchIndex found, and performs the
+// corresponding action.
+func (e *Editor) dispatchPlumbRule(rule PlumbRule, text string, match []int) {
+	expand := func(tmpl string) string {
+		return string(rule.pattern.ExpandString(nil, tmpl, text, match))
+	}
+
+	switch rule.action {
+	case PlumbOpenFile:
+		e.plumbOpenFile(expand(rule.args[0]))
+	case PlumbOpenURL:
+		e.openURL(expand(rule.args[0]))
+	case PlumbInsertIntoBuffer:
+		e.plumbInsertIntoBuffer(expand(rule.args[0]))
+	case PlumbRunCommand:
+		argv := make([]string, len(rule.args))
+		for i, a := range rule.args {
+			argv[i] = expand(a)
+		}
+		e.plumbRunCommand(argv)
+	case PlumbPipeThrough:
+		argv := make([]string, len(rule.args))
+		for i, a := range rule.args {
+			argv[i] = expand(a)
+		}
+		e.plumbPipeThrough(argv, text)
+	case PlumbSendToLSP:
+		e.gotoDefinition()
+	}
+}
+
+// plumbOpenFile opens target, which may carry trailing :line[:col]
+// references (e.g. "errors.go:42:15"), resolving a relative path against
+// the active buffer's directory and falling back to the path as given
+// (i.e. relative to the CWD), same as plumbFallback.
+func (e *Editor) plumbOpenFile(target string) {
+	path, line, col := splitPathLineCol(target)
+	if path == "" {
+		e.setMessage("Plumber: no path in open-file target")
+		return
+	}
+
+	targetPath := path
+	if b := e.activeBuffer(); b != nil {
+		if candidate := filepath.Join(filepath.Dir(b.filename), path); fileExists(candidate) {
+			targetPath = candidate
+		}
+	}
+	if !fileExists(targetPath) {
+		e.setMessage("Plumber: file not found: " + path)
+		return
+	}
+
+	absPath, err := filepath.Abs(targetPath)
+	if err != nil {
+		e.setMessage("Plumber: error resolving path: " + err.Error())
+		return
+	}
+
+	e.pushJump()
+	for i, buf := range e.buffers {
+		bufAbs, _ := filepath.Abs(buf.filename)
+		if absPath == bufAbs {
+			e.activeBufferIndex = i
+			e.plumbGotoLineCol(line, col)
+			return
+		}
+	}
+
+	if err := e.LoadFile(targetPath); err != nil {
+		e.setMessage("Plumber: error opening file: " + err.Error())
+		return
+	}
+	e.plumbGotoLineCol(line, col)
+}
+
+// fileExists reports whether path names an existing file.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// splitPathLineCol parses "path[:line[:col]]", returning 1-based line/col
+// (0 when absent or not a number).
+func splitPathLineCol(target string) (path string, line, col int) {
+	parts := strings.Split(target, ":")
+	path = parts[0]
+	if len(parts) > 1 {
+		line, _ = strconv.Atoi(parts[1])
+	}
+	if len(parts) > 2 {
+		col, _ = strconv.Atoi(parts[2])
+	}
+	return path, line, col
+}
+
+// plumbGotoLineCol moves the active buffer's primary cursor to a 1-based
+// line/col reference, ignoring a zero or out-of-range line.
+func (e *Editor) plumbGotoLineCol(line, col int) {
+	if line <= 0 {
+		return
+	}
+	b := e.activeBuffer()
+	if b == nil {
+		return
+	}
+	y := line - 1
+	if y < 0 || y >= len(b.buffer) {
+		return
+	}
+	x := 0
+	if col > 0 {
+		x = col - 1
+	}
+	if x > len(b.buffer[y]) {
+		x = len(b.buffer[y])
+	}
+
+	cursor := b.PrimaryCursor()
+	cursor.Y = y
+	cursor.X = x
+	cursor.PreferredCol = x
+}
+
+// plumbInsertIntoBuffer types text at the cursor through the normal
+// insertion path, so it participates in undo coalescing and diagnostics
+// like any other edit.
+func (e *Editor) plumbInsertIntoBuffer(text string) {
+	for _, r := range text {
+		if r == '\n' {
+			e.insertNewline()
+			continue
+		}
+		e.insertRune(r)
+	}
+	e.checkDiagnostics()
+}
+
+// plumbRunCommand runs argv directly (no shell, so captured text can't be
+// interpreted as shell syntax), streaming its output into the "[Plumber]"
+// scratch buffer. Mirrors executeShell's job-tracking (see shell.go).
+func (e *Editor) plumbRunCommand(argv []string) {
+	if len(argv) == 0 {
+		e.setMessage("Plumber: run-command has no arguments")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	attachProcessGroup(cmd)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		e.setMessage(fmt.Sprintf("Plumber: %v", err))
+		return
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		cancel()
+		e.setMessage(fmt.Sprintf("Plumber: %v", err))
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		cancel()
+		e.setMessage(fmt.Sprintf("Plumber: error starting command: %v", err))
+		return
+	}
+
+	job := &ShellJob{cmdline: strings.Join(argv, " "), cmd: cmd, cancel: cancel, startedAt: time.Now(), running: true}
+	e.activeShellJob = job
+
+	outBuf := e.getOrCreateScratchBuffer("[Plumber]")
+	appendScratchLine(outBuf, fmt.Sprintf("$ %s", job.cmdline))
+
+	go e.commands.streamShellOutput(job, outBuf, io.MultiReader(stdout, stderr))
+}
+
+// plumbPipeThrough runs argv synchronously with text piped to its stdin and
+// inserts its stdout in place of the plumbed text — generalizing the
+// selection-in, AI-response-out plumbing ollamaComplete does for Ollama
+// (see editor.go) to any external filter. Unlike plumbRunCommand, which
+// streams fire-and-forget output into a scratch buffer, this blocks until
+// the command exits, since the result has to land at a specific spot in the
+// active buffer.
+func (e *Editor) plumbPipeThrough(argv []string, text string) {
+	if len(argv) == 0 {
+		e.setMessage("Plumber: pipe-through has no arguments")
+		return
+	}
+	if e.activeBuffer() == nil {
+		return
+	}
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		msg := err.Error()
+		if stderr.Len() > 0 {
+			msg = strings.TrimSpace(stderr.String())
+		}
+		e.setMessage(fmt.Sprintf("Plumber: %s", msg))
+		return
+	}
+
+	if e.mode == ModeVisual || e.mode == ModeVisualLine {
+		e.deleteVisualSelection()
+	}
+	e.plumbInsertIntoBuffer(strings.TrimRight(string(out), "\n"))
+}
+
+// plumbTargetText returns what Plumb (the `gp` action) should hunt a rule
+// for: the visual selection verbatim if one is active, or the WORD touching
+// the primary cursor otherwise. Unlike gotoFile, which narrows the text it
+// extracts to each rule's own chars class, Plumb always matches the whole
+// selection or WORD, since it's meant to act on arbitrary text (shell
+// output, a URL with a query string) rather than just a path under the
+// cursor.
+func (e *Editor) plumbTargetText() string {
+	if e.mode == ModeVisual || e.mode == ModeVisualLine {
+		return strings.TrimSpace(string(e.getSelection()))
+	}
+	return e.getWORDUnderCursor()
+}
+
+// Plumb is the `gp` normal/visual-mode action: Plan 9's "plumb this"
+// (traditionally a middle-click) applied to the current visual selection,
+// or the WORD under the cursor outside Visual mode. It runs plumbTargetText
+// through the same plumb.rules chain gotoFile uses and dispatches the first
+// matching rule; unlike gotoFile there's no path/URL fallback, since the
+// text plumbed here isn't assumed to be a path in the first place.
+func (e *Editor) Plumb() {
+	b := e.activeBuffer()
+	if b == nil {
+		return
+	}
+
+	text := e.plumbTargetText()
+	if text == "" {
+		e.setMessage("Nothing to plumb")
+		return
+	}
+	inVisual := e.mode == ModeVisual || e.mode == ModeVisualLine
+
+	for _, rule := range e.plumbRules {
+		if !e.plumbRuleApplies(rule, b) {
+			continue
+		}
+		match := rule.pattern.FindStringSubmatchIndex(text)
+		if match == nil {
+			continue
+		}
+		e.dispatchPlumbRule(rule, text, match)
+		if inVisual {
+			e.mode = ModeNormal
+		}
+		return
+	}
+
+	e.setMessage("Plumber: no rule matches " + text)
+}
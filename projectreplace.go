@@ -0,0 +1,271 @@
+package main
+
+// Project-wide regex search-and-replace (:project-replace), spanning every
+// open Buffer plus the filesystem rooted at the working directory. It's a
+// preview-then-commit workflow rather than an interactive one like Replace
+// mode: :project-replace builds a "[Project Replace]" scratch buffer listing
+// every matching file with its match count, the user deletes lines to
+// exclude files they don't want touched, then :project-replace-apply commits
+// the edits that remain.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// projectReplaceEntry is one row of a project-wide replace: a file (open
+// buffer or on-disk) and how many times the pattern matched in it. buf is
+// nil for files that aren't open in any buffer; when non-nil it's the
+// *Buffer itself rather than its index into e.buffers, since closing any
+// other buffer between :project-replace and :project-replace-apply (e.g.
+// deleteCurrentBuffer) shifts every later index down - a stale index would
+// silently apply the edit to whatever buffer now sits there. line is the
+// exact text projectReplaceCommand placed in the "[Project Replace]"
+// preview buffer for this entry, so projectReplaceApply can tell which
+// entries the user left in place by comparing against the buffer's current
+// lines rather than trying to re-parse arbitrary edited text.
+type projectReplaceEntry struct {
+	file  string
+	buf   *Buffer
+	count int
+	line  string
+}
+
+// projectReplaceCommand implements `:project-replace /pattern/replacement/flags`.
+// It counts matches across every open buffer and every non-ignored file on
+// disk, then opens a preview buffer grouped by file for the user to narrow
+// down before running :project-replace-apply.
+func (ch *Command) projectReplaceCommand(input string) {
+	e := ch.e
+
+	pattern, replacement, flags, err := parseReplaceCommand(input)
+	if err != nil || pattern == "" {
+		e.setMessage("Usage: :project-replace /pattern/replacement/flags")
+		return
+	}
+
+	re, err := compileReplacePattern(pattern, flags)
+	if err != nil {
+		e.setMessage("Invalid regex pattern")
+		return
+	}
+
+	seen := map[string]bool{} // Absolute paths already accounted for via an open buffer.
+	var entries []projectReplaceEntry
+
+	for _, b := range e.buffers {
+		if b.filename == "" || b.bufType != BufTypeDefault {
+			continue
+		}
+		count := len(re.FindAllString(b.toString(), -1))
+		if count == 0 {
+			continue
+		}
+		if abs, err := filepath.Abs(b.filename); err == nil {
+			seen[abs] = true
+		}
+		entries = append(entries, projectReplaceEntry{file: b.filename, buf: b, count: count})
+	}
+
+	filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" || info.Name() == "node_modules" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fuzzyIgnored(path) {
+			return nil
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil || seen[abs] {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		count := len(re.FindAll(data, -1))
+		if count == 0 {
+			return nil
+		}
+		entries = append(entries, projectReplaceEntry{file: path, count: count})
+		return nil
+	})
+
+	if len(entries) == 0 {
+		e.setMessage("Pattern not found in any open buffer or workspace file")
+		return
+	}
+
+	lines := make([][]rune, 0, len(entries))
+	for i := range entries {
+		entries[i].line = fmt.Sprintf("%s (%d matches)", entries[i].file, entries[i].count)
+		lines = append(lines, []rune(entries[i].line))
+	}
+
+	e.projectReplacePattern = pattern
+	e.projectReplaceReplacement = replacement
+	e.projectReplaceFlags = flags
+	e.projectReplaceEntries = entries
+
+	b := &Buffer{
+		buffer:    lines,
+		filename:  "[Project Replace]",
+		undoStack: []Edit{},
+		redoStack: []Edit{},
+		fileType:  getFileType("[Project Replace]"),
+	}
+	b.setBufType(BufTypeScratch)
+	e.buffers = append(e.buffers, b)
+	e.activeBufferIndex = len(e.buffers) - 1
+	e.setMessage(fmt.Sprintf("%d file(s) match. Delete lines to exclude, then :project-replace-apply", len(entries)))
+}
+
+// projectReplaceApplyCommand implements `:project-replace-apply`. It reuses
+// the last :project-replace's pattern/replacement/flags, applying them only
+// to entries whose preview line is still present somewhere in the
+// "[Project Replace]" buffer (the 'c' confirm-each flag isn't meaningful at
+// this scope, since the preview/toggle step already is the per-file
+// confirmation). Open buffers go through the same replaceInRange +
+// saveState + Reparse path as the rest of the replace subsystem; files with
+// no open buffer are streamed through the regex and rewritten atomically.
+func (ch *Command) projectReplaceApplyCommand() {
+	e := ch.e
+	if len(e.projectReplaceEntries) == 0 {
+		e.setMessage("No pending :project-replace")
+		return
+	}
+
+	b := e.activeBuffer()
+	if b == nil || b.filename != "[Project Replace]" {
+		e.setMessage(":project-replace-apply must be run from the [Project Replace] buffer")
+		return
+	}
+
+	kept := map[string]bool{}
+	for _, line := range b.buffer {
+		kept[string(line)] = true
+	}
+
+	re, err := compileReplacePattern(e.projectReplacePattern, e.projectReplaceFlags)
+	if err != nil {
+		e.setMessage("Invalid regex pattern")
+		return
+	}
+
+	previewIndex := e.activeBufferIndex
+	filesChanged, matchesChanged := 0, 0
+	for _, entry := range e.projectReplaceEntries {
+		if !kept[entry.line] {
+			continue
+		}
+
+		if entry.buf != nil {
+			idx := indexOfBuffer(e.buffers, entry.buf)
+			if idx < 0 {
+				// Closed since :project-replace; the file may still be on
+				// disk, but re-targeting it there risks double-applying
+				// whatever of the buffer's unsaved edits did or didn't make
+				// it to disk, so it's simplest and safest to just skip it.
+				continue
+			}
+			ob := entry.buf
+			// saveState records undo history against e.activeBuffer(), so
+			// it has to be switched to ob for the duration of this edit.
+			e.activeBufferIndex = idx
+			e.saveState()
+			e.activeBufferIndex = previewIndex
+			endY := len(ob.buffer) - 1
+			endX := 0
+			if endY >= 0 {
+				endX = len(ob.buffer[endY])
+			}
+			count := replaceInRange(ob, 0, 0, endY, endX, re, e.projectReplaceReplacement, e.projectReplaceFlags.global)
+			if count > 0 {
+				ob.modified = true
+				ob.searchMatches = nil
+				filesChanged++
+				matchesChanged += count
+			}
+			if ob.syntax != nil {
+				ob.syntaxReparse()
+			}
+			continue
+		}
+
+		count, err := replaceFileOnDisk(entry.file, re, e.projectReplaceReplacement, e.projectReplaceFlags.global)
+		if err != nil {
+			e.addLog("ProjectReplace", fmt.Sprintf("Failed to rewrite %s: %v", entry.file, err))
+			continue
+		}
+		if count > 0 {
+			filesChanged++
+			matchesChanged += count
+		}
+	}
+
+	e.projectReplacePattern = ""
+	e.projectReplaceReplacement = ""
+	e.projectReplaceFlags = ReplaceFlags{}
+	e.projectReplaceEntries = nil
+
+	e.setMessage(fmt.Sprintf("%d replacement(s) across %d file(s)", matchesChanged, filesChanged))
+}
+
+// indexOfBuffer returns buf's current index in buffers, or -1 if it's no
+// longer open (e.g. closed via deleteCurrentBuffer since it was recorded).
+func indexOfBuffer(buffers []*Buffer, buf *Buffer) int {
+	for i, b := range buffers {
+		if b == buf {
+			return i
+		}
+	}
+	return -1
+}
+
+// replaceFileOnDisk rewrites an on-disk-only file (no open buffer) by
+// streaming its content through re, then writing the result atomically via
+// writeFileAtomic (temp file + rename), the same guarantee :w and :<range>w
+// already rely on.
+func replaceFileOnDisk(path string, re *regexp.Regexp, replacement string, global bool) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	content := string(data)
+
+	var newContent string
+	var count int
+	if global {
+		count = len(re.FindAllString(content, -1))
+		newContent = re.ReplaceAllString(content, replacement)
+	} else if re.MatchString(content) {
+		// Only the first match in the whole file is replaced, mirroring
+		// replaceInRange's per-line "first match" semantics for non-global.
+		count = 1
+		first := true
+		newContent = re.ReplaceAllStringFunc(content, func(match string) string {
+			if !first {
+				return match
+			}
+			first = false
+			return re.ReplaceAllString(match, replacement)
+		})
+	} else {
+		newContent = content
+	}
+
+	if count == 0 {
+		return 0, nil
+	}
+	if err := writeFileAtomic(path, newContent); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,527 @@
+package main
+
+// Ex-style ranges and the :g/:v global operators. Parses addresses like
+// `10,20`, `.,+5`, `%`, and `'<,'>` in front of a command letter, resolves
+// them against the active buffer, and dispatches to range-aware
+// implementations of d, y, w, ! and s.
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ExRange is a resolved, 0-based, inclusive line range.
+type ExRange struct {
+	start    int
+	end      int
+	hasRange bool
+}
+
+// parseExRange consumes the leading address portion of an ex command line and
+// returns the resolved range plus whatever text follows it (the command and
+// its arguments). If no range is present, hasRange is false and rest == cmd.
+func (ch *Command) parseExRange(cmd string) (ExRange, string) {
+	b := ch.e.activeBuffer()
+	if b == nil {
+		return ExRange{}, cmd
+	}
+
+	if strings.HasPrefix(cmd, "%") {
+		return ExRange{start: 0, end: len(b.buffer) - 1, hasRange: true}, cmd[1:]
+	}
+
+	addr1, rest, ok := ch.parseExAddress(cmd, b.PrimaryCursor().Y)
+	if !ok {
+		return ExRange{}, cmd
+	}
+
+	if !strings.HasPrefix(rest, ",") {
+		// A single address without a comma isn't a "range" in the :g/:s
+		// sense used here; treat the whole string as unranged.
+		return ExRange{}, cmd
+	}
+
+	addr2, rest2, ok := ch.parseExAddress(rest[1:], addr1)
+	if !ok {
+		return ExRange{}, cmd
+	}
+
+	start, end := addr1, addr2
+	if start > end {
+		start, end = end, start
+	}
+	return ExRange{start: start, end: end, hasRange: true}, rest2
+}
+
+// parseExAddress parses a single address token (line number, '.', '$', '<,
+// '>, a named mark, or a +N/-N offset from base) and returns the 0-based
+// line, the unconsumed remainder, and whether parsing succeeded.
+func (ch *Command) parseExAddress(s string, base int) (int, string, bool) {
+	b := ch.e.activeBuffer()
+	if b == nil || s == "" {
+		return 0, s, false
+	}
+
+	switch {
+	case s[0] == '.':
+		return b.PrimaryCursor().Y, s[1:], true
+	case s[0] == '$':
+		return len(b.buffer) - 1, s[1:], true
+	case s[0] == '/' || s[0] == '?':
+		return ch.searchAddress(s, base)
+	case strings.HasPrefix(s, "'<"):
+		return ch.e.lastVisualStartY, s[2:], true
+	case strings.HasPrefix(s, "'>"):
+		return ch.e.lastVisualEndY, s[2:], true
+	case s[0] == '\'' && len(s) >= 2:
+		line, ok := ch.e.marks[rune(s[1])]
+		return line, s[2:], ok
+	case s[0] == '+' || s[0] == '-':
+		n, rest := consumeInt(s[1:])
+		if n == 0 && rest == s[1:] {
+			n = 1 // Bare '+'/'-' means one line.
+		}
+		if s[0] == '-' {
+			n = -n
+		}
+		return base + n, rest, true
+	case s[0] >= '0' && s[0] <= '9':
+		numStr, rest := consumeInt(s)
+		line, err := strconv.Atoi(numStr)
+		if err != nil {
+			return 0, s, false
+		}
+		return line - 1, rest, true // Ex line numbers are 1-based.
+	}
+
+	return 0, s, false
+}
+
+// searchAddress parses a leading /pattern/ or ?pattern? address and resolves
+// it to the line of the next (/.../) or previous (?...?) match relative to
+// base, wrapping around the buffer if necessary.
+func (ch *Command) searchAddress(s string, base int) (int, string, bool) {
+	b := ch.e.activeBuffer()
+	delim := s[0]
+	end := strings.IndexByte(s[1:], delim)
+	if end == -1 {
+		return 0, s, false
+	}
+	pattern := s[1 : end+1]
+	rest := s[end+2:]
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return 0, s, false
+	}
+
+	n := len(b.buffer)
+	if n == 0 {
+		return 0, s, false
+	}
+
+	if delim == '/' {
+		for i := 1; i <= n; i++ {
+			y := (base + i) % n
+			if re.MatchString(string(b.buffer[y])) {
+				return y, rest, true
+			}
+		}
+	} else {
+		for i := 1; i <= n; i++ {
+			y := ((base-i)%n + n) % n
+			if re.MatchString(string(b.buffer[y])) {
+				return y, rest, true
+			}
+		}
+	}
+	return 0, s, false
+}
+
+// consumeInt reads a run of digits off the front of s, returning the digit
+// string consumed and the remainder.
+func consumeInt(s string) (string, string) {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// clampRange keeps a resolved range within the bounds of the buffer.
+func clampRange(r ExRange, lastLine int) ExRange {
+	if r.start < 0 {
+		r.start = 0
+	}
+	if r.end > lastLine {
+		r.end = lastLine
+	}
+	return r
+}
+
+// HandleRanged is the entry point for commands that begin with an address.
+// It returns true if `cmd` was recognized and handled as a ranged command.
+func (ch *Command) HandleRanged(cmd string) bool {
+	r, rest := ch.parseExRange(cmd)
+	if !r.hasRange {
+		return false
+	}
+
+	b := ch.e.activeBuffer()
+	if b == nil || len(b.buffer) == 0 {
+		return true
+	}
+	r = clampRange(r, len(b.buffer)-1)
+
+	switch {
+	case rest == "d":
+		ch.rangeDelete(r)
+	case rest == "y":
+		ch.rangeYank(r)
+	case rest == "p":
+		ch.rangePrint(r)
+	case rest == "w" || strings.HasPrefix(rest, "w "):
+		filename := strings.TrimSpace(strings.TrimPrefix(rest, "w"))
+		ch.rangeWrite(r, filename)
+	case strings.HasPrefix(rest, "!"):
+		ch.rangeFilter(r, strings.TrimPrefix(rest, "!"))
+	case strings.HasPrefix(rest, "s/") || strings.HasPrefix(rest, "s,"):
+		ch.rangeSubstitute(r, strings.TrimPrefix(rest, "s"))
+	case strings.HasPrefix(rest, "g/") || strings.HasPrefix(rest, "g!"):
+		ch.globalCommand(r, rest, false)
+	case strings.HasPrefix(rest, "v/"):
+		ch.globalCommand(r, rest, true)
+	case strings.HasPrefix(rest, "x/"):
+		ch.matchCommand(r, strings.TrimPrefix(rest, "x"))
+	case strings.HasPrefix(rest, "c/"):
+		ch.rangeReplaceText(r, strings.TrimPrefix(rest, "c"))
+	case strings.HasPrefix(rest, "a/"):
+		ch.rangeInsertText(r, strings.TrimPrefix(rest, "a"), false)
+	case strings.HasPrefix(rest, "i/"):
+		ch.rangeInsertText(r, strings.TrimPrefix(rest, "i"), true)
+	default:
+		return false
+	}
+	return true
+}
+
+// rangePrint writes every line in the range to the message bar, acme's "p"
+// command. Used mostly as the no-op tail of an address (e.g. "/func /,/^}/p")
+// to preview what a range resolved to.
+func (ch *Command) rangePrint(r ExRange) {
+	b := ch.e.activeBuffer()
+	lines := make([]string, 0, r.end-r.start+1)
+	for i := r.start; i <= r.end; i++ {
+		lines = append(lines, string(b.buffer[i]))
+	}
+	ch.e.setMessage(strings.Join(lines, " | "))
+}
+
+// delimitedArg extracts the text between a leading and trailing delimiter
+// (e.g. "/hello/" -> "hello"), as used by acme's a/i/c commands.
+func delimitedArg(s string) (string, bool) {
+	if len(s) < 2 || s[0] != '/' {
+		return "", false
+	}
+	end := strings.IndexByte(s[1:], '/')
+	if end == -1 {
+		return "", false
+	}
+	return s[1 : end+1], true
+}
+
+// rangeReplaceText implements acme's "c" command: replace every line in the
+// range with a single line of text.
+func (ch *Command) rangeReplaceText(r ExRange, arg string) {
+	text, ok := delimitedArg(arg)
+	if !ok {
+		ch.e.setMessage("Usage: :<range>c/text/")
+		return
+	}
+	b := ch.e.activeBuffer()
+	if b.readOnly {
+		ch.e.setMessage("File is read-only")
+		return
+	}
+	ch.e.saveState()
+	b.buffer = append(b.buffer[:r.start], append([][]rune{[]rune(text)}, b.buffer[r.end+1:]...)...)
+	ch.e.markModified()
+	ch.e.setMessage(fmt.Sprintf("%d lines changed", r.end-r.start+1))
+}
+
+// rangeInsertText implements acme's "a"/"i" commands: insert a single line of
+// text after (a) or before (i) the range.
+func (ch *Command) rangeInsertText(r ExRange, arg string, before bool) {
+	text, ok := delimitedArg(arg)
+	if !ok {
+		ch.e.setMessage("Usage: :<range>a/text/ or :<range>i/text/")
+		return
+	}
+	b := ch.e.activeBuffer()
+	if b.readOnly {
+		ch.e.setMessage("File is read-only")
+		return
+	}
+	ch.e.saveState()
+	at := r.end + 1
+	if before {
+		at = r.start
+	}
+	newBuffer := make([][]rune, 0, len(b.buffer)+1)
+	newBuffer = append(newBuffer, b.buffer[:at]...)
+	newBuffer = append(newBuffer, []rune(text))
+	newBuffer = append(newBuffer, b.buffer[at:]...)
+	b.buffer = newBuffer
+	ch.e.markModified()
+	ch.e.setMessage("1 line inserted")
+}
+
+// matchCommand implements acme's "x/re/cmd": cmd runs once per match of re
+// found anywhere in the range (unlike :g, which matches whole lines), with
+// the cursor positioned at the start of each match before cmd runs.
+func (ch *Command) matchCommand(r ExRange, rest string) {
+	if rest == "" || rest[0] != '/' {
+		ch.e.setMessage("Usage: :x/pattern/cmd")
+		return
+	}
+
+	end := strings.Index(rest[1:], "/")
+	if end == -1 {
+		ch.e.setMessage("Usage: :x/pattern/cmd")
+		return
+	}
+	pattern := rest[1 : end+1]
+	subCmd := strings.TrimSpace(rest[end+2:])
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		ch.e.setMessage("Invalid regex pattern")
+		return
+	}
+
+	b := ch.e.activeBuffer()
+	if b == nil {
+		return
+	}
+
+	type matchPos struct{ y, x int }
+	var matches []matchPos
+	for i := r.start; i <= r.end && i < len(b.buffer); i++ {
+		for _, loc := range re.FindAllStringIndex(string(b.buffer[i]), -1) {
+			matches = append(matches, matchPos{y: i, x: loc[0]})
+		}
+	}
+
+	if subCmd == "" {
+		ch.e.setMessage(fmt.Sprintf("%d matches", len(matches)))
+		return
+	}
+
+	ch.e.saveState()
+	applied := 0
+	for i := len(matches) - 1; i >= 0; i-- {
+		m := matches[i]
+		if m.y >= len(b.buffer) {
+			continue
+		}
+		b.PrimaryCursor().Y = m.y
+		b.PrimaryCursor().X = m.x
+		ch.Handle(subCmd)
+		applied++
+	}
+	ch.e.setMessage(fmt.Sprintf(":x applied to %d match(es)", applied))
+}
+
+// rangeDelete removes every line in the range, storing it all in the registers.
+func (ch *Command) rangeDelete(r ExRange) {
+	b := ch.e.activeBuffer()
+	if b.readOnly {
+		ch.e.setMessage("File is read-only")
+		return
+	}
+	ch.e.saveState()
+
+	var clip strings.Builder
+	for i := r.start; i <= r.end; i++ {
+		clip.WriteString(string(b.buffer[i]))
+		clip.WriteString("\n")
+	}
+	ch.e.deleteToRegister([]rune(clip.String()), RegLinewise)
+
+	b.buffer = append(b.buffer[:r.start], b.buffer[r.end+1:]...)
+	if len(b.buffer) == 0 {
+		b.buffer = [][]rune{{}}
+	}
+	if b.PrimaryCursor().Y >= len(b.buffer) {
+		b.PrimaryCursor().Y = len(b.buffer) - 1
+	}
+	ch.e.markModified()
+	ch.e.setMessage(fmt.Sprintf("%d lines deleted", r.end-r.start+1))
+}
+
+// rangeYank copies every line in the range into the registers without modifying the buffer.
+func (ch *Command) rangeYank(r ExRange) {
+	b := ch.e.activeBuffer()
+	var clip strings.Builder
+	for i := r.start; i <= r.end; i++ {
+		clip.WriteString(string(b.buffer[i]))
+		clip.WriteString("\n")
+	}
+	ch.e.yankToRegister([]rune(clip.String()), RegLinewise)
+	ch.e.setMessage(fmt.Sprintf("%d lines yanked", r.end-r.start+1))
+}
+
+// rangeWrite saves only the lines in the range to filename (or the buffer's own file).
+func (ch *Command) rangeWrite(r ExRange, filename string) {
+	b := ch.e.activeBuffer()
+	if filename == "" {
+		filename = b.filename
+	}
+	if filename == "" {
+		ch.e.setMessage("No filename specified")
+		return
+	}
+
+	var out strings.Builder
+	for i := r.start; i <= r.end; i++ {
+		out.WriteString(string(b.buffer[i]))
+		out.WriteString("\n")
+	}
+
+	if err := writeFileAtomic(filename, out.String()); err != nil {
+		ch.e.setMessage(fmt.Sprintf("Error writing range: %v", err))
+		return
+	}
+	ch.e.setMessage(fmt.Sprintf("%q %d lines written", filename, r.end-r.start+1))
+}
+
+// rangeFilter pipes the range's lines through a shell command and replaces
+// them with its stdout.
+func (ch *Command) rangeFilter(r ExRange, shellCmd string) {
+	b := ch.e.activeBuffer()
+	if b.readOnly {
+		ch.e.setMessage("File is read-only")
+		return
+	}
+	shellCmd = strings.TrimSpace(shellCmd)
+	if shellCmd == "" {
+		ch.e.setMessage("No shell command specified")
+		return
+	}
+
+	var in strings.Builder
+	for i := r.start; i <= r.end; i++ {
+		in.WriteString(string(b.buffer[i]))
+		in.WriteString("\n")
+	}
+
+	cmd := exec.Command("/bin/sh", "-c", shellCmd)
+	cmd.Stdin = strings.NewReader(in.String())
+	output, err := cmd.Output()
+	if err != nil {
+		ch.e.setMessage(fmt.Sprintf("Error: %v", err))
+		return
+	}
+
+	ch.e.saveState()
+	newLines := strings.Split(strings.TrimSuffix(string(output), "\n"), "\n")
+	replacement := make([][]rune, len(newLines))
+	for i, l := range newLines {
+		replacement[i] = []rune(l)
+	}
+
+	b.buffer = append(b.buffer[:r.start], append(replacement, b.buffer[r.end+1:]...)...)
+	ch.e.markModified()
+	ch.e.setMessage(fmt.Sprintf("%d lines filtered through %q", r.end-r.start+1, shellCmd))
+}
+
+// rangeSubstitute applies a /pattern/replacement/flags substitution to every
+// line in the range. It's a thin wrapper around Editor.substitute (see
+// replace.go), the same core used by visual-selection Replace mode, so %s/
+// gets the same g/i/w/c flag handling for free.
+func (ch *Command) rangeSubstitute(r ExRange, input string) {
+	b := ch.e.activeBuffer()
+	if b == nil {
+		return
+	}
+
+	endX := 0
+	if r.end < len(b.buffer) {
+		endX = len(b.buffer[r.end])
+	}
+	ch.e.substitute(r.start, 0, r.end, endX, input)
+}
+
+// globalCommand implements :g/pattern/cmd and :v/pattern/cmd: cmd runs once
+// per line in the range that matches (:g) or doesn't match (:v) pattern.
+func (ch *Command) globalCommand(r ExRange, rest string, invert bool) {
+	rest = strings.TrimPrefix(rest, "g")
+	rest = strings.TrimPrefix(rest, "v")
+	if rest == "" || rest[0] != '/' {
+		ch.e.setMessage("Usage: :g/pattern/cmd")
+		return
+	}
+
+	end := strings.Index(rest[1:], "/")
+	if end == -1 {
+		ch.e.setMessage("Usage: :g/pattern/cmd")
+		return
+	}
+	pattern := rest[1 : end+1]
+	subCmd := strings.TrimSpace(rest[end+2:])
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		ch.e.setMessage("Invalid regex pattern")
+		return
+	}
+
+	b := ch.e.activeBuffer()
+	if b == nil {
+		return
+	}
+
+	// Collect matching line indices up-front, then apply subCmd from the
+	// bottom of the range upward: since line-mutating commands like 'd' only
+	// shift indices below the line they touch, processing descending keeps
+	// every not-yet-visited index valid.
+	var matchedIndices []int
+	for i := r.start; i <= r.end && i < len(b.buffer); i++ {
+		matches := re.MatchString(string(b.buffer[i]))
+		if matches != invert {
+			matchedIndices = append(matchedIndices, i)
+		}
+	}
+
+	if subCmd == "" {
+		ch.e.setMessage(fmt.Sprintf("%d matching lines", len(matchedIndices)))
+		return
+	}
+
+	ch.e.saveState()
+	applied := 0
+	for i := len(matchedIndices) - 1; i >= 0; i-- {
+		idx := matchedIndices[i]
+		if idx >= len(b.buffer) {
+			continue
+		}
+		b.PrimaryCursor().Y = idx
+		ch.Handle(subCmd)
+		applied++
+	}
+	ch.e.setMessage(fmt.Sprintf(":g applied to %d line(s)", applied))
+}
+
+// writeFileAtomic writes content to filename via a temp file + rename so a
+// crash mid-write can't leave a partially written file.
+func writeFileAtomic(filename, content string) error {
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
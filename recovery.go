@@ -0,0 +1,212 @@
+package main
+
+// Crash/power-loss recovery for on-disk buffers, mirroring micro's backup and
+// unsaved-changes model. Two independent mechanisms live here:
+//
+//   - Backups: when Config.BackupOnSave is set, SaveFile moves the previous
+//     on-disk version into ~/.qwe/backups/<hash>/<timestamp> before writing
+//     the new one, keeping the last Config.BackupMaxVersions copies.
+//   - Swap files: a background goroutine (see Editor.writeSwapFiles) periodically
+//     snapshots every dirty buffer's content and cursor positions to
+//     ~/.qwe/swap/<hash>.swp. LoadFile offers to recover from it when the
+//     swap file is newer than the file on disk.
+//
+// <hash> is the hex SHA-1 of the file's absolute path, so unrelated files
+// named the same thing (in different directories) never collide.
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// hashFilePath returns the hex SHA-1 of filename's absolute path.
+func hashFilePath(filename string) string {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		abs = filename
+	}
+	sum := sha1.Sum([]byte(abs))
+	return hex.EncodeToString(sum[:])
+}
+
+// qweSubDir returns ~/.qwe/<name>, creating it if necessary.
+func qweSubDir(name string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(home, ".qwe", name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// backupBeforeOverwrite copies filename's current on-disk content into
+// ~/.qwe/backups/<hash>/<timestamp> and prunes old versions beyond
+// Config.BackupMaxVersions. It is a no-op when backups are disabled or the
+// file does not exist yet (nothing to back up).
+func backupBeforeOverwrite(filename string) error {
+	if !Config.BackupOnSave {
+		return nil
+	}
+
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	dir, err := qweSubDir(filepath.Join("backups", hashFilePath(filename)))
+	if err != nil {
+		return err
+	}
+
+	dest := filepath.Join(dir, time.Now().Format("20060102T150405.000000000"))
+	if err := os.WriteFile(dest, data, 0644); err != nil {
+		return err
+	}
+
+	return pruneBackups(dir)
+}
+
+// pruneBackups removes the oldest entries in dir until at most
+// Config.BackupMaxVersions remain. Entry names sort chronologically since
+// they're timestamps.
+func pruneBackups(dir string) error {
+	if Config.BackupMaxVersions <= 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+
+	excess := len(names) - Config.BackupMaxVersions
+	for i := 0; i < excess; i++ {
+		os.Remove(filepath.Join(dir, names[i]))
+	}
+	return nil
+}
+
+// swapState is the on-disk representation of a buffer's unsaved content.
+type swapState struct {
+	Lines   []string `json:"lines"`
+	Cursors []Cursor `json:"cursors"`
+}
+
+// swapFilePath returns ~/.qwe/swap/<hash>.swp, creating the swap directory if
+// necessary.
+func swapFilePath(filename string) (string, error) {
+	dir, err := qweSubDir("swap")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, hashFilePath(filename)+".swp"), nil
+}
+
+// writeSwapFile atomically serializes b's content and cursors to its swap
+// file.
+func writeSwapFile(b *Buffer) error {
+	path, err := swapFilePath(b.filename)
+	if err != nil {
+		return err
+	}
+
+	lines := make([]string, len(b.buffer))
+	for i, line := range b.buffer {
+		lines[i] = string(line)
+	}
+
+	data, err := json.Marshal(swapState{Lines: lines, Cursors: b.cursors})
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readSwapFile loads a buffer's swap file, if any.
+func readSwapFile(filename string) (*swapState, error) {
+	path, err := swapFilePath(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var state swapState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// removeSwapFile deletes filename's swap file, if any. Called after a
+// successful SaveFile, since the swap is only useful while changes are
+// unsaved.
+func removeSwapFile(filename string) {
+	path, err := swapFilePath(filename)
+	if err != nil {
+		return
+	}
+	os.Remove(path)
+}
+
+// swapFileNewerThan reports whether filename has a swap file whose mtime is
+// after modTime, meaning it holds edits LoadFile hasn't seen yet.
+func swapFileNewerThan(filename string, modTime time.Time) bool {
+	path, err := swapFilePath(filename)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return info.ModTime().After(modTime)
+}
+
+// applySwapState replaces b's content and cursors with the recovered swap
+// state.
+func applySwapState(b *Buffer, state *swapState) {
+	lines := make([][]rune, len(state.Lines))
+	for i, line := range state.Lines {
+		lines[i] = []rune(line)
+	}
+	if len(lines) == 0 {
+		lines = [][]rune{{}}
+	}
+	b.buffer = lines
+	if len(state.Cursors) > 0 {
+		b.cursors = state.Cursors
+	}
+	b.modified = true
+}
+
+// recoveryPromptMessage formats the :y/n prompt shown when a newer swap file
+// is found for filename.
+func recoveryPromptMessage(filename string) string {
+	return fmt.Sprintf("Found a newer swap file for %q. Recover? (y/n) ", filepath.Base(filename))
+}
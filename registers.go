@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Vim-style named registers plus a numbered kill ring, replacing the old
+// single Editor.clipboard. A register prefix is typed as `"<reg>` before
+// y/d/c/p/P (see the '"' case and the e.pendingKey == '"' guard in
+// handleNormalMode, kevent.go); with no prefix, operations go through the
+// unnamed register like Vim's default behavior.
+
+// RegisterKind records how a register's text should be pasted back:
+// linewise text is inserted as whole lines (pasteLine/pasteLineAbove),
+// blockwise text is inserted as a rectangle (visual-block paste), and
+// everything else is charwise.
+type RegisterKind int
+
+const (
+	RegCharwise RegisterKind = iota
+	RegLinewise
+	RegBlockwise
+)
+
+// Register holds one register's contents and how paste should treat it.
+type Register struct {
+	Text []rune
+	Kind RegisterKind
+}
+
+// Registers implements Vim's "a-"z (append with "A-"Z), the unnamed "", the
+// small-delete "-, the numbered "0-"9 kill ring, and the read-only ".
+// ("% is read-only too, but needs the active buffer's filename, so Editor
+// resolves it directly in registerValue instead of storing it here.)
+type Registers struct {
+	named   map[rune]Register // keys 'a'-'z'
+	unnamed Register          // `"`
+	small   Register          // `"-`, for deletes smaller than a line
+	ring    [10]Register      // ring[0] = "0 (last yank); ring[1..9] = "1-"9 kill ring
+	dot     Register          // `".`, the text of the last insert
+}
+
+func newRegisters() *Registers {
+	return &Registers{named: make(map[rune]Register)}
+}
+
+// isRegisterName reports whether r is a valid `"<reg>` prefix character.
+func isRegisterName(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '%' || r == '.'
+}
+
+// hasNewline reports whether text spans more than one line.
+func hasNewline(text []rune) bool {
+	for _, r := range text {
+		if r == '\n' {
+			return true
+		}
+	}
+	return false
+}
+
+// store writes reg into name, honoring Vim's append convention: an
+// uppercase letter appends to its lowercase register instead of
+// overwriting it. name == 0 (the unnamed register) is handled by callers.
+func (r *Registers) store(name rune, reg Register) {
+	switch {
+	case name >= 'a' && name <= 'z':
+		r.named[name] = reg
+	case name >= 'A' && name <= 'Z':
+		lower := name - 'A' + 'a'
+		existing := r.named[lower]
+		r.named[lower] = appendRegister(existing, reg)
+	case name == '-':
+		r.small = reg
+	case name >= '0' && name <= '9':
+		r.ring[name-'0'] = reg
+	}
+}
+
+// appendRegister concatenates new onto existing, the way Vim's uppercase
+// registers accumulate text across several yanks/deletes. Linewise content
+// joins on its own line; charwise content is simply concatenated.
+func appendRegister(existing, added Register) Register {
+	if len(existing.Text) == 0 {
+		return added
+	}
+	kind := existing.Kind
+	if added.Kind == RegLinewise {
+		kind = RegLinewise
+	}
+	var text []rune
+	if kind == RegLinewise && existing.Text[len(existing.Text)-1] != '\n' {
+		text = append(append([]rune(nil), existing.Text...), '\n')
+	} else {
+		text = append([]rune(nil), existing.Text...)
+	}
+	text = append(text, added.Text...)
+	return Register{Text: text, Kind: kind}
+}
+
+// Yank records a yank (y, visual y) into name (0 for the unnamed register),
+// always updating the unnamed register and "0.
+func (r *Registers) Yank(name rune, text []rune, kind RegisterKind) {
+	reg := Register{Text: append([]rune(nil), text...), Kind: kind}
+	if name != 0 {
+		r.store(name, reg)
+	}
+	r.unnamed = reg
+	r.ring[0] = reg
+}
+
+// Delete records a delete/change (d/c/x, visual d/c) into name. Linewise or
+// multi-line deletes shift into the "1-"9 ring (like readline/rustyline
+// kill rings); small, in-line deletes go to "- instead, mirroring Vim.
+func (r *Registers) Delete(name rune, text []rune, kind RegisterKind) {
+	reg := Register{Text: append([]rune(nil), text...), Kind: kind}
+	if name != 0 {
+		r.store(name, reg)
+	}
+	r.unnamed = reg
+
+	if kind == RegLinewise || hasNewline(text) {
+		for i := 9; i > 1; i-- {
+			r.ring[i] = r.ring[i-1]
+		}
+		r.ring[1] = reg
+	} else {
+		r.small = reg
+	}
+}
+
+// SetDot records the text of the most recently completed insert.
+func (r *Registers) SetDot(text []rune) {
+	r.dot = Register{Text: append([]rune(nil), text...), Kind: RegCharwise}
+}
+
+// Get resolves name to its Register. name == 0 means the unnamed register.
+// "% and the read-only filename register are resolved by Editor.registerValue,
+// since Registers has no access to the active buffer.
+func (r *Registers) Get(name rune) (Register, bool) {
+	switch {
+	case name == 0:
+		return r.unnamed, true
+	case name == '-':
+		return r.small, true
+	case name == '.':
+		return r.dot, true
+	case name >= '0' && name <= '9':
+		return r.ring[name-'0'], true
+	case name >= 'a' && name <= 'z':
+		reg, ok := r.named[name]
+		return reg, ok
+	case name >= 'A' && name <= 'Z':
+		reg, ok := r.named[name-'A'+'a']
+		return reg, ok
+	default:
+		return Register{}, false
+	}
+}
+
+// registerName consumes and returns the register name set by a preceding
+// `"<reg>` prefix (see kevent.go), defaulting to the unnamed register (0).
+func (e *Editor) registerName() rune {
+	name := e.pendingRegister
+	e.pendingRegister = 0
+	return name
+}
+
+// yankToRegister records yanked text into the register named by a preceding
+// `"<reg>` prefix, or the unnamed register if there was none.
+func (e *Editor) yankToRegister(text []rune, kind RegisterKind) {
+	e.registers.Yank(e.registerName(), text, kind)
+}
+
+// deleteToRegister records deleted/changed text into the register named by
+// a preceding `"<reg>` prefix, or the unnamed register if there was none.
+func (e *Editor) deleteToRegister(text []rune, kind RegisterKind) {
+	e.registers.Delete(e.registerName(), text, kind)
+}
+
+// pasteRegister returns the text and wise-ness that p/P should paste,
+// honoring a `"<reg>` prefix and falling back to the unnamed register.
+func (e *Editor) pasteRegister() ([]rune, RegisterKind) {
+	reg, ok := e.registerValue(e.registerName())
+	if !ok {
+		return nil, RegCharwise
+	}
+	return reg.Text, reg.Kind
+}
+
+// registerValue resolves name to its Register, handling the read-only "%
+// (current filename) that Registers itself can't see.
+func (e *Editor) registerValue(name rune) (Register, bool) {
+	if name == '%' {
+		b := e.activeBuffer()
+		if b == nil {
+			return Register{}, false
+		}
+		return Register{Text: []rune(b.filename), Kind: RegCharwise}, true
+	}
+	return e.registers.Get(name)
+}
+
+// regCommand implements `:reg`, listing every non-empty register in a
+// read-only scratch buffer, in the same style as :messages/:jumps.
+func (ch *Command) regCommand() {
+	order := []rune{0, '-'}
+	for i := rune('0'); i <= '9'; i++ {
+		order = append(order, i)
+	}
+	for c := rune('a'); c <= 'z'; c++ {
+		order = append(order, c)
+	}
+	order = append(order, '.', '%')
+
+	var lines [][]rune
+	for _, name := range order {
+		reg, ok := ch.e.registerValue(name)
+		if !ok || len(reg.Text) == 0 {
+			continue
+		}
+		label := string(name)
+		if name == 0 {
+			label = "\""
+		}
+		preview := strings.ReplaceAll(string(reg.Text), "\n", "^J")
+		lines = append(lines, []rune("\""+label+"   "+preview))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, []rune("--- No registers ---"))
+	}
+
+	b := &Buffer{
+		buffer:    lines,
+		filename:  "[Registers]",
+		readOnly:  true,
+		undoStack: []Edit{},
+		redoStack: []Edit{},
+		fileType:  getFileType("[Registers]"),
+	}
+	b.setBufType(BufTypeScratch)
+	ch.e.buffers = append(ch.e.buffers, b)
+	ch.e.activeBufferIndex = len(ch.e.buffers) - 1
+	ch.e.setMessage(fmt.Sprintf("%d registers", len(lines)))
+}
@@ -82,11 +82,20 @@ func (e *Editor) handleReplaceMode(ev termbox.Event) {
 	}
 }
 
+// ReplaceFlags are the trailing /flags of a replace command: g(lobal), i
+// (ignore case), w(hole word), c(onfirm each match).
+type ReplaceFlags struct {
+	global     bool
+	ignoreCase bool
+	wholeWord  bool
+	confirm    bool
+}
+
 // parseReplaceCommand splits the raw input string into pattern, replacement, and flags.
-func parseReplaceCommand(input string) (pattern, replacement string, globalFlag, ignoreCaseFlag bool, err error) {
+func parseReplaceCommand(input string) (pattern, replacement string, flags ReplaceFlags, err error) {
 	// Expected syntax: /pattern/replacement/[flags]
 	if !strings.HasPrefix(input, "/") {
-		return "", "", false, false, nil
+		return "", "", ReplaceFlags{}, nil
 	}
 
 	parts := []string{}
@@ -128,20 +137,39 @@ func parseReplaceCommand(input string) (pattern, replacement string, globalFlag,
 	}
 
 	if len(parts) < 2 {
-		return "", "", false, false, nil
+		return "", "", ReplaceFlags{}, nil
 	}
 
 	pattern = parts[0]
 	replacement = parts[1]
 
-	// Check optional flags (e.g., 'g' for global, 'i' for case-insensitive).
+	// Check optional flags: 'g' global, 'i' case-insensitive, 'w' whole
+	// word, 'c' confirm each match.
 	if len(parts) >= 3 {
-		flags := parts[2]
-		globalFlag = strings.Contains(flags, "g")
-		ignoreCaseFlag = strings.Contains(flags, "i")
+		f := parts[2]
+		flags.global = strings.Contains(f, "g")
+		flags.ignoreCase = strings.Contains(f, "i")
+		flags.wholeWord = strings.Contains(f, "w")
+		flags.confirm = strings.Contains(f, "c")
 	}
 
-	return pattern, replacement, globalFlag, ignoreCaseFlag, nil
+	return pattern, replacement, flags, nil
+}
+
+// compileReplacePattern builds the regexp for pattern honoring flags: 'w'
+// wraps it in word-boundary anchors and 'i' makes it case-insensitive.
+// Earlier versions of this subsystem always prepended "(?i)", which made the
+// 'i' flag a no-op and every search case-insensitive whether asked for or
+// not; this is the single place that decides case sensitivity now.
+func compileReplacePattern(pattern string, flags ReplaceFlags) (*regexp.Regexp, error) {
+	p := pattern
+	if flags.wholeWord {
+		p = `\b(?:` + p + `)\b`
+	}
+	if flags.ignoreCase {
+		p = "(?i)" + p
+	}
+	return regexp.Compile(p)
 }
 
 // updateReplacePreview finds and highlights matches in the buffer based on the current prompt.
@@ -149,18 +177,16 @@ func (e *Editor) updateReplacePreview() {
 	e.replaceMatches = []MatchRange{}
 
 	input := string(e.replaceInput)
-	pattern, _, globalFlag, _, err := parseReplaceCommand(input)
+	pattern, _, flags, err := parseReplaceCommand(input)
 	if err != nil || pattern == "" {
 		return
 	}
 
-	// Always use case-insensitive matching by default (?i).
-	regexPattern := "(?i)" + pattern
-
-	re, err := regexp.Compile(regexPattern)
+	re, err := compileReplacePattern(pattern, flags)
 	if err != nil {
 		return
 	}
+	globalFlag := flags.global
 
 	b := e.activeBuffer()
 	if b == nil {
@@ -212,40 +238,42 @@ func (e *Editor) updateReplacePreview() {
 	}
 }
 
-// executeReplace performs the actual string transformation in the active buffer.
+// executeReplace performs the actual string transformation in the active
+// buffer, over the selection captured by startReplaceMode.
 func (e *Editor) executeReplace() {
 	input := string(e.replaceInput)
-	pattern, replacement, globalFlag, ignoreCaseFlag, err := parseReplaceCommand(input)
 
 	// Logging for debugging purposes.
 	e.addLog("Replace", fmt.Sprintf("Input: '%s'", input))
-	e.addLog("Replace", fmt.Sprintf("Pattern: '%s', Replacement: '%s', g=%v, i=%v", pattern, replacement, globalFlag, ignoreCaseFlag))
+
+	startY, startX, endY, endX := e.replaceSelStartY, e.replaceSelStartX, e.replaceSelEndY, e.replaceSelEndX
+	e.mode = ModeNormal
+	e.replaceInput = []rune{}
+	e.replaceMatches = []MatchRange{}
+
+	e.substitute(startY, startX, endY, endX, input)
+}
+
+// substitute parses input ("/pattern/replacement/flags") and applies it to
+// the active buffer's [startY,startX]-[endY,endX] range. It's the shared
+// core behind the interactive selection-scope Replace mode (executeReplace)
+// and the immediate :%s/ whole-buffer command (substituteCommand).
+func (e *Editor) substitute(startY, startX, endY, endX int, input string) {
+	pattern, replacement, flags, err := parseReplaceCommand(input)
+	e.addLog("Replace", fmt.Sprintf("Pattern: '%s', Replacement: '%s', flags=%+v", pattern, replacement, flags))
 
 	if err != nil {
-		e.message = "Invalid regex pattern"
-		e.mode = ModeNormal
-		e.replaceInput = []rune{}
-		e.replaceMatches = []MatchRange{}
+		e.setMessage("Invalid regex pattern")
 		return
 	}
-
 	if pattern == "" {
-		e.message = "No pattern specified"
-		e.mode = ModeNormal
-		e.replaceInput = []rune{}
-		e.replaceMatches = []MatchRange{}
+		e.setMessage("No pattern specified")
 		return
 	}
 
-	// Always use case-insensitive matching by default (?i).
-	regexPattern := "(?i)" + pattern
-
-	re, err := regexp.Compile(regexPattern)
+	re, err := compileReplacePattern(pattern, flags)
 	if err != nil {
-		e.message = "Invalid regex pattern"
-		e.mode = ModeNormal
-		e.replaceInput = []rune{}
-		e.replaceMatches = []MatchRange{}
+		e.setMessage("Invalid regex pattern")
 		return
 	}
 
@@ -254,83 +282,102 @@ func (e *Editor) executeReplace() {
 		return
 	}
 
-	// Save state for Undo/Redo support before modifying text.
+	if flags.confirm {
+		e.saveState()
+		e.confirmReplaceInRange(b, startY, startX, endY, endX, re, replacement, func(count int) {
+			e.finishReplace(b, pattern, count)
+		})
+		return
+	}
+
 	e.saveState()
+	count := replaceInRange(b, startY, startX, endY, endX, re, replacement, flags.global)
+	e.finishReplace(b, pattern, count)
+}
 
-	replacementCount := 0
+// finishReplace reports the result of a (possibly confirm-each) replace and
+// reparses b's syntax, shared by the selection-scope and %s/project-wide
+// entry points.
+func (e *Editor) finishReplace(b *Buffer, pattern string, count int) {
+	if count > 0 {
+		e.setMessage(fmt.Sprintf("%d replacements made", count))
+		e.markModified()
+		// Populate lastSearch so hlsearch highlights the pattern just
+		// replaced, the same way committing a / search does.
+		e.lastSearch = pattern
+		e.hlsearch = true
+		e.invalidateSearchMatches()
+	} else {
+		e.setMessage("Pattern not found")
+	}
+
+	if b.syntax != nil {
+		b.syntaxReparse()
+	}
+}
 
-	e.addLog("Replace", fmt.Sprintf("Starting replacement: lines %d-%d", e.replaceSelStartY, e.replaceSelEndY))
+// replaceInRange applies re/replacement to every line in [startY,startX]
+// through [endY,endX] of b, furthest line first so editing one line never
+// invalidates the line indices of lines still to be processed. It returns
+// how many matches were replaced. Shared by the interactive selection-scope
+// replace, :%s/ (whole buffer), and project-wide replace's per-open-buffer
+// application.
+func replaceInRange(b *Buffer, startY, startX, endY, endX int, re *regexp.Regexp, replacement string, global bool) int {
+	replacementCount := 0
 
-	// Important: Iterate backwards from top to bottom through lines,
-	// but this loop actually goes from replaceSelEndY down to replaceSelStartY.
-	// This helps maintain line index stability during multi-line operations.
-	for lineIdx := e.replaceSelEndY; lineIdx >= e.replaceSelStartY && lineIdx < len(b.buffer); lineIdx-- {
+	for lineIdx := endY; lineIdx >= startY && lineIdx < len(b.buffer); lineIdx-- {
 		line := b.buffer[lineIdx]
 		lineStr := string(line)
 
-		startCol := 0
-		endCol := len(line)
-
-		if lineIdx == e.replaceSelStartY {
-			startCol = e.replaceSelStartX
+		colStart := 0
+		colEnd := len(line)
+		if lineIdx == startY {
+			colStart = startX
 		}
-		if lineIdx == e.replaceSelEndY {
-			endCol = e.replaceSelEndX
+		if lineIdx == endY {
+			colEnd = endX
 		}
-
-		if startCol >= len(line) {
-			e.addLog("Replace", fmt.Sprintf("Line %d: skipped (startCol >= len)", lineIdx))
+		if colStart >= len(line) {
 			continue
 		}
 
-		prefix := lineStr[:startCol]
-		searchPart := lineStr[startCol:endCol]
+		prefix := lineStr[:colStart]
+		searchPart := lineStr[colStart:colEnd]
 		suffix := ""
-		if endCol < len(lineStr) {
-			suffix = lineStr[endCol:]
+		if colEnd < len(lineStr) {
+			suffix = lineStr[colEnd:]
 		}
 
-		e.addLog("Replace", fmt.Sprintf("Line %d: searching '%s' in range [%d:%d]", lineIdx, searchPart, startCol, endCol))
-
 		var newSearchPart string
-		if globalFlag {
-			// Replace all occurrences in the slice.
+		if global {
 			newSearchPart = re.ReplaceAllString(searchPart, replacement)
-			matches := re.FindAllStringIndex(searchPart, -1)
-			matchCount := len(matches)
-			replacementCount += matchCount
-			e.addLog("Replace", fmt.Sprintf("Line %d: found %d matches (global)", lineIdx, matchCount))
-		} else {
-			// Replace first match only.
-			if re.MatchString(searchPart) {
-				newSearchPart = re.ReplaceAllStringFunc(searchPart, func(match string) string {
-					if replacementCount == 0 {
-						replacementCount++
-						return re.ReplaceAllString(match, replacement)
-					}
+			replacementCount += len(re.FindAllStringIndex(searchPart, -1))
+		} else if re.MatchString(searchPart) {
+			matched := false
+			newSearchPart = re.ReplaceAllStringFunc(searchPart, func(match string) string {
+				if matched {
 					return match
-				})
-				e.addLog("Replace", fmt.Sprintf("Line %d: found 1 match (first only)", lineIdx))
-			} else {
-				newSearchPart = searchPart
-				e.addLog("Replace", fmt.Sprintf("Line %d: no matches", lineIdx))
-			}
+				}
+				matched = true
+				replacementCount++
+				return re.ReplaceAllString(match, replacement)
+			})
+		} else {
+			newSearchPart = searchPart
 		}
 
-		// Update the line content and notify syntax highlighter of the edit.
 		oldLine := b.buffer[lineIdx]
 		newLineStr := prefix + newSearchPart + suffix
 		b.buffer[lineIdx] = []rune(newLineStr)
-		e.addLog("Replace", fmt.Sprintf("Line %d: '%s' -> '%s'", lineIdx, lineStr, newLineStr))
 
 		if b.syntax != nil {
 			oldLineBytes := uint32(len(string(oldLine)))
 			newLineBytes := uint32(len(newLineStr))
-			oldEndColBytes := b.getLineByteOffset(oldLine, endCol)
+			oldEndColBytes := b.getLineByteOffset(oldLine, colEnd)
 			newEndColBytes := b.getLineByteOffset(b.buffer[lineIdx], len(prefix)+len(newSearchPart))
 
 			b.handleEdit(
-				lineIdx, startCol,
+				lineIdx, colStart,
 				oldLineBytes, newLineBytes,
 				lineIdx, oldEndColBytes,
 				lineIdx, newEndColBytes,
@@ -338,19 +385,85 @@ func (e *Editor) executeReplace() {
 		}
 	}
 
-	if replacementCount > 0 {
-		e.message = fmt.Sprintf("%d replacements made", replacementCount)
-		e.markModified()
-	} else {
-		e.message = "Pattern not found"
-	}
+	return replacementCount
+}
+
+// spliceReplaceMatch replaces the single [startCol,endCol) span on lineIdx
+// with newText, notifying the syntax highlighter the same way
+// replaceInRange's per-line rewrite does. Used by confirmReplaceInRange,
+// which (unlike replaceInRange) applies one match at a time as the user
+// confirms it rather than a whole line's matches at once.
+func spliceReplaceMatch(b *Buffer, lineIdx, startCol, endCol int, newText string) {
+	oldLine := b.buffer[lineIdx]
+	lineStr := string(oldLine)
+	newLineStr := lineStr[:startCol] + newText + lineStr[endCol:]
+	b.buffer[lineIdx] = []rune(newLineStr)
 
-	// Force a full reparse of syntax to ensure all highlights are correct after mass edits.
 	if b.syntax != nil {
-		b.syntax.Reparse([]byte(b.toString()))
+		oldLineBytes := uint32(len(string(oldLine)))
+		newLineBytes := uint32(len(newLineStr))
+		oldEndColBytes := b.getLineByteOffset(oldLine, endCol)
+		newEndColBytes := b.getLineByteOffset(b.buffer[lineIdx], startCol+len([]rune(newText)))
+
+		b.handleEdit(
+			lineIdx, startCol,
+			oldLineBytes, newLineBytes,
+			lineIdx, oldEndColBytes,
+			lineIdx, newEndColBytes,
+		)
 	}
+}
 
-	e.mode = ModeNormal
-	e.replaceInput = []rune{}
-	e.replaceMatches = []MatchRange{}
+// confirmReplaceInRange drives the 'c' (confirm each match) flag: it prompts
+// y/n via ModeConfirm for one match at a time, calling onDone with the final
+// count once every match in the range has been confirmed or skipped. 'n' and
+// Esc both skip (ModeConfirm's own semantics; see handleConfirmMode) and move
+// on to the next match rather than aborting the whole operation. Each step
+// re-scans b's *current* content for the next match instead of working off a
+// list computed up front, so a replacement already applied earlier in this
+// same call can never shift a not-yet-confirmed match's position.
+func (e *Editor) confirmReplaceInRange(b *Buffer, startY, startX, endY, endX int, re *regexp.Regexp, replacement string, onDone func(count int)) {
+	count := 0
+
+	var step func(fromY, fromX int)
+	step = func(fromY, fromX int) {
+		for lineIdx := fromY; lineIdx <= endY && lineIdx < len(b.buffer); lineIdx++ {
+			line := b.buffer[lineIdx]
+
+			lo := 0
+			if lineIdx == fromY {
+				lo = fromX
+			}
+			hi := len(line)
+			if lineIdx == endY {
+				hi = endX
+			}
+			if lo > hi || lo > len(line) {
+				continue
+			}
+
+			loc := re.FindStringIndex(string(line[lo:hi]))
+			if loc == nil {
+				continue
+			}
+			matchStart, matchEnd := lo+loc[0], lo+loc[1]
+			matchText := string(line[matchStart:matchEnd])
+			newText := re.ReplaceAllString(matchText, replacement)
+
+			e.setMessage(fmt.Sprintf("Replace %q with %q (line %d)? y/n", matchText, newText, lineIdx+1))
+			e.mode = ModeConfirm
+			e.pendingConfirm = func() {
+				spliceReplaceMatch(b, lineIdx, matchStart, matchEnd, newText)
+				count++
+				step(lineIdx, matchStart+len([]rune(newText)))
+			}
+			e.pendingCancel = func() {
+				step(lineIdx, matchEnd)
+			}
+			return
+		}
+		onDone(count)
+	}
+
+	step(startY, startX)
 }
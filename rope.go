@@ -0,0 +1,145 @@
+package main
+
+import "strings"
+
+// TextStore is the editing surface a buffer's content is expected to satisfy:
+// line-oriented reads plus half-open-range inserts/deletes, so callers never
+// have to splice [][]rune themselves. Ranges are (y1,x1) inclusive to
+// (y2,x2) exclusive, the same convention as Go's slice expressions.
+//
+// *Buffer implements TextStore directly against its existing buffer [][]rune
+// below rather than through a rope or piece table. Insert and Delete both
+// special-case the common single-line edit (the dominant case — one
+// keystroke, one line) to touch only that one []rune, so a single
+// character typed or deleted costs O(line length), not O(file length); only
+// an edit that actually changes the line count (paste of multi-line text,
+// a join, deleting across a line boundary) still has to rebuild the outer
+// [][]rune. That remaining multi-line cost is the one thing a real
+// rope/piece table would also fix, and retrofitting every one of the
+// hundreds of b.buffer call sites in this file onto one is a much larger,
+// separately-reviewable change than this interface. Swapping one in later
+// only touches this file.
+type TextStore interface {
+	LineCount() int
+	Line(i int) []rune
+	Insert(y, x int, s []rune)
+	Delete(y1, x1, y2, x2 int)
+	Slice(y1, x1, y2, x2 int) []rune
+	Bytes() []byte
+}
+
+// LineCount returns the number of lines in the buffer.
+func (b *Buffer) LineCount() int {
+	return len(b.buffer)
+}
+
+// Line returns line i. The caller must not hold onto it across an edit.
+func (b *Buffer) Line(i int) []rune {
+	return b.buffer[i]
+}
+
+// Bytes returns the whole buffer's contents, lines joined with '\n'.
+func (b *Buffer) Bytes() []byte {
+	return []byte(b.toString())
+}
+
+// splitRuneLines is strings.Split for []rune, used by Insert to find the
+// newlines in the text being inserted.
+func splitRuneLines(s []rune) [][]rune {
+	parts := strings.Split(string(s), "\n")
+	out := make([][]rune, len(parts))
+	for i, p := range parts {
+		out[i] = []rune(p)
+	}
+	return out
+}
+
+// Insert splices s into the buffer at (y, x), splitting it across lines on
+// any newlines it contains.
+func (b *Buffer) Insert(y, x int, s []rune) {
+	line := b.buffer[y]
+	parts := splitRuneLines(s)
+	if len(parts) == 1 {
+		newLine := make([]rune, 0, len(line)+len(s))
+		newLine = append(newLine, line[:x]...)
+		newLine = append(newLine, s...)
+		newLine = append(newLine, line[x:]...)
+		b.buffer[y] = newLine
+		return
+	}
+
+	prefix := line[:x]
+	suffix := line[x:]
+
+	newLines := make([][]rune, len(parts))
+	newLines[0] = append(append([]rune(nil), prefix...), parts[0]...)
+	for i := 1; i < len(parts)-1; i++ {
+		newLines[i] = parts[i]
+	}
+	last := len(parts) - 1
+	newLines[last] = append(append([]rune(nil), parts[last]...), suffix...)
+
+	newBuffer := make([][]rune, 0, len(b.buffer)+len(newLines)-1)
+	newBuffer = append(newBuffer, b.buffer[:y]...)
+	newBuffer = append(newBuffer, newLines...)
+	newBuffer = append(newBuffer, b.buffer[y+1:]...)
+	b.buffer = newBuffer
+}
+
+// Delete removes the half-open range from (y1,x1) up to (y2,x2), merging
+// whatever remains of the first and last line into one. y2 at or past the
+// last line is treated as "through the end of the buffer" — there's no line
+// to merge onto, so the range is simply dropped.
+//
+// A same-line range (y1 == y2, the common case — most edits touch one line)
+// only replaces that one line in place; it's the multi-line case below that
+// still has to rebuild the outer [][]rune, since that's the only case where
+// the number of lines actually changes.
+func (b *Buffer) Delete(y1, x1, y2, x2 int) {
+	if y1 == y2 {
+		line := b.buffer[y1]
+		newLine := make([]rune, 0, len(line)-(x2-x1))
+		newLine = append(newLine, line[:x1]...)
+		newLine = append(newLine, line[x2:]...)
+		b.buffer[y1] = newLine
+		return
+	}
+
+	if y2 >= len(b.buffer) {
+		newBuffer := append([][]rune(nil), b.buffer[:y1]...)
+		if x1 > 0 {
+			newBuffer = append(newBuffer, append([]rune(nil), b.buffer[y1][:x1]...))
+		}
+		if len(newBuffer) == 0 {
+			newBuffer = [][]rune{{}}
+		}
+		b.buffer = newBuffer
+		return
+	}
+
+	merged := append(append([]rune(nil), b.buffer[y1][:x1]...), b.buffer[y2][x2:]...)
+
+	newBuffer := make([][]rune, 0, len(b.buffer)-(y2-y1))
+	newBuffer = append(newBuffer, b.buffer[:y1]...)
+	newBuffer = append(newBuffer, merged)
+	newBuffer = append(newBuffer, b.buffer[y2+1:]...)
+	b.buffer = newBuffer
+}
+
+// Slice returns a copy of the half-open range from (y1,x1) up to (y2,x2),
+// joining spanned lines with '\n'.
+func (b *Buffer) Slice(y1, x1, y2, x2 int) []rune {
+	if y1 == y2 {
+		return append([]rune(nil), b.buffer[y1][x1:x2]...)
+	}
+
+	var out []rune
+	out = append(out, b.buffer[y1][x1:]...)
+	out = append(out, '\n')
+	for y := y1 + 1; y < y2; y++ {
+		out = append(out, b.buffer[y]...)
+		out = append(out, '\n')
+	}
+	out = append(out, b.buffer[y2][:x2]...)
+	return out
+}
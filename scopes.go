@@ -0,0 +1,99 @@
+package main
+
+import "github.com/nsf/termbox-go"
+
+// Scope is a dotted tree-sitter capture name, e.g. "keyword.control.conditional"
+// or "function.builtin". Queries (see queries/<lang>.scm) emit these instead
+// of the old flat, single-word capture names, so a theme (or builtinScopes
+// below) can color a specific case like "constant.character.numeric.integer"
+// differently from plain "constant" without every query needing to agree on
+// a fixed, pre-enumerated set of buckets the way ColorTS* did.
+type Scope string
+
+// builtinScopes is the scope registry compiled into the binary, consulted by
+// ResolveScope once the active theme's own Scopes (see Theme.Scopes) leaves
+// a scope unspecified. The top-level entries (function, keyword, string,
+// ...) carry the same attributes the old flat ColorTS* constants used, so a
+// query that only ever emits bare, undotted capture names renders exactly
+// as it did before; the dotted entries beneath them are the finer-grained
+// buckets newer queries can reach for.
+var builtinScopes = map[Scope]Color{
+	"function":         {Background: termbox.ColorDefault, Foreground: termbox.Attribute(3)},
+	"function.builtin": {Background: termbox.ColorDefault, Foreground: termbox.Attribute(214)},
+	"function.method":  {Background: termbox.ColorDefault, Foreground: termbox.Attribute(3)},
+	"function.macro":   {Background: termbox.ColorDefault, Foreground: termbox.Attribute(203)},
+
+	"variable":           {Background: termbox.ColorDefault, Foreground: termbox.Attribute(255)},
+	"variable.parameter": {Background: termbox.ColorDefault, Foreground: termbox.Attribute(215)},
+	"variable.builtin":   {Background: termbox.ColorDefault, Foreground: termbox.Attribute(214)},
+
+	"type":         {Background: termbox.ColorDefault, Foreground: termbox.Attribute(112)},
+	"type.builtin":  {Background: termbox.ColorDefault, Foreground: termbox.Attribute(149)},
+
+	"string":         {Background: termbox.ColorDefault, Foreground: termbox.Attribute(37)},
+	"string.escape":  {Background: termbox.ColorDefault, Foreground: termbox.Attribute(208)},
+	"string.special": {Background: termbox.ColorDefault, Foreground: termbox.Attribute(208)},
+
+	"keyword":                     {Background: termbox.ColorDefault, Foreground: termbox.Attribute(178)},
+	"keyword.control":             {Background: termbox.ColorDefault, Foreground: termbox.Attribute(178)},
+	"keyword.control.conditional": {Background: termbox.ColorDefault, Foreground: termbox.Attribute(170)},
+	"keyword.control.repeat":      {Background: termbox.ColorDefault, Foreground: termbox.Attribute(170)},
+	"keyword.control.import":      {Background: termbox.ColorDefault, Foreground: termbox.Attribute(178)},
+	"keyword.control.return":      {Background: termbox.ColorDefault, Foreground: termbox.Attribute(170)},
+	"keyword.operator":            {Background: termbox.ColorDefault, Foreground: termbox.Attribute(216)},
+	"keyword.function":            {Background: termbox.ColorDefault, Foreground: termbox.Attribute(178)},
+
+	"comment":     {Background: termbox.ColorDefault, Foreground: termbox.Attribute(244)},
+	"comment.doc": {Background: termbox.ColorDefault, Foreground: termbox.Attribute(244)},
+
+	"constant":                           {Background: termbox.ColorDefault, Foreground: termbox.Attribute(254)},
+	"constant.builtin":                   {Background: termbox.ColorDefault, Foreground: termbox.Attribute(2)},
+	"constant.character":                 {Background: termbox.ColorDefault, Foreground: termbox.Attribute(135)},
+	"constant.character.numeric":         {Background: termbox.ColorDefault, Foreground: termbox.Attribute(135)},
+	"constant.character.numeric.integer": {Background: termbox.ColorDefault, Foreground: termbox.Attribute(135)},
+	"constant.character.numeric.float":   {Background: termbox.ColorDefault, Foreground: termbox.Attribute(135)},
+
+	"number":  {Background: termbox.ColorDefault, Foreground: termbox.Attribute(135)},
+	"boolean": {Background: termbox.ColorDefault, Foreground: termbox.Attribute(2)},
+	"null":    {Background: termbox.ColorDefault, Foreground: termbox.Attribute(135)},
+
+	"property":  {Background: termbox.ColorDefault, Foreground: termbox.Attribute(230)},
+	"tag":       {Background: termbox.ColorDefault, Foreground: termbox.Attribute(118)},
+	"attribute": {Background: termbox.ColorDefault, Foreground: termbox.Attribute(215)},
+
+	"punctuation.delimiter": {Background: termbox.ColorDefault, Foreground: termbox.Attribute(244)},
+	"punctuation.bracket":   {Background: termbox.ColorDefault, Foreground: termbox.Attribute(255)},
+	"punctuation.special":   {Background: termbox.ColorDefault, Foreground: termbox.Attribute(216)},
+}
+
+// ResolveScope returns the foreground/background attributes for a
+// tree-sitter capture name, walking from the full dotted scope up through
+// its ancestors (e.g. "keyword.control.conditional" -> "keyword.control" ->
+// "keyword") until the active theme's Scopes, then builtinScopes, defines
+// one. A scope nothing defines at any level falls back to
+// GetThemeColor(ColorDefault), same as an uncached line in Highlight.
+func ResolveScope(scope string) (termbox.Attribute, termbox.Attribute) {
+	theme := activeTheme.Load()
+	for s := Scope(scope); s != ""; s = parentScope(s) {
+		if theme != nil {
+			if c, ok := theme.Scopes[s]; ok {
+				return c.Foreground, c.Background
+			}
+		}
+		if c, ok := builtinScopes[s]; ok {
+			return c.Foreground, c.Background
+		}
+	}
+	return GetThemeColor(ColorDefault)
+}
+
+// parentScope trims the last dot-delimited segment off a scope, e.g.
+// "keyword.control.conditional" -> "keyword.control" -> "keyword" -> "".
+func parentScope(s Scope) Scope {
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] == '.' {
+			return s[:i]
+		}
+	}
+	return ""
+}
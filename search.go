@@ -0,0 +1,151 @@
+package main
+
+// Sublime-Text-style search helpers: optional regex matching and Unicode
+// diacritic folding, shared by performSearch and hlsearchMatchesForLine so
+// '/' search, n/N, and persistent hlsearch highlighting all agree on what
+// counts as a match.
+
+import (
+	"regexp"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// normalizeForSearch case-folds runes and, unless literal is true, strips
+// Unicode combining marks (NFD category Mn) so e.g. "sodanco" matches
+// "Só Dança Samba". It returns the folded runes together with a mapping
+// from each folded rune back to its source index in runes: a precomposed
+// letter like 'ó' decomposes into a base rune plus one or more marks, so
+// the folded slice can be longer than the input, and a mark that already
+// exists as its own rune in runes (combining diacritics typed directly)
+// disappears from the output entirely.
+func normalizeForSearch(runes []rune, literal bool) (folded []rune, mapping []int) {
+	folded = make([]rune, 0, len(runes))
+	mapping = make([]int, 0, len(runes))
+	for i, r := range runes {
+		if literal {
+			folded = append(folded, unicode.ToLower(r))
+			mapping = append(mapping, i)
+			continue
+		}
+		for _, d := range norm.NFD.String(string(r)) {
+			if unicode.Is(unicode.Mn, d) {
+				continue
+			}
+			folded = append(folded, unicode.ToLower(d))
+			mapping = append(mapping, i)
+		}
+	}
+	return folded, mapping
+}
+
+// runeIndexOfByte converts a byte offset from regexp.FindAllStringIndex
+// (which works in UTF-8 bytes) into the corresponding rune index of s.
+func runeIndexOfByte(s string, byteOffset int) int {
+	idx := 0
+	for i := range s {
+		if i >= byteOffset {
+			return idx
+		}
+		idx++
+	}
+	return idx
+}
+
+// compiledSearchRegex compiles query as a case-insensitive regex (following
+// the (?i) convention used elsewhere, see ranges.go), caching the result on
+// e.lastSearchRegex so holding 'n' doesn't recompile the same pattern on
+// every match.
+func (e *Editor) compiledSearchRegex(query string) (*regexp.Regexp, error) {
+	if e.lastSearchRegex != nil && e.lastSearchRegexSrc == query {
+		return e.lastSearchRegex, nil
+	}
+	re, err := regexp.Compile("(?i)" + query)
+	if err != nil {
+		e.lastSearchRegex = nil
+		e.lastSearchRegexSrc = ""
+		return nil, err
+	}
+	e.lastSearchRegex = re
+	e.lastSearchRegexSrc = query
+	return re, nil
+}
+
+// searchMatchesInLine returns every match of query in lineRunes as
+// [startCol, endCol) rune-index pairs in lineRunes' own coordinate space,
+// honoring e.searchRegex and the diacritic-folding toggle (Config.SearchLiteral
+// / e.searchLiteral).
+func (e *Editor) searchMatchesInLine(lineRunes []rune, query string) [][2]int {
+	if query == "" || len(lineRunes) == 0 {
+		return nil
+	}
+
+	literal := Config.SearchLiteral || e.searchLiteral
+	folded, mapping := normalizeForSearch(lineRunes, literal)
+	if len(folded) == 0 {
+		return nil
+	}
+
+	if e.searchRegex {
+		re, err := e.compiledSearchRegex(query)
+		if err != nil {
+			e.setMessage("Invalid regex: " + err.Error())
+			return nil
+		}
+		hay := string(folded)
+		var out [][2]int
+		for _, m := range re.FindAllStringIndex(hay, -1) {
+			startIdx := runeIndexOfByte(hay, m[0])
+			endIdx := runeIndexOfByte(hay, m[1])
+			if endIdx <= startIdx || endIdx > len(mapping) {
+				continue
+			}
+			out = append(out, [2]int{mapping[startIdx], mapping[endIdx-1] + 1})
+		}
+		return out
+	}
+
+	needle, _ := normalizeForSearch([]rune(query), literal)
+	if len(needle) == 0 || len(needle) > len(folded) {
+		return nil
+	}
+	var out [][2]int
+	for i := 0; i <= len(folded)-len(needle); i++ {
+		match := true
+		for j := range needle {
+			if folded[i+j] != needle[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			out = append(out, [2]int{mapping[i], mapping[i+len(needle)-1] + 1})
+		}
+	}
+	return out
+}
+
+// toggleSearchRegex flips regex-mode for '/' search and drops the cached
+// search-match highlighting so it's recomputed under the new rules.
+func (e *Editor) toggleSearchRegex() {
+	e.searchRegex = !e.searchRegex
+	e.lastSearchRegex = nil
+	e.lastSearchRegexSrc = ""
+	e.invalidateSearchMatches()
+}
+
+// toggleSearchLiteral flips the per-session diacritic-folding override for
+// '/' search and drops the cached search-match highlighting.
+func (e *Editor) toggleSearchLiteral() {
+	e.searchLiteral = !e.searchLiteral
+	e.invalidateSearchMatches()
+}
+
+// invalidateSearchMatches clears the active buffer's cached hlsearch ranges,
+// same as markModified, so a search-option toggle takes effect immediately.
+func (e *Editor) invalidateSearchMatches() {
+	if b := e.activeBuffer(); b != nil {
+		b.searchMatches = nil
+	}
+}
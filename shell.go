@@ -0,0 +1,261 @@
+package main
+
+// Asynchronous shell command execution for :! and :r!. Output is streamed
+// line-by-line as it is produced instead of being buffered until the process
+// exits, so long-running commands don't freeze the editor. A running job can
+// be cancelled with Ctrl-C, which delivers SIGINT to the process group.
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nsf/termbox-go"
+)
+
+// ShellJob tracks a single in-flight :! or :r! invocation.
+type ShellJob struct {
+	cmdline   string
+	cmd       *exec.Cmd
+	cancel    context.CancelFunc
+	startedAt time.Time
+	running   bool
+}
+
+// spinnerFrames are cycled based on elapsed time to animate the status bar.
+var spinnerFrames = []rune{'|', '/', '-', '\\'}
+
+// Spinner returns the current animation frame and elapsed time for display.
+func (j *ShellJob) Spinner() (rune, time.Duration) {
+	elapsed := time.Since(j.startedAt)
+	frame := spinnerFrames[int(elapsed/(150*time.Millisecond))%len(spinnerFrames)]
+	return frame, elapsed
+}
+
+// CancelShellJob interrupts the currently running shell job, if any.
+func (e *Editor) CancelShellJob() {
+	if e.activeShellJob == nil || !e.activeShellJob.running {
+		return
+	}
+	e.activeShellJob.cancel()
+	e.setMessage("Interrupted")
+}
+
+// attachProcessGroup configures cmd to run in its own process group so a
+// cancellation can be delivered to the whole group, not just the direct
+// child, and wires Cancel to send SIGINT instead of the default SIGKILL,
+// giving the child a chance to clean up.
+func attachProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGINT)
+	}
+}
+
+// newShellCommand builds an *exec.Cmd that runs shellCmd through /bin/sh in
+// its own process group (see attachProcessGroup).
+func newShellCommand(ctx context.Context, shellCmd string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", shellCmd)
+	attachProcessGroup(cmd)
+	return cmd
+}
+
+// getOrCreateScratchBuffer returns the named scratch buffer, creating and
+// switching to it if it doesn't already exist.
+func (e *Editor) getOrCreateScratchBuffer(name string) *Buffer {
+	for _, b := range e.buffers {
+		if b.filename == name {
+			return b
+		}
+	}
+
+	b := &Buffer{
+		buffer:    [][]rune{{}},
+		undoStack: []Edit{},
+		redoStack: []Edit{},
+		filename:  name,
+		readOnly:  true,
+		fileType:  getFileType(name),
+	}
+	b.setBufType(BufTypeRaw)
+	e.buffers = append(e.buffers, b)
+	return b
+}
+
+// appendScratchLine appends a line of text to a read-only scratch buffer,
+// temporarily lifting the read-only flag to do so.
+func appendScratchLine(b *Buffer, line string) {
+	wasReadOnly := b.readOnly
+	b.readOnly = false
+	if len(b.buffer) == 1 && len(b.buffer[0]) == 0 {
+		b.buffer[0] = []rune(line)
+	} else {
+		b.buffer = append(b.buffer, []rune(line))
+	}
+	b.readOnly = wasReadOnly
+}
+
+// executeShell runs a shell command asynchronously, streaming its combined
+// stdout/stderr into the "[Shell Output]" scratch buffer as it runs.
+func (ch *Command) executeShell(shellCmd string) {
+	shellCmd = strings.TrimSpace(shellCmd)
+	if shellCmd == "" {
+		ch.e.setMessage("No shell command specified")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := newShellCommand(ctx, shellCmd)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		ch.e.setMessage(fmt.Sprintf("Error: %v", err))
+		return
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		cancel()
+		ch.e.setMessage(fmt.Sprintf("Error: %v", err))
+		return
+	}
+
+	if err := cmd.Start(); err != nil {
+		cancel()
+		ch.e.setMessage(fmt.Sprintf("Error starting command: %v", err))
+		return
+	}
+
+	job := &ShellJob{cmdline: shellCmd, cmd: cmd, cancel: cancel, startedAt: time.Now(), running: true}
+	ch.e.activeShellJob = job
+
+	outBuf := ch.e.getOrCreateScratchBuffer("[Shell Output]")
+	appendScratchLine(outBuf, fmt.Sprintf("$ %s", shellCmd))
+
+	go ch.streamShellOutput(job, outBuf, io.MultiReader(stdout, stderr))
+}
+
+// streamShellOutput reads lines from the process as they arrive and appends
+// them to the scratch buffer, waking up the render loop after each one.
+func (ch *Command) streamShellOutput(job *ShellJob, b *Buffer, r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		appendScratchLine(b, scanner.Text())
+		termbox.Interrupt()
+	}
+
+	err := job.cmd.Wait()
+	job.running = false
+
+	duration := time.Since(job.startedAt)
+	exitCode := 0
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			exitCode = exitErr.ExitCode()
+		} else {
+			exitCode = -1
+		}
+	}
+
+	ch.e.addLog("Shell", fmt.Sprintf("%q exited %d in %s", job.cmdline, exitCode, duration.Round(time.Millisecond)))
+	appendScratchLine(b, fmt.Sprintf("[exit %d, %s]", exitCode, duration.Round(time.Millisecond)))
+	termbox.Interrupt()
+}
+
+// readShell runs a shell command asynchronously and inserts its output lines
+// into the active buffer at the cursor position as they arrive.
+func (ch *Command) readShell(shellCmd string) {
+	shellCmd = strings.TrimSpace(shellCmd)
+	if shellCmd == "" {
+		ch.e.setMessage("No shell command specified")
+		return
+	}
+
+	b := ch.e.activeBuffer()
+	if b == nil {
+		return
+	}
+	if b.readOnly {
+		ch.e.setMessage("File is read-only")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := newShellCommand(ctx, shellCmd)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		ch.e.setMessage(fmt.Sprintf("Error: %v", err))
+		return
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		cancel()
+		ch.e.setMessage(fmt.Sprintf("Error: %v", err))
+		return
+	}
+
+	if err := cmd.Start(); err != nil {
+		cancel()
+		ch.e.setMessage(fmt.Sprintf("Error starting command: %v", err))
+		return
+	}
+
+	job := &ShellJob{cmdline: shellCmd, cmd: cmd, cancel: cancel, startedAt: time.Now(), running: true}
+	ch.e.activeShellJob = job
+
+	ch.e.saveState()
+	insertY := b.PrimaryCursor().Y
+
+	go ch.streamShellInsert(job, b, &insertY, io.MultiReader(stdout, stderr))
+}
+
+// streamShellInsert reads lines from the process and splices each one into
+// the buffer right after insertY, advancing insertY as lines arrive.
+func (ch *Command) streamShellInsert(job *ShellJob, b *Buffer, insertY *int, r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	count := 0
+	for scanner.Scan() {
+		*insertY++
+		newLine := []rune(scanner.Text())
+		y := *insertY
+		if y <= len(b.buffer) {
+			b.buffer = append(b.buffer[:y], append([][]rune{newLine}, b.buffer[y:]...)...)
+		} else {
+			b.buffer = append(b.buffer, newLine)
+		}
+		count++
+		ch.e.markModified()
+		termbox.Interrupt()
+	}
+
+	err := job.cmd.Wait()
+	job.running = false
+	duration := time.Since(job.startedAt)
+	exitCode := 0
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			exitCode = exitErr.ExitCode()
+		} else {
+			exitCode = -1
+		}
+	}
+
+	ch.e.addLog("Shell", fmt.Sprintf("%q exited %d in %s, %d lines inserted", job.cmdline, exitCode, duration.Round(time.Millisecond), count))
+
+	if b.syntax != nil {
+		b.syntaxReparse()
+	}
+	if b.lspClient != nil {
+		b.lspClient.SendDidChange(b.toString())
+	}
+
+	ch.e.setMessage(fmt.Sprintf("%d lines inserted (exit %d)", count, exitCode))
+	termbox.Interrupt()
+}
@@ -0,0 +1,303 @@
+package main
+
+// Snippet placeholder support for LSP completions whose InsertTextFormat is
+// Snippet (value 2, see lsp.go's CompletionItem). insertCompletion renders a
+// snippet body into plain text up front (see parseSnippet) and this file
+// tracks the resulting tabstops so Tab/Shift-Tab can walk between them, each
+// arrival clearing that stop's placeholder text and dropping one cursor per
+// mirrored occurrence so the existing multi-cursor typing path in insertRune
+// edits every mirror at once.
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// insertTextFormatSnippet is the LSP InsertTextFormat value marking a
+// completion item's inserted text as the snippet mini-language below rather
+// than plain text.
+const insertTextFormatSnippet = 2
+
+// snippetSpan is a [Start, End) rune-offset range into the rendered (plain,
+// marker-free) snippet text produced by parseSnippet.
+type snippetSpan struct {
+	Start, End int
+}
+
+// snippetTabstop is one numbered placeholder parsed out of a snippet body.
+// Mirrors lists every occurrence of that number in the rendered text, first
+// occurrence first.
+type snippetTabstop struct {
+	Number  int
+	Mirrors []snippetSpan
+}
+
+// SnippetStop is one tabstop left to visit after inserting a snippet
+// completion, tracked as live buffer Ranges (one per mirrored occurrence)
+// using the same Range/Position types the rest of lsp.go uses.
+type SnippetStop struct {
+	Ranges []Range
+}
+
+// parseSnippet renders a snippet body to plain text, expanding each
+// placeholder to its default text (or "" if it has none) and returns the
+// tabstops found, ordered by stop number with 0 sorted last since LSP uses
+// it to mean "final cursor position" rather than an actual rank. Recognizes
+// `$1`, `${1:default}` (default text may itself contain nested
+// `${2:...}` placeholders, which are parsed as further tabstops rather than
+// swallowed as literal text), `${1|one,two,three|}` choice placeholders
+// (rendered as their first choice), and the escapes `\$`, `\}`, `\\`;
+// anything else passes through unchanged.
+func parseSnippet(body string) (rendered string, stops []snippetTabstop) {
+	src := []rune(body)
+	var out []rune
+	byNumber := map[int]int{} // tabstop number -> index into stops
+
+	i := 0
+	for i < len(src) {
+		r := src[i]
+
+		if r == '\\' && i+1 < len(src) && (src[i+1] == '$' || src[i+1] == '}' || src[i+1] == '\\') {
+			out = append(out, src[i+1])
+			i += 2
+			continue
+		}
+
+		if r != '$' {
+			out = append(out, r)
+			i++
+			continue
+		}
+
+		j := i + 1
+		braced := j < len(src) && src[j] == '{'
+		if braced {
+			j++
+		}
+		numStart := j
+		for j < len(src) && src[j] >= '0' && src[j] <= '9' {
+			j++
+		}
+		if j == numStart {
+			// A bare '$' not followed by a tabstop number; keep it literal.
+			out = append(out, r)
+			i++
+			continue
+		}
+		num, _ := strconv.Atoi(string(src[numStart:j]))
+
+		var placeholderBody string
+		isChoice := false
+		if braced && j < len(src) && (src[j] == ':' || src[j] == '|') {
+			isChoice = src[j] == '|'
+			j++
+			defStart := j
+			depth := 0
+			for j < len(src) {
+				c := src[j]
+				if c == '{' {
+					depth++
+				} else if c == '}' {
+					if depth == 0 {
+						break
+					}
+					depth--
+				} else if isChoice && c == '|' && depth == 0 {
+					break
+				}
+				j++
+			}
+			placeholderBody = string(src[defStart:j])
+			if isChoice && j < len(src) && src[j] == '|' {
+				j++ // Skip the closing '|' of "|a,b,c|"; a trailing '}' still follows.
+			}
+		}
+		if braced && j < len(src) && src[j] == '}' {
+			j++
+		}
+		i = j
+
+		placeholder := placeholderBody
+		if isChoice {
+			// ${N|a,b,c|}: render the first choice, same as most editors'
+			// default selection before the user picks a different one.
+			if comma := strings.IndexByte(placeholderBody, ','); comma != -1 {
+				placeholder = placeholderBody[:comma]
+			}
+		}
+
+		start := len(out)
+		if isChoice {
+			out = append(out, []rune(placeholder)...)
+		} else {
+			// Default text can itself contain nested tabstops/placeholders
+			// (e.g. gopls's `${1:func(${2:args})}`), so recurse instead of
+			// inserting it as opaque literal text.
+			nested, nestedStops := parseSnippet(placeholder)
+			for _, ns := range nested {
+				out = append(out, ns)
+			}
+			for _, ns := range nestedStops {
+				for _, m := range ns.Mirrors {
+					idx, ok := byNumber[ns.Number]
+					if !ok {
+						idx = len(stops)
+						byNumber[ns.Number] = idx
+						stops = append(stops, snippetTabstop{Number: ns.Number})
+					}
+					stops[idx].Mirrors = append(stops[idx].Mirrors, snippetSpan{Start: start + m.Start, End: start + m.End})
+				}
+			}
+		}
+		end := len(out)
+
+		idx, ok := byNumber[num]
+		if !ok {
+			idx = len(stops)
+			byNumber[num] = idx
+			stops = append(stops, snippetTabstop{Number: num})
+		}
+		stops[idx].Mirrors = append(stops[idx].Mirrors, snippetSpan{Start: start, End: end})
+	}
+
+	sort.SliceStable(stops, func(a, b int) bool {
+		if stops[a].Number == 0 {
+			return false
+		}
+		if stops[b].Number == 0 {
+			return true
+		}
+		return stops[a].Number < stops[b].Number
+	})
+
+	return string(out), stops
+}
+
+// bufferPosForOffset returns the (line, col) that rune offset off lands on
+// within text, given text was inserted starting at (startY, startX).
+func bufferPosForOffset(startY, startX int, text []rune, off int) (int, int) {
+	line, col := startY, startX
+	for i := 0; i < off && i < len(text); i++ {
+		if text[i] == '\n' {
+			line++
+			col = 0
+		} else {
+			col++
+		}
+	}
+	return line, col
+}
+
+// beginSnippet converts parseSnippet's rune-offset tabstops into buffer
+// Ranges (text has already been spliced into b at (startY, startX) by the
+// caller) and selects the first one.
+func (e *Editor) beginSnippet(b *Buffer, startY, startX int, text []rune, stops []snippetTabstop) {
+	e.snippetStops = make([]SnippetStop, len(stops))
+	for i, st := range stops {
+		ranges := make([]Range, len(st.Mirrors))
+		for j, m := range st.Mirrors {
+			sy, sx := bufferPosForOffset(startY, startX, text, m.Start)
+			ey, ex := bufferPosForOffset(startY, startX, text, m.End)
+			ranges[j] = Range{Start: Position{Line: sy, Character: sx}, End: Position{Line: ey, Character: ex}}
+		}
+		e.snippetStops[i] = SnippetStop{Ranges: ranges}
+	}
+	e.selectSnippetStop(b, 0)
+}
+
+// selectSnippetStop moves into snippetStops[index]: it clears the
+// placeholder text at each of the stop's mirrored ranges (furthest down the
+// buffer first, so earlier ranges stay valid while later ones are cleared),
+// then drops one cursor per mirror so typing through insertRune/backspace
+// edits all of them in lockstep.
+func (e *Editor) selectSnippetStop(b *Buffer, index int) {
+	if b == nil || index < 0 || index >= len(e.snippetStops) {
+		return
+	}
+	stop := e.snippetStops[index]
+	if len(stop.Ranges) == 0 {
+		e.endSnippet()
+		return
+	}
+
+	ranges := append([]Range{}, stop.Ranges...)
+	sort.Slice(ranges, func(i, j int) bool {
+		if ranges[i].Start.Line != ranges[j].Start.Line {
+			return ranges[i].Start.Line > ranges[j].Start.Line
+		}
+		return ranges[i].Start.Character > ranges[j].Start.Character
+	})
+	for _, r := range ranges {
+		e.applyLSPTextEdit(b, TextEdit{Range: r})
+	}
+
+	b.ClearCursors()
+	primary := b.PrimaryCursor()
+	primary.Y, primary.X = stop.Ranges[0].Start.Line, stop.Ranges[0].Start.Character
+	primary.PreferredCol = primary.X
+	for _, r := range stop.Ranges[1:] {
+		b.AddCursor(r.Start.Character, r.Start.Line)
+	}
+
+	e.snippetIndex = index
+}
+
+// nextSnippetStop advances to the next tabstop, or ends snippet mode if the
+// cursor was already on the last one.
+func (e *Editor) nextSnippetStop() {
+	if len(e.snippetStops) == 0 {
+		return
+	}
+	if e.snippetIndex+1 >= len(e.snippetStops) {
+		e.endSnippet()
+		return
+	}
+	e.selectSnippetStop(e.activeBuffer(), e.snippetIndex+1)
+}
+
+// prevSnippetStop returns to the previous tabstop, if any.
+func (e *Editor) prevSnippetStop() {
+	if len(e.snippetStops) == 0 || e.snippetIndex == 0 {
+		return
+	}
+	e.selectSnippetStop(e.activeBuffer(), e.snippetIndex-1)
+}
+
+// endSnippet leaves snippet-navigation mode, keeping whatever the cursors
+// currently contain.
+func (e *Editor) endSnippet() {
+	e.snippetStops = nil
+	e.snippetIndex = 0
+}
+
+// adjustSnippetStopsForEdit keeps tabstops the user hasn't reached yet
+// aligned with edits made while filling in the active one, mirroring what
+// Buffer.adjustCursorsForEdit does for sibling cursors. Called from
+// insertRune/backspace for every cursor they edit while a snippet is active.
+func (e *Editor) adjustSnippetStopsForEdit(y, x, deltaRunes, deltaLines int) {
+	for i := range e.snippetStops {
+		if i == e.snippetIndex {
+			continue // Tracked live via cursors, not a stored Range, while active.
+		}
+		ranges := e.snippetStops[i].Ranges
+		for j := range ranges {
+			adjustSnippetPosition(&ranges[j].Start, y, x, deltaRunes, deltaLines)
+			adjustSnippetPosition(&ranges[j].End, y, x, deltaRunes, deltaLines)
+		}
+	}
+}
+
+// adjustSnippetPosition shifts a single Position by an edit at (y, x), the
+// same rule Buffer.adjustCursorsForEdit applies to cursors.
+func adjustSnippetPosition(p *Position, y, x, deltaRunes, deltaLines int) {
+	if deltaRunes != 0 && p.Line == y && p.Character >= x {
+		p.Character += deltaRunes
+		if p.Character < x {
+			p.Character = x
+		}
+	}
+	if deltaLines != 0 && p.Line > y {
+		p.Line += deltaLines
+	}
+}
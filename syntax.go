@@ -6,6 +6,11 @@ package main
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	sitter "github.com/mitjafelicijan/go-tree-sitter"
 	"github.com/mitjafelicijan/go-tree-sitter/bash"
@@ -28,17 +33,100 @@ import (
 
 // SyntaxHighlighter manages the tree-sitter parser, tree, and calculated highlights for a buffer.
 type SyntaxHighlighter struct {
-	Parser     *sitter.Parser
-	Tree       *sitter.Tree
-	Lang       *sitter.Language
-	Query      *sitter.Query
-	Language   string
-	Highlights map[int]map[int]termbox.Attribute // Cached colors: Line -> Col -> termbox.Attribute
-	Log        func(string, string)              // Debug logging function.
+	Parser         *sitter.Parser
+	Tree           *sitter.Tree
+	Lang           *sitter.Language
+	Query          *sitter.Query
+	RainbowQuery   *sitter.Query // Nested-delimiter depth coloring, see queries/<lang>/rainbows.scm and rainbowHighlights.
+	InjectionQuery *sitter.Query // Embedded-language detection, see queries/<lang>/injections.scm and injectionHighlights.
+	LocalsQuery    *sitter.Query // Scope-aware def/reference coloring, see queries/<lang>/locals.scm and localsHighlights.
+	Language       string
+	Log            func(string, string) // Debug logging function.
+
+	// source is the raw content Tree was parsed from. injectionHighlights
+	// needs it both to evaluate eq?/match? predicates in InjectionQuery
+	// (FilterPredicates reads node text out of it) and to slice out the
+	// bytes of each @injection.content span to hand to a child highlighter.
+	source []byte
+
+	// Highlights is the render-facing color cache (Line -> Col -> Attribute).
+	// It's written from both the main goroutine (Edit's synchronous
+	// updateHighlightsRange, for the line(s) just typed) and the background
+	// worker below (for the visible viewport), so every access goes through
+	// highlightsMu; Highlight takes an RLock, everything that writes takes
+	// a Lock.
+	Highlights   map[int]map[int]termbox.Attribute
+	highlightsMu sync.RWMutex
+
+	// injectionCache holds one child SyntaxHighlighter per injected region,
+	// keyed by "<language>:<content hash>" (see getInjectionHighlighter), so
+	// an untouched fenced code block or <script> tag reuses its
+	// already-computed highlights instead of reparsing on every recompute
+	// triggered by edits elsewhere in the file.
+	injectionCache   map[string]*SyntaxHighlighter
+	injectionCacheMu sync.Mutex
+
+	// needsReparse is set by Edit when its incremental parse is cancelled by
+	// syntaxEditBudget, leaving Tree/Highlights stale. Buffer.handleEdit
+	// keeps feeding it further incremental edits either way (Tree.Edit still
+	// tracks the accumulated position deltas), but the next idle tick (see
+	// kevent.go's EventInterrupt handling) forces a full Reparse to catch up.
+	needsReparse bool
+
+	// highlightJobs feeds the background worker started in
+	// NewSyntaxHighlighter (see EnqueueViewport/runHighlightWorker). Buffered
+	// to 1 so EnqueueViewport can drain a stale, not-yet-picked-up job and
+	// replace it with the latest one without blocking the caller (draw).
+	highlightJobs   chan highlightJob
+	highlightCancel context.CancelFunc
+	lastViewport    highlightViewport
+	haveViewport    bool
 }
 
-// NewSyntaxHighlighter initializes a parser for the given file type.
+// highlightViewport is the row range (inclusive) the editor currently has
+// visible, as reported by Editor.draw via EnqueueViewport.
+type highlightViewport struct {
+	startRow, endRow int
+}
+
+// highlightJob is one unit of work for the background highlight worker: the
+// tree to query and the viewport to restrict the query to, cancellable via
+// ctx if a newer viewport supersedes it before the worker gets to it.
+type highlightJob struct {
+	tree     *sitter.Tree
+	source   []byte
+	viewport highlightViewport
+	ctx      context.Context
+}
+
+// highlightOverscan extends a requested viewport on both ends so a small
+// scroll doesn't immediately reveal unhighlighted lines at the new edge.
+const highlightOverscan = 50
+
+// maxInjectionCacheEntries bounds injectionCache (see getInjectionHighlighter)
+// so editing inside one injected block repeatedly doesn't grow it without limit.
+const maxInjectionCacheEntries = 64
+
+// NewSyntaxHighlighter initializes a parser for the given file type and
+// starts its background highlight worker (see runHighlightWorker).
 func NewSyntaxHighlighter(fileType string, log func(string, string)) *SyntaxHighlighter {
+	s := newSyntaxHighlighterCore(fileType, log)
+	if s == nil {
+		return nil
+	}
+
+	go s.runHighlightWorker()
+
+	return s
+}
+
+// newSyntaxHighlighterCore does the language/query setup NewSyntaxHighlighter
+// needs, without starting a background worker. Used directly by
+// getInjectionHighlighter: an injected region's child highlighter is parsed
+// once up front (see (*SyntaxHighlighter).ParseFull) rather than driven by a
+// scrolling viewport, so it has no use for EnqueueViewport's worker/channel
+// and starting one per cached child would just leak a blocked goroutine.
+func newSyntaxHighlighterCore(fileType string, log func(string, string)) *SyntaxHighlighter {
 	parser := sitter.NewParser()
 	var lang *sitter.Language
 	var langName string
@@ -96,17 +184,28 @@ func NewSyntaxHighlighter(fileType string, log func(string, string)) *SyntaxHigh
 
 	parser.SetLanguage(lang)
 	s := &SyntaxHighlighter{
-		Parser:     parser,
-		Lang:       lang,
-		Language:   langName,
-		Highlights: make(map[int]map[int]termbox.Attribute),
-		Log:        log,
+		Parser:         parser,
+		Lang:           lang,
+		Language:       langName,
+		Highlights:     make(map[int]map[int]termbox.Attribute),
+		Log:            log,
+		highlightJobs:  make(chan highlightJob, 1),
+		injectionCache: make(map[string]*SyntaxHighlighter),
 	}
 
 	// Load the tree-sitter query file (.scm) for this language.
 	queryPath := fmt.Sprintf("queries/%s.scm", langName)
 	s.LoadQuery(queryPath)
 
+	// Rainbow-bracket, injection, and locals queries live one directory
+	// deeper (queries/<lang>/rainbows.scm, queries/<lang>/injections.scm,
+	// queries/<lang>/locals.scm, not queries/<lang>.scm) since they're
+	// optional extra layers, not every language ships one. A missing file is
+	// logged by the loader and otherwise ignored, same as a missing base query.
+	s.LoadRainbowQuery(fmt.Sprintf("queries/%s/rainbows.scm", langName))
+	s.LoadInjectionQuery(fmt.Sprintf("queries/%s/injections.scm", langName))
+	s.LoadLocalsQuery(fmt.Sprintf("queries/%s/locals.scm", langName))
+
 	return s
 }
 
@@ -132,14 +231,92 @@ func (s *SyntaxHighlighter) LoadQuery(path string) {
 	}
 }
 
-// Parse runs a full parse of the content and updates the highlight cache.
+// LoadRainbowQuery reads and compiles the optional rainbow-bracket query for
+// this language. Not every language ships one yet, so a missing file is
+// logged and left as a no-op (RainbowQuery stays nil) rather than treated as
+// an error, matching LoadQuery's own tolerance for a missing base query.
+func (s *SyntaxHighlighter) LoadRainbowQuery(path string) {
+	content, err := QueriesFS.ReadFile(path)
+	if err != nil {
+		if s.Log != nil {
+			s.Log("TS", fmt.Sprintf("LoadRainbowQuery failed to read %s: %v", path, err))
+		}
+		return
+	}
+
+	q, err := sitter.NewQuery(content, s.Lang)
+	if err == nil {
+		s.RainbowQuery = q
+	} else if s.Log != nil {
+		s.Log("TS", fmt.Sprintf("LoadRainbowQuery failed to compile query for %s: %v", path, err))
+	}
+}
+
+// LoadInjectionQuery reads and compiles the optional embedded-language query
+// for this language. Not every language ships one yet, so a missing file is
+// logged and left as a no-op (InjectionQuery stays nil), matching
+// LoadRainbowQuery's tolerance for a missing file.
+func (s *SyntaxHighlighter) LoadInjectionQuery(path string) {
+	content, err := QueriesFS.ReadFile(path)
+	if err != nil {
+		if s.Log != nil {
+			s.Log("TS", fmt.Sprintf("LoadInjectionQuery failed to read %s: %v", path, err))
+		}
+		return
+	}
+
+	q, err := sitter.NewQuery(content, s.Lang)
+	if err == nil {
+		s.InjectionQuery = q
+	} else if s.Log != nil {
+		s.Log("TS", fmt.Sprintf("LoadInjectionQuery failed to compile query for %s: %v", path, err))
+	}
+}
+
+// LoadLocalsQuery reads and compiles the optional scope/def/reference query
+// for this language. Not every language ships one yet, so a missing file is
+// logged and left as a no-op (LocalsQuery stays nil), matching
+// LoadRainbowQuery's tolerance for a missing file.
+func (s *SyntaxHighlighter) LoadLocalsQuery(path string) {
+	content, err := QueriesFS.ReadFile(path)
+	if err != nil {
+		if s.Log != nil {
+			s.Log("TS", fmt.Sprintf("LoadLocalsQuery failed to read %s: %v", path, err))
+		}
+		return
+	}
+
+	q, err := sitter.NewQuery(content, s.Lang)
+	if err == nil {
+		s.LocalsQuery = q
+	} else if s.Log != nil {
+		s.Log("TS", fmt.Sprintf("LoadLocalsQuery failed to compile query for %s: %v", path, err))
+	}
+}
+
+// Parse runs a full parse of the content. It deliberately does not recompute
+// Highlights itself: doing that for the whole file on the main goroutine is
+// exactly the O(file)-per-edit stall this was meant to avoid (see
+// EnqueueViewport). Instead it drops the cache and resets haveViewport so
+// the next EnqueueViewport call (from Editor.draw, on the very next frame)
+// recomputes the now-visible lines in the background even if the viewport
+// itself hasn't moved.
 func (s *SyntaxHighlighter) Parse(content []byte) {
 	if s.Parser == nil {
 		return
 	}
 	tree, _ := s.Parser.ParseCtx(context.Background(), nil, content)
 	s.Tree = tree
-	s.updateHighlights(content)
+	s.source = content
+
+	s.highlightsMu.Lock()
+	s.Highlights = make(map[int]map[int]termbox.Attribute)
+	s.highlightsMu.Unlock()
+	s.haveViewport = false
+
+	s.injectionCacheMu.Lock()
+	s.injectionCache = make(map[string]*SyntaxHighlighter)
+	s.injectionCacheMu.Unlock()
 }
 
 // Reparse is a wrapper around Parse (used for batch updates).
@@ -147,23 +324,111 @@ func (s *SyntaxHighlighter) Reparse(content []byte) {
 	s.Parse(content)
 }
 
-// Edit is a placeholder for incremental parsing (currently does a full reparse).
-func (s *SyntaxHighlighter) Edit(edit sitter.EditInput, newContent []byte) {
-	s.Reparse(newContent)
+// ParseFull parses content and synchronously computes highlights for the
+// whole result, bypassing the viewport/background-worker machinery Parse
+// defers to. Used for injected regions (see getInjectionHighlighter), which
+// are small, self-contained spans computed once up front rather than driven
+// by a scrolling viewport.
+func (s *SyntaxHighlighter) ParseFull(content []byte) {
+	if s.Parser == nil {
+		return
+	}
+	tree, _ := s.Parser.ParseCtx(context.Background(), nil, content)
+	s.Tree = tree
+	s.source = content
+
+	endRow := strings.Count(string(content), "\n")
+
+	var result map[int]map[int]termbox.Attribute
+	if s.Query != nil {
+		qc := sitter.NewQueryCursor()
+		qc.Exec(s.Query, tree.RootNode())
+		result = s.collectCaptures(qc)
+	}
+	result = mergeHighlights(result, s.localsHighlights(tree, content, 0, endRow))
+	result = mergeHighlights(result, s.rainbowHighlights(tree, 0, endRow))
+	result = mergeHighlights(result, s.injectionHighlights(tree, content, 0, endRow))
+
+	s.highlightsMu.Lock()
+	s.Highlights = result
+	s.highlightsMu.Unlock()
 }
 
-// updateHighlights executes the tree-sitter query on the syntax tree and populates the highlight cache.
-func (s *SyntaxHighlighter) updateHighlights(source []byte) {
-	// Always clear previous highlights to prevent ghosting.
-	s.Highlights = make(map[int]map[int]termbox.Attribute)
+// syntaxEditBudget bounds how long Edit's incremental ParseCtx is allowed to
+// run before it's cancelled, so a single keystroke that happens to produce a
+// pathological parse (e.g. deeply nested unmatched brackets) can't stall the
+// UI. This is comfortably above what a normal incremental reparse of one
+// edited region takes, but well under a frame.
+const syntaxEditBudget = 20 * time.Millisecond
 
-	if s.Tree == nil || s.Query == nil {
+// Edit applies an incremental parse for one buffer mutation: it tells the
+// existing tree about the edited byte/point range via Tree.Edit, then
+// reparses newContent against that tree so tree-sitter only has to redo work
+// around the edit instead of the whole file. The reparse runs under
+// syntaxEditBudget; if it's cancelled, the stale (but edit-adjusted) tree and
+// highlights are kept as-is and needsReparse is set so a later idle pass
+// does a full Reparse instead. On success, only the highlights for the rows
+// the edit touched are recomputed.
+func (s *SyntaxHighlighter) Edit(edit sitter.EditInput, newContent []byte) {
+	if s.Parser == nil {
 		return
 	}
+	if s.Tree != nil {
+		s.Tree.Edit(edit)
+	}
 
-	qc := sitter.NewQueryCursor()
-	qc.Exec(s.Query, s.Tree.RootNode())
+	ctx, cancel := context.WithTimeout(context.Background(), syntaxEditBudget)
+	defer cancel()
+	tree, err := s.Parser.ParseCtx(ctx, s.Tree, newContent)
+	if err != nil {
+		s.needsReparse = true
+		return
+	}
+
+	s.needsReparse = false
+	s.Tree = tree
+	s.source = newContent
+
+	startRow := int(edit.StartPoint.Row)
+	endRow := int(edit.OldEndPoint.Row)
+	if int(edit.NewEndPoint.Row) > endRow {
+		endRow = int(edit.NewEndPoint.Row)
+	}
+	s.updateHighlightsRange(startRow, endRow)
+}
+
+// updateHighlightsRange re-executes the query restricted to rows
+// [startRow, endRow] and merges the result into the existing highlight
+// cache, leaving highlights for every other line untouched. Used by Edit,
+// where a successful incremental parse only invalidates the lines the edit
+// actually touched.
+func (s *SyntaxHighlighter) updateHighlightsRange(startRow, endRow int) {
+	var result map[int]map[int]termbox.Attribute
+	if s.Tree != nil && s.Query != nil {
+		qc := sitter.NewQueryCursor()
+		qc.SetPointRange(sitter.Point{Row: uint32(startRow)}, sitter.Point{Row: uint32(endRow + 1)})
+		qc.Exec(s.Query, s.Tree.RootNode())
+		result = s.collectCaptures(qc)
+	}
+	result = mergeHighlights(result, s.localsHighlights(s.Tree, s.source, startRow, endRow))
+	result = mergeHighlights(result, s.rainbowHighlights(s.Tree, startRow, endRow))
+	result = mergeHighlights(result, s.injectionHighlights(s.Tree, s.source, startRow, endRow))
 
+	s.highlightsMu.Lock()
+	for r := startRow; r <= endRow; r++ {
+		delete(s.Highlights, r)
+	}
+	for r, cols := range result {
+		s.Highlights[r] = cols
+	}
+	s.highlightsMu.Unlock()
+}
+
+// collectCaptures drains qc into a fresh Line -> Col -> Attribute map. It
+// doesn't touch s.Highlights itself, so callers merge the result in under
+// whatever locking (and row range) fits their caller.
+func (s *SyntaxHighlighter) collectCaptures(qc *sitter.QueryCursor) map[int]map[int]termbox.Attribute {
+	result := make(map[int]map[int]termbox.Attribute)
 	for {
 		m, ok := qc.NextMatch()
 		if !ok {
@@ -182,8 +447,8 @@ func (s *SyntaxHighlighter) updateHighlights(source []byte) {
 
 			// Map the capture span to line/column color attributes.
 			for r := startRow; r <= endRow; r++ {
-				if _, ok := s.Highlights[r]; !ok {
-					s.Highlights[r] = make(map[int]termbox.Attribute)
+				if _, ok := result[r]; !ok {
+					result[r] = make(map[int]termbox.Attribute)
 				}
 
 				cStart := 0
@@ -202,51 +467,676 @@ func (s *SyntaxHighlighter) updateHighlights(source []byte) {
 				}
 
 				for col := cStart; col < limit; col++ {
-					s.Highlights[r][col] = attr
+					result[r][col] = attr
 				}
 			}
 		}
 	}
+	return result
 }
 
-// getTermboxAttr maps a tree-sitter capture name to a color name from our theme.
-func getTermboxAttr(captureName string) termbox.Attribute {
-	var cn ColorName
-	switch captureName {
-	case "function":
-		cn = ColorTSFunction
-	case "tag":
-		cn = ColorTSTag
-	case "attribute":
-		cn = ColorTSAttribute
-	case "constant":
-		cn = ColorTSConstant
-	case "variable":
-		cn = ColorTSVariable
-	case "type":
-		cn = ColorTSType
-	case "string":
-		cn = ColorTSString
-	case "keyword":
-		cn = ColorTSKeyword
-	case "comment":
-		cn = ColorTSComment
-	case "number":
-		cn = ColorTSNumber
-	case "boolean":
-		cn = ColorTSBoolean
-	case "null":
-		cn = ColorTSNull
-	case "property":
-		cn = ColorTSProperty
+// EnqueueViewport asks the background highlight worker to recompute
+// highlights for [startRow, endRow] plus highlightOverscan on either side,
+// cancelling whatever viewport job is still in flight. Editor.draw calls
+// this every frame; a viewport identical to the last one requested is a
+// no-op, so scrolling (or Parse resetting haveViewport) is what actually
+// triggers work, not redrawing in place.
+func (s *SyntaxHighlighter) EnqueueViewport(tree *sitter.Tree, startRow, endRow int) {
+	if tree == nil || s.Query == nil {
+		return
+	}
+
+	vp := highlightViewport{startRow: startRow, endRow: endRow}
+	if s.haveViewport && vp == s.lastViewport {
+		return
+	}
+	s.lastViewport = vp
+	s.haveViewport = true
+
+	if s.highlightCancel != nil {
+		s.highlightCancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.highlightCancel = cancel
+
+	// source is captured here (on the same goroutine that writes s.source in
+	// Edit/Parse) rather than read from s.source later inside the worker
+	// goroutine, which would otherwise race the slice header against a
+	// concurrent Edit/Parse call. See the job.tree staleness check below for
+	// the same reasoning applied to s.Tree.
+	job := highlightJob{tree: tree, source: s.source, viewport: vp, ctx: ctx}
+
+	// Drain a stale, not-yet-picked-up job so the worker only ever acts on
+	// the most recent viewport.
+	select {
+	case <-s.highlightJobs:
 	default:
-		return termbox.ColorDefault
 	}
+	s.highlightJobs <- job
+}
+
+// runHighlightWorker is the background goroutine started by
+// NewSyntaxHighlighter. It lives for the lifetime of the SyntaxHighlighter,
+// computing one viewport's highlights at a time as EnqueueViewport feeds it.
+func (s *SyntaxHighlighter) runHighlightWorker() {
+	for job := range s.highlightJobs {
+		s.computeViewportHighlights(job)
+	}
+}
+
+// computeViewportHighlights runs the query restricted to job's viewport
+// (plus overscan) against job.tree, then merges the result into Highlights
+// under highlightsMu. Bails out without touching Highlights if job.ctx was
+// cancelled before or after the (lock-free) query execution, so a
+// superseded job never clobbers a newer one's results. It also bails out if
+// s.Tree has moved on from job.tree by the time the query finishes: Edit()
+// replaces s.Tree (and synchronously recomputes the edited rows' highlights)
+// without going through highlightJobs, so a worker job started against an
+// older tree can otherwise finish after Edit() and overwrite its fresher,
+// edit-adjacent highlights with results computed from stale content.
+func (s *SyntaxHighlighter) computeViewportHighlights(job highlightJob) {
+	if job.ctx.Err() != nil {
+		return
+	}
+
+	start := job.viewport.startRow - highlightOverscan
+	if start < 0 {
+		start = 0
+	}
+	end := job.viewport.endRow + highlightOverscan
+
+	qc := sitter.NewQueryCursor()
+	qc.SetPointRange(sitter.Point{Row: uint32(start)}, sitter.Point{Row: uint32(end + 1)})
+	qc.Exec(s.Query, job.tree.RootNode())
+	result := s.collectCaptures(qc)
+	result = mergeHighlights(result, s.localsHighlights(job.tree, job.source, start, end))
+	result = mergeHighlights(result, s.rainbowHighlights(job.tree, start, end))
+	result = mergeHighlights(result, s.injectionHighlights(job.tree, job.source, start, end))
+
+	if job.ctx.Err() != nil || job.tree != s.Tree {
+		return
+	}
+
+	s.highlightsMu.Lock()
+	for r := start; r <= end; r++ {
+		delete(s.Highlights, r)
+	}
+	for r, cols := range result {
+		s.Highlights[r] = cols
+	}
+	s.highlightsMu.Unlock()
+
+	termbox.Interrupt()
+}
+
+// rainbowHighlights runs RainbowQuery restricted to [startRow, endRow] against
+// tree and returns a Line -> Col -> Attribute map colorizing each
+// @rainbow.bracket capture by how many @rainbow.scope captures are proper
+// ancestors of it. Returns nil if rainbow brackets are disabled or this
+// language has no rainbows.scm.
+func (s *SyntaxHighlighter) rainbowHighlights(tree *sitter.Tree, startRow, endRow int) map[int]map[int]termbox.Attribute {
+	if !Config.RainbowBrackets || s.RainbowQuery == nil || tree == nil {
+		return nil
+	}
+
+	// Depth needs every @rainbow.scope ancestor of a bracket, including ones
+	// whose own start lies outside [startRow, endRow] (a bracket deep inside
+	// a long block is still nested under that block's opening brace, even if
+	// the brace itself scrolled off 50+ lines ago). Restricting this pass to
+	// the viewport like the bracket query below would silently drop those
+	// enclosing scopes and undercount depth, so scopes are collected from an
+	// unrestricted pass over the whole tree; only the (far more numerous)
+	// bracket tokens are restricted to the viewport.
+	scopeIDs := s.collectRainbowScopeIDs(tree)
+
+	qc := sitter.NewQueryCursor()
+	qc.SetPointRange(sitter.Point{Row: uint32(startRow)}, sitter.Point{Row: uint32(endRow + 1)})
+	qc.Exec(s.RainbowQuery, tree.RootNode())
+
+	result := make(map[int]map[int]termbox.Attribute)
+	for {
+		m, ok := qc.NextMatch()
+		if !ok {
+			break
+		}
+		for _, c := range m.Captures {
+			if s.RainbowQuery.CaptureNameForId(c.Index) != "rainbow.bracket" {
+				continue
+			}
+
+			depth := 0
+			for p := c.Node.Parent(); p != nil; p = p.Parent() {
+				if scopeIDs[p.ID()] {
+					depth++
+				}
+			}
+			attr := getRainbowAttr(depth)
+
+			row := int(c.Node.StartPoint().Row)
+			if _, ok := result[row]; !ok {
+				result[row] = make(map[int]termbox.Attribute)
+			}
+			for col := int(c.Node.StartPoint().Column); col < int(c.Node.EndPoint().Column); col++ {
+				result[row][col] = attr
+			}
+		}
+	}
+	return result
+}
+
+// collectRainbowScopeIDs runs RainbowQuery unrestricted over the whole tree
+// and returns the node IDs of every @rainbow.scope capture. Node.Parent()
+// walks the real underlying tree regardless of any point range a query
+// cursor was restricted to, so a bracket's ancestor chain can reach a scope
+// node that a viewport-restricted query pass would never have matched.
+func (s *SyntaxHighlighter) collectRainbowScopeIDs(tree *sitter.Tree) map[uintptr]bool {
+	qc := sitter.NewQueryCursor()
+	qc.Exec(s.RainbowQuery, tree.RootNode())
+
+	scopeIDs := make(map[uintptr]bool)
+	for {
+		m, ok := qc.NextMatch()
+		if !ok {
+			break
+		}
+		for _, c := range m.Captures {
+			if s.RainbowQuery.CaptureNameForId(c.Index) == "rainbow.scope" {
+				scopeIDs[c.Node.ID()] = true
+			}
+		}
+	}
+	return scopeIDs
+}
+
+// mergeHighlights overwrites base's entries with overlay's, so overlay (e.g.
+// rainbowHighlights' brackets) takes precedence over base's capture-based
+// coloring (e.g. @punctuation.bracket) without discarding anything else base
+// computed.
+func mergeHighlights(base, overlay map[int]map[int]termbox.Attribute) map[int]map[int]termbox.Attribute {
+	if overlay == nil {
+		return base
+	}
+	if base == nil {
+		base = make(map[int]map[int]termbox.Attribute)
+	}
+	for row, cols := range overlay {
+		if base[row] == nil {
+			base[row] = make(map[int]termbox.Attribute)
+		}
+		for col, attr := range cols {
+			base[row][col] = attr
+		}
+	}
+	return base
+}
+
+// localScope is one @local.scope capture from LocalsQuery, identified by the
+// node's ID (stable for the lifetime of tree) so definitions can be grouped
+// by their owning scope without holding onto *sitter.Node as a map key.
+type localScope struct {
+	id                 uintptr
+	startByte, endByte uint32
+}
+
+// localDef is one @local.definition.<kind> capture, already resolved to the
+// ID of the tightest @local.scope that encloses it (see resolveLocalScope).
+type localDef struct {
+	name      string
+	kind      string // text after "local.definition.", e.g. "parameter", "var", "function".
+	scopeID   uintptr
+	startByte uint32
+}
+
+// contains reports whether byte range [startByte, endByte) fully encloses node.
+func (sc localScope) contains(startByte, endByte uint32) bool {
+	return sc.startByte <= startByte && sc.endByte >= endByte
+}
+
+// resolveLocalScope returns the tightest (smallest-span) scope in scopes that
+// encloses [startByte, endByte), or nil if none does (e.g. a top-level
+// definition/reference outside any @local.scope capture). skipSelf, when
+// true, ignores a scope whose range is exactly [startByte, endByte) — needed
+// for a named function/method's own definition, whose identifier sits inside
+// the function_declaration node that is itself the scope it introduces:
+// without skipping it, the function's name would resolve into its own scope
+// instead of the scope it's actually visible from, and no caller elsewhere
+// in the file could ever resolve a call to it.
+func resolveLocalScope(scopes []localScope, startByte, endByte uint32, skipSelf bool) *localScope {
+	var best *localScope
+	for i := range scopes {
+		sc := &scopes[i]
+		if skipSelf && sc.startByte == startByte && sc.endByte == endByte {
+			continue
+		}
+		if !sc.contains(startByte, endByte) {
+			continue
+		}
+		if best == nil || (sc.endByte-sc.startByte) < (best.endByte-best.startByte) {
+			best = sc
+		}
+	}
+	return best
+}
+
+// enclosingScopeIDs returns the IDs of every scope in scopes that encloses
+// [startByte, endByte), ordered innermost (smallest span) to outermost, with
+// a trailing 0 for the file-level bucket (definitions with no enclosing
+// @local.scope at all), which is always the last place to look.
+func enclosingScopeIDs(scopes []localScope, startByte, endByte uint32) []uintptr {
+	var enclosing []localScope
+	for _, sc := range scopes {
+		if sc.contains(startByte, endByte) {
+			enclosing = append(enclosing, sc)
+		}
+	}
+	sort.Slice(enclosing, func(i, j int) bool {
+		return (enclosing[i].endByte - enclosing[i].startByte) < (enclosing[j].endByte - enclosing[j].startByte)
+	})
+
+	ids := make([]uintptr, 0, len(enclosing)+1)
+	for _, sc := range enclosing {
+		ids = append(ids, sc.id)
+	}
+	return append(ids, 0)
+}
+
+// localsHighlights runs LocalsQuery over the whole tree to resolve
+// definitions and references, then returns a Line -> Col -> Attribute map for
+// the definition and (resolved) reference occurrences that fall within
+// [startRow, endRow].
+//
+// Scopes and definitions need the whole tree regardless of which rows the
+// caller actually wants painted: a reference on an onscreen line can be
+// defined by a parameter several scopes up that's scrolled out of view, the
+// same viewport-blind-spot problem rainbowHighlights works around (see
+// collectRainbowScopeIDs). References are cheaper to bound, though — one is
+// only worth resolving if it'll be painted, so references outside
+// [startRow, endRow] are dropped before the (more expensive) scope-chain
+// search runs, not just after.
+//
+// Known limitation: editing a definition only invalidates the edited rows
+// (see Edit/updateHighlightsRange), so a reference elsewhere in an unchanged,
+// already-onscreen viewport keeps its old color until something else (a
+// scroll, another edit touching its row) recomputes it. Worth fixing if it
+// turns out to matter in practice; not worth a full-viewport recompute on
+// every keystroke to close in the meantime.
+func (s *SyntaxHighlighter) localsHighlights(tree *sitter.Tree, source []byte, startRow, endRow int) map[int]map[int]termbox.Attribute {
+	if s.LocalsQuery == nil || tree == nil || source == nil {
+		return nil
+	}
+
+	var scopes []localScope
+	var defNodes []struct {
+		node *sitter.Node
+		kind string
+	}
+	var refNodes []*sitter.Node
+
+	qc := sitter.NewQueryCursor()
+	qc.Exec(s.LocalsQuery, tree.RootNode())
+	for {
+		m, ok := qc.NextMatch()
+		if !ok {
+			break
+		}
+		for _, c := range m.Captures {
+			name := s.LocalsQuery.CaptureNameForId(c.Index)
+			switch {
+			case name == "local.scope":
+				n := c.Node
+				scopes = append(scopes, localScope{id: n.ID(), startByte: n.StartByte(), endByte: n.EndByte()})
+			case name == "local.reference":
+				n := c.Node
+				if isCompositeLiteralKey(n) {
+					continue
+				}
+				// Resolving a reference only matters if it'll actually get
+				// painted below, so skip the (expensive) scope-chain search
+				// for anything outside the requested rows. Scopes and
+				// definitions still need the full unrestricted pass above —
+				// an onscreen reference can resolve to a definition that's
+				// scrolled out of view.
+				row := int(n.StartPoint().Row)
+				if row < startRow || row > endRow {
+					continue
+				}
+				refNodes = append(refNodes, n)
+			case strings.HasPrefix(name, "local.definition."):
+				n := c.Node
+				defNodes = append(defNodes, struct {
+					node *sitter.Node
+					kind string
+				}{n, strings.TrimPrefix(name, "local.definition.")})
+			}
+		}
+	}
+
+	// A definition's own identifier also satisfies the blanket
+	// (identifier) @local.reference pattern, so it shows up in refNodes too
+	// (tree-sitter reports one match per pattern, not per node). Drop those:
+	// resolving a definition against itself is redundant work, and if another
+	// same-named definition exists in the same scope it can even steal the
+	// node's own color.
+	isDef := make(map[uintptr]bool, len(defNodes))
+	for _, d := range defNodes {
+		isDef[d.node.ID()] = true
+	}
+	filteredRefs := refNodes[:0]
+	for _, ref := range refNodes {
+		if !isDef[ref.ID()] {
+			filteredRefs = append(filteredRefs, ref)
+		}
+	}
+	refNodes = filteredRefs
+
+	// Group definitions by owning scope so each reference only has to search
+	// the scopes in its own chain, not every definition in the file.
+	defsByScope := make(map[uintptr][]localDef)
+	for _, d := range defNodes {
+		var scope *localScope
+		if d.kind == "function" {
+			// The name sits inside the function_declaration/method_declaration
+			// node, which is itself @local.scope — resolve against the scope
+			// that encloses the declaration, not the one it introduces for its
+			// own body, so other functions can find it.
+			target := d.node
+			if decl := d.node.Parent(); decl != nil {
+				target = decl
+			}
+			scope = resolveLocalScope(scopes, target.StartByte(), target.EndByte(), true)
+		} else {
+			scope = resolveLocalScope(scopes, d.node.StartByte(), d.node.EndByte(), false)
+		}
+		var scopeID uintptr
+		if scope != nil {
+			scopeID = scope.id
+		}
+		defsByScope[scopeID] = append(defsByScope[scopeID], localDef{
+			name:      d.node.Content(source),
+			kind:      d.kind,
+			scopeID:   scopeID,
+			startByte: d.node.StartByte(),
+		})
+	}
+
+	result := make(map[int]map[int]termbox.Attribute)
+	paint := func(node *sitter.Node, attr termbox.Attribute) {
+		row := int(node.StartPoint().Row)
+		if row < startRow || row > endRow {
+			return
+		}
+		if _, ok := result[row]; !ok {
+			result[row] = make(map[int]termbox.Attribute)
+		}
+		for col := int(node.StartPoint().Column); col < int(node.EndPoint().Column); col++ {
+			result[row][col] = attr
+		}
+	}
+
+	for _, d := range defNodes {
+		paint(d.node, localDefinitionAttr(d.kind))
+	}
+
+	for _, ref := range refNodes {
+		name := ref.Content(source)
 
+		// Search the reference's enclosing scopes innermost-first (shadowing
+		// order), then the file-level bucket (scopeID 0, definitions with no
+		// enclosing @local.scope at all) as the last, outermost fallback.
+		//
+		// Within a non-file scope, Go requires declare-before-use, so a
+		// same-named local declared after this reference doesn't shadow it —
+		// keep looking at outer scopes instead. The file-level bucket skips
+		// this check: package-level functions/vars are visible throughout the
+		// file regardless of declaration order.
+		var resolved *localDef
+		for _, scopeID := range enclosingScopeIDs(scopes, ref.StartByte(), ref.EndByte()) {
+			defs := defsByScope[scopeID]
+			for i := len(defs) - 1; i >= 0; i-- {
+				if defs[i].name != name {
+					continue
+				}
+				if scopeID != 0 && defs[i].startByte > ref.StartByte() {
+					continue
+				}
+				resolved = &defs[i]
+				break
+			}
+			if resolved != nil {
+				break
+			}
+		}
+
+		if resolved != nil {
+			paint(ref, localDefinitionAttr(resolved.kind))
+		} else {
+			fg, _ := GetThemeColor(ColorTSLocalUnresolved)
+			paint(ref, fg)
+		}
+	}
+
+	return result
+}
+
+// isCompositeLiteralKey reports whether node is the key half of a keyed
+// composite literal element (the Name in Point{Name: "x"}), which is a
+// struct field label, not a variable reference. This grammar wraps a
+// keyed_element's key and value in an unlabeled (no field name) pair of
+// literal_element children, so the key is identified positionally: it's
+// whichever literal_element is keyed_element's first named child. (Struct
+// field access (p.Name) and field_declaration names (the Name in a
+// `Name int` member) use a distinct field_identifier node, so they never
+// match LocalsQuery's (identifier) @local.reference pattern in the first
+// place and need no equivalent check here.)
+func isCompositeLiteralKey(node *sitter.Node) bool {
+	literalElement := node.Parent()
+	if literalElement == nil || literalElement.Type() != "literal_element" {
+		return false
+	}
+	keyedElement := literalElement.Parent()
+	if keyedElement == nil || keyedElement.Type() != "keyed_element" {
+		return false
+	}
+	key := keyedElement.NamedChild(0)
+	return key != nil && key.StartByte() == literalElement.StartByte() && key.EndByte() == literalElement.EndByte()
+}
+
+// localDefinitionAttr maps a @local.definition.<kind> kind to its theme
+// color: parameters get their own color, everything else (var, function,
+// type, ...) shares ColorTSLocalDefinition.
+func localDefinitionAttr(kind string) termbox.Attribute {
+	cn := ColorTSLocalDefinition
+	if kind == "parameter" {
+		cn = ColorTSLocalParameter
+	}
 	fg, _ := GetThemeColor(cn)
 	return fg
 }
 
+// injectionLanguageFileType maps an injection.language name (either the text
+// of an @injection.language capture, e.g. a fenced code block's info string,
+// or the string given to a `#set! injection.language "..."` predicate) to
+// the FileType name newSyntaxHighlighterCore expects. Matched case-insensitively.
+var injectionLanguageFileType = map[string]string{
+	"c":          "C",
+	"cpp":        "C++",
+	"c++":        "C++",
+	"go":         "Go",
+	"golang":     "Go",
+	"javascript": "JavaScript",
+	"js":         "JavaScript",
+	"typescript": "TypeScript",
+	"ts":         "TypeScript",
+	"tsx":        "TSX",
+	"python":     "Python",
+	"py":         "Python",
+	"bash":       "Bash",
+	"sh":         "Bash",
+	"shell":      "Bash",
+	"css":        "CSS",
+	"dockerfile": "Dockerfile",
+	"html":       "HTML",
+	"lua":        "Lua",
+	"markdown":   "Markdown",
+	"md":         "Markdown",
+	"php":        "PHP",
+	"sql":        "SQL",
+}
+
+// injectionHighlights runs InjectionQuery restricted to [startRow, endRow]
+// against tree and returns a Line -> Col -> Attribute map covering every
+// @injection.content span found: each is parsed by a cached child
+// SyntaxHighlighter for its language (see getInjectionHighlighter) and the
+// child's highlights are remapped from its own local (0,0)-based coordinates
+// into tree's. Returns nil if this language has no injections.scm.
+func (s *SyntaxHighlighter) injectionHighlights(tree *sitter.Tree, source []byte, startRow, endRow int) map[int]map[int]termbox.Attribute {
+	if s.InjectionQuery == nil || tree == nil || source == nil {
+		return nil
+	}
+
+	qc := sitter.NewQueryCursor()
+	qc.SetPointRange(sitter.Point{Row: uint32(startRow)}, sitter.Point{Row: uint32(endRow + 1)})
+	qc.Exec(s.InjectionQuery, tree.RootNode())
+
+	result := make(map[int]map[int]termbox.Attribute)
+	for {
+		m, ok := qc.NextMatch()
+		if !ok {
+			break
+		}
+
+		// FilterPredicates resolves any #eq?/#match? predicate on the
+		// pattern (e.g. the Go query's guard on the called method's name);
+		// a match that fails comes back with no captures and is skipped.
+		filtered := qc.FilterPredicates(&m, source)
+		if filtered == nil || len(filtered.Captures) == 0 {
+			continue
+		}
+
+		var contentNode *sitter.Node
+		language := ""
+		for _, c := range filtered.Captures {
+			switch s.InjectionQuery.CaptureNameForId(c.Index) {
+			case "injection.content":
+				contentNode = c.Node
+			case "injection.language":
+				language = c.Node.Content(source)
+			}
+		}
+		if language == "" {
+			language = s.injectionLanguageFromSetPredicate(filtered.PatternIndex)
+		}
+		if contentNode == nil || language == "" {
+			continue
+		}
+
+		fileType, ok := injectionLanguageFileType[strings.ToLower(language)]
+		if !ok {
+			continue
+		}
+
+		startByte, endByte := contentNode.StartByte(), contentNode.EndByte()
+		if endByte < startByte || int(endByte) > len(source) {
+			continue
+		}
+		content := source[startByte:endByte]
+
+		child := s.getInjectionHighlighter(fileType, content)
+		if child == nil {
+			continue
+		}
+
+		baseRow := int(contentNode.StartPoint().Row)
+		baseCol := int(contentNode.StartPoint().Column)
+		child.highlightsMu.RLock()
+		for row, cols := range child.Highlights {
+			destRow := baseRow + row
+			if _, ok := result[destRow]; !ok {
+				result[destRow] = make(map[int]termbox.Attribute)
+			}
+			for col, attr := range cols {
+				destCol := col
+				if row == 0 {
+					destCol += baseCol
+				}
+				result[destRow][destCol] = attr
+			}
+		}
+		child.highlightsMu.RUnlock()
+	}
+	return result
+}
+
+// injectionLanguageFromSetPredicate scans InjectionQuery's predicates for
+// pattern patternIndex for a `#set! injection.language "<value>"` and
+// returns value, or "" if the pattern has none. Used when a pattern names
+// its language statically (e.g. the Go raw-string-SQL query) instead of
+// capturing it from source text via @injection.language.
+func (s *SyntaxHighlighter) injectionLanguageFromSetPredicate(patternIndex uint32) string {
+	for _, steps := range s.InjectionQuery.PredicatesForPattern(patternIndex) {
+		if len(steps) < 3 {
+			continue
+		}
+		if s.InjectionQuery.StringValueForId(steps[0].ValueId) != "set!" {
+			continue
+		}
+		if s.InjectionQuery.StringValueForId(steps[1].ValueId) != "injection.language" {
+			continue
+		}
+		return s.InjectionQuery.StringValueForId(steps[2].ValueId)
+	}
+	return ""
+}
+
+// getInjectionHighlighter returns the cached child SyntaxHighlighter for
+// (fileType, content), building and parsing one with ParseFull if this exact
+// content hasn't been seen before. The cache key includes a hash of content,
+// so editing inside one fenced block/script tag only reparses that one; an
+// untouched sibling keeps reusing its entry. Returns nil for an unsupported
+// or unrecognized fileType.
+func (s *SyntaxHighlighter) getInjectionHighlighter(fileType string, content []byte) *SyntaxHighlighter {
+	h := fnv.New64a()
+	h.Write(content)
+	key := fmt.Sprintf("%s:%x", fileType, h.Sum64())
+
+	s.injectionCacheMu.Lock()
+	defer s.injectionCacheMu.Unlock()
+
+	if child, ok := s.injectionCache[key]; ok {
+		return child
+	}
+
+	// Typing inside an injected block changes its hash on every keystroke, so
+	// the cache would otherwise grow by one entry per keystroke for the
+	// lifetime of the buffer (it's only ever cleared wholesale on a full
+	// Parse). Past maxInjectionCacheEntries, drop everything and start over
+	// rather than keep every stale revision around — the same trade-off Parse
+	// already makes for Highlights, favoring a bounded cache over an LRU this
+	// codebase has no other use for.
+	if len(s.injectionCache) >= maxInjectionCacheEntries {
+		s.injectionCache = make(map[string]*SyntaxHighlighter)
+	}
+
+	child := newSyntaxHighlighterCore(fileType, s.Log)
+	if child == nil {
+		return nil
+	}
+	child.ParseFull(content)
+	s.injectionCache[key] = child
+	return child
+}
+
+// getTermboxAttr maps a tree-sitter capture name to a theme color. Capture
+// names are dotted Scopes (e.g. "keyword.control.conditional"); ResolveScope
+// (see scopes.go) walks up to a coarser ancestor ("keyword.control", then
+// "keyword") for any scope the active theme or builtinScopes doesn't define
+// directly, so a plain single-word capture like "function" still resolves
+// exactly as it did under the old flat ColorTS* enum.
+func getTermboxAttr(captureName string) termbox.Attribute {
+	fg, _ := ResolveScope(captureName)
+	return fg
+}
+
 // Highlight returns a slice of attributes for each character in a line.
 func (s *SyntaxHighlighter) Highlight(lineIdx int, lineContent []rune) []termbox.Attribute {
 	attrs := make([]termbox.Attribute, len(lineContent))
@@ -256,8 +1146,14 @@ func (s *SyntaxHighlighter) Highlight(lineIdx int, lineContent []rune) []termbox
 		attrs[i] = defaultFg
 	}
 
-	// Apply cached highlights if they exist for this line.
-	if lineHighlights, ok := s.Highlights[lineIdx]; ok {
+	// Apply cached highlights if they exist for this line. A miss (line not
+	// computed yet, e.g. the worker hasn't caught up with a scroll) just
+	// leaves the default foreground filled in above, so rendering never
+	// blocks waiting on the background worker.
+	s.highlightsMu.RLock()
+	lineHighlights, ok := s.Highlights[lineIdx]
+	s.highlightsMu.RUnlock()
+	if ok {
 		for col, color := range lineHighlights {
 			if col < len(attrs) {
 				attrs[col] = color
@@ -267,3 +1163,45 @@ func (s *SyntaxHighlighter) Highlight(lineIdx int, lineContent []rune) []termbox
 
 	return attrs
 }
+
+// ScopesAt reports tree-sitter info for the node under (row, col), where col
+// is a byte offset (see Buffer.getLineByteOffset, not a rune index): the
+// chain of node types from that node up to the root (leaf first), followed
+// by every query capture name whose node equals or contains it. Meant for
+// theme/query authoring (see command.go's ":ts-scopes"), not for rendering.
+func (s *SyntaxHighlighter) ScopesAt(row, col int) []string {
+	if s.Tree == nil {
+		return nil
+	}
+
+	point := sitter.Point{Row: uint32(row), Column: uint32(col)}
+	node := s.Tree.RootNode().NamedDescendantForPointRange(point, point)
+	if node == nil {
+		return nil
+	}
+
+	var scopes []string
+	for n := node; n != nil; n = n.Parent() {
+		scopes = append(scopes, n.Type())
+	}
+
+	if s.Query != nil {
+		qc := sitter.NewQueryCursor()
+		qc.SetPointRange(node.StartPoint(), node.EndPoint())
+		qc.Exec(s.Query, s.Tree.RootNode())
+
+		for {
+			m, ok := qc.NextMatch()
+			if !ok {
+				break
+			}
+			for _, c := range m.Captures {
+				if c.Node.StartByte() <= node.StartByte() && c.Node.EndByte() >= node.EndByte() {
+					scopes = append(scopes, s.Query.CaptureNameForId(c.Index))
+				}
+			}
+		}
+	}
+
+	return scopes
+}
@@ -0,0 +1,354 @@
+package main
+
+// Structural text objects shared by the normal-mode iw/i(/i"/it motions (see
+// kevent.go) and their operator entry points deleteInside/deleteAround/
+// deleteInsideWord/deleteInsideTag (see editor.go). Everything here works in
+// "flat offset" space: the whole buffer viewed as one []rune with lines
+// joined by '\n', which lets delimiter pairs (and the edits that act on them)
+// span line boundaries instead of being stuck on a single line.
+
+import "strings"
+
+// textObjectFlat joins b's lines into one []rune with '\n' separators and
+// returns the flat offset corresponding to (y, x).
+func textObjectFlat(b *Buffer, y, x int) (flat []rune, cursorOffset int) {
+	offset := 0
+	for i, line := range b.buffer {
+		if i == y {
+			cursorOffset = offset + x
+		}
+		flat = append(flat, line...)
+		if i < len(b.buffer)-1 {
+			flat = append(flat, '\n')
+		}
+		offset += len(line) + 1
+	}
+	return flat, cursorOffset
+}
+
+// offsetToPos converts a flat offset (see textObjectFlat) back to (y, x).
+func offsetToPos(b *Buffer, offset int) (y, x int) {
+	for i, line := range b.buffer {
+		if offset <= len(line) {
+			return i, offset
+		}
+		offset -= len(line) + 1
+	}
+	last := len(b.buffer) - 1
+	return last, len(b.buffer[last])
+}
+
+// lineFlatBounds returns the [start, end) flat-offset range of line y.
+func lineFlatBounds(b *Buffer, y int) (start, end int) {
+	offset := 0
+	for i, line := range b.buffer {
+		if i == y {
+			return offset, offset + len(line)
+		}
+		offset += len(line) + 1
+	}
+	return offset, offset
+}
+
+// bestPair picks the innermost pair enclosing pos, or else the nearest pair
+// starting at or after pos. Shared by findDelimiterPair's two search modes.
+func bestPair(pairs [][2]int, pos int) (start, end int, ok bool) {
+	var best *[2]int
+	for i := range pairs {
+		p := &pairs[i]
+		if pos >= p[0] && pos <= p[1] {
+			if best == nil || p[0] > best[0] {
+				best = p
+			}
+		}
+	}
+	if best == nil {
+		for i := range pairs {
+			p := &pairs[i]
+			if p[0] >= pos && (best == nil || p[0] < best[0]) {
+				best = p
+			}
+		}
+	}
+	if best == nil {
+		return 0, 0, false
+	}
+	return best[0], best[1], true
+}
+
+// findDelimiterPair locates the open/close pair enclosing the cursor,
+// returning flat offsets (see textObjectFlat). Quote-like delimiters
+// (open == close) are matched pairwise on the cursor's own line only, since a
+// quote spanning multiple lines is almost never what the user means;
+// bracket-like delimiters are matched with a stack across the whole buffer.
+func findDelimiterPair(b *Buffer, y, x int, open, close rune) (start, end int, ok bool) {
+	if open == close {
+		line := b.buffer[y]
+		var indices []int
+		for i, r := range line {
+			if r == open {
+				indices = append(indices, i)
+			}
+		}
+		var pairs [][2]int
+		for i := 0; i+1 < len(indices); i += 2 {
+			pairs = append(pairs, [2]int{indices[i], indices[i+1]})
+		}
+		s, e, found := bestPair(pairs, x)
+		if !found {
+			return 0, 0, false
+		}
+		lineStart, _ := lineFlatBounds(b, y)
+		return lineStart + s, lineStart + e, true
+	}
+
+	flat, cursorOffset := textObjectFlat(b, y, x)
+	var stack []int
+	var pairs [][2]int
+	for i, r := range flat {
+		switch r {
+		case open:
+			stack = append(stack, i)
+		case close:
+			if len(stack) > 0 {
+				s := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				pairs = append(pairs, [2]int{s, i})
+			}
+		}
+	}
+	return bestPair(pairs, cursorOffset)
+}
+
+// wordObjectRange returns the flat offset range of the word under the
+// cursor. When around is true, it also consumes one run of trailing (or,
+// failing that, leading) whitespace, matching vim's distinction between "iw"
+// and "aw".
+func wordObjectRange(b *Buffer, y, x int, around bool) (start, end int, ok bool) {
+	line := b.buffer[y]
+	if len(line) == 0 {
+		return 0, 0, false
+	}
+	if x >= len(line) {
+		x = len(line) - 1
+	}
+
+	isWord := func(r rune) bool {
+		return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_'
+	}
+	isSpace := func(r rune) bool { return r == ' ' || r == '\t' }
+
+	class := func(r rune) int {
+		switch {
+		case isWord(r):
+			return 1
+		case isSpace(r):
+			return 2
+		default:
+			return 3
+		}
+	}
+	cls := class(line[x])
+
+	s, e := x, x
+	for s > 0 && class(line[s-1]) == cls {
+		s--
+	}
+	for e+1 < len(line) && class(line[e+1]) == cls {
+		e++
+	}
+
+	if around {
+		if e+1 < len(line) && isSpace(line[e+1]) {
+			for e+1 < len(line) && isSpace(line[e+1]) {
+				e++
+			}
+		} else {
+			for s > 0 && isSpace(line[s-1]) {
+				s--
+			}
+		}
+	}
+
+	lineStart, _ := lineFlatBounds(b, y)
+	return lineStart + s, lineStart + e, true
+}
+
+// tagObjectRange finds the HTML/XML tag enclosing the cursor and returns the
+// flat offset range of its content ("it"), or of the whole element including
+// its open/close tags when around is true ("at"). Matching tracks nesting
+// depth for same-named tags but otherwise assumes well-formed markup.
+func tagObjectRange(b *Buffer, y, x int, around bool) (start, end int, ok bool) {
+	flat, cursorOffset := textObjectFlat(b, y, x)
+	text := string(flat)
+
+	type tag struct {
+		name          string
+		start, end    int // flat offsets of '<' and the char after '>'
+		closing       bool
+		selfClosing   bool
+	}
+	var tags []tag
+
+	for i := 0; i < len(flat); i++ {
+		if flat[i] != '<' {
+			continue
+		}
+		close := strings.IndexRune(string(flat[i:]), '>')
+		if close < 0 {
+			break
+		}
+		raw := text[i : i+close+1]
+		closing := strings.HasPrefix(raw, "</")
+		selfClosing := strings.HasSuffix(strings.TrimSpace(raw), "/>")
+		name := strings.TrimPrefix(raw, "<")
+		name = strings.TrimPrefix(name, "/")
+		name = strings.TrimSuffix(name, ">")
+		name = strings.TrimSuffix(strings.TrimSpace(name), "/")
+		if sp := strings.IndexAny(name, " \t\n"); sp >= 0 {
+			name = name[:sp]
+		}
+		tags = append(tags, tag{name: name, start: i, end: i + close + 1, closing: closing, selfClosing: selfClosing})
+	}
+
+	// Find the innermost unmatched open tag before the cursor whose matching
+	// close tag appears after the cursor, tracking nesting depth per name.
+	depth := map[string]int{}
+	var openStack []tag
+	for _, t := range tags {
+		if t.selfClosing {
+			continue
+		}
+		if t.closing {
+			for i := len(openStack) - 1; i >= 0; i-- {
+				if openStack[i].name == t.name {
+					if depth[t.name] > 0 {
+						depth[t.name]--
+					} else if openStack[i].end <= cursorOffset && t.start >= cursorOffset {
+						if around {
+							return openStack[i].start, t.end - 1, true
+						}
+						return openStack[i].end, t.start - 1, true
+					}
+					openStack = append(openStack[:i], openStack[i+1:]...)
+					break
+				}
+			}
+			continue
+		}
+		if t.start <= cursorOffset {
+			depth[t.name]++
+		}
+		openStack = append(openStack, t)
+	}
+
+	return 0, 0, false
+}
+
+// paragraphObjectRange returns the flat offset range of the paragraph
+// containing line y (vim's "ip"): the maximal run of lines of the same
+// blank/non-blank kind as y, bounded by the buffer's edges or a change in
+// kind, the same boundary jumpToPrevEmptyLine/jumpToNextEmptyLine (see
+// editor.go) jump between. Paragraph objects are linewise, so the range
+// also consumes the newline that follows the last line (or, if there is
+// none, the one that precedes the first) rather than leaving an empty line
+// behind. When around is true, "ap" additionally consumes one adjoining
+// run of blank lines, preferring a trailing run over a leading one.
+func paragraphObjectRange(b *Buffer, y, x int, around bool) (start, end int, ok bool) {
+	if y < 0 || y >= len(b.buffer) {
+		return 0, 0, false
+	}
+	blank := len(b.buffer[y]) == 0
+	top, bottom := y, y
+	for top > 0 && (len(b.buffer[top-1]) == 0) == blank {
+		top--
+	}
+	for bottom+1 < len(b.buffer) && (len(b.buffer[bottom+1]) == 0) == blank {
+		bottom++
+	}
+
+	if around && !blank {
+		if bottom+1 < len(b.buffer) && len(b.buffer[bottom+1]) == 0 {
+			for bottom+1 < len(b.buffer) && len(b.buffer[bottom+1]) == 0 {
+				bottom++
+			}
+		} else {
+			for top > 0 && len(b.buffer[top-1]) == 0 {
+				top--
+			}
+		}
+	}
+
+	flat, _ := textObjectFlat(b, 0, 0)
+	from, _ := lineFlatBounds(b, top)
+	_, bottomEnd := lineFlatBounds(b, bottom)
+
+	to := bottomEnd
+	if to >= len(flat) {
+		if from > 0 {
+			from--
+		}
+		to = len(flat) - 1
+	}
+	if to < from {
+		to = from
+	}
+	return from, to, true
+}
+
+// applyTextObjectDelete removes flat[from:to] (inclusive) from b, rewraps the
+// result into lines, and re-syncs syntax highlighting and any attached LSP
+// client against the full buffer content (cheaper than computing precise
+// handleEdit deltas for an edit that may span many lines, and these call
+// sites are not hot paths). Every cursor, not just the primary one, is
+// carried through the rewrap by flat offset (see textObjectFlat), clamping
+// any cursor inside the deleted range to `from`, so deleteInside and
+// friends stay well-behaved under multiple cursors instead of discarding
+// the secondary ones.
+func (e *Editor) applyTextObjectDelete(b *Buffer, from, to int) {
+	flat, _ := textObjectFlat(b, 0, 0)
+	if to >= len(flat) {
+		to = len(flat) - 1
+	}
+	if from > to {
+		return
+	}
+	removed := to - from + 1
+
+	offsets := make([]int, len(b.cursors))
+	for i, c := range b.cursors {
+		_, offsets[i] = textObjectFlat(b, c.Y, c.X)
+	}
+
+	newFlat := append(append([]rune{}, flat[:from]...), flat[to+1:]...)
+	lines := strings.Split(string(newFlat), "\n")
+	b.buffer = make([][]rune, len(lines))
+	for i, l := range lines {
+		b.buffer[i] = []rune(l)
+	}
+	if len(b.buffer) == 0 {
+		b.buffer = [][]rune{{}}
+	}
+
+	for i, offset := range offsets {
+		switch {
+		case offset > to:
+			offset -= removed
+		case offset >= from:
+			offset = from
+		}
+		y, x := offsetToPos(b, offset)
+		b.cursors[i].Y = y
+		b.cursors[i].X = x
+		b.cursors[i].PreferredCol = x
+	}
+
+	if b.syntax != nil {
+		b.syntaxReparse()
+	}
+	if b.lspClient != nil {
+		b.lspClient.SendDidChange(b.toString())
+	}
+	e.markModified()
+	e.mergeCursors()
+}
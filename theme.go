@@ -1,18 +1,56 @@
 package main
 
 // Color palette and theme used by the editor. Maps semantic color names (like
-// ColorNormalMode) to specific terminal attributes (foreground and background).
+// ColorNormalMode) to specific terminal attributes (foreground and
+// background). builtinTheme below is the fallback theme compiled into the
+// binary; :theme <name> (see themes.go) swaps activeTheme to a theme loaded
+// from a TOML file at runtime, falling back to builtinTheme for any
+// ColorName the loaded file doesn't mention.
 
-import "github.com/nsf/termbox-go"
+import (
+	"sync/atomic"
+
+	"github.com/nsf/termbox-go"
+)
 
 // To see available colors execute `qwe -colors`.
 
-// Color represents a pair of foreground and background terminal attributes.
+// RGB is a 24-bit true color, as parsed from a theme file's "#rrggbb"
+// literals (see themes.go). It only carries meaning where the corresponding
+// HasRGB flag on Color is set; builtinTheme's plain 256-color indices leave
+// it zero-valued and unused.
+type RGB struct {
+	R, G, B uint8
+}
+
+// Color represents a pair of foreground and background terminal attributes,
+// each with an optional true-color override. Attribute is what every
+// draw call actually renders with and what GetThemeColor returns; the RGB
+// fields exist purely for setCell's true-color overlay (see truecolor.go),
+// which bypasses termbox's palette mapping on COLORTERM=truecolor terminals.
 type Color struct {
 	Background termbox.Attribute
 	Foreground termbox.Attribute
+
+	BackgroundRGB    RGB
+	ForegroundRGB    RGB
+	BackgroundHasRGB bool
+	ForegroundHasRGB bool
 }
 
+// Variant distinguishes a light-background palette from a dark one. A theme
+// file can ship both under [light]/[dark] sections (see themes.go's
+// parseTheme); background.go's DetectBackgroundVariant picks the starting
+// one from the terminal's actual background color, and `:set
+// background=light|dark` (see themes.go's setBackgroundCommand) overrides
+// it at runtime.
+type Variant string
+
+const (
+	VariantDark  Variant = "dark"
+	VariantLight Variant = "light"
+)
+
 // ColorName is an enum-like type for semantic color identifiers.
 type ColorName int
 
@@ -38,21 +76,29 @@ const (
 	ColorSearchMatch         // Highlighting for found search terms.
 	ColorReplaceMatch        // Highlighting for replacement targets.
 	ColorCursor              // The color of the cursor itself.
+	ColorMatchingBrace       // Highlighting for the brace pair under the cursor (see matchbrace.go).
+	ColorTrailingWhitespace  // Highlighting for trailing spaces/tabs at the end of a line.
+	ColorMixedIndent         // Highlighting for leading indent that mixes tabs and spaces.
 
-	ColorGutterLineNumber   // Line numbers in the left gutter.
-	ColorGutterSignError    // LSP error icons in the gutter.
-	ColorGutterSignWarning  // LSP warning icons in the gutter.
-	ColorGutterSignInfo     // LSP info icons in the gutter.
-	ColorGutterSignHint     // LSP hint icons in the gutter.
-	ColorFuzzyResult        // Plain text in fuzzy finder results.
-	ColorFuzzySelected      // Highlighted item in fuzzy finder.
-	ColorEmptyLineMarker    // The '~' marker for lines beyond EOF.
-	ColorDebugTitle         // Header for the debug window.
-	ColorDiagSummaryError   // Error count in the status bar.
-	ColorDiagSummaryWarning // Warning count in the status bar.
-	ColorFuzzyModeBuffers   // Indicator that fuzzy finder is searching buffers.
-	ColorFuzzyModeFiles     // Indicator that fuzzy finder is searching files.
-	ColorFuzzyModeWarnings  // Indicator that fuzzy finder is searching diagnostics.
+	ColorGutterLineNumber     // Line numbers in the left gutter.
+	ColorGutterSignError      // LSP error icons in the gutter.
+	ColorGutterSignWarning    // LSP warning icons in the gutter.
+	ColorGutterSignInfo       // LSP info icons in the gutter.
+	ColorGutterSignHint       // LSP hint icons in the gutter.
+	ColorFuzzyResult          // Plain text in fuzzy finder results.
+	ColorFuzzySelected        // Highlighted item in fuzzy finder.
+	ColorFuzzyMatch           // Matched-term spans within a fuzzy finder result (see fuzzyquery.go).
+	ColorEmptyLineMarker      // The '~' marker for lines beyond EOF.
+	ColorDebugTitle           // Header for the debug window.
+	ColorDiagSummaryError     // Error count in the status bar.
+	ColorDiagSummaryWarning   // Warning count in the status bar.
+	ColorFuzzyModeBuffers     // Indicator that fuzzy finder is searching buffers.
+	ColorFuzzyModeFiles       // Indicator that fuzzy finder is searching files.
+	ColorFuzzyModeWarnings    // Indicator that fuzzy finder is searching diagnostics.
+	ColorFuzzyModeDirs        // Indicator that fuzzy finder is searching directories.
+	ColorFuzzyModeTags        // Indicator that fuzzy finder is searching tags.
+	ColorFuzzyModeCodeActions // Indicator that fuzzy finder is picking a code action.
+	ColorFuzzyModeRename      // Indicator that fuzzy finder is previewing a rename.
 
 	// Colors for Tree-sitter syntax highlighting.
 	ColorTSFunction
@@ -69,19 +115,52 @@ const (
 	ColorTSAttribute
 	ColorTSConstant
 
+	// Scope-aware colors for locals.scm definitions/references (see
+	// SyntaxHighlighter.localsHighlights), layered on top of the plain
+	// ColorTS* capture colors above.
+	ColorTSLocalParameter  // @local.definition.parameter and references to it.
+	ColorTSLocalDefinition // Other @local.definition.* kinds (var, function, type, ...) and their references.
+	ColorTSLocalUnresolved // @local.reference with no definition found in its scope chain.
+
 	// External service status indicators.
 	ColorLSPStatusConnected
 	ColorLSPStatusDisconnected
 	ColorOllamaStatusConnected
 	ColorOllamaStatusDisconnected
 
-	ColorHoverWindow // LSP hover information popup.
-	ColorAutocompleteWindow
-	ColorAutocompleteSelected
+	ColorHoverWindow  // LSP hover information popup.
+	ColorHoverCode    // Inline `code` and fenced code blocks inside hover/detail Markdown.
+	ColorHoverHeading // ATX (#/##/###) headings inside hover/detail Markdown.
+	ColorHoverBold    // **bold** spans inside hover/detail Markdown.
+
+	ColorAutocompleteWindow   // Completion list popup.
+	ColorAutocompleteSelected // Highlighted item in the completion list.
+
+	ColorGhostText // Dim, unaccepted inline AI suggestion rendered past the cursor (see ghosttext.go).
+
+	// Rainbow-bracket nesting colors (see Config.RainbowBrackets and
+	// SyntaxHighlighter's rainbows.scm handling in syntax.go). Indexed by
+	// depth % len(ColorRainbowN).
+	ColorRainbow0
+	ColorRainbow1
+	ColorRainbow2
+	ColorRainbow3
+	ColorRainbow4
+	ColorRainbow5
+	ColorRainbow6
 )
 
-// Theme maps each ColorName to its actual visual attributes.
-var Theme = map[ColorName]Color{
+// rainbowColors is ColorRainbow0..ColorRainbow6 in depth order, so
+// getRainbowAttr can index into it with depth % len(rainbowColors).
+var rainbowColors = []ColorName{
+	ColorRainbow0, ColorRainbow1, ColorRainbow2, ColorRainbow3,
+	ColorRainbow4, ColorRainbow5, ColorRainbow6,
+}
+
+// builtinTheme maps each ColorName to its actual visual attributes. It's
+// the theme the editor starts with and the fallback activeTheme is checked
+// against for colors a loaded theme file leaves unspecified.
+var builtinTheme = map[ColorName]Color{
 	ColorDefault: {Background: termbox.ColorDefault, Foreground: termbox.Attribute(254)},
 
 	// Annotations
@@ -103,6 +182,9 @@ var Theme = map[ColorName]Color{
 	ColorSearchMatch:         {Background: termbox.Attribute(166), Foreground: termbox.Attribute(1)},
 	ColorReplaceMatch:        {Background: termbox.Attribute(221), Foreground: termbox.Attribute(1)},
 	ColorCursor:              {Background: termbox.Attribute(252), Foreground: termbox.ColorWhite},
+	ColorMatchingBrace:       {Background: termbox.Attribute(240), Foreground: termbox.Attribute(255)},
+	ColorTrailingWhitespace:  {Background: termbox.Attribute(52), Foreground: termbox.Attribute(255)},
+	ColorMixedIndent:         {Background: termbox.Attribute(94), Foreground: termbox.Attribute(255)},
 
 	ColorGutterLineNumber:  {Background: termbox.ColorDefault, Foreground: termbox.Attribute(244)},
 	ColorGutterSignError:   {Background: termbox.Attribute(125), Foreground: termbox.Attribute(16)},
@@ -110,11 +192,16 @@ var Theme = map[ColorName]Color{
 	ColorGutterSignInfo:    {Background: termbox.Attribute(221), Foreground: termbox.Attribute(1)},
 	ColorGutterSignHint:    {Background: termbox.Attribute(221), Foreground: termbox.Attribute(1)},
 
-	ColorFuzzyResult:       {Background: termbox.ColorDefault, Foreground: termbox.Attribute(254)},
-	ColorFuzzySelected:     {Background: termbox.Attribute(236), Foreground: termbox.Attribute(254)},
-	ColorFuzzyModeBuffers:  {Background: termbox.Attribute(125), Foreground: termbox.Attribute(255)},
-	ColorFuzzyModeFiles:    {Background: termbox.Attribute(125), Foreground: termbox.Attribute(255)},
-	ColorFuzzyModeWarnings: {Background: termbox.Attribute(33), Foreground: termbox.Attribute(255)},
+	ColorFuzzyResult:          {Background: termbox.ColorDefault, Foreground: termbox.Attribute(254)},
+	ColorFuzzySelected:        {Background: termbox.Attribute(236), Foreground: termbox.Attribute(254)},
+	ColorFuzzyMatch:           {Background: termbox.ColorDefault, Foreground: termbox.Attribute(178)},
+	ColorFuzzyModeBuffers:     {Background: termbox.Attribute(125), Foreground: termbox.Attribute(255)},
+	ColorFuzzyModeFiles:       {Background: termbox.Attribute(125), Foreground: termbox.Attribute(255)},
+	ColorFuzzyModeWarnings:    {Background: termbox.Attribute(33), Foreground: termbox.Attribute(255)},
+	ColorFuzzyModeDirs:        {Background: termbox.Attribute(64), Foreground: termbox.Attribute(255)},
+	ColorFuzzyModeTags:        {Background: termbox.Attribute(99), Foreground: termbox.Attribute(255)},
+	ColorFuzzyModeCodeActions: {Background: termbox.Attribute(28), Foreground: termbox.Attribute(255)},
+	ColorFuzzyModeRename:      {Background: termbox.Attribute(94), Foreground: termbox.Attribute(255)},
 
 	ColorEmptyLineMarker: {Background: termbox.ColorDefault, Foreground: termbox.Attribute(244)},
 
@@ -137,23 +224,103 @@ var Theme = map[ColorName]Color{
 	ColorTSAttribute: {Background: termbox.ColorDefault, Foreground: termbox.Attribute(215)},
 	ColorTSConstant:  {Background: termbox.ColorDefault, Foreground: termbox.Attribute(254)},
 
+	// Scope-aware locals (see ColorTSLocalParameter and friends above).
+	// ColorTSLocalUnresolved defaults to the same foreground as
+	// ColorTSVariable, since an unresolved reference is, visually, still
+	// just a variable.
+	ColorTSLocalParameter:  {Background: termbox.ColorDefault, Foreground: termbox.Attribute(215)},
+	ColorTSLocalDefinition: {Background: termbox.ColorDefault, Foreground: termbox.Attribute(75)},
+	ColorTSLocalUnresolved: {Background: termbox.ColorDefault, Foreground: termbox.Attribute(255)},
+
 	// Status bar indicators
 	ColorLSPStatusConnected:       {Background: termbox.Attribute(29), Foreground: termbox.Attribute(255)},
 	ColorLSPStatusDisconnected:    {Background: termbox.Attribute(239), Foreground: termbox.Attribute(255)},
 	ColorOllamaStatusConnected:    {Background: termbox.Attribute(131), Foreground: termbox.Attribute(255)},
 	ColorOllamaStatusDisconnected: {Background: termbox.Attribute(239), Foreground: termbox.Attribute(255)},
 
-	ColorHoverWindow: {Background: termbox.Attribute(253), Foreground: termbox.Attribute(1)},
+	ColorHoverWindow:  {Background: termbox.Attribute(253), Foreground: termbox.Attribute(1)},
+	ColorHoverCode:    {Background: termbox.Attribute(251), Foreground: termbox.Attribute(90)},
+	ColorHoverHeading: {Background: termbox.Attribute(253), Foreground: termbox.Attribute(25)},
+	ColorHoverBold:    {Background: termbox.Attribute(253), Foreground: termbox.Attribute(1)},
 
 	ColorAutocompleteWindow:   {Background: termbox.Attribute(253), Foreground: termbox.Attribute(1)},
 	ColorAutocompleteSelected: {Background: termbox.Attribute(239), Foreground: termbox.Attribute(255)},
+
+	ColorGhostText: {Background: termbox.ColorDefault, Foreground: termbox.Attribute(241)},
+
+	// Rainbow brackets
+	ColorRainbow0: {Background: termbox.ColorDefault, Foreground: termbox.Attribute(179)},
+	ColorRainbow1: {Background: termbox.ColorDefault, Foreground: termbox.Attribute(170)},
+	ColorRainbow2: {Background: termbox.ColorDefault, Foreground: termbox.Attribute(112)},
+	ColorRainbow3: {Background: termbox.ColorDefault, Foreground: termbox.Attribute(37)},
+	ColorRainbow4: {Background: termbox.ColorDefault, Foreground: termbox.Attribute(68)},
+	ColorRainbow5: {Background: termbox.ColorDefault, Foreground: termbox.Attribute(134)},
+	ColorRainbow6: {Background: termbox.ColorDefault, Foreground: termbox.Attribute(203)},
+}
+
+// Theme is a named set of ColorName -> Color mappings, as loaded from a
+// theme TOML file by LoadTheme (see themes.go) or built from builtinTheme.
+type Theme struct {
+	Name    string
+	Colors  map[ColorName]Color
+	Variant Variant // Which palette this is, for themes that ship both (see parseTheme's [light]/[dark] handling).
+
+	// Scopes holds dotted tree-sitter capture overrides (see Scope and
+	// ResolveScope in scopes.go), keyed by the same dotted key a theme file
+	// uses in parseTheme: any top-level key that isn't one of Colors' fixed
+	// colorNameKeys falls in here instead. Left nil for builtinTheme, which
+	// relies on builtinScopes directly.
+	Scopes map[Scope]Color
+
+	// sourceData/sourceName are the raw TOML and theme name LoadTheme parsed
+	// this Theme from, kept around so setBackgroundCommand (see themes.go)
+	// can re-resolve Colors/Scopes against the other Variant section of the
+	// same file without re-reading it from disk. Left nil/empty for
+	// builtinTheme and any theme with no [light]/[dark] sections to switch
+	// between.
+	sourceData []byte
+	sourceName string
+
+	// rgbByAttr indexes Colors' true-color entries by the Attribute they
+	// quantize to, so setCell's true-color overlay (see truecolor.go) can
+	// recover an RGB value from the Attribute a draw call already has in
+	// hand, without changing setCell's signature or any of its call sites.
+	// Left nil for builtinTheme, which has no true-color source to index.
+	// Two ColorNames that happen to quantize to the same Attribute but were
+	// given different hex values will collide here; whichever was inserted
+	// last wins, which only affects the true-color overlay (the 256-color
+	// Attribute each cell renders with either way is unambiguous).
+	rgbByAttr map[termbox.Attribute]RGB
+}
+
+// activeTheme is the theme currently in effect, swapped atomically by
+// :theme (see themes.go's themeCommand) so a live redraw never reads a
+// half-updated map. Starts out wrapping builtinTheme.
+var activeTheme atomic.Pointer[Theme]
+
+func init() {
+	activeTheme.Store(&Theme{Name: "builtin", Colors: builtinTheme, Variant: VariantDark})
 }
 
-// GetThemeColor returns the foreground and background attributes for a given semantic name.
+// getRainbowAttr returns the theme foreground color for a bracket nested
+// depth levels deep, cycling through rainbowColors.
+func getRainbowAttr(depth int) termbox.Attribute {
+	cn := rainbowColors[depth%len(rainbowColors)]
+	fg, _ := GetThemeColor(cn)
+	return fg
+}
+
+// GetThemeColor returns the foreground and background attributes for a
+// given semantic name, preferring the active (possibly user-loaded) theme
+// and falling back to builtinTheme for any name it leaves unspecified.
 func GetThemeColor(name ColorName) (termbox.Attribute, termbox.Attribute) {
-	if c, ok := Theme[name]; ok {
+	if theme := activeTheme.Load(); theme != nil {
+		if c, ok := theme.Colors[name]; ok {
+			return c.Foreground, c.Background
+		}
+	}
+	if c, ok := builtinTheme[name]; ok {
 		return c.Foreground, c.Background
 	}
-	// Fallback to default if name is not found.
 	return termbox.ColorDefault, termbox.ColorDefault
 }
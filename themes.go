@@ -0,0 +1,446 @@
+package main
+
+// Loadable TOML theme files (Helix-style): each ColorName that builtinTheme
+// defines has a corresponding dotted key here (colorNameKeys), so a theme
+// file can override it with a {fg, bg, bold} table, e.g.
+//
+//	"ts.function" = { fg = "#87ff87", bg = "default", bold = true }
+//
+// fg/bg accept "default" (termbox.ColorDefault), a "#rrggbb" hex literal, or
+// the name of an entry in the file's own top-level [palette] table. Keys a
+// theme file doesn't mention simply aren't in its Theme.Colors map, and
+// GetThemeColor falls back to builtinTheme for those.
+//
+// Theme files live in $XDG_CONFIG_HOME/qwe/themes (see themesConfigDir,
+// mirroring plumbConfigDir in plumber.go); :theme <name> (see themeCommand)
+// loads "<name>.toml" from there, falling back to one of the defaults
+// shipped in themes/ (see ThemesFS) if the user hasn't installed their own.
+//
+// A top-level key that isn't in colorNameKeys is treated as a tree-sitter
+// Scope override instead (see scopes.go's ResolveScope), e.g.
+//
+//	"keyword.control.conditional" = { fg = "#d33682" }
+//
+// so a theme can target scopes finer than the fixed ts.* buckets without
+// qwe needing to know about them in advance.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/nsf/termbox-go"
+)
+
+// colorNameKeys maps each themeable ColorName to the dotted key a theme
+// file uses for it. Not every ColorName constant needs an entry here: the
+// ColorSource* group is currently unused dead weight left over from an
+// earlier highlighting scheme, and omitting them just means a theme file
+// can't target something nothing reads anyway.
+var colorNameKeys = map[ColorName]string{
+	ColorDefault: "default",
+
+	ColorAnnotationTodo:  "annotation.todo",
+	ColorAnnotationFixme: "annotation.fixme",
+
+	ColorStatusBar:           "ui.status_bar",
+	ColorDebugWindow:         "ui.debug_window",
+	ColorNormalMode:          "ui.mode.normal",
+	ColorInsertMode:          "ui.mode.insert",
+	ColorHighlightedLine:     "ui.highlighted_line",
+	ColorVisualModeSelection: "ui.selection",
+	ColorVisualMode:          "ui.mode.visual",
+	ColorSearchMatch:         "ui.search_match",
+	ColorReplaceMatch:        "ui.replace_match",
+	ColorCursor:              "ui.cursor",
+	ColorMatchingBrace:       "ui.matching_brace",
+	ColorTrailingWhitespace:  "ui.trailing_whitespace",
+	ColorMixedIndent:         "ui.mixed_indent",
+
+	ColorGutterLineNumber:  "gutter.line_number",
+	ColorGutterSignError:   "gutter.sign.error",
+	ColorGutterSignWarning: "gutter.sign.warning",
+	ColorGutterSignInfo:    "gutter.sign.info",
+	ColorGutterSignHint:    "gutter.sign.hint",
+
+	ColorFuzzyResult:          "fuzzy.result",
+	ColorFuzzySelected:        "fuzzy.selected",
+	ColorFuzzyMatch:           "fuzzy.match",
+	ColorFuzzyModeBuffers:     "fuzzy.mode.buffers",
+	ColorFuzzyModeFiles:       "fuzzy.mode.files",
+	ColorFuzzyModeWarnings:    "fuzzy.mode.warnings",
+	ColorFuzzyModeDirs:        "fuzzy.mode.dirs",
+	ColorFuzzyModeTags:        "fuzzy.mode.tags",
+	ColorFuzzyModeCodeActions: "fuzzy.mode.code_actions",
+	ColorFuzzyModeRename:      "fuzzy.mode.rename",
+
+	ColorEmptyLineMarker: "ui.empty_line_marker",
+
+	ColorDebugTitle:         "ui.debug_title",
+	ColorDiagSummaryError:   "ui.diag_summary.error",
+	ColorDiagSummaryWarning: "ui.diag_summary.warning",
+
+	ColorTSFunction:  "ts.function",
+	ColorTSVariable:  "ts.variable",
+	ColorTSType:      "ts.type",
+	ColorTSString:    "ts.string",
+	ColorTSKeyword:   "ts.keyword",
+	ColorTSComment:   "ts.comment",
+	ColorTSNumber:    "ts.number",
+	ColorTSBoolean:   "ts.boolean",
+	ColorTSNull:      "ts.null",
+	ColorTSProperty:  "ts.property",
+	ColorTSTag:       "ts.tag",
+	ColorTSAttribute: "ts.attribute",
+	ColorTSConstant:  "ts.constant",
+
+	ColorTSLocalParameter:  "ts.local.parameter",
+	ColorTSLocalDefinition: "ts.local.definition",
+	ColorTSLocalUnresolved: "ts.local.unresolved",
+
+	ColorLSPStatusConnected:       "status.lsp.connected",
+	ColorLSPStatusDisconnected:    "status.lsp.disconnected",
+	ColorOllamaStatusConnected:    "status.ollama.connected",
+	ColorOllamaStatusDisconnected: "status.ollama.disconnected",
+
+	ColorHoverWindow:  "hover.window",
+	ColorHoverCode:    "hover.code",
+	ColorHoverHeading: "hover.heading",
+	ColorHoverBold:    "hover.bold",
+
+	ColorAutocompleteWindow:   "autocomplete.window",
+	ColorAutocompleteSelected: "autocomplete.selected",
+
+	ColorGhostText: "ui.ghost_text",
+
+	ColorRainbow0: "rainbow.0",
+	ColorRainbow1: "rainbow.1",
+	ColorRainbow2: "rainbow.2",
+	ColorRainbow3: "rainbow.3",
+	ColorRainbow4: "rainbow.4",
+	ColorRainbow5: "rainbow.5",
+	ColorRainbow6: "rainbow.6",
+}
+
+// colorKeyNames is colorNameKeys inverted, so LoadTheme can resolve a TOML
+// key straight back to the ColorName it overrides.
+var colorKeyNames = func() map[string]ColorName {
+	m := make(map[string]ColorName, len(colorNameKeys))
+	for name, key := range colorNameKeys {
+		m[key] = name
+	}
+	return m
+}()
+
+// themesConfigDir returns $XDG_CONFIG_HOME/qwe/themes (falling back to
+// ~/.config/qwe/themes), creating it if necessary. Mirrors plumbConfigDir
+// in plumber.go.
+func themesConfigDir() (string, error) {
+	base := os.Getenv("XDG_CONFIG_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(home, ".config")
+	}
+
+	dir := filepath.Join(base, "qwe", "themes")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// LoadTheme reads and parses a theme TOML file at path for the given
+// Variant, naming the result after the file's base name (minus extension).
+func LoadTheme(path string, variant Variant) (*Theme, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return parseTheme(name, data, variant)
+}
+
+// parseTheme decodes raw TOML theme source into a Theme for the given
+// Variant. A theme file can either define its colors flat at the top level
+// (applies to both variants alike, as every theme shipped before chunk9-5
+// does) or split them into [light] and [dark] sections; in the latter case,
+// the requested variant's section is used, falling back to whichever
+// section the file does have if it only ships one. A key that's neither
+// "palette"/"light"/"dark" nor a recognized table is skipped rather than
+// failing the whole theme, so a mostly-valid file still loads; a key that's
+// a valid {fg, bg, bold} table but isn't one of colorNameKeys' fixed
+// ColorNames is assumed to be a tree-sitter Scope override (see scopes.go).
+func parseTheme(name string, data []byte, variant Variant) (*Theme, error) {
+	var raw map[string]interface{}
+	if _, err := toml.Decode(string(data), &raw); err != nil {
+		return nil, fmt.Errorf("parsing theme %q: %w", name, err)
+	}
+
+	palette, _ := raw["palette"].(map[string]interface{})
+
+	section := raw
+	light, hasLight := raw["light"].(map[string]interface{})
+	dark, hasDark := raw["dark"].(map[string]interface{})
+	if hasLight || hasDark {
+		switch {
+		case variant == VariantLight && hasLight:
+			section = light
+		case variant == VariantDark && hasDark:
+			section = dark
+		case hasDark:
+			section, variant = dark, VariantDark
+		default:
+			section, variant = light, VariantLight
+		}
+	}
+
+	colors, scopes, rgbByAttr, err := resolveThemeSection(section, palette)
+	if err != nil {
+		return nil, fmt.Errorf("parsing theme %q: %w", name, err)
+	}
+
+	return &Theme{
+		Name: name, Colors: colors, Scopes: scopes, Variant: variant,
+		sourceData: data, sourceName: name, rgbByAttr: rgbByAttr,
+	}, nil
+}
+
+// resolveThemeSection turns one flat key -> {fg, bg, bold} table (either a
+// whole theme file's top level, or one of its [light]/[dark] sections) into
+// Colors/Scopes maps plus the true-color index parseTheme's caller stores on
+// Theme.rgbByAttr.
+func resolveThemeSection(section map[string]interface{}, palette map[string]interface{}) (map[ColorName]Color, map[Scope]Color, map[termbox.Attribute]RGB, error) {
+	colors := make(map[ColorName]Color, len(section))
+	scopes := make(map[Scope]Color)
+	rgbByAttr := make(map[termbox.Attribute]RGB)
+	for key, val := range section {
+		if key == "palette" || key == "light" || key == "dark" {
+			continue
+		}
+		entry, ok := val.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		color, err := resolveColorEntry(entry, palette)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%s: %w", key, err)
+		}
+		if colorName, ok := colorKeyNames[key]; ok {
+			colors[colorName] = color
+		} else {
+			scopes[Scope(key)] = color
+		}
+		if color.ForegroundHasRGB {
+			rgbByAttr[color.Foreground] = color.ForegroundRGB
+		}
+		if color.BackgroundHasRGB {
+			rgbByAttr[color.Background] = color.BackgroundRGB
+		}
+	}
+	return colors, scopes, rgbByAttr, nil
+}
+
+// resolveColorEntry turns a single {fg, bg, bold} TOML table into a Color,
+// carrying both the quantized 256-color Attribute every draw call renders
+// with and (for hex/palette values) the exact RGB the true-color overlay
+// prefers.
+func resolveColorEntry(entry map[string]interface{}, palette map[string]interface{}) (Color, error) {
+	fgAttr, fgRGB, fgHasRGB, err := resolveColorValue(entry["fg"], palette)
+	if err != nil {
+		return Color{}, err
+	}
+	bgAttr, bgRGB, bgHasRGB, err := resolveColorValue(entry["bg"], palette)
+	if err != nil {
+		return Color{}, err
+	}
+	if bold, _ := entry["bold"].(bool); bold {
+		fgAttr |= termbox.AttrBold
+	}
+	return Color{
+		Foreground: fgAttr, Background: bgAttr,
+		ForegroundRGB: fgRGB, BackgroundRGB: bgRGB,
+		ForegroundHasRGB: fgHasRGB, BackgroundHasRGB: bgHasRGB,
+	}, nil
+}
+
+// resolveColorValue turns one fg/bg value into a 256-color Attribute plus,
+// for hex/palette values, the RGB it was quantized from: missing or
+// "default" means termbox.ColorDefault with no RGB override, a "#rrggbb"
+// literal is both quantized to the nearest 256-color index and kept exactly
+// as RGB, and anything else is looked up by name in the theme's own
+// [palette] table before the same hex handling applies.
+func resolveColorValue(raw interface{}, palette map[string]interface{}) (attr termbox.Attribute, rgb RGB, hasRGB bool, err error) {
+	s, ok := raw.(string)
+	if !ok || s == "" || s == "default" {
+		return termbox.ColorDefault, RGB{}, false, nil
+	}
+	if !strings.HasPrefix(s, "#") {
+		named, ok := palette[s]
+		if !ok {
+			return 0, RGB{}, false, fmt.Errorf("unknown palette color %q", s)
+		}
+		s, ok = named.(string)
+		if !ok {
+			return 0, RGB{}, false, fmt.Errorf("palette entry %q is not a string", named)
+		}
+	}
+	rgb, err = hexToRGB(s)
+	if err != nil {
+		return 0, RGB{}, false, err
+	}
+	return termbox.Attribute(nearest256(int(rgb.R), int(rgb.G), int(rgb.B)) + 1), rgb, true, nil
+}
+
+// cubeLevels are the six per-channel intensities of xterm-256's 6x6x6 color
+// cube (indices 16-231).
+var cubeLevels = []int{0, 95, 135, 175, 215, 255}
+
+// hexToRGB parses a "#rrggbb" literal into an RGB triple.
+func hexToRGB(hex string) (RGB, error) {
+	hex = strings.TrimPrefix(hex, "#")
+	if len(hex) != 6 {
+		return RGB{}, fmt.Errorf("invalid hex color %q", hex)
+	}
+	r, errR := strconv.ParseUint(hex[0:2], 16, 8)
+	g, errG := strconv.ParseUint(hex[2:4], 16, 8)
+	b, errB := strconv.ParseUint(hex[4:6], 16, 8)
+	if errR != nil || errG != nil || errB != nil {
+		return RGB{}, fmt.Errorf("invalid hex color %q", hex)
+	}
+	return RGB{R: uint8(r), G: uint8(g), B: uint8(b)}, nil
+}
+
+// nearest256 finds the closer of the 6x6x6 color cube and the 24-step
+// grayscale ramp to (r, g, b), and returns that xterm-256 palette index.
+func nearest256(r, g, b int) int {
+	cr, cg, cb := nearestCubeLevel(r), nearestCubeLevel(g), nearestCubeLevel(b)
+	cubeIndex := 16 + 36*cr + 6*cg + cb
+	cubeDist := colorDistSq(r, g, b, cubeLevels[cr], cubeLevels[cg], cubeLevels[cb])
+
+	gray := (r + g + b) / 3
+	grayIndex := 232
+	switch {
+	case gray < 8:
+		grayIndex = 232
+	case gray > 238:
+		grayIndex = 255
+	default:
+		grayIndex = 232 + (gray-8)/10
+	}
+	grayLevel := 8 + (grayIndex-232)*10
+	grayDist := colorDistSq(r, g, b, grayLevel, grayLevel, grayLevel)
+
+	if grayDist < cubeDist {
+		return grayIndex
+	}
+	return cubeIndex
+}
+
+// nearestCubeLevel returns the index into cubeLevels closest to v.
+func nearestCubeLevel(v int) int {
+	best, bestDist := 0, 1<<30
+	for i, level := range cubeLevels {
+		d := v - level
+		if d < 0 {
+			d = -d
+		}
+		if d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+// colorDistSq is the squared Euclidean distance between two RGB triples,
+// good enough for picking the closer of two candidate palette entries
+// without needing an actual sqrt.
+func colorDistSq(r1, g1, b1, r2, g2, b2 int) int {
+	dr, dg, db := r1-r2, g1-g2, b1-b2
+	return dr*dr + dg*dg + db*db
+}
+
+// themeCommand implements `:theme <name>`, checking
+// themesConfigDir()/<name>.toml first and falling back to one of the
+// defaults baked into the binary via ThemesFS. On success it swaps
+// activeTheme (picked up by the next draw; no explicit redraw call needed
+// since GetThemeColor is read fresh every frame) and reports the new name.
+func (ch *Command) themeCommand(name string) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		ch.e.setMessage("No theme name specified")
+		return
+	}
+
+	if dir, err := themesConfigDir(); err == nil {
+		path := filepath.Join(dir, name+".toml")
+		if fileExists(path) {
+			theme, err := LoadTheme(path, activeVariant)
+			if err != nil {
+				ch.e.setMessage(fmt.Sprintf("Theme: %v", err))
+				return
+			}
+			activeTheme.Store(theme)
+			ch.e.setMessage(fmt.Sprintf("Theme: %s", theme.Name))
+			return
+		}
+	}
+
+	data, err := ThemesFS.ReadFile("themes/" + name + ".toml")
+	if err != nil {
+		ch.e.setMessage(fmt.Sprintf("Theme not found: %s", name))
+		return
+	}
+
+	theme, err := parseTheme(name, data, activeVariant)
+	if err != nil {
+		ch.e.setMessage(fmt.Sprintf("Theme: %v", err))
+		return
+	}
+	activeTheme.Store(theme)
+	ch.e.setMessage(fmt.Sprintf("Theme: %s", theme.Name))
+}
+
+// activeVariant is the Variant new :theme loads and `:set background=`
+// resolve against. Set once at startup from DetectBackgroundVariant (see
+// background.go's call in main.go), and overridable at runtime; only ever
+// touched from the main loop's command handling, so (unlike activeTheme)
+// it doesn't need atomic access.
+var activeVariant = VariantDark
+
+// setBackgroundCommand implements `:set background=light|dark`, matching
+// Vim's option syntax. If the active theme was loaded from a file with
+// [light]/[dark] sections, it's re-resolved against the new variant in
+// place (see Theme.sourceData); a theme with no sections to switch between
+// (including builtinTheme) just records the new activeVariant for the next
+// :theme load.
+func (ch *Command) setBackgroundCommand(value string) {
+	var variant Variant
+	switch strings.TrimSpace(value) {
+	case "light":
+		variant = VariantLight
+	case "dark":
+		variant = VariantDark
+	default:
+		ch.e.setMessage(fmt.Sprintf("Unknown background: %s (want light or dark)", value))
+		return
+	}
+
+	activeVariant = variant
+
+	if theme := activeTheme.Load(); theme != nil && theme.sourceData != nil {
+		resolved, err := parseTheme(theme.sourceName, theme.sourceData, variant)
+		if err != nil {
+			ch.e.setMessage(fmt.Sprintf("background=%s: %v", variant, err))
+			return
+		}
+		activeTheme.Store(resolved)
+	}
+
+	ch.e.setMessage(fmt.Sprintf("background=%s", variant))
+}
@@ -0,0 +1,86 @@
+package main
+
+// True-color overlay: termbox only ever paints from a 256/16-color palette,
+// so on a terminal that advertises COLORTERM=truecolor|24bit, setCell (see
+// editor.go) queues every cell whose theme Color carries an exact RGB value
+// (see activeTheme.rgbByAttr in theme.go) into trueColorQueue. Once per
+// frame, right after termbox.Flush() paints its own (lower-fidelity)
+// approximation, flushTrueColorOverlay repaints those cells directly with
+// raw `ESC[38;2;r;g;bm`/`ESC[48;2;r;g;bm` sequences, bypassing termbox's
+// palette mapping entirely, then restores the cursor to where termbox left
+// it.
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+// trueColorCell is one cell queued for the true-color overlay pass, in
+// terminal-absolute coordinates (already offset by screenBaseY).
+type trueColorCell struct {
+	x, y  int
+	ch    rune
+	fg    RGB
+	bg    RGB
+	hasFg bool
+	hasBg bool
+}
+
+// trueColorActive reports whether COLORTERM advertises 24-bit support,
+// checked once at startup since the terminal doesn't change mid-session.
+var trueColorActive = func() bool {
+	ct := strings.ToLower(os.Getenv("COLORTERM"))
+	return ct == "truecolor" || ct == "24bit"
+}()
+
+// queueTrueColorCell records (x, y) for the overlay pass if the active
+// theme defines an exact RGB value for fg and/or bg, doing nothing
+// otherwise (builtinTheme, or any Attribute a loaded theme didn't derive
+// from hex, has no entry in rgbByAttr).
+func (e *Editor) queueTrueColorCell(x, y int, ch rune, fg, bg termbox.Attribute) {
+	theme := activeTheme.Load()
+	if theme == nil || len(theme.rgbByAttr) == 0 {
+		return
+	}
+	fgRGB, hasFg := theme.rgbByAttr[fg]
+	bgRGB, hasBg := theme.rgbByAttr[bg]
+	if !hasFg && !hasBg {
+		return
+	}
+	e.trueColorQueue = append(e.trueColorQueue, trueColorCell{
+		x: x, y: y, ch: ch,
+		fg: fgRGB, bg: bgRGB,
+		hasFg: hasFg, hasBg: hasBg,
+	})
+}
+
+// flushTrueColorOverlay repaints every queued cell with raw 24-bit SGR
+// sequences, positioned via cursor-addressing escapes since there's no
+// other way to poke an arbitrary cell without going through termbox. Resets
+// attributes and restores the cursor to e.lastCursorX/Y (set by setCursor)
+// when done, then clears the queue for the next frame.
+func (e *Editor) flushTrueColorOverlay() {
+	if len(e.trueColorQueue) == 0 {
+		return
+	}
+
+	var sb strings.Builder
+	for _, c := range e.trueColorQueue {
+		fmt.Fprintf(&sb, "\x1b[%d;%dH", c.y+1, c.x+1)
+		if c.hasFg {
+			fmt.Fprintf(&sb, "\x1b[38;2;%d;%d;%dm", c.fg.R, c.fg.G, c.fg.B)
+		}
+		if c.hasBg {
+			fmt.Fprintf(&sb, "\x1b[48;2;%d;%d;%dm", c.bg.R, c.bg.G, c.bg.B)
+		}
+		sb.WriteRune(c.ch)
+		sb.WriteString("\x1b[0m")
+	}
+	fmt.Fprintf(&sb, "\x1b[%d;%dH", e.lastCursorY+1, e.lastCursorX+1)
+
+	os.Stdout.WriteString(sb.String())
+	e.trueColorQueue = e.trueColorQueue[:0]
+}
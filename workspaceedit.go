@@ -0,0 +1,300 @@
+package main
+
+// Applying an LSP WorkspaceEdit, as returned by textDocument/rename or a code
+// action's `edit` field: open every affected file into a Buffer (if not
+// already open), replay each file's edits furthest-down-the-file first
+// through the same rune-buffer splicing applyLSPTextEdit already uses for a
+// single completion edit (editor.go), and push one combined undo entry per
+// touched buffer via saveState.
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// openBufferForEdit returns the already-open buffer for path, loading it
+// first if necessary. Unlike gotoDefinition, it never changes which buffer
+// is active; applyWorkspaceEdit may touch many files the user isn't looking
+// at.
+func (e *Editor) openBufferForEdit(path string) (*Buffer, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	for _, b := range e.buffers {
+		if bAbs, err := filepath.Abs(b.filename); err == nil && bAbs == abs {
+			return b, nil
+		}
+	}
+
+	restoreIndex := e.activeBufferIndex
+	if err := e.LoadFile(path); err != nil {
+		return nil, err
+	}
+	b := e.activeBuffer()
+	e.activeBufferIndex = restoreIndex
+	return b, nil
+}
+
+// applyWorkspaceEdit applies every per-file edit list in we, sorted furthest
+// line/column first so earlier offsets in the same file stay valid while
+// later ones are applied. It returns how many files were touched.
+func (e *Editor) applyWorkspaceEdit(we *WorkspaceEdit) (int, error) {
+	if we == nil {
+		return 0, nil
+	}
+
+	perFile := map[string][]TextEdit{}
+	for uri, edits := range we.Changes {
+		perFile[uri] = append(perFile[uri], edits...)
+	}
+	for _, dc := range we.DocumentChanges {
+		if dc.TextDocument.URI == "" || len(dc.Edits) == 0 {
+			continue // A create/rename/delete entry, not an edit; not yet supported.
+		}
+		perFile[dc.TextDocument.URI] = append(perFile[dc.TextDocument.URI], dc.Edits...)
+	}
+
+	touched := 0
+	for uri, edits := range perFile {
+		if len(edits) == 0 {
+			continue
+		}
+		path := strings.TrimPrefix(uri, "file://")
+		b, err := e.openBufferForEdit(path)
+		if err != nil {
+			return touched, fmt.Errorf("opening %s: %w", path, err)
+		}
+
+		sort.Slice(edits, func(i, j int) bool {
+			if edits[i].Range.Start.Line != edits[j].Range.Start.Line {
+				return edits[i].Range.Start.Line > edits[j].Range.Start.Line
+			}
+			return edits[i].Range.Start.Character > edits[j].Range.Start.Character
+		})
+
+		last := len(b.buffer) - 1
+		b.pushEdit(Edit{
+			Kind:          EditReplace,
+			Y1:            0,
+			X1:            0,
+			Y2:            last,
+			X2:            len(b.buffer[last]),
+			Old:           []rune(b.toString()),
+			CursorsBefore: append([]Cursor(nil), b.cursors...),
+			captured:      false,
+			timestamp:     time.Now(),
+		})
+
+		for _, edit := range edits {
+			e.applyLSPTextEdit(b, edit)
+		}
+
+		for i := range b.cursors {
+			c := &b.cursors[i]
+			if c.Y >= len(b.buffer) {
+				c.Y = len(b.buffer) - 1
+			}
+			if c.Y < 0 {
+				c.Y = 0
+			}
+			if c.X > len(b.buffer[c.Y]) {
+				c.X = len(b.buffer[c.Y])
+			}
+		}
+
+		b.modified = true
+		b.searchMatches = nil
+		if b.syntax != nil {
+			b.syntaxReparse()
+		}
+		if b.lspClient != nil {
+			b.lspClient.SendDidChange(b.toString())
+		}
+		touched++
+	}
+
+	return touched, nil
+}
+
+// RenameSymbol asks the active buffer's LSP server to rename the symbol at
+// the cursor to newName (:rename newName) and opens a FuzzyModeRenamePreview
+// list of every location the rename would touch; nothing is written until
+// the user confirms via confirmRenamePreview, so a project-wide rename can be
+// reviewed the same way a code action is picked from its own fuzzy list.
+func (e *Editor) RenameSymbol(newName string) {
+	b := e.activeBuffer()
+	if b == nil || b.lspClient == nil {
+		e.setMessage("No LSP server attached to this buffer")
+		return
+	}
+	if newName == "" {
+		e.setMessage("No new name specified")
+		return
+	}
+
+	cursor := b.PrimaryCursor()
+	ok, err := b.lspClient.PrepareRename(cursor.Y, cursor.X)
+	if err != nil {
+		e.setMessage(fmt.Sprintf("LSP PrepareRename error: %v", err))
+		return
+	}
+	if !ok {
+		e.setMessage("Nothing renameable at cursor")
+		return
+	}
+
+	we, err := b.lspClient.Rename(cursor.Y, cursor.X, newName)
+	if err != nil {
+		e.setMessage(fmt.Sprintf("LSP Rename error: %v", err))
+		return
+	}
+	if we == nil {
+		e.setMessage("Server returned no edits")
+		return
+	}
+
+	locations, files := renameEditSummary(we)
+	if locations == 0 {
+		e.setMessage("Server returned no edits")
+		return
+	}
+
+	e.fuzzyRenameEdit = we
+	e.fuzzyRenameLabel = fmt.Sprintf("Rename to %q across %d location(s) in %d file(s) — Enter to apply", newName, locations, files)
+
+	e.fuzzyCandidates = append([]string{e.fuzzyRenameLabel}, renameEditLines(we)...)
+	e.fuzzyBuffer = []rune{}
+	e.fuzzyIndex = 0
+	e.fuzzyType = FuzzyModeRenamePreview
+	e.updateFuzzyResults()
+	e.mode = ModeFuzzy
+}
+
+// confirmRenamePreview applies the WorkspaceEdit RenameSymbol staged,
+// regardless of which row in the preview list was selected — the list is
+// for review, not per-location toggling, so any selection confirms the
+// whole rename.
+func (e *Editor) confirmRenamePreview() {
+	we := e.fuzzyRenameEdit
+	e.fuzzyRenameEdit = nil
+	if we == nil {
+		return
+	}
+
+	touched, err := e.applyWorkspaceEdit(we)
+	if err != nil {
+		e.setMessage(fmt.Sprintf("Rename applied partially: %v", err))
+		return
+	}
+	e.setMessage(fmt.Sprintf("Renamed across %d file(s)", touched))
+}
+
+// renameEditSummary counts the total edits and distinct files a
+// WorkspaceEdit touches, for the preview's confirm-row label.
+func renameEditSummary(we *WorkspaceEdit) (locations, files int) {
+	perFile := map[string]int{}
+	for uri, edits := range we.Changes {
+		perFile[uri] += len(edits)
+	}
+	for _, dc := range we.DocumentChanges {
+		perFile[dc.TextDocument.URI] += len(dc.Edits)
+	}
+	for _, n := range perFile {
+		locations += n
+	}
+	return locations, len(perFile)
+}
+
+// renameEditLines formats one "path:line" entry per edit in we, sorted for
+// stable display, so the preview list shows exactly where the rename will
+// land before the user confirms it.
+func renameEditLines(we *WorkspaceEdit) []string {
+	var lines []string
+	addEdits := func(uri string, edits []TextEdit) {
+		path := strings.TrimPrefix(uri, "file://")
+		for _, edit := range edits {
+			lines = append(lines, fmt.Sprintf("  %s:%d", path, edit.Range.Start.Line+1))
+		}
+	}
+	for uri, edits := range we.Changes {
+		addEdits(uri, edits)
+	}
+	for _, dc := range we.DocumentChanges {
+		addEdits(dc.TextDocument.URI, dc.Edits)
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// StartCodeAction requests the code actions available at the cursor (or, in
+// Visual mode, across the selection) and opens a fuzzy-finder picker listing
+// their titles; see openSelectedFile's FuzzyModeCodeAction case for what
+// happens when one is chosen.
+func (e *Editor) StartCodeAction() {
+	b := e.activeBuffer()
+	if b == nil || b.lspClient == nil {
+		e.setMessage("No LSP server attached to this buffer")
+		return
+	}
+
+	cursor := b.PrimaryCursor()
+	startY, startX, endY, endX := cursor.Y, cursor.X, cursor.Y, cursor.X
+	if e.mode == ModeVisual || e.mode == ModeVisualLine || e.mode == ModeVisualBlock {
+		startY, startX, endY, endX = e.getSelectionBounds()
+	}
+
+	actions, err := b.lspClient.CodeAction(startY, startX, endY, endX, b.diagnostics)
+	if err != nil {
+		e.setMessage(fmt.Sprintf("LSP CodeAction error: %v", err))
+		return
+	}
+	if len(actions) == 0 {
+		e.setMessage("No code actions available")
+		return
+	}
+
+	e.fuzzyCodeActions = actions
+	e.fuzzyCandidates = make([]string, len(actions))
+	for i, a := range actions {
+		e.fuzzyCandidates[i] = a.Title
+	}
+	e.fuzzyBuffer = []rune{}
+	e.fuzzyIndex = 0
+	e.fuzzyType = FuzzyModeCodeAction
+	e.updateFuzzyResults()
+	e.mode = ModeFuzzy
+}
+
+// runCodeAction applies the chosen action: edits go through
+// applyWorkspaceEdit, commands are forwarded via workspace/executeCommand.
+func (e *Editor) runCodeAction(action CodeAction) {
+	b := e.activeBuffer()
+	if b == nil || b.lspClient == nil {
+		return
+	}
+
+	if action.Edit != nil {
+		touched, err := e.applyWorkspaceEdit(action.Edit)
+		if err != nil {
+			e.setMessage(fmt.Sprintf("Code action applied partially: %v", err))
+			return
+		}
+		e.setMessage(fmt.Sprintf("Applied %q across %d file(s)", action.Title, touched))
+		return
+	}
+
+	if action.Command != nil {
+		if err := b.lspClient.ExecuteCommand(action.Command.Command, action.Command.Arguments); err != nil {
+			e.setMessage(fmt.Sprintf("Code action command error: %v", err))
+			return
+		}
+		e.setMessage(fmt.Sprintf("Ran %q", action.Title))
+		return
+	}
+
+	e.setMessage(fmt.Sprintf("%q has neither an edit nor a command", action.Title))
+}